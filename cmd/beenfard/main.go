@@ -1,16 +1,165 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
-	"net/http"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
 
 	"github.com/jacobalberty/beenfar/service"
+	"github.com/jacobalberty/beenfar/service/adapter/edgeos"
+	"github.com/jacobalberty/beenfar/service/adapter/openwrt"
+	"github.com/jacobalberty/beenfar/service/certs"
+	"github.com/jacobalberty/beenfar/service/config"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/profile"
 )
 
 func main() {
+	cfg, opts, err := config.Load(os.Args[0], os.Args[1:], os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	bfs := service.NewBeenFarService()
-	bfs.Init()
+	if opts.CheckConfig {
+		if err := cfg.Check(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("configuration ok")
+		return
+	}
 
-	log.Fatal(http.ListenAndServe(":8080", bfs))
+	level, _ := logging.ParseLevel(cfg.LogLevel)
+	logger := logging.New(os.Stderr, level)
+	logging.SetDefault(logger)
+	// Messages of the log package, such as TLS handshake errors from net/http, become warnings
+	log.SetFlags(0)
+	log.SetOutput(logger.With("component", "stdlib").Writer(logging.LevelWarn))
+
+	addrs := map[string]string{
+		service.ListenerInform:      cfg.Listen.Inform,
+		service.ListenerAPI:         cfg.Listen.API,
+		service.ListenerAPIRedirect: cfg.Listen.APIRedirect,
+		service.ListenerPortal:      cfg.Listen.Portal,
+		service.ListenerFirmware:    cfg.Listen.Firmware,
+	}
+	var enabled []string
+	for _, listener := range service.Listeners {
+		if addrs[listener] != "" {
+			enabled = append(enabled, listener)
+		}
+	}
+
+	profiles, err := profileOptions(cfg)
+	if err != nil {
+		fatal(logger, "error loading profile settings", err)
+	}
+
+	bfs := service.NewBeenFarService(
+		service.WithAdminPassword(cfg.AdminPassword),
+		service.WithDataDir(cfg.DataDir),
+		service.WithUnifiKey(cfg.Key()),
+		service.WithInformInterval(cfg.InformInterval),
+		service.WithListeners(enabled...),
+		service.WithAPIPort(cfg.APIPort()),
+		service.WithDrainTimeout(cfg.DrainTimeout),
+		service.WithLogger(logger),
+		service.WithProfiles(profiles),
+		service.WithDrivers(
+			openwrt.NewDriver(openwrt.Config{Uplink: cfg.OpenWRT.Uplink}),
+			edgeos.NewDriver(edgeos.Config{Interface: cfg.EdgeOS.Interface, WAN: cfg.EdgeOS.WAN}),
+		),
+	)
+
+	tlsConfig, err := apiTLSConfig(logger, cfg)
+	if err != nil {
+		fatal(logger, "error configuring TLS", err)
+	}
+
+	listeners := make(map[string]net.Listener, len(enabled))
+	for _, listener := range enabled {
+		l, err := net.Listen("tcp", addrs[listener])
+		if err != nil {
+			fatal(logger, "error listening", err, "listener", listener)
+		}
+		if listener == service.ListenerAPI {
+			l = tls.NewListener(l, tlsConfig)
+		}
+		logger.Info("listening", "listener", listener, "address", l.Addr().String())
+		listeners[listener] = l
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), service.ShutdownSignals...)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// A second signal exits right away
+		stop()
+		logger.Info("shutting down", "drain_timeout", cfg.DrainTimeout)
+	}()
+
+	if err := bfs.Run(ctx, listeners); err != nil {
+		fatal(logger, "stopped with an error", err)
+	}
+	logger.Info("stopped")
+}
+
+// fatal logs err and exits
+func fatal(logger *logging.Logger, msg string, err error, kv ...any) {
+	logger.Error(msg, append([]any{"error", err}, kv...)...)
+	os.Exit(1)
+}
+
+// apiTLSConfig serves the configured certificate, or a self-signed one kept in the data directory
+func apiTLSConfig(logger *logging.Logger, cfg config.Config) (*tls.Config, error) {
+	certFile, keyFile := cfg.TLS.CertFile, cfg.TLS.KeyFile
+	if certFile == "" {
+		var err error
+		certFile, keyFile, err = certs.EnsureSelfSigned(filepath.Join(cfg.DataDir, "tls"), certs.DefaultHosts())
+		if err != nil {
+			return nil, err
+		}
+		if fingerprint, err := certs.Fingerprint(certFile); err == nil {
+			logger.Info("using self-signed certificate", "file", certFile, "sha256_fingerprint", fingerprint)
+		}
+	}
+
+	m, err := certs.NewManager(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return m.TLSConfig(cfg.TLS.ClientCAFile)
+}
+
+// profileOptions loads the certificates wifi profiles are signed with and trust the radius server by
+func profileOptions(cfg config.Config) (profile.Options, error) {
+	opts := profile.Options{
+		Organization:      cfg.Profile.Organization,
+		RadiusServerNames: cfg.Profile.RadiusServerNames,
+		Domain:            cfg.Profile.PasspointDomain,
+	}
+	if cfg.Profile.SigningCertFile != "" {
+		signer, err := profile.LoadSigner(cfg.Profile.SigningCertFile, cfg.Profile.SigningKeyFile)
+		if err != nil {
+			return opts, err
+		}
+		opts.Signer = signer
+	}
+	if cfg.Profile.RadiusCAFile != "" {
+		cas, err := certs.LoadCertificates(cfg.Profile.RadiusCAFile)
+		if err != nil {
+			return opts, err
+		}
+		opts.RadiusCAs = cas
+	}
+	return opts, nil
 }
@@ -28,7 +28,9 @@ func main() {
 		return
 	}
 
-	ipd.Decrypt()
+	if err = ipd.Decrypt(); err != nil {
+		log.Fatalf("Error decrypting inform packet: %v", err)
+	}
 	json, err := ipd.Uncompress()
 	if err != nil {
 		log.Fatalf("Error decompressing inform packet: %v", err)
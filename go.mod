@@ -7,3 +7,7 @@ require github.com/golang/snappy v0.0.4
 require github.com/google/jsonapi v1.0.0
 
 require github.com/go-chi/chi/v5 v5.0.7
+
+require golang.org/x/crypto v0.24.0
+
+require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,376 @@
+package edgeos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrInvalidConfig      = errors.New("invalid config.boot")
+	ErrInvalidConfigValue = errors.New("config value contains a control character")
+)
+
+// Indentation of config.boot
+const indent = "    "
+
+// Node is a node of the EdgeOS configuration tree.
+//
+// Containers such as "interfaces" only have a Name, tag nodes such as "ethernet eth1" also
+// have a Value. Both have children, leaves such as "address 192.168.1.1/24" do not.
+// A leaf may be repeated with different values.
+type Node struct {
+	Name     string
+	Value    string
+	Children []*Node
+	// Replace marks nodes rendered as a whole, Diff deletes their children that are not rendered
+	Replace bool
+
+	leaf bool
+}
+
+// NewConfig returns an empty configuration tree
+func NewConfig() *Node {
+	return &Node{}
+}
+
+// IsLeaf reports whether the node holds a value instead of children
+func (n *Node) IsLeaf() bool {
+	return n.leaf
+}
+
+// Child returns the container or tag node with name and value, adding it if missing
+func (n *Node) Child(name, value string) *Node {
+	if child := n.find(name, value, false); child != nil {
+		return child
+	}
+	child := &Node{Name: name, Value: value}
+	n.Children = append(n.Children, child)
+	return child
+}
+
+// Set replaces the values of a leaf, empty values are left out so the device default applies
+func (n *Node) Set(name, value string) {
+	if value == "" {
+		return
+	}
+	children := n.Children[:0]
+	for _, child := range n.Children {
+		if !child.leaf || child.Name != name {
+			children = append(children, child)
+		}
+	}
+	n.Children = append(children, &Node{Name: name, Value: value, leaf: true})
+}
+
+// Add adds values to a leaf that may be repeated, values it already has are skipped
+func (n *Node) Add(name string, values ...string) {
+	for _, value := range values {
+		if value != "" && n.find(name, value, true) == nil {
+			n.Children = append(n.Children, &Node{Name: name, Value: value, leaf: true})
+		}
+	}
+}
+
+// Get returns the node at path, where every element is a name optionally followed by a space and a value
+func (n *Node) Get(path ...string) *Node {
+	node := n
+	for _, element := range path {
+		name, value, _ := strings.Cut(element, " ")
+		next := node.find(name, value, false)
+		if next == nil {
+			next = node.find(name, value, true)
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+func (n *Node) find(name, value string, leaf bool) *Node {
+	if n == nil {
+		return nil
+	}
+	for _, child := range n.Children {
+		if child.leaf == leaf && child.Name == name && child.Value == value {
+			return child
+		}
+	}
+	return nil
+}
+
+// hasLeaf reports whether the node has any value of the leaf name
+func (n *Node) hasLeaf(name string) bool {
+	for _, child := range n.Children {
+		if child.leaf && child.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sorted returns the children in the order EdgeOS writes them, numeric values are compared as numbers
+func (n *Node) sorted() []*Node {
+	children := append([]*Node(nil), n.Children...)
+	sort.SliceStable(children, func(i, j int) bool {
+		a, b := children[i], children[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		an, aerr := strconv.Atoi(a.Value)
+		bn, berr := strconv.Atoi(b.Value)
+		if aerr == nil && berr == nil {
+			return an < bn
+		}
+		return a.Value < b.Value
+	})
+	return children
+}
+
+// validate checks that every value can be written to config.boot and set commands
+func (n *Node) validate() error {
+	for _, child := range n.Children {
+		if strings.IndexFunc(child.Name+child.Value, unicode.IsControl) >= 0 {
+			return fmt.Errorf("%w: %s %q", ErrInvalidConfigValue, child.Name, child.Value)
+		}
+		if err := child.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String renders the tree in the format of /config/config.boot
+func (n *Node) String() string {
+	var sb strings.Builder
+	n.write(&sb, "")
+	return sb.String()
+}
+
+func (n *Node) write(sb *strings.Builder, prefix string) {
+	for _, child := range n.sorted() {
+		sb.WriteString(prefix + child.Name)
+		if child.Value != "" {
+			sb.WriteString(" " + quoteBoot(child.Value))
+		}
+		if child.leaf {
+			sb.WriteByte('\n')
+			continue
+		}
+		sb.WriteString(" {\n")
+		child.write(sb, prefix+indent)
+		sb.WriteString(prefix + "}\n")
+	}
+}
+
+// MarshalJSON renders the tree in the JSON form UniFi gateways take in system_cfg and
+// config.gateway.json. Tag nodes become an object keyed by value, a leaf with more than one
+// value becomes an array and a leaf without a value holds two single quotes.
+func (n *Node) MarshalJSON() ([]byte, error) {
+	return json.Marshal(n.object())
+}
+
+func (n *Node) object() map[string]any {
+	object := make(map[string]any)
+	for _, child := range n.sorted() {
+		switch {
+		case child.leaf:
+			value := child.Value
+			if value == "" {
+				value = "''"
+			}
+			switch values := object[child.Name].(type) {
+			case nil:
+				object[child.Name] = value
+			case string:
+				object[child.Name] = []string{values, value}
+			case []string:
+				object[child.Name] = append(values, value)
+			}
+		case child.Value == "":
+			object[child.Name] = child.object()
+		default:
+			tags, ok := object[child.Name].(map[string]any)
+			if !ok {
+				tags = make(map[string]any)
+				object[child.Name] = tags
+			}
+			tags[child.Value] = child.object()
+		}
+	}
+	return object
+}
+
+// Commands returns the set commands that build the tree on an empty configuration.
+// Empty tag nodes are set, empty containers are left out as EdgeOS does not keep them.
+func (n *Node) Commands() []string {
+	return Diff(NewConfig(), n)
+}
+
+// Diff returns the commands that change current into desired, delete commands come first.
+//
+// Only what desired renders is changed: nodes missing from desired are kept unless their
+// parent is marked Replace, and values of a leaf are only deleted if desired sets that leaf.
+func Diff(current, desired *Node) []string {
+	var deletes, sets []string
+	diff(nil, current, desired, false, &deletes, &sets)
+	return append(deletes, sets...)
+}
+
+func diff(path []string, current, desired *Node, replace bool, deletes, sets *[]string) {
+	replace = replace || desired.Replace
+	if current != nil {
+		for _, child := range current.sorted() {
+			if desired.find(child.Name, child.Value, child.leaf) != nil {
+				continue
+			}
+			if replace || child.leaf && desired.hasLeaf(child.Name) {
+				*deletes = append(*deletes, command("delete", path, child))
+			}
+		}
+	}
+
+	for _, child := range desired.sorted() {
+		var match *Node
+		if current != nil {
+			match = current.find(child.Name, child.Value, child.leaf)
+		}
+		switch {
+		case child.leaf && match == nil:
+			*sets = append(*sets, command("set", path, child))
+		case !child.leaf && len(child.Children) == 0 && child.Value != "" && match == nil:
+			*sets = append(*sets, command("set", path, child))
+		case !child.leaf:
+			diff(append(path, words(child)...), match, child, replace, deletes, sets)
+		}
+	}
+}
+
+// words returns the node as words of a command
+func words(n *Node) []string {
+	if n.Value == "" {
+		return []string{n.Name}
+	}
+	return []string{n.Name, quoteCommand(n.Value)}
+}
+
+func command(verb string, path []string, n *Node) string {
+	elements := append([]string{verb}, path...)
+	return strings.Join(append(elements, words(n)...), " ")
+}
+
+// quoteBoot double quotes values config.boot can not hold bare
+func quoteBoot(value string) string {
+	if isBare(value) {
+		return value
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// quoteCommand single quotes values the shell would split or expand, a quote inside closes the quote, adds an escaped quote and reopens it
+func quoteCommand(value string) string {
+	if isBare(value) {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func isBare(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, c := range value {
+		if !(unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("._-:/+@,", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse reads a configuration tree in the format of /config/config.boot.
+// The version comments EdgeOS appends are skipped.
+func Parse(text string) (*Node, error) {
+	root := NewConfig()
+	stack := []*Node{root}
+
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "/*") {
+			if !strings.HasSuffix(line, "*/") {
+				return nil, fmt.Errorf("%w: line %d: unterminated comment", ErrInvalidConfig, i+1)
+			}
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		if line == "}" {
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("%w: line %d: unexpected }", ErrInvalidConfig, i+1)
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		open := strings.HasSuffix(line, "{")
+		tokens, err := tokenize(strings.TrimSuffix(line, "{"))
+		if err != nil || len(tokens) == 0 || len(tokens) > 2 {
+			return nil, fmt.Errorf("%w: line %d: expected a name and at most one value", ErrInvalidConfig, i+1)
+		}
+		node := &Node{Name: tokens[0], leaf: !open}
+		if len(tokens) == 2 {
+			node.Value = tokens[1]
+		}
+
+		parent := stack[len(stack)-1]
+		parent.Children = append(parent.Children, node)
+		if open {
+			stack = append(stack, node)
+		}
+	}
+
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("%w: missing }", ErrInvalidConfig)
+	}
+	return root, nil
+}
+
+// tokenize splits a line into words, double quoted words may contain spaces and escaped quotes
+func tokenize(line string) ([]string, error) {
+	var tokens []string
+	for {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return tokens, nil
+		}
+		if line[0] != '"' {
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			tokens = append(tokens, line[:end])
+			line = line[end:]
+			continue
+		}
+
+		var sb strings.Builder
+		i := 1
+		for ; i < len(line) && line[i] != '"'; i++ {
+			if line[i] == '\\' && i+1 < len(line) {
+				i++
+			}
+			sb.WriteByte(line[i])
+		}
+		if i == len(line) {
+			return nil, errors.New("unterminated quote")
+		}
+		tokens = append(tokens, sb.String())
+		line = line[i+1:]
+	}
+}
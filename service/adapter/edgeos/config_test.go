@@ -0,0 +1,146 @@
+package edgeos_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service/adapter/edgeos"
+)
+
+const runningConfig = `firewall {
+    name WAN_IN {
+        default-action drop
+    }
+}
+interfaces {
+    ethernet eth0 {
+        address dhcp
+        description "Internet (WAN)"
+    }
+    ethernet eth1 {
+        address 192.168.0.1/24
+        description lan
+        vif 20 {
+            address 10.0.20.1/24
+            description guest
+            mtu 1400
+        }
+        vif 99 {
+            address 10.0.99.1/24
+        }
+    }
+}
+service {
+    dhcp-server {
+        shared-network-name guest {
+            authoritative enable
+            subnet 10.0.20.0/24 {
+                start 10.0.20.100 {
+                    stop 10.0.20.200
+                }
+            }
+        }
+    }
+}
+/* Warning: Do not remove the following line. */
+/* === vyatta-config-version: "config-management@1:system@4" === */
+/* Release version: v2.0.9-hotfix.6.5574651.221230.1015 */
+`
+
+func TestParse(t *testing.T) {
+	config, err := edgeos.Parse(runningConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	description := config.Get("interfaces", "ethernet eth0", "description Internet (WAN)")
+	if description == nil || !description.IsLeaf() {
+		t.Fatalf("Expected the quoted description of eth0, got:\n%s", config)
+	}
+	if vif := config.Get("interfaces", "ethernet eth1", "vif 99"); vif == nil || vif.IsLeaf() {
+		t.Errorf("Expected vif 99 on eth1, got:\n%s", config)
+	}
+
+	// Formatting keeps everything but the comments
+	again, err := edgeos.Parse(config.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != config.String() {
+		t.Errorf("Expected the same config after formatting, got:\n%s", again)
+	}
+
+	for name, text := range map[string]string{
+		"unclosed":    "interfaces {\n",
+		"extra brace": "}\n",
+		"three words": "interfaces ethernet eth0 {\n}\n",
+		"quote":       "description \"open\n",
+		"comment":     "/* open\n",
+	} {
+		if _, err := edgeos.Parse(text); !errors.Is(err, edgeos.ErrInvalidConfig) {
+			t.Errorf("%s: Expected %v, got %v", name, edgeos.ErrInvalidConfig, err)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	running, err := edgeos.Parse(runningConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desired := edgeos.NewConfig()
+	eth1 := desired.Child("interfaces", "").Child("ethernet", "eth1")
+	eth1.Add("address", "192.168.1.1/24")
+	eth1.Set("description", "lan")
+	vif := eth1.Child("vif", "20")
+	vif.Replace = true
+	vif.Add("address", "10.0.20.1/24")
+	vif.Set("description", "guest network")
+	shared := desired.Child("service", "").Child("dhcp-server", "").Child("shared-network-name", "guest")
+	shared.Replace = true
+	shared.Set("authoritative", "enable")
+	shared.Child("subnet", "10.0.20.0/24").Child("start", "10.0.20.10").Set("stop", "10.0.20.50")
+
+	// The WAN, firewall and vif 99 are not managed and kept
+	expected := []string{
+		"delete interfaces ethernet eth1 address 192.168.0.1/24",
+		"delete interfaces ethernet eth1 vif 20 description guest",
+		"delete interfaces ethernet eth1 vif 20 mtu 1400",
+		"delete service dhcp-server shared-network-name guest subnet 10.0.20.0/24 start 10.0.20.100",
+		"set interfaces ethernet eth1 address 192.168.1.1/24",
+		"set interfaces ethernet eth1 vif 20 description 'guest network'",
+		"set service dhcp-server shared-network-name guest subnet 10.0.20.0/24 start 10.0.20.10 stop 10.0.20.50",
+	}
+	if commands := edgeos.Diff(running, desired); !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Expected commands:\n%q\ngot:\n%q", expected, commands)
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	config, err := edgeos.Parse(`interfaces {
+    ethernet eth1 {
+        address 192.168.1.1/24
+        address 192.168.2.1/24
+        description lan
+        vif 20 {
+            disable
+        }
+    }
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"interfaces":{"ethernet":{"eth1":{"address":["192.168.1.1/24","192.168.2.1/24"],"description":"lan","vif":{"20":{"disable":"''"}}}}}}`
+	if string(b) != expected {
+		t.Errorf("Expected %s, got %s", expected, b)
+	}
+}
@@ -0,0 +1,22 @@
+package edgeos
+
+// Device is an EdgeOS router added by an admin
+type Device struct {
+	mac string
+}
+
+func (ed Device) GetMac() string {
+	return ed.mac
+}
+
+func (ed Device) Adopt() error {
+	return nil
+}
+
+func (ed Device) Delete() error {
+	return nil
+}
+
+func (ed Device) Refresh() {
+
+}
@@ -0,0 +1,195 @@
+// Package edgeos manages EdgeOS routers by rendering their configuration tree and applying
+// it over SSH with commit-confirm
+package edgeos
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jacobalberty/beenfar/service/controller"
+	"github.com/jacobalberty/beenfar/service/driver"
+	"github.com/jacobalberty/beenfar/service/event"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/model"
+	"github.com/jacobalberty/beenfar/service/secret"
+)
+
+// Name of the EdgeOS driver
+const DriverName = "edgeos"
+
+// Largest config.boot that is accepted
+const maxConfigSize = 1 << 20
+
+// Config holds the settings of the EdgeOS driver
+type Config struct {
+	// Interface is the port networks are served on, DefaultInterface if empty
+	Interface string
+	// WAN is the port facing the internet, DefaultWAN if empty
+	WAN string
+}
+
+// Driver renders the configuration of EdgeOS routers and applies it over SSH.
+//
+// Routers are added by an admin, either by uploading their running config.boot or by giving
+// an ssh target. The rendered configuration is compared against the running one to preview
+// the commands that apply it, routers with an ssh target are read live.
+type Driver struct {
+	renderer Renderer
+	sites    *model.Sites
+	devices  *model.Devices
+	audit    *model.AuditLog
+	events   *event.Bus
+	logger   *logging.Logger
+	secrets  *secret.Box
+	dataDir  string
+
+	// Last known running configuration by MAC
+	running map[string]*Node
+	targets map[string]*target
+	mu      sync.RWMutex
+}
+
+func NewDriver(config Config) *Driver {
+	return &Driver{
+		renderer: Renderer{Interface: config.Interface, WAN: config.WAN},
+		running:  make(map[string]*Node),
+		targets:  make(map[string]*target),
+	}
+}
+
+func (h *Driver) Info() driver.Info {
+	return driver.Info{
+		Name:      DriverName,
+		Discovery: driver.DiscoveryManual,
+		Commands:  []driver.Command{driver.CommandProvision},
+	}
+}
+
+func (h *Driver) Init(deps driver.Deps, routers driver.Routers) error {
+	h.sites = deps.Sites
+	h.devices = deps.Devices
+	h.audit = deps.Audit
+	h.events = deps.Events
+	h.logger = deps.Logger
+	h.secrets = deps.Secrets
+	h.dataDir = deps.DataDir
+	if err := h.load(); err != nil {
+		return err
+	}
+
+	api := routers[driver.ListenerAPI]
+	admin := api.With(controller.RequireRole(model.RoleAdmin))
+	admin.With(controller.LimitBody(maxConfigSize)).Put("/api/edgeos/{mac:^[[:xdigit:]]{12}$}/config", h.PutRunningConfig)
+	admin.Get("/api/edgeos/{mac:^[[:xdigit:]]{12}$}/ssh", h.GetTarget)
+	admin.Put("/api/edgeos/{mac:^[[:xdigit:]]{12}$}/ssh", h.PutTarget)
+	admin.Delete("/api/edgeos/{mac:^[[:xdigit:]]{12}$}/ssh", h.DeleteTarget)
+	// Commands include wifi and dhcp settings, the same as the rendered configuration
+	api.With(controller.RequireRole(model.RoleOperator)).
+		Get("/api/edgeos/{mac:^[[:xdigit:]]{12}$}/diff", h.GetDiff)
+	return nil
+}
+
+// Render returns the managed part of the configuration in the format of config.boot
+func (h *Driver) Render(d model.Device) ([]byte, error) {
+	cd, err := h.sites.ConfigData(d.Site)
+	if err != nil {
+		return nil, err
+	}
+	config, err := h.renderer.Render(cd)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(config.String()), nil
+}
+
+// Records the running config.boot of a router, unknown routers are saved as pending
+func (h *Driver) PutRunningConfig(w http.ResponseWriter, r *http.Request) {
+	mac := strings.ToLower(chi.URLParam(r, "mac"))
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	running, err := Parse(string(body))
+	if err != nil {
+		controller.WriteError(w, http.StatusUnprocessableEntity, "Invalid Config", err.Error())
+		return
+	}
+
+	if !h.claim(w, mac) {
+		return
+	}
+
+	h.mu.Lock()
+	h.running[mac] = running
+	h.mu.Unlock()
+	controller.AuditRequest(h.audit, r, "edgeos.config.update", "device/"+mac, nil, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Returns the delete and set commands that apply the rendered configuration to the running
+// config.boot of a router, one per line. Routers with an ssh target are read first,
+// without a running config every command is a set.
+func (h *Driver) GetDiff(w http.ResponseWriter, r *http.Request) {
+	mac := strings.ToLower(chi.URLParam(r, "mac"))
+	device, err := h.devices.Get(mac)
+	switch {
+	case err != nil:
+		controller.WriteError(w, http.StatusNotFound, "Device Not Found", "Device with MAC "+mac+" does not exist")
+		return
+	case device.Driver != DriverName:
+		controller.WriteError(w, http.StatusConflict, "Device Managed By Another Driver", "Device "+mac+" is managed by the "+device.Driver+" driver")
+		return
+	}
+
+	cd, err := h.sites.ConfigData(device.Site)
+	if err != nil {
+		controller.WriteError(w, http.StatusInternalServerError, "Error Rendering Config", err.Error())
+		return
+	}
+	desired, err := h.renderer.Render(cd)
+	if err != nil {
+		controller.WriteError(w, http.StatusInternalServerError, "Error Rendering Config", err.Error())
+		return
+	}
+	if t, ok := h.target(mac); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), sshTimeout)
+		defer cancel()
+		err := h.withClient(t, func(client *SSHClient) error {
+			running, err := client.ReadConfig(ctx)
+			if err == nil {
+				h.mu.Lock()
+				h.running[mac] = running
+				h.mu.Unlock()
+			}
+			return err
+		})
+		if err != nil {
+			logging.FromContext(r.Context()).ForDevice(mac).Warn("error reading config", "error", err)
+			controller.WriteError(w, http.StatusBadGateway, "Error Reading Config", err.Error())
+			return
+		}
+	}
+
+	h.mu.RLock()
+	running, ok := h.running[mac]
+	h.mu.RUnlock()
+	if !ok {
+		running = NewConfig()
+	}
+
+	var sb strings.Builder
+	for _, command := range Diff(running, desired) {
+		sb.WriteString(command + "\n")
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+	}
+}
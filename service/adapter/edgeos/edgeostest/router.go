@@ -0,0 +1,401 @@
+// Package edgeostest provides a fake EdgeOS router reachable over SSH for testing the EdgeOS driver
+package edgeostest
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service/adapter/edgeos"
+	"golang.org/x/crypto/ssh"
+)
+
+// Tag nodes the fake knows, every other node is a container unless it is the last name of a command
+var tagNodes = map[string]bool{
+	"ethernet": true, "vif": true, "pd": true, "interface": true, "prefix": true,
+	"shared-network-name": true, "subnet": true, "start": true, "name": true, "rule": true,
+	"address-group": true, "port-group": true, "static-mapping": true,
+}
+
+// Router is an SSH server that runs configuration scripts the way vbash does on EdgeOS.
+//
+// "cat /config/config.boot" returns the saved configuration and "vbash -s" runs the script on
+// stdin. Scripts may enter configure mode, set and delete nodes, commit, commit-confirm, confirm
+// and save. A commit-confirm that is not confirmed within its minutes rolls back, with a minute
+// lasting ConfirmUnit.
+type Router struct {
+	Addr     string
+	Username string
+	Password string
+	// HostKey is the fingerprint of the key the server presents
+	HostKey string
+	// ConfirmUnit is how long a minute of commit-confirm lasts
+	ConfirmUnit time.Duration
+
+	listener net.Listener
+	config   *ssh.ServerConfig
+	running  *edgeos.Node
+	saved    string
+	rollback *time.Timer
+	refuse   bool
+	cutOff   bool
+	scripts  []string
+	mu       sync.Mutex
+}
+
+// NewRouter starts a fake router with the running configuration in config.boot format
+func NewRouter(username, password, config string) (*Router, error) {
+	running, err := edgeos.Parse(config)
+	if err != nil {
+		return nil, err
+	}
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Router{
+		Addr:        listener.Addr().String(),
+		Username:    username,
+		Password:    password,
+		HostKey:     ssh.FingerprintSHA256(signer.PublicKey()),
+		ConfirmUnit: time.Minute,
+		listener:    listener,
+		running:     running,
+		saved:       running.String(),
+	}
+	r.config = &ssh.ServerConfig{PasswordCallback: r.login}
+	r.config.AddHostKey(signer)
+
+	go r.serve()
+	return r, nil
+}
+
+// Close stops the server
+func (r *Router) Close() error {
+	r.mu.Lock()
+	if r.rollback != nil {
+		r.rollback.Stop()
+	}
+	r.mu.Unlock()
+	return r.listener.Close()
+}
+
+// Running returns the running configuration
+func (r *Router) Running() *edgeos.Node {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return clone(r.running)
+}
+
+// Saved returns the configuration saved to config.boot
+func (r *Router) Saved() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.saved
+}
+
+// Scripts returns the scripts run so far in order
+func (r *Router) Scripts() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.scripts...)
+}
+
+// RefuseLogins makes the router unreachable for the controller, as after a change cutting it off
+func (r *Router) RefuseLogins(refuse bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.refuse = refuse
+}
+
+// CutOffOnCommit makes the next commit-confirm refuse further logins, like a change that
+// cuts the router off from the controller
+func (r *Router) CutOffOnCommit() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cutOff = true
+}
+
+func (r *Router) login(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.refuse || conn.User() != r.Username || string(password) != r.Password {
+		return nil, errors.New("permission denied")
+	}
+	return nil, nil
+}
+
+func (r *Router) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		go r.handle(conn)
+	}
+}
+
+func (r *Router) handle(conn net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, r.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go r.session(channel, requests)
+	}
+}
+
+func (r *Router) session(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	for req := range requests {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		var exec struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &exec); err != nil {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+
+		status := r.exec(exec.Command, channel, channel.Stderr())
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		return
+	}
+}
+
+func (r *Router) exec(command string, channel ssh.Channel, stderr io.Writer) uint32 {
+	switch command {
+	case "cat /config/config.boot":
+		io.WriteString(channel, r.Saved())
+		return 0
+	case "vbash -s":
+		script, err := io.ReadAll(channel)
+		if err != nil {
+			return 1
+		}
+		if err := r.run(string(script)); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(stderr, "%s: command not found\n", command)
+	return 127
+}
+
+// run runs a script, stopping at the first line that fails
+func (r *Router) run(script string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.scripts = append(r.scripts, script)
+	var candidate *edgeos.Node
+	scanner := bufio.NewScanner(strings.NewReader(script))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		words, err := split(line)
+		if err != nil {
+			return err
+		}
+		if len(words) == 0 || words[0] == "source" || line == "set -e" {
+			continue
+		}
+
+		if words[0] == "configure" {
+			candidate = clone(r.running)
+			continue
+		}
+		if candidate == nil {
+			return fmt.Errorf("%s: not in configure mode", words[0])
+		}
+		switch words[0] {
+		case "set":
+			err = set(candidate, words[1:])
+		case "delete":
+			err = remove(candidate, words[1:])
+		case "commit":
+			r.running = clone(candidate)
+		case "commit-confirm":
+			err = r.commitConfirm(candidate, words[1:])
+		case "confirm":
+			if r.rollback == nil {
+				err = errors.New("no commit to confirm")
+			} else {
+				r.rollback.Stop()
+				r.rollback = nil
+			}
+		case "save":
+			r.saved = r.running.String()
+		case "exit":
+			candidate = nil
+		default:
+			err = fmt.Errorf("invalid command: %s", words[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (r *Router) commitConfirm(candidate *edgeos.Node, args []string) error {
+	if len(args) != 1 {
+		return errors.New("commit-confirm: expected the minutes to wait")
+	}
+	minutes, err := strconv.Atoi(args[0])
+	if err != nil || minutes < 1 {
+		return fmt.Errorf("commit-confirm: invalid minutes %q", args[0])
+	}
+
+	previous := r.running
+	r.running = clone(candidate)
+	if r.cutOff {
+		r.refuse, r.cutOff = true, false
+	}
+	if r.rollback != nil {
+		r.rollback.Stop()
+	}
+	r.rollback = time.AfterFunc(time.Duration(minutes)*r.ConfirmUnit, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.running = previous
+		r.rollback = nil
+	})
+	return nil
+}
+
+// set adds the node at path, tag nodes are known by name and the last two words are a leaf
+func set(root *edgeos.Node, path []string) error {
+	node := root
+	for len(path) > 2 {
+		if tagNodes[path[0]] {
+			node, path = node.Child(path[0], path[1]), path[2:]
+		} else {
+			node, path = node.Child(path[0], ""), path[1:]
+		}
+	}
+	if len(path) != 2 {
+		return errors.New("set: expected a value")
+	}
+	node.Add(path[0], path[1])
+	return nil
+}
+
+// remove deletes the leaf, tag node or container at path
+func remove(root *edgeos.Node, path []string) error {
+	parent := root
+	for {
+		var (
+			name, value = path[0], ""
+			rest        = path[1:]
+		)
+		if len(path) > 1 && (tagNodes[name] || len(path) == 2) {
+			value, rest = path[1], path[2:]
+		}
+		i := childIndex(parent, name, value)
+		if i < 0 {
+			return fmt.Errorf("delete: %s %s does not exist", name, value)
+		}
+		if len(rest) == 0 {
+			parent.Children = append(parent.Children[:i], parent.Children[i+1:]...)
+			return nil
+		}
+		parent, path = parent.Children[i], rest
+	}
+}
+
+func childIndex(n *edgeos.Node, name, value string) int {
+	for i, child := range n.Children {
+		if child.Name == name && child.Value == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func clone(n *edgeos.Node) *edgeos.Node {
+	c, err := edgeos.Parse(n.String())
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// split splits a command into words, single quoted words may contain spaces and a quote
+// inside them closes the quote, adds an escaped quote and reopens it
+func split(line string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		inQuote bool
+	)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case inQuote && c == '\'':
+			inQuote = false
+		case inQuote:
+			word.WriteByte(c)
+		case c == '\'':
+			inQuote, inWord = true, true
+		case c == '\\' && i+1 < len(line):
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inQuote {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
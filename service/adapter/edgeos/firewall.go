@@ -0,0 +1,218 @@
+package edgeos
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// Port facing the internet unless set in Renderer
+const DefaultWAN = "eth0"
+
+// Rule numbers of the rendered rules. Firewall rules are numbered from their index after the
+// state rules, port forwards are accepted after them and nat rules come before the source nat
+// rules UniFi gateways add.
+const (
+	ruleEstablished  = 1
+	ruleInvalid      = 2
+	ruleFirewallBase = 1000
+	ruleForwardBase  = 3000
+	natForwardBase   = 1000
+	natRuleBase      = 5000
+)
+
+// zone is a network or the WAN as seen by the firewall, the WAN has no interface node until a
+// rule set is bound to it
+type zone struct {
+	name   string
+	iface  *Node
+	subnet string
+}
+
+// firewall renders the groups, rule sets, port forwards and nat rules. Rule sets are named after
+// the zone traffic comes from and attached to its interface:
+//
+//   - <zone>_LOCAL filters traffic to the router itself
+//   - WAN_IN filters traffic from the WAN to networks, port forwards are accepted in it
+//   - WAN_OUT filters traffic from networks to the WAN
+//   - <network>_IN filters traffic between networks
+//
+// Every rule set accepts established and related traffic and drops invalid traffic first.
+// Rule sets of the WAN drop what no rule accepts, the others accept it.
+func (r Renderer) firewall(root *Node, cd *model.ConfigData, zones map[string]zone) {
+	wanPort := r.wan()
+	zones[model.ZoneWAN] = zone{name: "WAN"}
+
+	groups := make(map[string]string)
+	for _, group := range cd.FirewallGroupList() {
+		groups[group.ID] = group.Name
+		var g *Node
+		if group.Type == model.FirewallGroupPort {
+			g = root.Child("firewall", "").Child("group", "").Child("port-group", group.Name)
+			g.Add("port", group.Members...)
+		} else {
+			g = root.Child("firewall", "").Child("group", "").Child("address-group", group.Name)
+			g.Add("address", group.Members...)
+		}
+		g.Replace = true
+	}
+
+	for _, rule := range cd.FirewallRuleList() {
+		if rule.Disabled {
+			continue
+		}
+		src, srcOK := zones[rule.Source]
+		dst, dstOK := zones[rule.Destination]
+		if !srcOK || !dstOK && rule.Destination != model.ZoneLocal {
+			continue
+		}
+
+		set := r.ruleSet(root, src, dst, rule.Destination == model.ZoneLocal)
+		n := set.Child("rule", strconv.Itoa(ruleFirewallBase+rule.Index))
+		n.Set("action", rule.Action)
+		n.Set("description", rule.Name)
+		n.Set("protocol", edgeProtocol(rule.Protocol))
+		n.Set("log", enable(rule.Log))
+
+		// Rule sets are shared by several zones, the zone subnet limits rules that set no address
+		source, destination := rule.SourceAddress, rule.DestinationAddress
+		switch rule.Destination {
+		case model.ZoneLocal:
+		case model.ZoneWAN:
+			if source == "" && rule.SourceGroup == "" {
+				source = src.subnet
+			}
+		default:
+			if destination == "" && rule.DestinationGroup == "" {
+				destination = dst.subnet
+			}
+		}
+		match(n, "source", source, groups[rule.SourceGroup], "", "")
+		match(n, "destination", destination, groups[rule.DestinationGroup], rule.DestinationPort, groups[rule.DestinationPortGroup])
+	}
+
+	for i, forward := range cd.PortForwardList() {
+		if forward.Disabled {
+			continue
+		}
+		port := forward.ForwardPort
+		if port == "" {
+			port = forward.Port
+		}
+
+		nat := root.Child("service", "").Child("nat", "").Child("rule", strconv.Itoa(natForwardBase+i+1))
+		nat.Replace = true
+		nat.Set("description", forward.Name)
+		nat.Set("type", "destination")
+		nat.Set("inbound-interface", wanPort)
+		nat.Set("protocol", edgeProtocol(forward.Protocol))
+		nat.Set("log", enable(forward.Log))
+		match(nat, "destination", "", "", forward.Port, "")
+		match(nat, "source", forward.SourceAddress, "", "", "")
+		inside := nat.Child("inside-address", "")
+		inside.Set("address", forward.ForwardAddress)
+		inside.Set("port", port)
+
+		set := r.ruleSet(root, zones[model.ZoneWAN], zones[string(forward.Network)], false)
+		accept := set.Child("rule", strconv.Itoa(ruleForwardBase+i+1))
+		accept.Set("action", model.FirewallActionAccept)
+		accept.Set("description", "port forward "+forward.Name)
+		accept.Set("protocol", edgeProtocol(forward.Protocol))
+		match(accept, "source", forward.SourceAddress, "", "", "")
+		match(accept, "destination", forward.ForwardAddress, "", port, "")
+	}
+
+	for _, rule := range cd.NATRuleList() {
+		network, ok := zones[string(rule.Network)]
+		if rule.Disabled || !ok {
+			continue
+		}
+		nat := root.Child("service", "").Child("nat", "").Child("rule", strconv.Itoa(natRuleBase+rule.Index))
+		nat.Replace = true
+		nat.Set("description", rule.Name)
+		nat.Set("type", rule.Type)
+		nat.Set("outbound-interface", wanPort)
+		nat.Set("protocol", "all")
+		nat.Set("log", "disable")
+		nat.Child("source", "").Set("address", network.subnet)
+		if rule.Type == model.NATSource {
+			nat.Child("outside-address", "").Set("address", rule.TranslationAddress)
+		}
+	}
+}
+
+// ruleSet returns the rule set filtering traffic from src to dst and attaches it to its interface
+func (r Renderer) ruleSet(root *Node, src, dst zone, local bool) *Node {
+	owner, direction, suffix := src, "in", "_IN"
+	switch {
+	case local:
+		direction, suffix = "local", "_LOCAL"
+	case dst.name == "WAN":
+		owner, direction, suffix = dst, "out", "_OUT"
+	}
+	name := owner.name + suffix
+
+	set := root.Child("firewall", "").Child("name", name)
+	if set.Get("rule "+strconv.Itoa(ruleEstablished)) != nil {
+		return set
+	}
+	set.Replace = true
+	if src.name == "WAN" {
+		set.Set("default-action", model.FirewallActionDrop)
+	} else {
+		set.Set("default-action", model.FirewallActionAccept)
+	}
+	established := set.Child("rule", strconv.Itoa(ruleEstablished))
+	established.Set("action", model.FirewallActionAccept)
+	established.Set("description", "established and related")
+	state := established.Child("state", "")
+	state.Set("established", "enable")
+	state.Set("related", "enable")
+	invalid := set.Child("rule", strconv.Itoa(ruleInvalid))
+	invalid.Set("action", model.FirewallActionDrop)
+	invalid.Set("description", "invalid state")
+	invalid.Child("state", "").Set("invalid", "enable")
+
+	iface := owner.iface
+	if iface == nil {
+		iface = root.Child("interfaces", "").Child("ethernet", r.wan())
+	}
+	iface.Child("firewall", "").Child(direction, "").Set("name", name)
+	return set
+}
+
+func (r Renderer) wan() string {
+	if r.WAN == "" {
+		return DefaultWAN
+	}
+	return r.WAN
+}
+
+// match sets the address, port and groups a rule matches on its source or destination, side is
+// left out when the rule matches anything
+func match(rule *Node, side, address, addressGroup, port, portGroup string) {
+	if address == "" && addressGroup == "" && port == "" && portGroup == "" {
+		return
+	}
+	n := rule.Child(side, "")
+	n.Set("address", address)
+	n.Set("port", port)
+	if addressGroup != "" || portGroup != "" {
+		group := n.Child("group", "")
+		group.Set("address-group", addressGroup)
+		group.Set("port-group", portGroup)
+	}
+}
+
+// edgeProtocol converts a protocol of the model, EdgeOS writes tcp_udp the same way
+func edgeProtocol(protocol string) string {
+	return strings.ToLower(protocol)
+}
+
+func enable(b bool) string {
+	if b {
+		return "enable"
+	}
+	return "disable"
+}
@@ -0,0 +1,166 @@
+package edgeos
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// Port networks are served on unless set in Renderer
+const DefaultInterface = "eth1"
+
+// Length of the prefix requested from the ISP, prefix IDs select a /64 inside it
+const PrefixLength = "/56"
+
+// Renderer turns the configuration data into an EdgeOS configuration tree.
+//
+// Only what beenfar manages is rendered: the addresses of the LAN port, a vif per VLAN, a dhcp
+// server or relay per network, dns forwarding, IPv6 prefix delegation and the firewall and nat
+// rules. The WAN addressing and anything else set up on the router are kept when the tree is
+// applied with Diff.
+type Renderer struct {
+	// Interface is the port networks are served on, VLANs are vifs on it
+	Interface string
+	// WAN is the port facing the internet, rule sets and nat rules of the WAN are bound to it
+	WAN string
+}
+
+// Render renders every network with a valid gateway subnet
+func (r Renderer) Render(cd *model.ConfigData) (*Node, error) {
+	root := NewConfig()
+	zones := make(map[string]zone)
+	for _, network := range cd.NetworkList() {
+		gateway, subnet, err := net.ParseCIDR(network.GatewayIPSubnet)
+		if err != nil {
+			continue
+		}
+
+		ifname, iface := r.iface(root, network)
+		iface.Add("address", network.GatewayIPSubnet)
+		iface.Set("description", network.Name)
+
+		r.dhcp(root, network, cd.DHCPReservationList(model.NetworkID(network.ID)), ifname, gateway, subnet)
+		r.ipv6(root, network, ifname, iface)
+		zones[network.ID] = zone{name: strings.ToUpper(network.Name), iface: iface, subnet: subnet.String()}
+	}
+	r.firewall(root, cd, zones)
+
+	if err := root.validate(); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// iface returns the name and node of the interface serving the network, tagged networks are vifs
+func (r Renderer) iface(root *Node, network model.NetworkConfig) (string, *Node) {
+	port := r.Interface
+	if port == "" {
+		port = DefaultInterface
+	}
+
+	ethernet := root.Child("interfaces", "").Child("ethernet", port)
+	if network.Vlan == 0 {
+		return port, ethernet
+	}
+	vif := ethernet.Child("vif", strconv.Itoa(network.Vlan))
+	vif.Replace = true
+	return port + "." + strconv.Itoa(network.Vlan), vif
+}
+
+// dhcp adds the dhcp server or relay of the network, routers handing out their own address as
+// name server forward dns on the interface. Reservations are static mappings named by their
+// hostname, or by their MAC address without one.
+func (r Renderer) dhcp(root *Node, network model.NetworkConfig, reservations []model.DHCPReservation, ifname string, gateway net.IP, subnet *net.IPNet) {
+	dhcp := network.DHCPConfig
+
+	switch dhcp.DHCPMode {
+	case model.DHCPModeServer:
+		service := root.Child("service", "")
+		shared := service.Child("dhcp-server", "").Child("shared-network-name", network.Name)
+		shared.Replace = true
+		shared.Set("authoritative", "enable")
+
+		s := shared.Child("subnet", subnet.String())
+		switch {
+		case dhcp.DHCPGateway.Auto:
+			s.Set("default-router", gateway.String())
+		case dhcp.DHCPGateway.Address != "":
+			s.Set("default-router", dhcp.DHCPGateway.Address)
+		}
+		if dhcp.DHCPNameServer.Auto {
+			s.Add("dns-server", gateway.String())
+			service.Child("dns", "").Child("forwarding", "").Add("listen-on", ifname)
+		} else {
+			s.Add("dns-server", dhcp.DHCPNameServer.Addresses...)
+		}
+		s.Set("domain-name", network.DomainName)
+		if dhcp.DHCPLeaseTime > 0 {
+			s.Set("lease", strconv.Itoa(dhcp.DHCPLeaseTime))
+		}
+		s.Child("start", dhcp.DHCPStart).Set("stop", dhcp.DHCPStop)
+		for _, reservation := range reservations {
+			name := reservation.Hostname
+			if name == "" {
+				name = "host-" + strings.ReplaceAll(reservation.MAC, ":", "")
+			}
+			mapping := s.Child("static-mapping", name)
+			mapping.Set("ip-address", reservation.IP)
+			mapping.Set("mac-address", reservation.MAC)
+		}
+	case model.DHCPModeRelay:
+		relay := root.Child("service", "").Child("dhcp-relay", "")
+		relay.Add("interface", ifname)
+		relay.Add("server", dhcp.DHCPRelayServer)
+	}
+}
+
+// ipv6 requests a /64 for the network from the prefix delegated to the WAN port and announces it.
+// Name servers are only announced when set, otherwise clients keep the ones from dhcp.
+func (r Renderer) ipv6(root *Node, network model.NetworkConfig, ifname string, iface *Node) {
+	ipv6 := network.IPV6NetworkConfig
+	if ipv6.Type != model.IPV6TypePD {
+		return
+	}
+
+	wan := "eth" + strconv.Itoa(ipv6.PrefixDelegationInterface)
+	pd := root.Child("interfaces", "").Child("ethernet", wan).Child("dhcpv6-pd", "").Child("pd", "0")
+	pd.Set("prefix-length", PrefixLength)
+	delegated := pd.Child("interface", ifname)
+	delegated.Replace = true
+	delegated.Set("host-address", "::1")
+	delegated.Set("prefix-id", fmt.Sprintf(":%x", ipv6.PrefixID))
+
+	if !ipv6.RAEnabled {
+		return
+	}
+	ra := iface.Child("ipv6", "").Child("router-advert", "")
+	ra.Replace = true
+	ra.Set("send-advert", "true")
+	ra.Set("default-preference", raPreference(ipv6.RAPriority))
+	if !ipv6.RDNSSControlAuto {
+		ra.Add("name-server", ipv6.RDNSSNameServers...)
+	}
+
+	prefix := ra.Child("prefix", "::/64")
+	prefix.Set("autonomous-flag", "true")
+	prefix.Set("on-link-flag", "true")
+	if ipv6.RAValidLifetime > 0 {
+		prefix.Set("valid-lifetime", strconv.Itoa(ipv6.RAValidLifetime))
+	}
+	if ipv6.RAPrefferedLifetime > 0 {
+		prefix.Set("preferred-lifetime", strconv.Itoa(ipv6.RAPrefferedLifetime))
+	}
+}
+
+func raPreference(priority int) string {
+	switch priority {
+	case model.RAPriorityHigh:
+		return "high"
+	case model.RAPriorityLow:
+		return "low"
+	}
+	return "medium"
+}
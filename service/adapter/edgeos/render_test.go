@@ -0,0 +1,176 @@
+package edgeos_test
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service/adapter/edgeos"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func testConfigData() *model.ConfigData {
+	cd := model.NewConfigData()
+	cd.Networks = map[string]model.NetworkConfig{
+		"000000000000000000000001": {
+			ID:              "000000000000000000000001",
+			Name:            "lan",
+			GatewayIPSubnet: "192.168.1.1/24",
+			DomainName:      "home.example",
+			DHCPConfig: model.DHCPConfig{
+				DHCPMode:       model.DHCPModeServer,
+				DHCPStart:      "192.168.1.100",
+				DHCPStop:       "192.168.1.249",
+				DHCPLeaseTime:  86400,
+				DHCPNameServer: model.DHCPNameServer{Auto: true},
+				DHCPGateway:    model.DHCPGateway{Auto: true},
+			},
+			IPV6NetworkConfig: model.IPV6NetworkConfig{
+				Type:                model.IPV6TypePD,
+				RAEnabled:           true,
+				RAPriority:          model.RAPriorityHigh,
+				RAValidLifetime:     86400,
+				RAPrefferedLifetime: 14400,
+				RDNSSControlAuto:    true,
+			},
+		},
+		"000000000000000000000002": {
+			ID:              "000000000000000000000002",
+			Name:            "guest",
+			Purpose:         model.NetworkPurposeGuest,
+			Vlan:            20,
+			GatewayIPSubnet: "10.0.20.1/24",
+			DHCPConfig: model.DHCPConfig{
+				DHCPMode:       model.DHCPModeServer,
+				DHCPStart:      "10.0.20.10",
+				DHCPStop:       "10.0.20.50",
+				DHCPNameServer: model.DHCPNameServer{Addresses: []string{"1.1.1.1", "9.9.9.9"}},
+				DHCPGateway:    model.DHCPGateway{Address: "10.0.20.254"},
+			},
+			IPV6NetworkConfig: model.IPV6NetworkConfig{
+				Type:             model.IPV6TypePD,
+				PrefixID:         20,
+				RAEnabled:        true,
+				RDNSSNameServers: []string{"2606:4700:4700::1111"},
+			},
+		},
+		"000000000000000000000003": {
+			ID:              "000000000000000000000003",
+			Name:            "iot",
+			Vlan:            30,
+			GatewayIPSubnet: "10.0.30.1/25",
+			DHCPConfig: model.DHCPConfig{
+				DHCPMode:        model.DHCPModeRelay,
+				DHCPRelayServer: "192.168.1.2",
+			},
+		},
+		"000000000000000000000004": {
+			ID:              "000000000000000000000004",
+			Name:            "lab",
+			Vlan:            40,
+			GatewayIPSubnet: "10.0.40.1/24",
+			IPV6NetworkConfig: model.IPV6NetworkConfig{
+				Type:                      model.IPV6TypePD,
+				PrefixDelegationInterface: 2,
+				PrefixID:                  40,
+			},
+		},
+	}
+	cd.DHCPReservations = map[string]model.DHCPReservation{
+		"000000000000000000000051": {ID: "000000000000000000000051", Network: "000000000000000000000001", MAC: "00:11:22:33:44:55", IP: "192.168.1.10", Hostname: "nas"},
+		"000000000000000000000052": {ID: "000000000000000000000052", Network: "000000000000000000000001", MAC: "00:11:22:33:44:66", IP: "192.168.1.11"},
+	}
+	cd.FirewallGroups = map[string]model.FirewallGroup{
+		"000000000000000000000011": {ID: "000000000000000000000011", Name: "servers", Type: model.FirewallGroupAddress, Members: []string{"192.168.1.10", "192.168.1.11"}},
+		"000000000000000000000012": {ID: "000000000000000000000012", Name: "web", Type: model.FirewallGroupPort, Members: []string{"80", "443"}},
+	}
+	cd.FirewallRules = map[string]model.FirewallRule{
+		"000000000000000000000021": {ID: "000000000000000000000021", Name: "guest to servers", Index: 1, Action: model.FirewallActionAccept, Protocol: model.ProtocolTCP,
+			Source: "000000000000000000000002", Destination: "000000000000000000000001", DestinationGroup: "000000000000000000000011", DestinationPortGroup: "000000000000000000000012"},
+		"000000000000000000000022": {ID: "000000000000000000000022", Name: "guest isolation", Index: 2, Action: model.FirewallActionDrop, Protocol: model.ProtocolAll,
+			Source: "000000000000000000000002", Destination: "000000000000000000000001", Log: true},
+		"000000000000000000000023": {ID: "000000000000000000000023", Name: "no ssh from iot", Index: 3, Action: model.FirewallActionReject, Protocol: model.ProtocolTCP,
+			Source: "000000000000000000000003", Destination: model.ZoneLocal, DestinationPort: "22"},
+		"000000000000000000000024": {ID: "000000000000000000000024", Name: "ping", Index: 4, Action: model.FirewallActionAccept, Protocol: model.ProtocolICMP,
+			Source: model.ZoneWAN, Destination: model.ZoneLocal},
+		"000000000000000000000025": {ID: "000000000000000000000025", Name: "iot offline", Index: 5, Action: model.FirewallActionDrop, Protocol: model.ProtocolAll,
+			Source: "000000000000000000000003", Destination: model.ZoneWAN},
+		"000000000000000000000026": {ID: "000000000000000000000026", Name: "disabled", Index: 6, Disabled: true, Action: model.FirewallActionDrop, Protocol: model.ProtocolAll,
+			Source: "000000000000000000000001", Destination: model.ZoneWAN},
+	}
+	cd.PortForwards = map[string]model.PortForward{
+		"000000000000000000000031": {ID: "000000000000000000000031", Name: "https", Protocol: model.ProtocolTCP, Port: "443",
+			Network: "000000000000000000000001", ForwardAddress: "192.168.1.10", ForwardPort: "8443"},
+	}
+	cd.NATRules = map[string]model.NATRule{
+		"000000000000000000000041": {ID: "000000000000000000000041", Name: "lab", Index: 1, Type: model.NATSource,
+			Network: "000000000000000000000004", TranslationAddress: "203.0.113.9"},
+	}
+	return cd
+}
+
+func TestRender(t *testing.T) {
+	config, err := edgeos.Renderer{}.Render(testConfigData())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{
+		"config.boot.golden": config.String(),
+		"commands.golden":    strings.Join(config.Commands(), "\n") + "\n",
+	} {
+		path := filepath.Join("testdata", name)
+		if *update {
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		golden, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if content != string(golden) {
+			t.Errorf("Expected output to match %s, got:\n%s", path, content)
+		}
+	}
+
+	// The rendered tree can be read back as config.boot
+	parsed, err := edgeos.Parse(config.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commands := edgeos.Diff(parsed, config); len(commands) != 0 {
+		t.Errorf("Expected no changes after parsing the rendered config, got %v", commands)
+	}
+
+	moved, err := edgeos.Renderer{Interface: "switch0"}.Render(testConfigData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Get("interfaces", "ethernet switch0", "vif 20") == nil {
+		t.Errorf("Expected the vifs on switch0, got:\n%s", moved)
+	}
+
+	wan, err := edgeos.Renderer{WAN: "eth2"}.Render(testConfigData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wan.Get("interfaces", "ethernet eth2", "firewall", "local", "name WAN_LOCAL") == nil || wan.Get("service", "nat", "rule 1001", "inbound-interface eth2") == nil {
+		t.Errorf("Expected the WAN rule sets and port forwards on eth2, got:\n%s", wan)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	cd := testConfigData()
+	network := cd.Networks["000000000000000000000001"]
+	network.DomainName = "line\nbreak"
+	cd.Networks[network.ID] = network
+	if _, err := (edgeos.Renderer{}).Render(cd); !errors.Is(err, edgeos.ErrInvalidConfigValue) {
+		t.Errorf("Expected %v, got %v", edgeos.ErrInvalidConfigValue, err)
+	}
+}
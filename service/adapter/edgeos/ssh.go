@@ -0,0 +1,172 @@
+package edgeos
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrHostKeyMismatch = errors.New("ssh host key does not match the pinned key")
+	ErrNotConfirmed    = errors.New("commit was not confirmed, the router rolls back")
+)
+
+// Minutes the router waits for the commit to be confirmed before it rolls back
+const ConfirmMinutes = 10
+
+// Scripts are run by vbash with the configuration commands enabled
+const (
+	shellCommand   = "vbash -s"
+	scriptTemplate = "source /opt/vyatta/etc/functions/script-template"
+	configPath     = "/config/config.boot"
+)
+
+// SSHClient configures a router over SSH.
+//
+// Changes are committed with commit-confirm on one connection and confirmed on a new one,
+// so the router rolls back by itself if it can no longer be reached after the change.
+type SSHClient struct {
+	// Address is host:port of the ssh server
+	Address  string
+	Username string
+	Password string
+	// HostKey is the SHA256 fingerprint the router must present. If empty the first key
+	// presented is trusted and HostKey is set to it.
+	HostKey string
+
+	mu sync.Mutex
+}
+
+// dial connects to the router, the connection is closed when ctx is done
+func (c *SSHClient) dial(ctx context.Context) (*ssh.Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ssh.ClientConfig{
+		User: c.Username,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(c.Password),
+			ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = c.Password
+				}
+				return answers, nil
+			}),
+		},
+		HostKeyCallback: c.checkHostKey,
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, c.Address, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	go func() {
+		<-ctx.Done()
+		client.Close()
+	}()
+	return client, nil
+}
+
+func (c *SSHClient) checkHostKey(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	fingerprint := ssh.FingerprintSHA256(key)
+	if c.HostKey == "" {
+		c.HostKey = fingerprint
+		return nil
+	}
+	if fingerprint != c.HostKey {
+		return fmt.Errorf("%w: got %s", ErrHostKeyMismatch, fingerprint)
+	}
+	return nil
+}
+
+// run runs a command with stdin on a new connection and returns its output
+func (c *SSHClient) run(ctx context.Context, command, stdin string) (string, error) {
+	client, err := c.dial(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdin = strings.NewReader(stdin)
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if err := session.Run(command); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return stdout.String(), fmt.Errorf("%w: %s", err, msg)
+		}
+		return stdout.String(), err
+	}
+	return stdout.String(), nil
+}
+
+// configure runs commands in configuration mode, it stops at the first command that fails
+func (c *SSHClient) configure(ctx context.Context, commands ...string) error {
+	script := append([]string{scriptTemplate, "set -e", "configure"}, commands...)
+	_, err := c.run(ctx, shellCommand, strings.Join(append(script, "exit"), "\n")+"\n")
+	return err
+}
+
+// ReadConfig reads the saved configuration of the router
+func (c *SSHClient) ReadConfig(ctx context.Context) (*Node, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	text, err := c.run(ctx, "cat "+configPath, "")
+	if err != nil {
+		return nil, err
+	}
+	return Parse(text)
+}
+
+// Apply changes the configuration of the router to desired and returns the commands it ran,
+// none if the router already has it.
+//
+// The commands are committed with commit-confirm, then a new connection confirms and saves
+// them. If that fails ErrNotConfirmed is returned and the router rolls back on its own.
+func (c *SSHClient) Apply(ctx context.Context, desired *Node) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	text, err := c.run(ctx, "cat "+configPath, "")
+	if err != nil {
+		return nil, err
+	}
+	current, err := Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	commands := Diff(current, desired)
+	if len(commands) == 0 {
+		return nil, nil
+	}
+
+	if err := c.configure(ctx, append(commands, "commit-confirm "+strconv.Itoa(ConfirmMinutes))...); err != nil {
+		return nil, err
+	}
+	if err := c.configure(ctx, "confirm", "save"); err != nil {
+		return commands, fmt.Errorf("%w: %v", ErrNotConfirmed, err)
+	}
+	return commands, nil
+}
@@ -0,0 +1,111 @@
+package edgeos_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service/adapter/edgeos"
+	"github.com/jacobalberty/beenfar/service/adapter/edgeos/edgeostest"
+)
+
+func TestSSHApply(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	router, err := edgeostest.NewRouter("ubnt", "secret", runningConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close()
+	router.ConfirmUnit = 50 * time.Millisecond
+
+	desired, err := edgeos.Renderer{}.Render(testConfigData())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &edgeos.SSHClient{Address: router.Addr, Username: "ubnt", Password: "wrong"}
+	if _, err := client.Apply(ctx, desired); err == nil {
+		t.Error("Expected an error with the wrong password")
+	}
+
+	// The first host key is trusted and pinned
+	client = &edgeos.SSHClient{Address: router.Addr, Username: "ubnt", Password: "secret"}
+	commands, err := client.Apply(ctx, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.HostKey != router.HostKey {
+		t.Errorf("Expected host key %s to be pinned, got %s", router.HostKey, client.HostKey)
+	}
+	if len(commands) == 0 || commands[0] != "delete interfaces ethernet eth1 address 192.168.0.1/24" {
+		t.Errorf("Expected the old address to be deleted first, got %q", commands)
+	}
+	scripts := router.Scripts()
+	if len(scripts) != 2 || !strings.Contains(scripts[0], "commit-confirm 10\n") || !strings.Contains(scripts[1], "confirm\nsave\n") {
+		t.Errorf("Expected a commit-confirm and a confirm, got %q", scripts)
+	}
+
+	// Confirmed changes are saved and kept, unmanaged nodes are untouched
+	time.Sleep(3 * router.ConfirmUnit)
+	saved, err := client.ReadConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commands := edgeos.Diff(saved, desired); len(commands) != 0 {
+		t.Errorf("Expected the saved config to match, got %q", commands)
+	}
+	if saved.Get("interfaces", "ethernet eth0", "address dhcp") == nil || router.Running().Get("interfaces", "ethernet eth1", "vif 99") == nil {
+		t.Errorf("Expected unmanaged nodes to be kept, got:\n%s", saved)
+	}
+	if commands, err := client.Apply(ctx, desired); err != nil || len(commands) != 0 {
+		t.Errorf("Expected nothing to apply, got %q and %v", commands, err)
+	}
+
+	// Another key is rejected
+	other := &edgeos.SSHClient{Address: router.Addr, Username: "ubnt", Password: "secret", HostKey: "SHA256:other"}
+	if _, err := other.ReadConfig(ctx); !errors.Is(err, edgeos.ErrHostKeyMismatch) {
+		t.Errorf("Expected %v, got %v", edgeos.ErrHostKeyMismatch, err)
+	}
+}
+
+func TestSSHRollback(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	router, err := edgeostest.NewRouter("ubnt", "secret", runningConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close()
+	router.ConfirmUnit = 5 * time.Millisecond
+
+	desired, err := edgeos.Renderer{}.Render(testConfigData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := router.Running().String()
+
+	// The change cuts the router off, so it is never confirmed and rolls back
+	router.CutOffOnCommit()
+	client := &edgeos.SSHClient{Address: router.Addr, Username: "ubnt", Password: "secret"}
+	if _, err := client.Apply(ctx, desired); !errors.Is(err, edgeos.ErrNotConfirmed) {
+		t.Fatalf("Expected %v, got %v", edgeos.ErrNotConfirmed, err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for router.Running().String() != before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if running := router.Running().String(); running != before {
+		t.Errorf("Expected the router to roll back, got:\n%s", running)
+	}
+	if saved := router.Saved(); saved != before {
+		t.Errorf("Expected the saved config to be unchanged, got:\n%s", saved)
+	}
+}
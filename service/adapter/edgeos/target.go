@@ -0,0 +1,310 @@
+package edgeos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service/controller"
+	"github.com/jacobalberty/beenfar/service/driver"
+	"github.com/jacobalberty/beenfar/service/event"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// Name of the file in the data directory holding the ssh targets
+const targetsFile = "edgeos.json"
+
+// How long reading or applying a configuration may take, including the confirming connection
+const sshTimeout = 2 * time.Minute
+
+// SSHTarget is how a router is reached over SSH to apply its configuration
+type SSHTarget struct {
+	Mac string `jsonapi:"primary,edgeos_ssh"`
+	// Address is host:port of the ssh server such as 192.168.1.1:22
+	Address  string `jsonapi:"attr,address"`
+	Username string `jsonapi:"attr,username"`
+	Password string `jsonapi:"attr,password,omitempty" audit:"secret"`
+	// HostKey is the SHA256 fingerprint of the router, the first key presented is pinned if empty
+	HostKey string `jsonapi:"attr,host_key,omitempty"`
+}
+
+// Validate checks that the router can be logged in to
+func (t SSHTarget) Validate() error {
+	var errs model.ValidationErrors
+
+	if host, port, err := net.SplitHostPort(t.Address); err != nil || host == "" || port == "" {
+		errs.Add("address", "address must be a host and port such as 192.168.1.1:22")
+	}
+	if t.Username == "" {
+		errs.Add("username", "username must not be empty")
+	}
+	if t.Password == "" {
+		errs.Add("password", "password must not be empty")
+	}
+	if t.HostKey != "" && !strings.HasPrefix(t.HostKey, "SHA256:") {
+		errs.Add("host_key", "host_key must be a SHA256 fingerprint such as SHA256:jCZc...")
+	}
+	return errs.Err()
+}
+
+// savedTarget is an SSHTarget as kept in memory and in the data directory, the password is sealed
+type savedTarget struct {
+	Mac            string `json:"mac"`
+	Address        string `json:"address"`
+	Username       string `json:"username"`
+	SealedPassword string `json:"sealed_password"`
+	HostKey        string `json:"host_key,omitempty"`
+}
+
+// public returns the target without its password
+func (t savedTarget) public() SSHTarget {
+	return SSHTarget{Mac: t.Mac, Address: t.Address, Username: t.Username, HostKey: t.HostKey}
+}
+
+// target is a router configured over SSH, connections to it are serialized
+type target struct {
+	savedTarget
+
+	mu sync.Mutex
+}
+
+// withClient runs fn with a client logged in as the target, a host key pinned by fn is saved
+func (h *Driver) withClient(t *target, fn func(*SSHClient) error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	password, err := h.secrets.Open(t.SealedPassword)
+	if err != nil {
+		return err
+	}
+	client := &SSHClient{Address: t.Address, Username: t.Username, Password: password, HostKey: t.HostKey}
+	err = fn(client)
+
+	if client.HostKey != t.HostKey {
+		h.mu.Lock()
+		t.HostKey = client.HostKey
+		saveErr := h.save()
+		h.mu.Unlock()
+		h.logger.ForDevice(t.Mac).Info("pinned ssh host key", "host_key", client.HostKey)
+		if saveErr != nil {
+			h.logger.Error("error saving ssh targets", "error", saveErr)
+		}
+	}
+	return err
+}
+
+func (h *Driver) target(mac string) (*target, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	t, ok := h.targets[mac]
+	return t, ok
+}
+
+// load reads the ssh targets from the data directory, their routers are saved as pending
+func (h *Driver) load() error {
+	if h.dataDir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(h.dataDir, targetsFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var saved []savedTarget
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("%s: %w", targetsFile, err)
+	}
+	for _, t := range saved {
+		h.targets[t.Mac] = &target{savedTarget: t}
+		d := model.Device{Driver: DriverName}
+		d.Init(Device{mac: t.Mac})
+		h.devices.SavePending(d)
+	}
+	return nil
+}
+
+// save writes the ssh targets to the data directory, h.mu must be held
+func (h *Driver) save() error {
+	if h.dataDir == "" {
+		return nil
+	}
+	saved := make([]savedTarget, 0, len(h.targets))
+	for _, t := range h.targets {
+		saved = append(saved, t.savedTarget)
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(h.dataDir, 0o700); err != nil {
+		return err
+	}
+	path := filepath.Join(h.dataDir, targetsFile)
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Returns the ssh target of a router, the password is not included
+func (h *Driver) GetTarget(w http.ResponseWriter, r *http.Request) {
+	mac := strings.ToLower(chi.URLParam(r, "mac"))
+	t, ok := h.target(mac)
+	if !ok {
+		controller.WriteError(w, http.StatusNotFound, "SSH Target Not Found", "Router "+mac+" has no ssh target")
+		return
+	}
+
+	t.mu.Lock()
+	response := t.public()
+	t.mu.Unlock()
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &response); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+	}
+}
+
+// Sets the ssh target of a router, unknown routers are saved as pending.
+// The password is encrypted before it is kept.
+func (h *Driver) PutTarget(w http.ResponseWriter, r *http.Request) {
+	mac := strings.ToLower(chi.URLParam(r, "mac"))
+	request := new(SSHTarget)
+	if err := jsonapi.UnmarshalPayload(r.Body, request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Mac != "" && strings.ToLower(request.Mac) != mac {
+		controller.WriteError(w, http.StatusConflict, "SSH Target ID Mismatch", "SSH target "+request.Mac+" does not match "+mac)
+		return
+	}
+	request.Mac = mac
+	if err := request.Validate(); err != nil {
+		controller.WriteValidationErrors(w, "Invalid SSH Target", err)
+		return
+	}
+	if !h.claim(w, mac) {
+		return
+	}
+
+	sealed, err := h.secrets.Seal(request.Password)
+	if err != nil {
+		controller.WriteError(w, http.StatusInternalServerError, "Error Encrypting Password", err.Error())
+		return
+	}
+	t := &target{savedTarget: savedTarget{
+		Mac:            mac,
+		Address:        request.Address,
+		Username:       request.Username,
+		SealedPassword: sealed,
+		HostKey:        request.HostKey,
+	}}
+
+	var before any
+	h.mu.Lock()
+	if current, ok := h.targets[mac]; ok {
+		before = current.public()
+	}
+	h.targets[mac] = t
+	err = h.save()
+	h.mu.Unlock()
+	if err != nil {
+		controller.WriteError(w, http.StatusInternalServerError, "Error Saving SSH Target", err.Error())
+		return
+	}
+	controller.AuditRequest(h.audit, r, "edgeos.ssh.update", "device/"+mac, before, *request)
+
+	response := t.public()
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &response); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+	}
+}
+
+// Removes the ssh target of a router along with its password
+func (h *Driver) DeleteTarget(w http.ResponseWriter, r *http.Request) {
+	mac := strings.ToLower(chi.URLParam(r, "mac"))
+	h.mu.Lock()
+	t, ok := h.targets[mac]
+	delete(h.targets, mac)
+	err := h.save()
+	h.mu.Unlock()
+	if !ok {
+		controller.WriteError(w, http.StatusNotFound, "SSH Target Not Found", "Router "+mac+" has no ssh target")
+		return
+	}
+	if err != nil {
+		controller.WriteError(w, http.StatusInternalServerError, "Error Saving SSH Targets", err.Error())
+		return
+	}
+
+	controller.AuditRequest(h.audit, r, "edgeos.ssh.delete", "device/"+mac, t.public(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// claim saves an unknown router as pending. If the MAC belongs to another driver
+// an error response is written and false is returned.
+func (h *Driver) claim(w http.ResponseWriter, mac string) bool {
+	device, err := h.devices.Get(mac)
+	switch {
+	case errors.Is(err, model.ErrDeviceNotFound):
+		d := model.Device{Driver: DriverName}
+		d.Init(Device{mac: mac})
+		if h.devices.SavePending(d) {
+			h.events.Publish(event.DevicePending, "device/"+mac, nil)
+		}
+	case device.Driver != DriverName:
+		controller.WriteError(w, http.StatusConflict, "Device Managed By Another Driver", "Device "+mac+" is managed by the "+device.Driver+" driver")
+		return false
+	}
+	return true
+}
+
+// Command applies the rendered configuration to a router with an ssh target
+func (h *Driver) Command(d model.Device, c driver.Command) error {
+	if c != driver.CommandProvision {
+		return fmt.Errorf("%w: %s", driver.ErrNotSupported, c)
+	}
+	t, ok := h.target(d.GetMac())
+	if !ok {
+		return fmt.Errorf("%w: %s has no ssh target", driver.ErrNotSupported, d.GetMac())
+	}
+	if !h.devices.IsAdopted(t.Mac) {
+		return fmt.Errorf("%w: %s", model.ErrDeviceNotFound, t.Mac)
+	}
+
+	cd, err := h.sites.ConfigData(d.Site)
+	if err != nil {
+		return err
+	}
+	desired, err := h.renderer.Render(cd)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sshTimeout)
+	defer cancel()
+
+	return h.withClient(t, func(client *SSHClient) error {
+		commands, err := client.Apply(ctx, desired)
+		if err != nil {
+			return err
+		}
+		h.logger.ForDevice(t.Mac).Info("applied config", "commands", len(commands))
+		return nil
+	})
+}
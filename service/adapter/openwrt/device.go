@@ -0,0 +1,22 @@
+package openwrt
+
+// Device is an OpenWRT router known from its agent
+type Device struct {
+	mac string
+}
+
+func (od Device) GetMac() string {
+	return od.mac
+}
+
+func (od Device) Adopt() error {
+	return nil
+}
+
+func (od Device) Delete() error {
+	return nil
+}
+
+func (od Device) Refresh() {
+
+}
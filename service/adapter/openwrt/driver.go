@@ -0,0 +1,209 @@
+// Package openwrt manages OpenWRT routers, either through an agent that pulls UCI configuration
+// or by pushing it over the JSON-RPC api of rpcd
+package openwrt
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jacobalberty/beenfar/service/controller"
+	"github.com/jacobalberty/beenfar/service/driver"
+	"github.com/jacobalberty/beenfar/service/event"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// Name of the OpenWRT driver
+const DriverName = "openwrt"
+
+// Shortest key an agent may identify itself with
+const MinAgentKeyLength = 16
+
+// Config holds the settings of the OpenWRT driver
+type Config struct {
+	// Uplink is the port wired networks are bridged to, DefaultUplink if empty
+	Uplink string
+}
+
+// Driver adopts OpenWRT routers that poll for their configuration or have it pushed.
+//
+// The agent generates a key on first start and sends it with every poll. The key is
+// pinned when the router first shows up, adopting the router trusts that key.
+// Routers with an rpcd target are pushed to instead, see RPCTarget.
+type Driver struct {
+	renderer       Renderer
+	informInterval time.Duration
+	sites          *model.Sites
+	devices        *model.Devices
+	audit          *model.AuditLog
+	events         *event.Bus
+	logger         *logging.Logger
+
+	// SHA-256 of the key of every agent by MAC
+	agents  map[string][sha256.Size]byte
+	targets map[string]*target
+	mu      sync.RWMutex
+}
+
+func NewDriver(config Config) *Driver {
+	return &Driver{
+		renderer: Renderer{Uplink: config.Uplink},
+		agents:   make(map[string][sha256.Size]byte),
+		targets:  make(map[string]*target),
+	}
+}
+
+func (h *Driver) Info() driver.Info {
+	return driver.Info{
+		Name:         DriverName,
+		Discovery:    driver.DiscoveryInform,
+		Capabilities: []driver.Capability{driver.CapabilityWifi},
+		Commands:     []driver.Command{driver.CommandProvision, driver.CommandRefresh},
+	}
+}
+
+func (h *Driver) Init(deps driver.Deps, routers driver.Routers) error {
+	h.informInterval = deps.InformInterval
+	h.sites = deps.Sites
+	h.devices = deps.Devices
+	h.audit = deps.Audit
+	h.events = deps.Events
+	h.logger = deps.Logger
+
+	// The bundle holds the wifi keys so it is only served over TLS
+	routers[driver.ListenerAgent].Get("/openwrt/{mac:^[[:xdigit:]]{12}$}/config", h.GetAgentConfig)
+
+	admin := routers[driver.ListenerAPI].With(controller.RequireRole(model.RoleAdmin))
+	admin.Get("/api/openwrt/{mac:^[[:xdigit:]]{12}$}/rpc", h.GetTarget)
+	admin.Put("/api/openwrt/{mac:^[[:xdigit:]]{12}$}/rpc", h.PutTarget)
+	admin.Delete("/api/openwrt/{mac:^[[:xdigit:]]{12}$}/rpc", h.DeleteTarget)
+	return nil
+}
+
+// Render returns every UCI package, the output can be loaded with uci import
+func (h *Driver) Render(d model.Device) ([]byte, error) {
+	cd, err := h.sites.ConfigData(d.Site)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := h.renderer.Render(cd)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(bundle.String()), nil
+}
+
+// AgentConfig is the response to an agent poll
+type AgentConfig struct {
+	Bundle
+	// Interval is how often the agent polls, in seconds
+	Interval int64 `json:"interval"`
+}
+
+// Returns the configuration bundle of an adopted router.
+//
+// The agent authenticates with "Authorization: Bearer <key>". Unknown routers are saved as
+// pending and get a 404 until they are adopted. The bundle version is the ETag, polls with
+// a matching If-None-Match get a 304.
+func (h *Driver) GetAgentConfig(w http.ResponseWriter, r *http.Request) {
+	mac := strings.ToLower(chi.URLParam(r, "mac"))
+	logger := logging.FromContext(r.Context()).ForDevice(mac)
+
+	key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if len(key) < MinAgentKeyLength {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, fmt.Sprintf("agent key of at least %d characters required", MinAgentKeyLength), http.StatusUnauthorized)
+		return
+	}
+	hash := sha256.Sum256([]byte(key))
+
+	device, err := h.devices.Get(mac)
+	switch {
+	case errors.Is(err, model.ErrDeviceNotFound):
+		h.announce(w, r, logger, mac, hash)
+		return
+	case device.Driver != DriverName:
+		http.Error(w, "device is managed by the "+device.Driver+" driver", http.StatusConflict)
+		return
+	case !h.verify(mac, hash):
+		logger.Warn("agent key mismatch", "remote", controller.SourceIP(r))
+		http.Error(w, "agent key does not match", http.StatusForbidden)
+		return
+	case !h.devices.IsAdopted(mac):
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+
+	if h.devices.Seen(mac) {
+		logger.Info("device online")
+		h.events.Publish(event.DeviceOnline, "device/"+mac, nil)
+	}
+
+	cd, err := h.sites.ConfigData(device.Site)
+	if err != nil {
+		logger.Error("error rendering config", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bundle, err := h.renderer.Render(cd)
+	if err != nil {
+		logger.Error("error rendering config", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	etag := `"` + bundle.Version + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(AgentConfig{Bundle: bundle, Interval: int64(h.informInterval / time.Second)}); err != nil {
+		logger.Warn("error writing response", "error", err)
+	}
+}
+
+// announce pins the key of a new router and saves it as pending
+func (h *Driver) announce(w http.ResponseWriter, r *http.Request, logger *logging.Logger, mac string, hash [sha256.Size]byte) {
+	d := model.Device{Driver: DriverName}
+	d.Init(Device{mac: mac})
+
+	h.mu.Lock()
+	saved := h.devices.SavePending(d)
+	if saved {
+		h.agents[mac] = hash
+	}
+	h.mu.Unlock()
+
+	switch {
+	case saved:
+		logger.Info("new adoption request", "remote", controller.SourceIP(r))
+		controller.AuditAs(h.audit, r, mac, "device.pending", "device/"+mac, nil, nil)
+		h.events.Publish(event.DevicePending, "device/"+mac, nil)
+	case !h.verify(mac, hash):
+		// Announced by another poll in the meantime
+		http.Error(w, "agent key does not match", http.StatusForbidden)
+		return
+	}
+	http.Error(w, "", http.StatusNotFound)
+}
+
+// verify checks the key of a known router against the one it was announced with
+func (h *Driver) verify(mac string, hash [sha256.Size]byte) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	pinned, ok := h.agents[mac]
+	return ok && subtle.ConstantTimeCompare(pinned[:], hash[:]) == 1
+}
@@ -0,0 +1,152 @@
+package openwrt
+
+import (
+	"net"
+	"strings"
+
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// Zone of the stock OpenWRT configuration facing the internet
+const WANZone = "wan"
+
+// firewall adds a zone per network forwarding to the WAN and to the other networks, followed by
+// the rules in order, the port forwards and the nat rules.
+//
+// Groups are expanded into the addresses and ports of the rules using them. Disabled rules are
+// rendered with enabled 0 so merging the package turns them off.
+func (r Renderer) firewall(cd *model.ConfigData, networks []model.NetworkConfig) Package {
+	zones := map[string]string{model.ZoneWAN: WANZone}
+	subnets := make(map[string]string, len(networks))
+	for _, network := range networks {
+		zones[network.ID] = network.Name
+		if _, subnet, err := net.ParseCIDR(network.GatewayIPSubnet); err == nil {
+			subnets[network.ID] = subnet.String()
+		}
+	}
+	groups := make(map[string][]string)
+	for _, group := range cd.FirewallGroupList() {
+		groups[group.ID] = group.Members
+	}
+
+	p := Package{Name: "firewall"}
+	for _, network := range networks {
+		zone := Section{Type: "zone", Name: "zone_" + network.Name}
+		zone.Set("name", network.Name)
+		zone.Add("network", network.Name)
+		zone.Set("input", "ACCEPT")
+		zone.Set("output", "ACCEPT")
+		zone.Set("forward", "ACCEPT")
+		p.Sections = append(p.Sections, zone)
+
+		forwarding := Section{Type: "forwarding", Name: "forwarding_" + network.Name}
+		forwarding.Set("src", network.Name)
+		forwarding.Set("dest", WANZone)
+		p.Sections = append(p.Sections, forwarding)
+		for _, other := range networks {
+			if other.ID == network.ID {
+				continue
+			}
+			forwarding := Section{Type: "forwarding", Name: "forwarding_" + network.Name + "_" + other.Name}
+			forwarding.Set("src", network.Name)
+			forwarding.Set("dest", other.Name)
+			p.Sections = append(p.Sections, forwarding)
+		}
+	}
+
+	for _, rule := range cd.FirewallRuleList() {
+		src, srcOK := zones[rule.Source]
+		dest, destOK := zones[rule.Destination]
+		if !srcOK || !destOK && rule.Destination != model.ZoneLocal {
+			continue
+		}
+
+		s := Section{Type: "rule", Name: "rule_" + rule.ID}
+		s.Set("name", rule.Name)
+		s.Set("src", src)
+		s.Set("dest", dest)
+		s.Set("proto", uciProtocol(rule.Protocol))
+		s.Add("src_ip", addresses(rule.SourceAddress, groups[rule.SourceGroup])...)
+		s.Add("dest_ip", addresses(rule.DestinationAddress, groups[rule.DestinationGroup])...)
+		s.Set("dest_port", ports(rule.DestinationPort, groups[rule.DestinationPortGroup]))
+		s.Set("target", strings.ToUpper(rule.Action))
+		if rule.Log {
+			s.Set("log", "1")
+		}
+		if rule.Disabled {
+			s.Set("enabled", "0")
+		}
+		p.Sections = append(p.Sections, s)
+	}
+
+	for _, forward := range cd.PortForwardList() {
+		dest, ok := zones[string(forward.Network)]
+		if !ok {
+			continue
+		}
+		s := Section{Type: "redirect", Name: "redirect_" + forward.ID}
+		s.Set("name", forward.Name)
+		s.Set("target", "DNAT")
+		s.Set("src", WANZone)
+		s.Set("src_ip", forward.SourceAddress)
+		s.Set("src_dport", ports(forward.Port, nil))
+		s.Set("dest", dest)
+		s.Set("dest_ip", forward.ForwardAddress)
+		s.Set("dest_port", ports(forward.ForwardPort, nil))
+		s.Set("proto", uciProtocol(forward.Protocol))
+		if forward.Log {
+			s.Set("log", "1")
+		}
+		if forward.Disabled {
+			s.Set("enabled", "0")
+		}
+		p.Sections = append(p.Sections, s)
+	}
+
+	for _, rule := range cd.NATRuleList() {
+		subnet, ok := subnets[string(rule.Network)]
+		if !ok {
+			continue
+		}
+		s := Section{Type: "nat", Name: "nat_" + rule.ID}
+		s.Set("name", rule.Name)
+		s.Set("src", WANZone)
+		s.Set("src_ip", subnet)
+		s.Set("proto", "all")
+		if rule.Type == model.NATSource {
+			s.Set("target", "SNAT")
+			s.Set("snat_ip", rule.TranslationAddress)
+		} else {
+			s.Set("target", "MASQUERADE")
+		}
+		if rule.Disabled {
+			s.Set("enabled", "0")
+		}
+		p.Sections = append(p.Sections, s)
+	}
+	return p
+}
+
+// uciProtocol converts a protocol of the model to the names fw4 takes
+func uciProtocol(protocol string) string {
+	if protocol == model.ProtocolTCPUDP {
+		return "tcp udp"
+	}
+	return protocol
+}
+
+// addresses returns the address of a rule or the members of its group
+func addresses(address string, group []string) []string {
+	if address != "" {
+		return []string{address}
+	}
+	return group
+}
+
+// ports returns the ports of a rule or its group separated by spaces, ranges are written as 8000-8080
+func ports(port string, group []string) string {
+	if port != "" {
+		group = []string{port}
+	}
+	return strings.Join(group, " ")
+}
@@ -0,0 +1,273 @@
+// Package openwrttest provides a fake rpcd for testing the OpenWRT driver
+package openwrttest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Status codes of ubus used by the fake
+const (
+	statusOK               = 0
+	statusMethodNotFound   = 3
+	statusNotFound         = 4
+	statusPermissionDenied = 6
+)
+
+// A Section is a UCI section as stored by the fake
+type Section struct {
+	Type   string
+	Values map[string]any
+}
+
+// RPCD serves the ubus JSON-RPC api of rpcd at /ubus.
+//
+// UCI changes are staged per config until uci.commit, commands run by file.exec are only recorded.
+// The status methods return the exported fields, which may be changed while no call is in flight.
+type RPCD struct {
+	*httptest.Server
+	Username string
+	Password string
+
+	Board      map[string]any
+	Info       map[string]any
+	Interfaces []map[string]any
+	// Clients holds the associated stations of each wireless device
+	Clients map[string]int
+	// Leases are returned by luci-rpc.getDHCPLeases
+	Leases []map[string]any
+	// WithoutLuCI answers calls to luci-rpc with object not found, as routers without LuCI do
+	WithoutLuCI bool
+
+	sessions  map[string]bool
+	staged    map[string]map[string]Section
+	committed map[string]map[string]Section
+	commits   []string
+	execs     []string
+	mu        sync.Mutex
+}
+
+// NewRPCD starts a fake rpcd that accepts the given login
+func NewRPCD(username, password string) *RPCD {
+	rpcd := &RPCD{
+		Username: username,
+		Password: password,
+		Board: map[string]any{
+			"hostname": "OpenWrt",
+			"model":    "Test Router",
+			"release":  map[string]any{"description": "OpenWrt 23.05.0"},
+		},
+		Info: map[string]any{
+			"uptime": 3600,
+			"memory": map[string]any{"total": 1000, "free": 250},
+		},
+		Interfaces: []map[string]any{
+			{"interface": "lan", "up": true, "uptime": 3500},
+			{"interface": "wan", "up": false},
+		},
+		Clients: map[string]int{"phy0-ap0": 2, "phy1-ap0": 1},
+		Leases: []map[string]any{
+			{"expires": 3600, "hostname": "laptop", "macaddr": "00:11:22:33:44:55", "ipaddr": "192.168.1.100"},
+			{"expires": false, "macaddr": "00:11:22:33:44:66", "ipaddr": "192.168.1.11"},
+		},
+		sessions:  make(map[string]bool),
+		staged:    make(map[string]map[string]Section),
+		committed: make(map[string]map[string]Section),
+	}
+	rpcd.Server = httptest.NewServer(http.HandlerFunc(rpcd.serve))
+	return rpcd
+}
+
+// UbusURL is the URL the driver is given as rpcd target
+func (d *RPCD) UbusURL() string {
+	return d.URL + "/ubus"
+}
+
+// Config returns the committed sections of a config by name
+func (d *RPCD) Config(config string) map[string]Section {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sections := make(map[string]Section, len(d.committed[config]))
+	for name, s := range d.committed[config] {
+		sections[name] = s
+	}
+	return sections
+}
+
+// Commits returns the configs committed so far in order
+func (d *RPCD) Commits() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string(nil), d.commits...)
+}
+
+// Execs returns the commands run through file.exec so far in order
+func (d *RPCD) Execs() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string(nil), d.execs...)
+}
+
+// SetPassword changes the password accepted by session.login
+func (d *RPCD) SetPassword(password string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.Password = password
+}
+
+// ExpireSessions logs out every client, their next call is denied
+func (d *RPCD) ExpireSessions() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sessions = make(map[string]bool)
+}
+
+type request struct {
+	ID     int               `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+func (d *RPCD) serve(w http.ResponseWriter, r *http.Request) {
+	var (
+		req                     request
+		session, object, method string
+		args                    map[string]any
+	)
+	if r.URL.Path != "/ubus" || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != "call" || len(req.Params) != 4 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	json.Unmarshal(req.Params[0], &session)
+	json.Unmarshal(req.Params[1], &object)
+	json.Unmarshal(req.Params[2], &method)
+	json.Unmarshal(req.Params[3], &args)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	response := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+	if object == "session" && method == "login" {
+		response["result"] = d.login(args)
+	} else if !d.sessions[session] {
+		response["error"] = map[string]any{"code": -32002, "message": "Access denied"}
+	} else if object == "luci-rpc" && d.WithoutLuCI {
+		response["error"] = map[string]any{"code": -32000, "message": "Object not found"}
+	} else {
+		response["result"] = d.call(object+"."+method, args)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (d *RPCD) login(args map[string]any) []any {
+	if args["username"] != d.Username || args["password"] != d.Password {
+		return []any{statusPermissionDenied}
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	session := hex.EncodeToString(b)
+	d.sessions[session] = true
+	return []any{statusOK, map[string]any{"ubus_rpc_session": session}}
+}
+
+func (d *RPCD) call(method string, args map[string]any) []any {
+	config, _ := args["config"].(string)
+	section, _ := args["section"].(string)
+
+	switch method {
+	case "uci.add":
+		name, _ := args["name"].(string)
+		typ, _ := args["type"].(string)
+		d.stage(config)[name] = Section{Type: typ, Values: make(map[string]any)}
+		return []any{statusOK, map[string]any{"section": name}}
+	case "uci.set":
+		s, ok := d.stage(config)[section]
+		if !ok {
+			return []any{statusNotFound}
+		}
+		values, _ := args["values"].(map[string]any)
+		for k, v := range values {
+			s.Values[k] = v
+		}
+		return []any{statusOK}
+	case "uci.delete":
+		if _, ok := d.stage(config)[section]; !ok {
+			return []any{statusNotFound}
+		}
+		delete(d.stage(config), section)
+		return []any{statusOK}
+	case "uci.get":
+		values := make(map[string]any, len(d.stage(config)))
+		for name, s := range d.stage(config) {
+			v := map[string]any{".type": s.Type, ".name": name}
+			for k, value := range s.Values {
+				v[k] = value
+			}
+			values[name] = v
+		}
+		return []any{statusOK, map[string]any{"values": values}}
+	case "uci.commit":
+		d.committed[config] = d.stage(config)
+		delete(d.staged, config)
+		d.commits = append(d.commits, config)
+		return []any{statusOK}
+	case "file.exec":
+		command, _ := args["command"].(string)
+		d.execs = append(d.execs, command)
+		return []any{statusOK, map[string]any{"code": 0}}
+	case "system.board":
+		return []any{statusOK, d.Board}
+	case "system.info":
+		return []any{statusOK, d.Info}
+	case "network.interface.dump":
+		return []any{statusOK, map[string]any{"interface": d.Interfaces}}
+	case "iwinfo.devices":
+		devices := make([]string, 0, len(d.Clients))
+		for device := range d.Clients {
+			devices = append(devices, device)
+		}
+		return []any{statusOK, map[string]any{"devices": devices}}
+	case "iwinfo.assoclist":
+		device, _ := args["device"].(string)
+		results := make([]map[string]any, d.Clients[device])
+		for i := range results {
+			results[i] = map[string]any{"signal": -50}
+		}
+		return []any{statusOK, map[string]any{"results": results}}
+	case "luci-rpc.getDHCPLeases":
+		return []any{statusOK, map[string]any{"dhcp_leases": d.Leases}}
+	}
+	return []any{statusMethodNotFound}
+}
+
+// stage returns the staged sections of a config, starting from the committed ones
+func (d *RPCD) stage(config string) map[string]Section {
+	if s, ok := d.staged[config]; ok {
+		return s
+	}
+	s := make(map[string]Section, len(d.committed[config]))
+	for name, section := range d.committed[config] {
+		values := make(map[string]any, len(section.Values))
+		for k, v := range section.Values {
+			values[k] = v
+		}
+		s[name] = Section{Type: section.Type, Values: values}
+	}
+	d.staged[config] = s
+	return s
+}
@@ -0,0 +1,191 @@
+package openwrt
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// ReloadCommand is run through file.exec after committing, it reloads the services whose configuration changed
+const ReloadCommand = "/sbin/reload_config"
+
+// ManagedOption tags the sections written by Push so they can be told apart from those set up on the router
+const ManagedOption = "beenfar"
+
+// Push writes the packages through rpcd and reloads the services using them.
+// Managed sections are replaced as a whole so options removed from the configuration are removed from the router,
+// managed sections that are no longer rendered, such as those of deleted wifi networks, are deleted.
+func Push(ctx context.Context, c *RPCClient, packages []Package) error {
+	for _, p := range packages {
+		if err := deleteStale(ctx, c, p); err != nil {
+			return err
+		}
+		for _, s := range p.Sections {
+			err := c.Call(ctx, "uci", "delete", map[string]string{"config": p.Name, "section": s.Name}, nil)
+			if err != nil && !errors.Is(err, UbusNotFound) {
+				return err
+			}
+			if err := c.Call(ctx, "uci", "add", map[string]string{"config": p.Name, "type": s.Type, "name": s.Name}, nil); err != nil {
+				return err
+			}
+			values := s.values()
+			values[ManagedOption] = "1"
+			if err := c.Call(ctx, "uci", "set", map[string]any{"config": p.Name, "section": s.Name, "values": values}, nil); err != nil {
+				return err
+			}
+		}
+		if err := c.Call(ctx, "uci", "commit", map[string]string{"config": p.Name}, nil); err != nil {
+			return err
+		}
+	}
+	return c.Call(ctx, "file", "exec", map[string]string{"command": ReloadCommand}, nil)
+}
+
+// deleteStale deletes the sections of the package on the router that are tagged as managed but no longer rendered
+func deleteStale(ctx context.Context, c *RPCClient, p Package) error {
+	var current struct {
+		Values map[string]map[string]any `json:"values"`
+	}
+	err := c.Call(ctx, "uci", "get", map[string]string{"config": p.Name}, &current)
+	if errors.Is(err, UbusNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	rendered := make(map[string]bool, len(p.Sections))
+	for _, s := range p.Sections {
+		rendered[s.Name] = true
+	}
+	var stale []string
+	for name, values := range current.Values {
+		if values[ManagedOption] == "1" && !rendered[name] {
+			stale = append(stale, name)
+		}
+	}
+	sort.Strings(stale)
+
+	for _, name := range stale {
+		err := c.Call(ctx, "uci", "delete", map[string]string{"config": p.Name, "section": name}, nil)
+		if err != nil && !errors.Is(err, UbusNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// values converts the options to the values of uci.set, lists are arrays
+func (s Section) values() map[string]any {
+	values := make(map[string]any, len(s.Options))
+	for _, option := range s.Options {
+		switch {
+		case option.List:
+			values[option.Name] = option.Values
+		case len(option.Values) != 0:
+			values[option.Name] = option.Values[0]
+		}
+	}
+	return values
+}
+
+type boardInfo struct {
+	Hostname string `json:"hostname"`
+	Model    string `json:"model"`
+	Release  struct {
+		Description string `json:"description"`
+	} `json:"release"`
+}
+
+type systemInfo struct {
+	Uptime int64 `json:"uptime"`
+	Memory struct {
+		Total uint64 `json:"total"`
+		Free  uint64 `json:"free"`
+	} `json:"memory"`
+}
+
+type interfaceDump struct {
+	Interface []struct {
+		Interface string `json:"interface"`
+		Up        bool   `json:"up"`
+		Uptime    int64  `json:"uptime"`
+	} `json:"interface"`
+}
+
+type dhcpLeases struct {
+	Leases []struct {
+		// Expires is the number of seconds left, false for static leases
+		Expires  any    `json:"expires"`
+		Hostname string `json:"hostname"`
+		MAC      string `json:"macaddr"`
+		IP       string `json:"ipaddr"`
+	} `json:"dhcp_leases"`
+}
+
+// Status reads the board, system, interface, wireless and dhcp lease state of a router through rpcd.
+// Routers without luci-rpc report no leases.
+func Status(ctx context.Context, c *RPCClient) (model.DeviceInfo, model.DeviceStats, error) {
+	var (
+		board   boardInfo
+		system  systemInfo
+		dump    interfaceDump
+		devices struct {
+			Devices []string `json:"devices"`
+		}
+		stats model.DeviceStats
+	)
+
+	if err := c.Call(ctx, "system", "board", nil, &board); err != nil {
+		return model.DeviceInfo{}, stats, err
+	}
+	info := model.DeviceInfo{Hostname: board.Hostname, Model: board.Model, Firmware: board.Release.Description}
+
+	if err := c.Call(ctx, "system", "info", nil, &system); err != nil {
+		return info, stats, err
+	}
+	stats.Uptime = system.Uptime
+	if system.Memory.Total != 0 {
+		stats.Memory = float64(system.Memory.Total-system.Memory.Free) / float64(system.Memory.Total)
+	}
+
+	if err := c.Call(ctx, "network.interface", "dump", nil, &dump); err != nil {
+		return info, stats, err
+	}
+	for _, iface := range dump.Interface {
+		stats.Interfaces = append(stats.Interfaces, model.InterfaceStats{Name: iface.Interface, Up: iface.Up, Uptime: iface.Uptime})
+	}
+
+	if err := c.Call(ctx, "iwinfo", "devices", nil, &devices); err != nil {
+		return info, stats, err
+	}
+	for _, device := range devices.Devices {
+		var assoc struct {
+			Results []struct{} `json:"results"`
+		}
+		if err := c.Call(ctx, "iwinfo", "assoclist", map[string]string{"device": device}, &assoc); err != nil {
+			return info, stats, err
+		}
+		stats.Radios = append(stats.Radios, model.RadioStats{Name: device, Clients: len(assoc.Results)})
+		stats.Clients += len(assoc.Results)
+	}
+
+	// luci-rpc is only there with LuCI installed, routers without it have no leases
+	var leases dhcpLeases
+	err := c.Call(ctx, "luci-rpc", "getDHCPLeases", nil, &leases)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) && !errors.Is(err, UbusMethodNotFound) {
+		return info, stats, err
+	}
+	now := time.Now().Unix()
+	for _, lease := range leases.Leases {
+		l := model.DHCPLease{MAC: lease.MAC, IP: lease.IP, Hostname: lease.Hostname}
+		if seconds, ok := lease.Expires.(float64); ok && seconds > 0 {
+			l.Expires = now + int64(seconds)
+		}
+		stats.Leases = append(stats.Leases, l)
+	}
+
+	return info, stats, nil
+}
@@ -0,0 +1,255 @@
+package openwrt
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+var (
+	ErrUnknownNetwork = errors.New("wifi network is bridged into an unknown network")
+)
+
+// Packages are the files in /etc/config that are rendered, in the order they are applied
+var Packages = []string{"network", "wireless", "dhcp", "firewall"}
+
+// Interface wifi networks are bridged into when they have no network
+const DefaultNetwork = "lan"
+
+// Port wired networks are bridged to unless set in Renderer
+const DefaultUplink = "eth0"
+
+// Radios of the usual OpenWRT layout, radio0 is 2.4GHz and radio1 is 5GHz
+const (
+	Radio2G = "radio0"
+	Radio5G = "radio1"
+)
+
+// Bundle is the configuration pulled by the agent
+type Bundle struct {
+	// Version changes whenever any of the files do
+	Version string `json:"version"`
+	// Files holds each package in the format of uci export by name
+	Files map[string]string `json:"files"`
+}
+
+// String concatenates the packages, the result can be loaded with uci import
+func (b Bundle) String() string {
+	var sb strings.Builder
+	for i, name := range Packages {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		sb.WriteString(b.Files[name])
+	}
+	return sb.String()
+}
+
+// Renderer turns the configuration data into UCI packages.
+//
+// Only named sections managed by beenfar are rendered, the agent merges them into the
+// existing configuration so radios, the WAN, its firewall zone and anything else set up on the
+// device are kept.
+type Renderer struct {
+	// Uplink is the port wired networks are bridged to, VLANs are tagged on it
+	Uplink string
+}
+
+// Packages renders the packages in the order they are applied
+func (r Renderer) Packages(cd *model.ConfigData) ([]Package, error) {
+	networks := cd.NetworkList()
+	wireless, err := r.wireless(cd.WifiNetworkList(), networks)
+	if err != nil {
+		return nil, err
+	}
+	return []Package{r.network(networks), wireless, r.dhcp(cd, networks), r.firewall(cd, networks)}, nil
+}
+
+// Render renders the packages into the bundle pulled by the agent
+func (r Renderer) Render(cd *model.ConfigData) (Bundle, error) {
+	packages, err := r.Packages(cd)
+	if err != nil {
+		return Bundle{}, err
+	}
+	return NewBundle(packages)
+}
+
+func NewBundle(packages []Package) (Bundle, error) {
+	bundle := Bundle{Files: make(map[string]string, len(packages))}
+	hash := sha256.New()
+	for _, p := range packages {
+		content, err := p.String()
+		if err != nil {
+			return Bundle{}, err
+		}
+		bundle.Files[p.Name] = content
+		hash.Write([]byte(content))
+	}
+	bundle.Version = hex.EncodeToString(hash.Sum(nil))[:16]
+	return bundle, nil
+}
+
+// network bridges every network to the uplink, tagged with its VLAN if it has one
+func (r Renderer) network(networks []model.NetworkConfig) Package {
+	uplink := r.Uplink
+	if uplink == "" {
+		uplink = DefaultUplink
+	}
+
+	p := Package{Name: "network"}
+	for _, network := range networks {
+		port := uplink
+		if network.Vlan != 0 {
+			port = uplink + "." + strconv.Itoa(network.Vlan)
+		}
+		bridge := Section{Type: "device", Name: "br_" + network.Name}
+		bridge.Set("name", "br-"+network.Name)
+		bridge.Set("type", "bridge")
+		bridge.Add("ports", port)
+
+		iface := Section{Type: "interface", Name: network.Name}
+		iface.Set("device", "br-"+network.Name)
+		iface.Set("proto", "static")
+		if ip, subnet, err := net.ParseCIDR(network.GatewayIPSubnet); err == nil {
+			iface.Set("ipaddr", ip.String())
+			iface.Set("netmask", net.IP(subnet.Mask).String())
+		}
+
+		p.Sections = append(p.Sections, bridge, iface)
+	}
+	return p
+}
+
+// wireless adds an access point per wifi network and radio.
+// WPA enterprise networks are left out until radius profiles can be rendered.
+func (r Renderer) wireless(wifis []model.WifiNetworkConfig, networks []model.NetworkConfig) (Package, error) {
+	names := make(map[model.NetworkID]string, len(networks))
+	for _, network := range networks {
+		names[model.NetworkID(network.ID)] = network.Name
+	}
+
+	p := Package{Name: "wireless"}
+	for _, wifi := range wifis {
+		network := DefaultNetwork
+		if wifi.Network != "" {
+			var ok bool
+			if network, ok = names[wifi.Network]; !ok {
+				return Package{}, fmt.Errorf("%w: %s", ErrUnknownNetwork, wifi.Ssid)
+			}
+		}
+
+		var radios []string
+		switch wifi.Band {
+		case model.WifiBand2G:
+			radios = []string{Radio2G}
+		case model.WifiBand5G:
+			radios = []string{Radio5G}
+		default:
+			radios = []string{Radio2G, Radio5G}
+		}
+
+		for _, radio := range radios {
+			s := Section{Type: "wifi-iface", Name: "wifi_" + wifi.ID + "_" + radio}
+			s.Set("device", radio)
+			s.Set("mode", "ap")
+			s.Set("network", network)
+			s.Set("ssid", wifi.Ssid)
+
+			switch wifi.SecurityType {
+			case model.WifiSecurityTypeOpen:
+				s.Set("encryption", "none")
+			case model.WifiSecurityTypeWep:
+				s.Set("encryption", "wep-open")
+				s.Set("key", "1")
+				s.Set("key1", wepKey(wifi.SecurityKey))
+			case model.WifiSecurityTypeWpaPersonal:
+				s.Set("encryption", "psk2")
+				s.Set("key", wifi.SecurityKey)
+			default:
+				continue
+			}
+
+			if wifi.Hidden {
+				s.Set("hidden", "1")
+			}
+			if wifi.Guest {
+				s.Set("isolate", "1")
+			}
+			p.Sections = append(p.Sections, s)
+		}
+	}
+	return p, nil
+}
+
+// wepKey prefixes ASCII keys with s:, hex keys are used as they are
+func wepKey(key string) string {
+	switch len(key) {
+	case 5, 13:
+		return "s:" + key
+	}
+	return key
+}
+
+// dhcp serves the dhcp range of every network, networks without a dhcp server are ignored by dnsmasq.
+// Reservations are host sections, their hostnames are resolved by dnsmasq.
+func (r Renderer) dhcp(cd *model.ConfigData, networks []model.NetworkConfig) Package {
+	p := Package{Name: "dhcp"}
+	for _, network := range networks {
+		s := Section{Type: "dhcp", Name: network.Name}
+		s.Set("interface", network.Name)
+
+		dhcp := network.DHCPConfig
+		_, subnet, err := net.ParseCIDR(network.GatewayIPSubnet)
+		if dhcp.DHCPMode != model.DHCPModeServer || err != nil {
+			s.Set("ignore", "1")
+			p.Sections = append(p.Sections, s)
+			continue
+		}
+
+		base := ipv4Int(subnet.IP)
+		start, stop := ipv4Int(net.ParseIP(dhcp.DHCPStart)), ipv4Int(net.ParseIP(dhcp.DHCPStop))
+		s.Set("start", strconv.FormatUint(uint64(start-base), 10))
+		s.Set("limit", strconv.FormatUint(uint64(stop-start+1), 10))
+		if dhcp.DHCPLeaseTime > 0 {
+			s.Set("leasetime", strconv.Itoa(dhcp.DHCPLeaseTime))
+		}
+
+		if !dhcp.DHCPGateway.Auto && dhcp.DHCPGateway.Address != "" {
+			s.Add("dhcp_option", "3,"+dhcp.DHCPGateway.Address)
+		}
+		if !dhcp.DHCPNameServer.Auto && len(dhcp.DHCPNameServer.Addresses) != 0 {
+			s.Add("dhcp_option", "6,"+strings.Join(dhcp.DHCPNameServer.Addresses, ","))
+		}
+		if network.DomainName != "" {
+			s.Add("dhcp_option", "15,"+network.DomainName)
+		}
+		p.Sections = append(p.Sections, s)
+
+		for _, reservation := range cd.DHCPReservationList(model.NetworkID(network.ID)) {
+			host := Section{Type: "host", Name: "host_" + reservation.ID}
+			host.Set("name", reservation.Hostname)
+			host.Add("mac", reservation.MAC)
+			host.Set("ip", reservation.IP)
+			if reservation.Hostname != "" {
+				host.Set("dns", "1")
+			}
+			p.Sections = append(p.Sections, host)
+		}
+	}
+	return p
+}
+
+func ipv4Int(ip net.IP) uint32 {
+	ip = ip.To4()
+	if ip == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(ip)
+}
@@ -0,0 +1,166 @@
+package openwrt_test
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service/adapter/openwrt"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// testConfigData has fixed IDs so the rendered section names are stable
+func testConfigData() *model.ConfigData {
+	cd := model.NewConfigData()
+	cd.Networks = map[string]model.NetworkConfig{
+		"000000000000000000000001": {
+			ID:              "000000000000000000000001",
+			Name:            "lan",
+			GatewayIPSubnet: "192.168.1.1/24",
+			DomainName:      "home.example",
+			DHCPConfig: model.DHCPConfig{
+				DHCPMode:       model.DHCPModeServer,
+				DHCPStart:      "192.168.1.100",
+				DHCPStop:       "192.168.1.249",
+				DHCPLeaseTime:  86400,
+				DHCPNameServer: model.DHCPNameServer{Auto: true},
+				DHCPGateway:    model.DHCPGateway{Auto: true},
+			},
+		},
+		"000000000000000000000002": {
+			ID:              "000000000000000000000002",
+			Name:            "guest",
+			Purpose:         model.NetworkPurposeGuest,
+			Vlan:            20,
+			GatewayIPSubnet: "10.0.20.1/24",
+			DHCPConfig: model.DHCPConfig{
+				DHCPMode:       model.DHCPModeServer,
+				DHCPStart:      "10.0.20.10",
+				DHCPStop:       "10.0.20.50",
+				DHCPNameServer: model.DHCPNameServer{Addresses: []string{"1.1.1.1", "9.9.9.9"}},
+				DHCPGateway:    model.DHCPGateway{Address: "10.0.20.254"},
+			},
+		},
+		"000000000000000000000003": {
+			ID:              "000000000000000000000003",
+			Name:            "iot",
+			Vlan:            30,
+			GatewayIPSubnet: "10.0.30.1/25",
+		},
+	}
+	cd.WifiNetworks = map[string]model.WifiNetworkConfig{
+		"00000000000000000000000a": {
+			ID:           "00000000000000000000000a",
+			Ssid:         "Bob's home",
+			SecurityType: model.WifiSecurityTypeWpaPersonal,
+			SecurityKey:  "correct horse",
+		},
+		"00000000000000000000000b": {
+			ID:      "00000000000000000000000b",
+			Ssid:    "guest",
+			Band:    model.WifiBand2G,
+			Network: "000000000000000000000002",
+			Guest:   true,
+		},
+		"00000000000000000000000c": {
+			ID:           "00000000000000000000000c",
+			Ssid:         "sensors",
+			SecurityType: model.WifiSecurityTypeWep,
+			SecurityKey:  "abcde",
+			Band:         model.WifiBand2G,
+			Network:      "000000000000000000000003",
+			Hidden:       true,
+		},
+		"00000000000000000000000d": {
+			ID:            "00000000000000000000000d",
+			Ssid:          "corp",
+			SecurityType:  model.WifiSecurityTypeWpaEnterprise,
+			Band:          model.WifiBand5G,
+			RadiusProfile: 1,
+		},
+	}
+	cd.DHCPReservations = map[string]model.DHCPReservation{
+		"000000000000000000000051": {ID: "000000000000000000000051", Network: "000000000000000000000001", MAC: "00:11:22:33:44:55", IP: "192.168.1.10", Hostname: "nas"},
+		"000000000000000000000052": {ID: "000000000000000000000052", Network: "000000000000000000000001", MAC: "00:11:22:33:44:66", IP: "192.168.1.11"},
+	}
+	cd.FirewallGroups = map[string]model.FirewallGroup{
+		"000000000000000000000011": {ID: "000000000000000000000011", Name: "servers", Type: model.FirewallGroupAddress, Members: []string{"192.168.1.10", "192.168.1.11"}},
+		"000000000000000000000012": {ID: "000000000000000000000012", Name: "web", Type: model.FirewallGroupPort, Members: []string{"80", "443", "8000-8080"}},
+	}
+	cd.FirewallRules = map[string]model.FirewallRule{
+		"000000000000000000000021": {ID: "000000000000000000000021", Name: "guest to servers", Index: 1, Action: model.FirewallActionAccept, Protocol: model.ProtocolTCPUDP,
+			Source: "000000000000000000000002", Destination: "000000000000000000000001", DestinationGroup: "000000000000000000000011", DestinationPortGroup: "000000000000000000000012"},
+		"000000000000000000000022": {ID: "000000000000000000000022", Name: "guest isolation", Index: 2, Action: model.FirewallActionDrop, Protocol: model.ProtocolAll,
+			Source: "000000000000000000000002", Destination: "000000000000000000000001", Log: true},
+		"000000000000000000000023": {ID: "000000000000000000000023", Name: "no ssh from iot", Index: 3, Action: model.FirewallActionReject, Protocol: model.ProtocolTCP,
+			Source: "000000000000000000000003", Destination: model.ZoneLocal, DestinationPort: "22", Disabled: true},
+	}
+	cd.PortForwards = map[string]model.PortForward{
+		"000000000000000000000031": {ID: "000000000000000000000031", Name: "https", Protocol: model.ProtocolTCP, Port: "443",
+			Network: "000000000000000000000001", ForwardAddress: "192.168.1.10", ForwardPort: "8443"},
+	}
+	cd.NATRules = map[string]model.NATRule{
+		"000000000000000000000041": {ID: "000000000000000000000041", Name: "iot", Index: 1, Type: model.NATSource,
+			Network: "000000000000000000000003", TranslationAddress: "203.0.113.9"},
+	}
+	return cd
+}
+
+func TestRender(t *testing.T) {
+	bundle, err := openwrt.Renderer{}.Render(testConfigData())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range openwrt.Packages {
+		path := filepath.Join("testdata", name+".golden")
+		if *update {
+			if err := os.WriteFile(path, []byte(bundle.Files[name]), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		golden, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bundle.Files[name] != string(golden) {
+			t.Errorf("Expected %s to match %s, got:\n%s", name, path, bundle.Files[name])
+		}
+	}
+
+	again, err := openwrt.Renderer{}.Render(testConfigData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Version == "" || again.Version != bundle.Version {
+		t.Errorf("Expected the same version for the same config, got %q and %q", bundle.Version, again.Version)
+	}
+
+	changed, err := openwrt.Renderer{Uplink: "lan1"}.Render(testConfigData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Version == bundle.Version {
+		t.Error("Expected the version to change with the config")
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	cd := testConfigData()
+	wifi := cd.WifiNetworks["00000000000000000000000a"]
+	wifi.Ssid = "line\nbreak"
+	cd.WifiNetworks[wifi.ID] = wifi
+	if _, err := (openwrt.Renderer{}).Render(cd); !errors.Is(err, openwrt.ErrInvalidConfigValue) {
+		t.Errorf("Expected %v, got %v", openwrt.ErrInvalidConfigValue, err)
+	}
+
+	cd = testConfigData()
+	delete(cd.Networks, "000000000000000000000002")
+	if _, err := (openwrt.Renderer{}).Render(cd); !errors.Is(err, openwrt.ErrUnknownNetwork) {
+		t.Errorf("Expected %v, got %v", openwrt.ErrUnknownNetwork, err)
+	}
+}
@@ -0,0 +1,172 @@
+package openwrt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+var (
+	ErrAccessDenied = errors.New("rpcd access denied")
+	// ErrObjectNotFound is returned for calls to ubus objects that do not exist on the router
+	ErrObjectNotFound = errors.New("rpcd object not found")
+)
+
+// Session used to log in, every other call uses the session returned by session.login
+const anonymousSession = "00000000000000000000000000000000"
+
+// JSON-RPC error codes rpcd returns for expired or unknown sessions and unknown objects
+const (
+	accessDeniedCode   = -32002
+	objectNotFoundCode = -32000
+)
+
+// UbusStatus is the status code ubus returns for a failed call
+type UbusStatus int
+
+const (
+	UbusOK UbusStatus = iota
+	UbusInvalidCommand
+	UbusInvalidArgument
+	UbusMethodNotFound
+	UbusNotFound
+	UbusNoData
+	UbusPermissionDenied
+	UbusTimeout
+	UbusNotSupported
+	UbusUnknownError
+	UbusConnectionFailed
+)
+
+func (s UbusStatus) Error() string {
+	names := []string{"ok", "invalid command", "invalid argument", "method not found", "not found", "no data",
+		"permission denied", "timeout", "not supported", "unknown error", "connection failed"}
+	if s >= 0 && int(s) < len(names) {
+		return "ubus: " + names[s]
+	}
+	return fmt.Sprintf("ubus: status %d", int(s))
+}
+
+// RPCClient calls ubus objects through the JSON-RPC api of rpcd, usually served at http://<router>/ubus
+type RPCClient struct {
+	URL      string
+	Username string
+	Password string
+	Client   *http.Client
+
+	session string
+	id      int
+	mu      sync.Mutex
+}
+
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      int    `json:"id"`
+	Method  string `json:"method"`
+	Params  []any  `json:"params"`
+}
+
+type rpcResponse struct {
+	Result []json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Call calls method on a ubus object and decodes the data it returns into result, result may be nil.
+// The client logs in on the first call and again once the session expires.
+func (c *RPCClient) Call(ctx context.Context, object, method string, args, result any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.session == "" {
+		if err := c.login(ctx); err != nil {
+			return err
+		}
+	}
+
+	err := c.call(ctx, c.session, object, method, args, result)
+	if errors.Is(err, ErrAccessDenied) {
+		if err = c.login(ctx); err != nil {
+			return err
+		}
+		err = c.call(ctx, c.session, object, method, args, result)
+	}
+	return err
+}
+
+func (c *RPCClient) login(ctx context.Context) error {
+	var login struct {
+		Session string `json:"ubus_rpc_session"`
+	}
+	c.session = ""
+	if err := c.call(ctx, anonymousSession, "session", "login", map[string]string{"username": c.Username, "password": c.Password}, &login); err != nil {
+		return fmt.Errorf("logging in: %w", err)
+	}
+	c.session = login.Session
+	return nil
+}
+
+func (c *RPCClient) call(ctx context.Context, session, object, method string, args, result any) error {
+	if args == nil {
+		args = struct{}{}
+	}
+	c.id++
+	body, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: c.id, Method: "call", Params: []any{session, object, method, args}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s.%s: unexpected status %s", object, method, resp.Status)
+	}
+
+	var response rpcResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("%s.%s: %w", object, method, err)
+	}
+	switch {
+	case response.Error != nil && response.Error.Code == accessDeniedCode:
+		return fmt.Errorf("%s.%s: %w", object, method, ErrAccessDenied)
+	case response.Error != nil && response.Error.Code == objectNotFoundCode:
+		return fmt.Errorf("%s.%s: %w", object, method, ErrObjectNotFound)
+	case response.Error != nil:
+		return fmt.Errorf("%s.%s: %s", object, method, response.Error.Message)
+	case len(response.Result) == 0:
+		return fmt.Errorf("%s.%s: empty result", object, method)
+	}
+
+	var status UbusStatus
+	if err := json.Unmarshal(response.Result[0], &status); err != nil {
+		return fmt.Errorf("%s.%s: %w", object, method, err)
+	}
+	if status != UbusOK {
+		return fmt.Errorf("%s.%s: %w", object, method, status)
+	}
+	if result != nil && len(response.Result) > 1 {
+		if err := json.Unmarshal(response.Result[1], result); err != nil {
+			return fmt.Errorf("%s.%s: %w", object, method, err)
+		}
+	}
+	return nil
+}
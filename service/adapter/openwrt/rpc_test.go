@@ -0,0 +1,139 @@
+package openwrt_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service/adapter/openwrt"
+	"github.com/jacobalberty/beenfar/service/adapter/openwrt/openwrttest"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+const (
+	testUsername = "root"
+	testPassword = "secret"
+)
+
+func TestPush(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	rpcd := openwrttest.NewRPCD(testUsername, testPassword)
+	defer rpcd.Close()
+	client := &openwrt.RPCClient{URL: rpcd.UbusURL(), Username: testUsername, Password: testPassword}
+
+	cd := testConfigData()
+	packages, err := openwrt.Renderer{Uplink: "eth0"}.Packages(cd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := openwrt.Push(ctx, client, packages); err != nil {
+		t.Fatal(err)
+	}
+
+	if commits := rpcd.Commits(); !reflect.DeepEqual(commits, openwrt.Packages) {
+		t.Errorf("Expected commits %v, got %v", openwrt.Packages, commits)
+	}
+	if execs := rpcd.Execs(); !reflect.DeepEqual(execs, []string{openwrt.ReloadCommand}) {
+		t.Errorf("Expected execs %v, got %v", []string{openwrt.ReloadCommand}, execs)
+	}
+
+	section, ok := rpcd.Config("wireless")["wifi_00000000000000000000000c_radio0"]
+	if !ok {
+		t.Fatal("Expected wireless section wifi_00000000000000000000000c_radio0")
+	}
+	if section.Type != "wifi-iface" || section.Values["ssid"] != "sensors" || section.Values["hidden"] != "1" || section.Values[openwrt.ManagedOption] != "1" {
+		t.Errorf("Expected managed hidden wifi-iface sensors, got %+v", section)
+	}
+	ports, _ := rpcd.Config("network")["br_guest"].Values["ports"].([]any)
+	if !reflect.DeepEqual(ports, []any{"eth0.20"}) {
+		t.Errorf("Expected ports %v, got %v", []any{"eth0.20"}, ports)
+	}
+
+	// Sections set up on the router are not tagged as managed
+	if err := client.Call(ctx, "uci", "add", map[string]string{"config": "wireless", "type": "wifi-iface", "name": "default_radio0"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call(ctx, "uci", "commit", map[string]string{"config": "wireless"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sections are replaced, so options removed from the configuration are removed from the router
+	wifi := cd.WifiNetworks["00000000000000000000000c"]
+	wifi.Hidden = false
+	cd.WifiNetworks[wifi.ID] = wifi
+	// and managed sections that are no longer rendered are deleted
+	delete(cd.WifiNetworks, "00000000000000000000000a")
+	if packages, err = (openwrt.Renderer{Uplink: "eth0"}).Packages(cd); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client logs in again once its session expires
+	rpcd.ExpireSessions()
+	if err := openwrt.Push(ctx, client, packages); err != nil {
+		t.Fatal(err)
+	}
+	section = rpcd.Config("wireless")["wifi_00000000000000000000000c_radio0"]
+	if _, ok := section.Values["hidden"]; ok {
+		t.Errorf("Expected hidden to be removed, got %+v", section)
+	}
+	wireless := rpcd.Config("wireless")
+	for _, name := range []string{"wifi_00000000000000000000000a_radio0", "wifi_00000000000000000000000a_radio1"} {
+		if _, ok := wireless[name]; ok {
+			t.Errorf("Expected section %s of the deleted wifi network to be removed", name)
+		}
+	}
+	if _, ok := wireless["default_radio0"]; !ok {
+		t.Error("Expected the unmanaged section default_radio0 to be kept")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	rpcd := openwrttest.NewRPCD(testUsername, testPassword)
+	defer rpcd.Close()
+
+	client := &openwrt.RPCClient{URL: rpcd.UbusURL(), Username: testUsername, Password: "wrong"}
+	if _, _, err := openwrt.Status(ctx, client); !errors.Is(err, openwrt.UbusPermissionDenied) {
+		t.Errorf("Expected error %v, got %v", openwrt.UbusPermissionDenied, err)
+	}
+
+	client.Password = testPassword
+	info, stats, err := openwrt.Status(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedInfo := model.DeviceInfo{Hostname: "OpenWrt", Model: "Test Router", Firmware: "OpenWrt 23.05.0"}
+	if info != expectedInfo {
+		t.Errorf("Expected info %+v, got %+v", expectedInfo, info)
+	}
+	if stats.Uptime != 3600 || stats.Memory != 0.75 || stats.Clients != 3 {
+		t.Errorf("Expected uptime 3600, memory 0.75 and 3 clients, got %+v", stats)
+	}
+	expectedInterfaces := []model.InterfaceStats{{Name: "lan", Up: true, Uptime: 3500}, {Name: "wan"}}
+	if !reflect.DeepEqual(stats.Interfaces, expectedInterfaces) {
+		t.Errorf("Expected interfaces %+v, got %+v", expectedInterfaces, stats.Interfaces)
+	}
+	if len(stats.Radios) != 2 {
+		t.Errorf("Expected 2 radios, got %+v", stats.Radios)
+	}
+	if len(stats.Leases) != 2 || stats.Leases[0].Hostname != "laptop" || stats.Leases[0].Expires <= time.Now().Unix() || stats.Leases[1].Expires != 0 {
+		t.Errorf("Expected a dynamic and a static lease, got %+v", stats.Leases)
+	}
+
+	// Stock rpcd has no luci-rpc, the rest of the status is still read
+	rpcd.WithoutLuCI = true
+	info, stats, err = openwrt.Status(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info != expectedInfo || stats.Uptime != 3600 || len(stats.Interfaces) != 2 || len(stats.Leases) != 0 {
+		t.Errorf("Expected the status without leases, got %+v and %+v", info, stats)
+	}
+}
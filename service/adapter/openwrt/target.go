@@ -0,0 +1,264 @@
+package openwrt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service/controller"
+	"github.com/jacobalberty/beenfar/service/driver"
+	"github.com/jacobalberty/beenfar/service/event"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// How long a push or status read may take
+const rpcTimeout = 30 * time.Second
+
+// RPCTarget is how a router is reached over rpcd to push its configuration
+type RPCTarget struct {
+	Mac string `jsonapi:"primary,openwrt_rpc"`
+	// URL of the ubus endpoint such as https://192.168.1.1/ubus
+	URL      string `jsonapi:"attr,url"`
+	Username string `jsonapi:"attr,username"`
+	Password string `jsonapi:"attr,password,omitempty" audit:"secret"`
+	// Version is the bundle version last pushed, empty until a push succeeded
+	Version string `jsonapi:"attr,version,omitempty"`
+}
+
+// Validate checks that the router can be logged in to
+func (t RPCTarget) Validate() error {
+	var errs model.ValidationErrors
+
+	if u, err := url.Parse(t.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs.Add("url", "url must be an http or https URL such as https://192.168.1.1/ubus")
+	}
+	if t.Username == "" {
+		errs.Add("username", "username must not be empty")
+	}
+	if t.Password == "" {
+		errs.Add("password", "password must not be empty")
+	}
+	return errs.Err()
+}
+
+// target is a router provisioned over rpcd, pushes and status reads are serialized
+type target struct {
+	RPCTarget
+	client *RPCClient
+
+	mu sync.Mutex
+}
+
+func (h *Driver) target(mac string) (*target, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	t, ok := h.targets[mac]
+	return t, ok
+}
+
+// Returns the rpcd target of a router, the password is not included
+func (h *Driver) GetTarget(w http.ResponseWriter, r *http.Request) {
+	mac := strings.ToLower(chi.URLParam(r, "mac"))
+	t, ok := h.target(mac)
+	if !ok {
+		controller.WriteError(w, http.StatusNotFound, "RPC Target Not Found", "Router "+mac+" has no rpcd target")
+		return
+	}
+
+	t.mu.Lock()
+	response := t.RPCTarget
+	t.mu.Unlock()
+	response.Password = ""
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &response); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+	}
+}
+
+// Sets the rpcd target of a router, unknown routers are saved as pending
+func (h *Driver) PutTarget(w http.ResponseWriter, r *http.Request) {
+	mac := strings.ToLower(chi.URLParam(r, "mac"))
+	request := new(RPCTarget)
+	if err := jsonapi.UnmarshalPayload(r.Body, request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Mac != "" && strings.ToLower(request.Mac) != mac {
+		controller.WriteError(w, http.StatusConflict, "RPC Target ID Mismatch", "RPC target "+request.Mac+" does not match "+mac)
+		return
+	}
+	request.Mac = mac
+	request.Version = ""
+	if err := request.Validate(); err != nil {
+		controller.WriteValidationErrors(w, "Invalid RPC Target", err)
+		return
+	}
+
+	device, err := h.devices.Get(mac)
+	switch {
+	case errors.Is(err, model.ErrDeviceNotFound):
+		d := model.Device{Driver: DriverName}
+		d.Init(Device{mac: mac})
+		if h.devices.SavePending(d) {
+			h.events.Publish(event.DevicePending, "device/"+mac, nil)
+		}
+	case device.Driver != DriverName:
+		controller.WriteError(w, http.StatusConflict, "Device Managed By Another Driver", "Device "+mac+" is managed by the "+device.Driver+" driver")
+		return
+	}
+
+	t := &target{
+		RPCTarget: *request,
+		client:    &RPCClient{URL: request.URL, Username: request.Username, Password: request.Password},
+	}
+	h.mu.Lock()
+	current, ok := h.targets[mac]
+	h.targets[mac] = t
+	h.mu.Unlock()
+
+	var before any
+	if ok {
+		before = current.RPCTarget
+	}
+	controller.AuditRequest(h.audit, r, "openwrt.rpc.update", "device/"+mac, before, t.RPCTarget)
+
+	response := t.RPCTarget
+	response.Password = ""
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &response); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+	}
+}
+
+// Removes the rpcd target of a router, the router is no longer pushed to
+func (h *Driver) DeleteTarget(w http.ResponseWriter, r *http.Request) {
+	mac := strings.ToLower(chi.URLParam(r, "mac"))
+	h.mu.Lock()
+	t, ok := h.targets[mac]
+	delete(h.targets, mac)
+	h.mu.Unlock()
+	if !ok {
+		controller.WriteError(w, http.StatusNotFound, "RPC Target Not Found", "Router "+mac+" has no rpcd target")
+		return
+	}
+
+	controller.AuditRequest(h.audit, r, "openwrt.rpc.delete", "device/"+mac, t.RPCTarget, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// push renders the configuration of the site of the router and writes it to the router unless it already has this version
+func (h *Driver) push(ctx context.Context, t *target, force bool) error {
+	device, err := h.devices.Get(t.Mac)
+	if err != nil {
+		return err
+	}
+	cd, err := h.sites.ConfigData(device.Site)
+	if err != nil {
+		return err
+	}
+	packages, err := h.renderer.Packages(cd)
+	if err != nil {
+		return err
+	}
+	bundle, err := NewBundle(packages)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !force && t.Version == bundle.Version {
+		return nil
+	}
+	if err := Push(ctx, t.client, packages); err != nil {
+		return err
+	}
+	t.Version = bundle.Version
+	h.logger.ForDevice(t.Mac).Info("pushed config", "version", bundle.Version)
+	return nil
+}
+
+// refresh reads the status of the router into the device
+func (h *Driver) refresh(ctx context.Context, t *target) error {
+	t.mu.Lock()
+	info, stats, err := Status(ctx, t.client)
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if h.devices.Seen(t.Mac) {
+		h.logger.ForDevice(t.Mac).Info("device online")
+		h.events.Publish(event.DeviceOnline, "device/"+t.Mac, nil)
+	}
+	if err := h.devices.UpdateInfo(t.Mac, info); err != nil {
+		return err
+	}
+	return h.devices.UpdateStats(t.Mac, stats)
+}
+
+// Command pushes the configuration or reads the status of a router with an rpcd target
+func (h *Driver) Command(d model.Device, c driver.Command) error {
+	t, ok := h.target(d.GetMac())
+	if !ok {
+		return fmt.Errorf("%w: %s has no rpcd target", driver.ErrNotSupported, d.GetMac())
+	}
+	if !h.devices.IsAdopted(t.Mac) {
+		return fmt.Errorf("%w: %s", model.ErrDeviceNotFound, t.Mac)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+
+	switch c {
+	case driver.CommandProvision:
+		return h.push(ctx, t, true)
+	case driver.CommandRefresh:
+		return h.refresh(ctx, t)
+	}
+	return fmt.Errorf("%w: %s", driver.ErrNotSupported, c)
+}
+
+// Run keeps adopted routers with an rpcd target provisioned and reads their status every inform interval
+func (h *Driver) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.informInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.mu.RLock()
+			targets := make([]*target, 0, len(h.targets))
+			for _, t := range h.targets {
+				targets = append(targets, t)
+			}
+			h.mu.RUnlock()
+
+			for _, t := range targets {
+				if !h.devices.IsAdopted(t.Mac) {
+					continue
+				}
+				tctx, cancel := context.WithTimeout(ctx, rpcTimeout)
+				if err := h.push(tctx, t, false); err != nil {
+					h.logger.ForDevice(t.Mac).Warn("error pushing config", "error", err)
+				}
+				if err := h.refresh(tctx, t); err != nil {
+					h.logger.ForDevice(t.Mac).Warn("error reading status", "error", err)
+				}
+				cancel()
+			}
+		}
+	}
+}
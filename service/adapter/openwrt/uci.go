@@ -0,0 +1,105 @@
+package openwrt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalidConfigValue = errors.New("config value contains a line break")
+	ErrInvalidSectionName = errors.New("invalid section name")
+)
+
+// An Option is a single option or list of a UCI section
+type Option struct {
+	Name   string
+	Values []string
+	// List options are written once per value, plain options take the first value only
+	List bool
+}
+
+// A Section is a named UCI section such as config wifi-iface 'guest'
+type Section struct {
+	Type    string
+	Name    string
+	Options []Option
+}
+
+// Set adds an option, empty values are left out so the device default applies
+func (s *Section) Set(name, value string) {
+	if value == "" {
+		return
+	}
+	s.Options = append(s.Options, Option{Name: name, Values: []string{value}})
+}
+
+// Add adds a value to a list option
+func (s *Section) Add(name string, values ...string) {
+	for i := range s.Options {
+		if s.Options[i].Name == name && s.Options[i].List {
+			s.Options[i].Values = append(s.Options[i].Values, values...)
+			return
+		}
+	}
+	if len(values) != 0 {
+		s.Options = append(s.Options, Option{Name: name, Values: values, List: true})
+	}
+}
+
+// A Package is the content of one file in /etc/config
+type Package struct {
+	Name     string
+	Sections []Section
+}
+
+// String renders the package in the format of uci export.
+//
+// Values are single quoted, a quote inside a value closes the quote, adds an escaped quote and reopens it.
+// Line breaks can not be represented and return ErrInvalidConfigValue.
+func (p Package) String() (string, error) {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "package %s\n", p.Name)
+	for _, section := range p.Sections {
+		if !validName(section.Name) {
+			return "", fmt.Errorf("%w: %q", ErrInvalidSectionName, section.Name)
+		}
+		fmt.Fprintf(&sb, "\nconfig %s '%s'\n", section.Type, section.Name)
+		for _, option := range section.Options {
+			keyword := "option"
+			values := option.Values
+			if option.List {
+				keyword = "list"
+			} else if len(values) > 1 {
+				values = values[:1]
+			}
+			for _, value := range values {
+				if strings.ContainsAny(value, "\r\n") {
+					return "", fmt.Errorf("%w: %s.%s.%s", ErrInvalidConfigValue, p.Name, section.Name, option.Name)
+				}
+				fmt.Fprintf(&sb, "\t%s %s %s\n", keyword, option.Name, quote(value))
+			}
+		}
+	}
+
+	return sb.String(), nil
+}
+
+func quote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// validName checks a section name, UCI only allows letters, digits and underscores
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
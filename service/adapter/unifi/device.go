@@ -0,0 +1,144 @@
+package unifi
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// Device is a UniFi device known from its inform packet
+type Device struct {
+	informPD *InformBuilder `json:"-"`
+}
+
+func (ud *Device) Init(informPD *InformBuilder) {
+	ud.informPD = informPD
+}
+
+func (ud Device) GetMac() string {
+	return ud.informPD.GetMac()
+}
+
+func (ud Device) Adopt() error {
+	return nil
+}
+
+func (ud Device) Delete() error {
+	return nil
+}
+
+func (ud Device) Refresh() {
+
+}
+
+// SystemConfig renders the wifi networks in cd into the device's system_cfg
+func (ud Device) SystemConfig(cd *model.ConfigData) (string, error) {
+	var wlans []WlanConfig
+
+	for _, network := range cd.WifiNetworkList() {
+		wlan := WlanConfig{
+			Ssid:   network.Ssid,
+			Key:    network.SecurityKey,
+			Hidden: network.Hidden,
+			Guest:  network.Guest,
+		}
+
+		switch network.SecurityType {
+		case model.WifiSecurityTypeWep:
+			wlan.Security = WlanSecurityWep
+		case model.WifiSecurityTypeWpaPersonal:
+			wlan.Security = WlanSecurityWpaPersonal
+		case model.WifiSecurityTypeWpaEnterprise:
+			wlan.Security = WlanSecurityWpaEnterprise
+		default:
+			wlan.Security = WlanSecurityOpen
+		}
+
+		switch network.Band {
+		case model.WifiBand2G:
+			wlan.Radio = WlanRadio2G
+		case model.WifiBand5G:
+			wlan.Radio = WlanRadio5G
+		}
+
+		wlans = append(wlans, wlan)
+	}
+
+	return SystemConfig(wlans)
+}
+
+// DeviceStats converts the statistics from an inform payload
+func (s InformStats) DeviceStats() model.DeviceStats {
+	stats := model.DeviceStats{
+		Uptime:  s.Uptime,
+		Clients: s.NumSta,
+		CPU:     float64(s.SystemStats.CPU) / 100,
+		Memory:  float64(s.SystemStats.Memory) / 100,
+	}
+
+	for _, port := range s.PortTable {
+		name := port.Name
+		if name == "" {
+			name = "Port " + strconv.Itoa(port.Index)
+		}
+		stats.Ports = append(stats.Ports, model.PortStats{
+			Name:    name,
+			Up:      port.Up,
+			RxBytes: port.RxBytes,
+			TxBytes: port.TxBytes,
+		})
+	}
+
+	for radio, total := range s.RadioTotals() {
+		stats.Radios = append(stats.Radios, model.RadioStats{
+			Name:    radio,
+			Clients: total.NumSta,
+			RxBytes: total.RxBytes,
+			TxBytes: total.TxBytes,
+		})
+	}
+	sort.Slice(stats.Radios, func(i, j int) bool {
+		return stats.Radios[i].Name < stats.Radios[j].Name
+	})
+
+	for _, wan := range []struct {
+		name  string
+		stats *WanStats
+	}{{"wan1", s.Wan1}, {"wan2", s.Wan2}} {
+		if wan.stats == nil {
+			continue
+		}
+		stats.Interfaces = append(stats.Interfaces, model.InterfaceStats{
+			Name:    wan.name,
+			Up:      wan.stats.Up,
+			Uptime:  wan.stats.Uptime,
+			Address: wan.stats.IP,
+			RxBytes: wan.stats.RxBytes,
+			TxBytes: wan.stats.TxBytes,
+			RxRate:  float64(wan.stats.RxRate),
+			TxRate:  float64(wan.stats.TxRate),
+		})
+	}
+
+	// Gateways do not report when leases expire
+	for _, network := range s.NetworkTable {
+		for _, host := range network.HostTable {
+			if host.IP == "" {
+				continue
+			}
+			stats.Leases = append(stats.Leases, model.DHCPLease{MAC: host.Mac, IP: host.IP, Hostname: host.Hostname})
+		}
+	}
+
+	return stats
+}
+
+// DeviceInfo is what the inform payload reports about the device
+func (s InformStats) DeviceInfo() model.DeviceInfo {
+	return model.DeviceInfo{
+		Hostname: s.Hostname,
+		Model:    s.Model,
+		Firmware: s.Version,
+	}
+}
@@ -0,0 +1,73 @@
+package unifi_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service/adapter/unifi"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+func TestSystemConfig(t *testing.T) {
+	var (
+		cd = model.NewConfigData()
+		ud unifi.Device
+	)
+
+	networks := []model.WifiNetworkConfig{
+		{Ssid: "Cafe-Guest", Guest: true},
+		{Ssid: "Bob's_Wifi", SecurityType: model.WifiSecurityTypeWpaPersonal, SecurityKey: "p=ss word"},
+		{Ssid: "Café ☕", Band: model.WifiBand5G, Hidden: true},
+		{Ssid: "a=b # c", SecurityType: model.WifiSecurityTypeWpaPersonal, SecurityKey: "k=y # 2.4", Band: model.WifiBand2G},
+	}
+	for _, network := range networks {
+		if err := network.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cd.AddWifiNetwork(network); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg, err := ud.SystemConfig(cd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Networks are rendered sorted by SSID
+	expected := []string{
+		"wireless.1.ssid=Bob's_Wifi",
+		"aaa.1.wpa.psk=p=ss word",
+		"wireless.1.security=wpapsk",
+		"wireless.2.ssid=Cafe-Guest",
+		"wireless.2.is_guest=true",
+		"wireless.3.ssid=Café ☕",
+		"wireless.3.radio=na",
+		"wireless.3.hide_ssid=true",
+		"wireless.4.ssid=a=b # c",
+		"aaa.4.wpa.psk=k=y # 2.4",
+		"wireless.4.radio=ng",
+	}
+	lines := strings.Split(cfg, "\n")
+	for _, e := range expected {
+		found := false
+		for _, line := range lines {
+			if line == e {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Expected line %q in system config:\n%s", e, cfg)
+		}
+	}
+
+	// Line breaks can not be represented and must not reach the device
+	cd = model.NewConfigData()
+	if _, err = cd.AddWifiNetwork(model.WifiNetworkConfig{Ssid: "a\nwireless.1.ssid=b"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ud.SystemConfig(cd); err == nil {
+		t.Error("Expected an error rendering an SSID with a line break")
+	}
+}
@@ -0,0 +1,292 @@
+package unifi
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service/controller"
+	"github.com/jacobalberty/beenfar/service/driver"
+	"github.com/jacobalberty/beenfar/service/event"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/metrics"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// Name of the UniFi driver
+const DriverName = "unifi"
+
+// Config holds the settings of the UniFi driver
+type Config struct {
+	// Key is given to adopted devices, it is generated if not 16 bytes long
+	Key []byte
+}
+
+// Driver adopts UniFi devices that check in on /inform
+type Driver struct {
+	key            []byte
+	informInterval time.Duration
+	sites          *model.Sites
+	devices        *model.Devices
+	audit          *model.AuditLog
+	events         *event.Bus
+
+	informs        *metrics.CounterVec
+	decodeFailures *metrics.CounterVec
+}
+
+func NewDriver(config Config) *Driver {
+	return &Driver{key: config.Key}
+}
+
+func (h *Driver) Info() driver.Info {
+	return driver.Info{
+		Name:         DriverName,
+		Discovery:    driver.DiscoveryInform,
+		Capabilities: []driver.Capability{driver.CapabilityStats, driver.CapabilityWifi},
+		Commands:     []driver.Command{},
+	}
+}
+
+func (h *Driver) Init(deps driver.Deps, routers driver.Routers) error {
+	if len(h.key) != 16 {
+		h.key = make([]byte, 16)
+		if _, err := rand.Read(h.key); err != nil {
+			return fmt.Errorf("error generating key: %w", err)
+		}
+		deps.Logger.Warn("generated a UniFi key that will not be kept", "component", DriverName)
+	}
+	h.informInterval = deps.InformInterval
+
+	h.sites = deps.Sites
+	h.devices = deps.Devices
+	h.audit = deps.Audit
+	h.events = deps.Events
+	h.informs = deps.Metrics.NewCounter("beenfar_informs_total", "Inform requests received from devices.")
+	h.decodeFailures = deps.Metrics.NewCounter("beenfar_inform_decode_failures_total", "Inform packets that could not be decoded by reason.", "reason")
+
+	routers[driver.ListenerInform].With(controller.LimitBody(maxInformSize)).Post("/inform", h.postInformHandler)
+	return nil
+}
+
+// Render returns the system_cfg of a device, gateways are recognized by the model they reported
+// before they were adopted
+func (h *Driver) Render(d model.Device) ([]byte, error) {
+	cd, err := h.sites.ConfigData(d.Site)
+	if err != nil {
+		return nil, err
+	}
+	if gw, ok := LookupGateway(d.Model); ok {
+		cfg, err := gw.SystemConfig(cd)
+		return []byte(cfg), err
+	}
+	cfg, err := Device{}.SystemConfig(cd)
+	return []byte(cfg), err
+}
+
+// Command is not supported yet, devices are provisioned as they inform
+func (h *Driver) Command(d model.Device, c driver.Command) error {
+	return fmt.Errorf("%w: %s", driver.ErrNotSupported, c)
+}
+
+// Largest inform packet accepted from devices
+const maxInformSize = 1 << 20
+
+// postInformHandler swagger:route POST /inform unifi postInform
+//
+// Handles communication between the controller and UniFi equipment.
+//
+// Responses:
+//   200: informResponse
+//   404: description:Returned to equipment that has not been adopted yet.
+func (h *Driver) postInformHandler(w http.ResponseWriter, r *http.Request) {
+	h.informs.Inc()
+	bodyBuffer, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ipd, err := NewInformBuilder(bodyBuffer)
+	if err != nil {
+		h.decodeFailures.Inc(decodeFailureReason(err))
+		logging.FromContext(r.Context()).Debug("malformed inform", "error", err, "remote", controller.SourceIP(r))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logger := logging.FromContext(r.Context()).ForDevice(ipd.GetMac())
+	if h.devices.IsAdopted(ipd.GetMac()) {
+		// Adopted, only informs encrypted with the controller key count as the device checking in
+		stats, ok := h.updateStats(logger, ipd)
+		if !ok {
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		if h.devices.Seen(ipd.GetMac()) {
+			logger.Info("device online")
+			h.events.Publish(event.DeviceOnline, "device/"+ipd.GetMac(), nil)
+		}
+		h.writeResponse(logger, w, ipd, h.response(logger, ipd.GetMac(), stats))
+	} else {
+		// Pending adoption, the reported info tells admins what they adopt
+		pd := Device{}
+		pd.Init(ipd)
+		d := model.Device{Driver: DriverName}
+		d.Init(pd)
+		if stats, err := h.decodePending(ipd); err == nil {
+			info := stats.DeviceInfo()
+			d.Info = &info
+		}
+		if h.devices.SavePending(d) {
+			logger.Info("new adoption request", "remote", controller.SourceIP(r))
+			controller.AuditAs(h.audit, r, d.GetMac(), "device.pending", "device/"+d.GetMac(), nil, nil)
+			h.events.Publish(event.DevicePending, "device/"+d.GetMac(), nil)
+		}
+		http.Error(w, "", http.StatusNotFound)
+	}
+}
+
+// updateStats decodes the inform payload of an adopted device and records its statistics.
+// It returns false if the payload could not be decoded.
+func (h *Driver) updateStats(logger *logging.Logger, ipd *InformBuilder) (InformStats, bool) {
+	stats, err := h.decodeStats(logger, ipd)
+	if err != nil {
+		h.decodeFailures.Inc(decodeFailureReason(err))
+		logger.Warn("error decoding inform", "error", err)
+		return stats, false
+	}
+
+	if err := h.devices.UpdateStats(ipd.GetMac(), stats.DeviceStats()); err != nil {
+		logger.Error("error saving stats", "error", err)
+	}
+	if err := h.devices.UpdateInfo(ipd.GetMac(), stats.DeviceInfo()); err != nil {
+		logger.Error("error saving device info", "error", err)
+	}
+	return stats, true
+}
+
+// response provisions devices whose configuration version differs from the rendered one,
+// provisioned devices are told when to check in next. It is only called for informs decoded with
+// the controller key, the configuration holds the key and secrets of the site.
+func (h *Driver) response(logger *logging.Logger, mac string, stats InformStats) any {
+	now := time.Now().Unix()
+	cfg, err := h.deviceConfig(mac)
+	switch {
+	case err != nil:
+		logger.Error("error rendering config", "error", err)
+	case cfg.ConfigVersion != stats.CfgVersion:
+		logger.Info("provisioning device", "cfgversion", cfg.ConfigVersion)
+		cfg.ServerTimeUTC = now
+		return cfg
+	}
+	return InformHeartbeatResponse{
+		Type:          "noop",
+		Interval:      int64(h.informInterval / time.Second),
+		ServerTimeUTC: now,
+	}
+}
+
+// deviceConfig renders the setparam response with the configuration of the site of a device
+func (h *Driver) deviceConfig(mac string) (InformConfigUpdateResponse, error) {
+	device, err := h.devices.Get(mac)
+	if err != nil {
+		return InformConfigUpdateResponse{}, err
+	}
+	b, err := h.Render(device)
+	if err != nil {
+		return InformConfigUpdateResponse{}, err
+	}
+	systemConfig := string(b)
+	version := ConfigVersion(h.key, systemConfig)
+	return InformConfigUpdateResponse{
+		Type:             "setparam",
+		ConfigVersion:    version,
+		ManagementConfig: ManagementConfig(h.key, version),
+		SystemConfig:     systemConfig,
+	}, nil
+}
+
+// writeResponse writes a response to an inform, it is encrypted with the key the inform was decoded with
+func (h *Driver) writeResponse(logger *logging.Logger, w http.ResponseWriter, ipd *InformBuilder, response any) {
+	b, err := ipd.BuildResponse(response)
+	if err != nil {
+		logger.Error("error building response", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-binary")
+	if _, err := w.Write(b); err != nil {
+		logger.Warn("error writing response", "error", err)
+	}
+}
+
+// decodeStats decodes the inform of an adopted device. Adopted devices have to encrypt their informs
+// with the controller key, anyone can encrypt with the default key or send a plain inform.
+// Decoded payloads are logged at debug level, debugging a single device dumps only its payloads.
+func (h *Driver) decodeStats(logger *logging.Logger, ipd *InformBuilder) (InformStats, error) {
+	if !ipd.Encrypted() {
+		return InformStats{}, errUnencrypted
+	}
+	ipd.Key = h.key
+	payload, err := ipd.Payload()
+	if err != nil {
+		return InformStats{}, err
+	}
+	logger.Debug("inform payload", "payload", json.RawMessage(payload))
+
+	stats, err := ParseInformStats(payload)
+	if err != nil {
+		return InformStats{}, fmt.Errorf("%w: %v", errInvalidPayload, err)
+	}
+	return stats, nil
+}
+
+// decodePending decodes the inform of a device waiting for adoption, it is tried with the
+// controller key of devices given the key over SSH and the default key of every other device
+func (h *Driver) decodePending(ipd *InformBuilder) (InformStats, error) {
+	var err error
+	for _, key := range [][]byte{h.key, MASTER_KEY} {
+		var payload []byte
+
+		ipd.Key = key
+		if payload, err = ipd.Payload(); err != nil {
+			continue
+		}
+		var stats InformStats
+		if stats, err = ParseInformStats(payload); err == nil {
+			return stats, nil
+		}
+	}
+	return InformStats{}, err
+}
+
+var (
+	errInvalidPayload = errors.New("invalid inform payload")
+	errUnencrypted    = errors.New("inform of an adopted device is not encrypted")
+)
+
+// decodeFailureReason is the metric label of an inform decoding error
+func decodeFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrPacketLength), errors.Is(err, ErrDataLength):
+		return "malformed"
+	case errors.Is(err, ErrInvalidKey):
+		return "invalid_key"
+	case errors.Is(err, ErrPadding):
+		return "padding"
+	case errors.Is(err, ErrAuthentication):
+		return "authentication"
+	case errors.Is(err, ErrDecompress):
+		return "decompress"
+	case errors.Is(err, errInvalidPayload):
+		return "payload"
+	case errors.Is(err, errUnencrypted):
+		return "unencrypted"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,82 @@
+package unifi
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+
+	"github.com/jacobalberty/beenfar/service/adapter/edgeos"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// First NAT rule number, the rules below are left to the user
+const natRuleBase = 6001
+
+// Gateway is a UniFi Security Gateway model, gateways run EdgeOS and take their system_cfg
+// as the JSON form of an EdgeOS configuration tree
+type Gateway struct {
+	Model string
+	// WAN is the port facing the internet, it gets its address by dhcp
+	WAN string
+	// LAN is the port networks are served on, VLANs are vifs on it
+	LAN string
+}
+
+// Gateway models keyed by the model reported in the inform payload
+var gateways = map[string]Gateway{
+	// USG
+	"UGW3": {Model: "UGW3", WAN: "eth0", LAN: "eth1"},
+	// USG-Pro-4
+	"UGW4": {Model: "UGW4", WAN: "eth2", LAN: "eth0"},
+}
+
+// LookupGateway returns the gateway for the model of a device, ok is false for other devices
+func LookupGateway(model string) (Gateway, bool) {
+	gw, ok := gateways[model]
+	return gw, ok
+}
+
+// Render renders the networks in cd into a configuration tree.
+//
+// The LAN port, VLAN vifs, dhcp server or relay, dns forwarding, IPv6 prefix delegation and the
+// firewall are rendered the same as on EdgeOS routers. The WAN port takes its address by dhcp and
+// every network is masqueraded behind it after the nat rules of the firewall.
+func (g Gateway) Render(cd *model.ConfigData) (*edgeos.Node, error) {
+	root, err := edgeos.Renderer{Interface: g.LAN, WAN: g.WAN}.Render(cd)
+	if err != nil {
+		return nil, err
+	}
+
+	wan := root.Child("interfaces", "").Child("ethernet", g.WAN)
+	wan.Add("address", "dhcp")
+	wan.Set("description", "WAN")
+
+	nat := root.Child("service", "").Child("nat", "")
+	rule := natRuleBase
+	for _, network := range cd.NetworkList() {
+		_, subnet, err := net.ParseCIDR(network.GatewayIPSubnet)
+		if err != nil {
+			continue
+		}
+		masquerade := nat.Child("rule", strconv.Itoa(rule))
+		masquerade.Set("description", "masquerade "+network.Name+" to WAN")
+		masquerade.Set("log", "disable")
+		masquerade.Set("outbound-interface", g.WAN)
+		masquerade.Set("protocol", "all")
+		masquerade.Child("source", "").Set("address", subnet.String())
+		masquerade.Set("type", "masquerade")
+		rule++
+	}
+
+	return root, nil
+}
+
+// SystemConfig renders the networks in cd into the gateway's system_cfg
+func (g Gateway) SystemConfig(cd *model.ConfigData) (string, error) {
+	root, err := g.Render(cd)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(root)
+	return string(b), err
+}
@@ -0,0 +1,141 @@
+package unifi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service/adapter/unifi"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestGatewaySystemConfig(t *testing.T) {
+	cd := model.NewConfigData()
+	cd.Networks = map[string]model.NetworkConfig{
+		"000000000000000000000001": {
+			ID:              "000000000000000000000001",
+			Name:            "lan",
+			GatewayIPSubnet: "192.168.1.1/24",
+			DHCPConfig: model.DHCPConfig{
+				DHCPMode:       model.DHCPModeServer,
+				DHCPStart:      "192.168.1.100",
+				DHCPStop:       "192.168.1.249",
+				DHCPNameServer: model.DHCPNameServer{Auto: true},
+				DHCPGateway:    model.DHCPGateway{Auto: true},
+			},
+			IPV6NetworkConfig: model.IPV6NetworkConfig{
+				Type:             model.IPV6TypePD,
+				RAEnabled:        true,
+				RDNSSControlAuto: true,
+			},
+		},
+		"000000000000000000000002": {
+			ID:              "000000000000000000000002",
+			Name:            "iot",
+			Vlan:            30,
+			GatewayIPSubnet: "10.0.30.1/24",
+			DHCPConfig: model.DHCPConfig{
+				DHCPMode:        model.DHCPModeRelay,
+				DHCPRelayServer: "192.168.1.2",
+			},
+		},
+	}
+	cd.FirewallRules = map[string]model.FirewallRule{
+		"000000000000000000000021": {ID: "000000000000000000000021", Name: "iot isolation", Index: 1, Action: model.FirewallActionDrop, Protocol: model.ProtocolAll,
+			Source: "000000000000000000000002", Destination: "000000000000000000000001"},
+	}
+	cd.PortForwards = map[string]model.PortForward{
+		"000000000000000000000031": {ID: "000000000000000000000031", Name: "ssh", Protocol: model.ProtocolTCP, Port: "2222",
+			Network: "000000000000000000000001", ForwardAddress: "192.168.1.10", ForwardPort: "22"},
+	}
+
+	if _, ok := unifi.LookupGateway("U7PG2"); ok {
+		t.Error("Expected access points not to be gateways")
+	}
+	gw, ok := unifi.LookupGateway("UGW3")
+	if !ok {
+		t.Fatal("Expected UGW3 to be a gateway")
+	}
+	cfg, err := gw.SystemConfig(cd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, []byte(cfg), "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	indented.WriteByte('\n')
+	path := filepath.Join("testdata", "gateway.json.golden")
+	if *update {
+		if err := os.WriteFile(path, indented.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(indented.Bytes(), golden) {
+		t.Errorf("Expected output to match %s, got:\n%s", path, indented.Bytes())
+	}
+
+	// The USG-Pro-4 serves networks on eth0 and has its WAN on eth2
+	pro, _ := unifi.LookupGateway("UGW4")
+	root, err := pro.Render(cd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Get("interfaces", "ethernet eth0", "vif 30") == nil || root.Get("interfaces", "ethernet eth2", "address dhcp") == nil ||
+		root.Get("interfaces", "ethernet eth2", "firewall", "in", "name WAN_IN") == nil {
+		t.Errorf("Expected the LAN on eth0 and the WAN on eth2, got:\n%s", root)
+	}
+}
+
+func TestGatewayStats(t *testing.T) {
+	stats, err := unifi.ParseInformStats([]byte(`{
+		"mac": "f0:9f:c2:00:00:01",
+		"model": "UGW3",
+		"version": "4.4.57.5578372",
+		"hostname": "gateway",
+		"uptime": 3600,
+		"wan1": {"ifname": "eth0", "ip": "203.0.113.7", "up": true, "uptime": 1800, "rx_bytes": 1000, "tx_bytes": 500, "rx_bytes-r": "1250.5", "tx_bytes-r": 300},
+		"network_table": [{"name": "lan", "address": "192.168.1.1/24", "host_table": [
+			{"mac": "00:11:22:33:44:55", "ip": "192.168.1.100", "hostname": "laptop"},
+			{"mac": "00:11:22:33:44:66"}
+		]}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := unifi.LookupGateway(stats.Model); !ok {
+		t.Error("Expected the inform to be from a gateway")
+	}
+	if info := stats.DeviceInfo(); info.Model != "UGW3" || info.Hostname != "gateway" || info.Firmware != "4.4.57.5578372" {
+		t.Errorf("Unexpected device info %+v", info)
+	}
+
+	expected := []model.InterfaceStats{{
+		Name:    "wan1",
+		Up:      true,
+		Uptime:  1800,
+		Address: "203.0.113.7",
+		RxBytes: 1000,
+		TxBytes: 500,
+		RxRate:  1250.5,
+		TxRate:  300,
+	}}
+	deviceStats := stats.DeviceStats()
+	if interfaces := deviceStats.Interfaces; len(interfaces) != 1 || interfaces[0] != expected[0] {
+		t.Errorf("Expected interfaces %+v, got %+v", expected, interfaces)
+	}
+	lease := model.DHCPLease{MAC: "00:11:22:33:44:55", IP: "192.168.1.100", Hostname: "laptop"}
+	if leases := deviceStats.Leases; len(leases) != 1 || leases[0] != lease {
+		t.Errorf("Expected the lease of the client with an address, got %+v", leases)
+	}
+}
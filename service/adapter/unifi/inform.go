@@ -1,14 +1,31 @@
 package unifi
 
 import (
+	"errors"
 	"fmt"
 )
 
+// Length of the packet header, it is also the additional data of AES-GCM packets
+const informHeaderLength = 40
+
+// Length of the AES-GCM authentication tag at the end of the payload
+const gcmTagSize = 16
+
 var (
 	// md5sum of "ubnt"
 	MASTER_KEY = []byte{0xba, 0x86, 0xf2, 0xbb, 0xe1, 0x07, 0xc7, 0xc5, 0x7e, 0xb5, 0xf2, 0x69, 0x07, 0x75, 0xc7, 0x12}
 
 	ErrDataLength = fmt.Errorf("Data length is larger than packet size")
+	// ErrPacketLength is returned for packets shorter than the header
+	ErrPacketLength = errors.New("packet is shorter than the inform header")
+	// ErrInvalidKey is returned when the key can not be used with the packet's cipher
+	ErrInvalidKey = errors.New("invalid key")
+	// ErrPadding is returned for AES-CBC payloads that are not padded correctly, usually because of a wrong key
+	ErrPadding = errors.New("invalid padding")
+	// ErrAuthentication is returned for AES-GCM payloads that fail authentication, usually because of a wrong key
+	ErrAuthentication = errors.New("message authentication failed")
+	// ErrDecompress is returned for payloads that can not be uncompressed
+	ErrDecompress = errors.New("error decompressing payload")
 )
 
 type InformPD struct {
@@ -5,18 +5,18 @@ import (
 	"compress/zlib"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"math/big"
-	"math/rand"
 	"strconv"
 
 	"github.com/golang/snappy"
+	"github.com/jacobalberty/beenfar/service/logging"
 )
 
 type InformBuilder struct {
@@ -40,6 +40,10 @@ func NewInformBuilder(packet []byte) (*InformBuilder, error) {
 	)
 	ib = &InformBuilder{}
 
+	if len(packet) < informHeaderLength {
+		return nil, ErrPacketLength
+	}
+
 	ipd.Magic = int32(big.NewInt(0).SetBytes(packet[0:4]).Uint64())
 
 	tInt64, err = strconv.ParseInt(hex.EncodeToString(packet[4:8]), 16, 32)
@@ -74,9 +78,11 @@ func NewInformBuilder(packet []byte) (*InformBuilder, error) {
 	}
 	ipd.Payload = packet[40 : 40+ipd.DataLength]
 
-	ipd.AAD = packet[:40]
+	ipd.AAD = packet[:informHeaderLength]
 
-	ipd.Tag = packet[:len(packet)-16]
+	if len(ipd.Payload) >= gcmTagSize {
+		ipd.Tag = ipd.Payload[len(ipd.Payload)-gcmTagSize:]
+	}
 
 	ib.Init(ipd)
 	return ib, err
@@ -84,21 +90,67 @@ func NewInformBuilder(packet []byte) (*InformBuilder, error) {
 
 func (p *InformBuilder) Init(ipd InformPD) {
 	p.packet = ipd
+	p.aad = ipd.AAD
+	p.tag = ipd.Tag
 
 	p.parseFlags()
 }
 
 func (p InformBuilder) Uncompress() (io.Reader, error) {
+	b := bytes.NewReader(p.compressedPayload)
 	if p.zlib {
-		b := bytes.NewReader(p.compressedPayload)
 		return zlib.NewReader(b)
-
 	} else if p.snappy {
-		b := bytes.NewReader(p.compressedPayload)
 		return snappy.NewReader(b), nil
 	}
 
-	return nil, fmt.Errorf("Unimplemented compression")
+	return b, nil
+}
+
+// compress applies the compression the packet flags ask for
+func (p InformBuilder) compress(b []byte) ([]byte, error) {
+	var (
+		buf = new(bytes.Buffer)
+		w   io.WriteCloser
+	)
+	switch {
+	case p.zlib:
+		w = zlib.NewWriter(buf)
+	case p.snappy:
+		w = snappy.NewBufferedWriter(buf)
+	default:
+		return b, nil
+	}
+
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Payload decrypts and uncompresses the packet and returns the json payload
+func (p *InformBuilder) Payload() ([]byte, error) {
+	if err := p.Decrypt(); err != nil {
+		return nil, err
+	}
+
+	r, err := p.Uncompress()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecompress, err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecompress, err)
+	}
+	return b, nil
+}
+
+// Encrypted reports whether the packet is flagged as encrypted
+func (p InformBuilder) Encrypted() bool {
+	return p.encrypted
 }
 
 func (p InformBuilder) GetMac() string {
@@ -117,20 +169,19 @@ func (p InformBuilder) String() string {
 
 }
 
-func (p *InformBuilder) Decrypt() {
+func (p *InformBuilder) Decrypt() error {
 	if len(p.Key) == 0 {
 		p.Key = MASTER_KEY
 	}
 	if !p.encrypted {
-		log.Println("Note: packet was not marked encrypted")
+		logging.Default().Debug("packet was not marked encrypted", "component", "unifi", "mac", p.GetMac())
 		p.compressedPayload = p.packet.Payload
-		return
+		return nil
 	}
 	if p.aesgcm {
-		p.decryptGCM()
-	} else {
-		p.decryptCBC()
+		return p.decryptGCM()
 	}
+	return p.decryptCBC()
 }
 
 func (p *InformBuilder) Encrypt(b []byte) error {
@@ -138,7 +189,7 @@ func (p *InformBuilder) Encrypt(b []byte) error {
 		p.Key = MASTER_KEY
 	}
 	if !p.encrypted {
-		log.Println("Note: packet was not marked encrypted")
+		logging.Default().Debug("packet was not marked encrypted", "component", "unifi", "mac", p.GetMac())
 		p.packet.Payload = p.compressedPayload
 		return nil
 	}
@@ -166,57 +217,48 @@ func (p InformBuilder) BuildResponse(r any) ([]byte, error) {
 		return nil, err
 	}
 
-	p.compressedPayload = b
-	err = p.Encrypt(b)
-	if err != nil {
+	if b, err = p.compress(b); err != nil {
 		return nil, err
 	}
 
-	err = binary.Write(buf, binary.BigEndian, p.packet.Magic)
+	p.compressedPayload = b
+	err = p.Encrypt(b)
 	if err != nil {
 		return nil, err
 	}
 
-	err = binary.Write(buf, binary.BigEndian, int32(len(p.packet.Payload)))
+	header, err := p.header(len(p.packet.Payload))
 	if err != nil {
 		return nil, err
 	}
 
-	b, err = hex.DecodeString(p.packet.Mac)
+	_, err = buf.Write(header)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = buf.Write(b)
+	_, err = buf.Write(p.packet.Payload)
 	if err != nil {
 		return nil, err
 	}
 
-	err = binary.Write(buf, binary.BigEndian, p.packet.Flags)
-	if err != nil {
-		return nil, err
-	}
+	return buf.Bytes(), nil
+}
 
-	_, err = buf.Write(p.packet.InitVector)
-	if err != nil {
-		return nil, err
-	}
+// header encodes the packet header for a payload of the given length
+func (p InformBuilder) header(length int) ([]byte, error) {
+	buf := new(bytes.Buffer)
 
-	err = binary.Write(buf, binary.BigEndian, p.packet.DataVersion)
+	mac, err := hex.DecodeString(p.packet.Mac)
 	if err != nil {
 		return nil, err
 	}
 
-	err = binary.Write(buf, binary.BigEndian, int32(len(p.packet.Payload)))
-	if err != nil {
-		return nil, err
+	for _, v := range []any{p.packet.Magic, p.packet.Version, mac, p.packet.Flags, p.packet.InitVector, p.packet.DataVersion, int32(length)} {
+		if err := binary.Write(buf, binary.BigEndian, v); err != nil {
+			return nil, err
+		}
 	}
-
-	_, err = buf.Write(p.packet.Payload)
-	if err != nil {
-		return nil, err
-	}
-
 	return buf.Bytes(), nil
 }
 
@@ -227,66 +269,75 @@ func (p *InformBuilder) parseFlags() {
 	p.aesgcm = (p.packet.Flags & 0x8) == 8
 }
 
-func (p *InformBuilder) decryptGCM() {
+func (p *InformBuilder) decryptGCM() error {
 	var block cipher.Block
 	var err error
 
 	block, err = aes.NewCipher(p.Key)
 	if err != nil {
-		log.Printf("error initializing cipher: %s", err)
-		return
+		return fmt.Errorf("%w: %v", ErrInvalidKey, err)
 	}
 
 	aesGCM, err := cipher.NewGCMWithNonceSize(block, 16)
 	if err != nil {
-		log.Printf("error initializing gcm: %s", err)
-		return
+		return fmt.Errorf("%w: %v", ErrInvalidKey, err)
 	}
 
 	p.compressedPayload, err = aesGCM.Open(nil, p.packet.InitVector, p.packet.Payload, p.aad)
 	if err != nil {
-		log.Printf("error decrypting: %s", err)
+		return ErrAuthentication
 	}
+	return nil
 }
 
-func (p *InformBuilder) decryptCBC() {
+func (p *InformBuilder) decryptCBC() error {
 	var block cipher.Block
 	var err error
 
 	if len(p.Key) != 16 {
-		log.Println("invalid key")
-		return
+		return ErrInvalidKey
 	}
-	if len(p.packet.Payload)%aes.BlockSize != 0 {
-		log.Println("data is not padded")
-		return
+	if len(p.packet.Payload) == 0 || len(p.packet.Payload)%aes.BlockSize != 0 {
+		return ErrPadding
 	}
 
 	block, err = aes.NewCipher(p.Key)
 	if err != nil {
-		log.Printf("error initializing cipher: %s", err)
-		return
+		return fmt.Errorf("%w: %v", ErrInvalidKey, err)
 	}
-	p.compressedPayload = make([]byte, len(p.packet.Payload))
+	plainText := make([]byte, len(p.packet.Payload))
 	cbc := cipher.NewCBCDecrypter(block, p.packet.InitVector)
-	cbc.CryptBlocks(p.compressedPayload, p.packet.Payload)
+	cbc.CryptBlocks(plainText, p.packet.Payload)
+
+	padding := int(plainText[len(plainText)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return ErrPadding
+	}
+	p.compressedPayload = plainText[:len(plainText)-padding]
+	return nil
 }
 
+// encryptGCM uses a random 16 byte IV as the nonce and the packet header as additional data, the same as devices
 func (p *InformBuilder) encryptGCM(b []byte) error {
 	block, err := aes.NewCipher(p.Key)
 	if err != nil {
 		return err
 	}
 
-	nonce := make([]byte, 12)
-	if n, err := rand.Read(nonce); err != nil || n != 12 {
-		return fmt.Errorf("error generating nonce")
+	p.packet.InitVector = make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, p.packet.InitVector); err != nil {
+		return fmt.Errorf("error generating nonce: %w", err)
 	}
-	aesgcm, err := cipher.NewGCM(block)
+	aesgcm, err := cipher.NewGCMWithNonceSize(block, 16)
+	if err != nil {
+		return err
+	}
+
+	aad, err := p.header(len(b) + gcmTagSize)
 	if err != nil {
 		return err
 	}
-	p.packet.Payload = aesgcm.Seal(nil, nonce, b, nil)
+	p.packet.Payload = aesgcm.Seal(nil, p.packet.InitVector, b, aad)
 	return nil
 }
 
@@ -294,9 +345,10 @@ func (p *InformBuilder) encryptCBC(b []byte) error {
 	block, _ := aes.NewCipher(p.Key)
 	plainText := PKCS5Padding(b, aes.BlockSize, len(b))
 	p.packet.Payload = make([]byte, len(plainText))
-	n, err := rand.Read(p.packet.InitVector)
-	if err != nil || n != 16 {
-		return fmt.Errorf("error creating IV")
+	// A new slice so the IV of the request packet is left alone
+	p.packet.InitVector = make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, p.packet.InitVector); err != nil {
+		return fmt.Errorf("error creating IV: %w", err)
 	}
 
 	mode := cipher.NewCBCEncrypter(block, p.packet.InitVector)
@@ -0,0 +1,106 @@
+package unifi_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service/adapter/unifi"
+)
+
+func TestInformRoundTrip(t *testing.T) {
+	key := []byte("0123456789abcdef")
+
+	for _, tc := range []struct {
+		name  string
+		flags int16
+	}{
+		{"cbc", 0b0001},
+		{"gcm", 0b1001},
+	} {
+		var ib unifi.InformBuilder
+		ib.Init(unifi.InformPD{
+			Magic: 1414414933,
+			Mac:   "deadbeef0000",
+			Flags: tc.flags,
+		})
+		ib.Key = key
+
+		packet, err := ib.BuildResponse(map[string]any{"uptime": 42, "system-stats": map[string]any{"cpu": "1.5", "mem": 2}})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		decoded, err := unifi.NewInformBuilder(packet)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if decoded.GetMac() != "deadbeef0000" {
+			t.Errorf("%s: Expected mac deadbeef0000, got %s", tc.name, decoded.GetMac())
+		}
+
+		decoded.Key = key
+		payload, err := decoded.Payload()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		stats, err := unifi.ParseInformStats(payload)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if stats.Uptime != 42 || stats.SystemStats.CPU != 1.5 || stats.SystemStats.Memory != 2 {
+			t.Errorf("%s: Unexpected stats %+v", tc.name, stats)
+		}
+	}
+
+	// GCM packets fail authentication with the wrong key
+	var ib unifi.InformBuilder
+	ib.Init(unifi.InformPD{Magic: 1414414933, Mac: "deadbeef0000", Flags: 0b1001})
+	packet, err := ib.BuildResponse(map[string]any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := unifi.NewInformBuilder(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded.Key = key
+	if _, err := decoded.Payload(); !errors.Is(err, unifi.ErrAuthentication) {
+		t.Errorf("Expected %v, got %v", unifi.ErrAuthentication, err)
+	}
+
+	if _, err := unifi.NewInformBuilder([]byte("short")); !errors.Is(err, unifi.ErrPacketLength) {
+		t.Errorf("Expected %v, got %v", unifi.ErrPacketLength, err)
+	}
+}
+
+func TestInformResponseIV(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		flags int16
+	}{
+		{"cbc", 0b0001},
+		{"gcm", 0b1001},
+	} {
+		var ib unifi.InformBuilder
+		ib.Init(unifi.InformPD{Magic: 1414414933, Mac: "deadbeef0000", Flags: tc.flags})
+		ib.Key = []byte("0123456789abcdef")
+
+		var ivs [][]byte
+		for i := 0; i < 2; i++ {
+			packet, err := ib.BuildResponse(map[string]any{"_type": "noop"})
+			if err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			// The IV follows the magic, version, mac and flags in the header
+			ivs = append(ivs, packet[16:32])
+		}
+		if bytes.Equal(ivs[0], ivs[1]) {
+			t.Errorf("%s: Expected a new IV for every response, got %x twice", tc.name, ivs[0])
+		}
+		if bytes.Equal(ivs[0], make([]byte, 16)) {
+			t.Errorf("%s: Expected a random IV, got zeros", tc.name)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package unifi
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// InformStats holds the statistics reported in an inform payload
+type InformStats struct {
+	Mac      string `json:"mac"`
+	Model    string `json:"model"`
+	Version  string `json:"version"`
+	Hostname string `json:"hostname"`
+	Uptime   int64  `json:"uptime"`
+	// CfgVersion is the version of the configuration the device was last provisioned with
+	CfgVersion string `json:"cfgversion"`
+	// NumSta is the number of connected clients
+	NumSta      int         `json:"num_sta"`
+	SystemStats SystemStats `json:"system-stats"`
+	PortTable   []PortStats `json:"port_table"`
+	RadioTable  []RadioInfo `json:"radio_table"`
+	VapTable    []VapStats  `json:"vap_table"`
+	// Wan1 and Wan2 are only reported by gateways, Wan2 only when a second WAN is configured
+	Wan1 *WanStats `json:"wan1"`
+	Wan2 *WanStats `json:"wan2"`
+	// NetworkTable lists the networks of a gateway with the clients it handed addresses to
+	NetworkTable []NetworkTableEntry `json:"network_table"`
+}
+
+// SystemStats are cpu and memory utilization in percent
+type SystemStats struct {
+	CPU    Float `json:"cpu"`
+	Memory Float `json:"mem"`
+}
+
+// PortStats are the counters of a switch or gateway port
+type PortStats struct {
+	Index   int    `json:"port_idx"`
+	Name    string `json:"name"`
+	Up      bool   `json:"up"`
+	RxBytes uint64 `json:"rx_bytes"`
+	TxBytes uint64 `json:"tx_bytes"`
+}
+
+// RadioInfo describes a radio of an access point
+type RadioInfo struct {
+	Name  string `json:"name"`
+	Radio string `json:"radio"`
+}
+
+// VapStats are the counters of a virtual access point, there is one per ssid and radio
+type VapStats struct {
+	Name    string `json:"name"`
+	Radio   string `json:"radio"`
+	Essid   string `json:"essid"`
+	NumSta  int    `json:"num_sta"`
+	RxBytes uint64 `json:"rx_bytes"`
+	TxBytes uint64 `json:"tx_bytes"`
+}
+
+// WanStats are the state and counters of a gateway WAN port
+type WanStats struct {
+	Ifname  string `json:"ifname"`
+	IP      string `json:"ip"`
+	Up      bool   `json:"up"`
+	Uptime  int64  `json:"uptime"`
+	RxBytes uint64 `json:"rx_bytes"`
+	TxBytes uint64 `json:"tx_bytes"`
+	// Throughput in bytes per second
+	RxRate Float `json:"rx_bytes-r"`
+	TxRate Float `json:"tx_bytes-r"`
+}
+
+// NetworkTableEntry is a network served by a gateway
+type NetworkTableEntry struct {
+	Name      string      `json:"name"`
+	Address   string      `json:"address"`
+	HostTable []HostEntry `json:"host_table"`
+}
+
+// HostEntry is a client of a gateway network
+type HostEntry struct {
+	Mac      string `json:"mac"`
+	IP       string `json:"ip"`
+	Hostname string `json:"hostname"`
+}
+
+// Float is a number that devices send either as a json number or a string
+type Float float64
+
+func (f *Float) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*f = 0
+		return nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*f = Float(v)
+	return nil
+}
+
+// ParseInformStats decodes the statistics from an inform payload
+func ParseInformStats(payload []byte) (InformStats, error) {
+	var stats InformStats
+	err := json.Unmarshal(payload, &stats)
+	return stats, err
+}
+
+// RadioTotals sums the vap counters of each radio, keyed by radio ("ng" or "na")
+func (s InformStats) RadioTotals() map[string]VapStats {
+	totals := make(map[string]VapStats)
+	for _, radio := range s.RadioTable {
+		totals[radio.Radio] = VapStats{Name: radio.Name, Radio: radio.Radio}
+	}
+	for _, vap := range s.VapTable {
+		total := totals[vap.Radio]
+		total.Radio = vap.Radio
+		total.NumSta += vap.NumSta
+		total.RxBytes += vap.RxBytes
+		total.TxBytes += vap.TxBytes
+		totals[vap.Radio] = total
+	}
+	return totals
+}
@@ -0,0 +1,95 @@
+package unifi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalidConfigValue = fmt.Errorf("Config value contains a line break")
+)
+
+// Security modes understood by the wireless section of system_cfg
+const (
+	WlanSecurityOpen          = "none"
+	WlanSecurityWep           = "wep"
+	WlanSecurityWpaPersonal   = "wpapsk"
+	WlanSecurityWpaEnterprise = "wpaeap"
+)
+
+// Radios a wlan can be limited to, an empty radio broadcasts on both
+const (
+	WlanRadio2G = "ng"
+	WlanRadio5G = "na"
+)
+
+// WlanConfig is a single SSID as broadcast by an access point
+type WlanConfig struct {
+	Ssid     string
+	Security string
+	Key      string
+	Radio    string
+	Hidden   bool
+	Guest    bool
+}
+
+// SystemConfig renders wlans into the key=value format used by system_cfg.
+//
+// Values are written verbatim as UTF-8, the only characters that can not be
+// represented are line breaks which would start a new key.
+func SystemConfig(wlans []WlanConfig) (string, error) {
+	var sb strings.Builder
+
+	for i, wlan := range wlans {
+		n := i + 1
+		lines := [][2]string{
+			{fmt.Sprintf("aaa.%d.ssid", n), wlan.Ssid},
+			{fmt.Sprintf("aaa.%d.status", n), "enabled"},
+		}
+		if wlan.Security == WlanSecurityWpaPersonal {
+			lines = append(lines, [2]string{fmt.Sprintf("aaa.%d.wpa.psk", n), wlan.Key})
+		}
+		lines = append(lines,
+			[2]string{fmt.Sprintf("wireless.%d.ssid", n), wlan.Ssid},
+			[2]string{fmt.Sprintf("wireless.%d.security", n), wlan.Security},
+			[2]string{fmt.Sprintf("wireless.%d.hide_ssid", n), fmt.Sprint(wlan.Hidden)},
+			[2]string{fmt.Sprintf("wireless.%d.is_guest", n), fmt.Sprint(wlan.Guest)},
+		)
+		if wlan.Security == WlanSecurityWep {
+			lines = append(lines, [2]string{fmt.Sprintf("wireless.%d.wep.key.1", n), wlan.Key})
+		}
+		if wlan.Radio != "" {
+			lines = append(lines, [2]string{fmt.Sprintf("wireless.%d.radio", n), wlan.Radio})
+		}
+		lines = append(lines, [2]string{fmt.Sprintf("wireless.%d.status", n), "enabled"})
+
+		for _, line := range lines {
+			if strings.ContainsAny(line[1], "\r\n") {
+				return "", fmt.Errorf("%w: %s", ErrInvalidConfigValue, line[0])
+			}
+			sb.WriteString(line[0])
+			sb.WriteByte('=')
+			sb.WriteString(line[1])
+			sb.WriteByte('\n')
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// ManagementConfig renders the mgmt_cfg sent along with the system_cfg, it gives the device the
+// controller key and the version of its configuration
+func ManagementConfig(key []byte, version string) string {
+	return "cfgversion=" + version + "\nauthkey=" + hex.EncodeToString(key) + "\n"
+}
+
+// ConfigVersion returns the version of a rendered configuration and key, devices report the version
+// they were provisioned with as cfgversion
+func ConfigVersion(key []byte, systemConfig string) string {
+	h := sha256.New()
+	h.Write(key)
+	h.Write([]byte(systemConfig))
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
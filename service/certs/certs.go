@@ -0,0 +1,296 @@
+// Package certs provides the TLS configuration of the management api
+package certs
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service/logging"
+)
+
+// Names of the generated certificate files
+const (
+	SelfSignedCert = "cert.pem"
+	SelfSignedKey  = "key.pem"
+)
+
+// How long generated certificates are valid
+const selfSignedLifetime = 10 * 365 * 24 * time.Hour
+
+// How often certificate files are checked for changes
+const DefaultCheckInterval = 5 * time.Second
+
+var (
+	ErrNoClientCAs    = errors.New("no certificates found in client ca file")
+	ErrNoCertificates = errors.New("no certificates found")
+)
+
+// EnsureSelfSigned returns the certificate and key files in dir, a self-signed certificate for hosts is generated if there is none
+func EnsureSelfSigned(dir string, hosts []string) (certFile, keyFile string, err error) {
+	certFile = filepath.Join(dir, SelfSignedCert)
+	keyFile = filepath.Join(dir, SelfSignedKey)
+
+	if _, err := os.Stat(certFile); err == nil {
+		return certFile, keyFile, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", "", err
+	}
+
+	certPEM, keyPEM, err := selfSigned(hosts, time.Now())
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", "", err
+	}
+	// Write the key first so a certificate is never left without its key
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(certFile, certPEM, 0o644); err != nil {
+		return "", "", err
+	}
+	logging.Default().Info("generated self-signed certificate", "component", "certs", "file", certFile)
+	return certFile, keyFile, nil
+}
+
+// selfSigned creates a PEM encoded ECDSA certificate and key, hosts may be names or IP addresses
+func selfSigned(hosts []string, now time.Time) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "beenfar", Organization: []string{"beenfar"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedLifetime),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// DefaultHosts are the names a self-signed certificate is issued for
+func DefaultHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if name, err := os.Hostname(); err == nil && name != "" && name != "localhost" {
+		hosts = append(hosts, name)
+	}
+	return hosts
+}
+
+// Manager serves a certificate from files and reloads it when they change
+type Manager struct {
+	// CheckInterval is the minimum time between checks for changed files
+	CheckInterval time.Duration
+
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time
+	checked  time.Time
+
+	mu sync.Mutex
+}
+
+// NewManager loads the certificate and key files
+func NewManager(certFile, keyFile string) (*Manager, error) {
+	m := &Manager{
+		CheckInterval: DefaultCheckInterval,
+		certFile:      certFile,
+		keyFile:       keyFile,
+	}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload loads the certificate files, the current certificate is kept if they are invalid
+func (m *Manager) Reload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.reload()
+}
+
+func (m *Manager) reload() error {
+	modTime, err := m.lastModified()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(m.certFile, m.keyFile)
+	if err != nil {
+		return err
+	}
+
+	m.cert = &cert
+	m.modTime = modTime
+	m.checked = time.Now()
+	return nil
+}
+
+// lastModified returns the newest modification time of the certificate and key
+func (m *Manager) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{m.certFile, m.keyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate, it reloads the files if they changed
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if time.Since(m.checked) >= m.CheckInterval {
+		m.checked = time.Now()
+		if modTime, err := m.lastModified(); err == nil && !modTime.Equal(m.modTime) {
+			if err := m.reload(); err != nil {
+				logging.Default().Error("error reloading certificate, keeping the current one", "component", "certs", "file", m.certFile, "error", err)
+			} else {
+				logging.Default().Info("reloaded certificate", "component", "certs", "file", m.certFile)
+			}
+		}
+	}
+	return m.cert, nil
+}
+
+// TLSConfig returns the server configuration, client certificates are verified against clientCAFile if it is set.
+// Clients without a certificate are still accepted so they can use passwords and api tokens.
+func (m *Manager) TLSConfig(clientCAFile string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		GetCertificate: m.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	if clientCAFile != "" {
+		pool, err := LoadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+// LoadCertPool reads the PEM encoded certificates in a file
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("%s: %w", file, ErrNoClientCAs)
+	}
+	return pool, nil
+}
+
+// LoadCertificates reads every PEM encoded certificate in a file, in order
+func LoadCertificates(file string) ([]*x509.Certificate, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*x509.Certificate
+	for {
+		var block *pem.Block
+		if block, b = pem.Decode(b); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		result = append(result, cert)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%s: %w", file, ErrNoCertificates)
+	}
+	return result, nil
+}
+
+// Fingerprint returns the sha256 fingerprint of a PEM encoded certificate file, for logging
+func Fingerprint(certFile string) (string, error) {
+	b, err := os.ReadFile(certFile)
+	if err != nil {
+		return "", err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return "", fmt.Errorf("%s: no certificate found", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(cert.Raw)
+
+	var buf bytes.Buffer
+	for i, b := range sum {
+		if i > 0 {
+			buf.WriteByte(':')
+		}
+		fmt.Fprintf(&buf, "%02X", b)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,168 @@
+package certs_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service/certs"
+)
+
+func TestEnsureSelfSigned(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "tls")
+
+	certFile, keyFile, err := certs.EnsureSelfSigned(dir, []string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("Expected key mode %o, got %o", 0o600, perm)
+	}
+	first, err := certs.Fingerprint(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The certificate is kept across restarts
+	if _, _, err := certs.EnsureSelfSigned(dir, []string{"localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	if second, _ := certs.Fingerprint(certFile); second != first {
+		t.Errorf("Expected fingerprint %s to be kept, got %s", first, second)
+	}
+}
+
+func TestManagerReload(t *testing.T) {
+	certFile, keyFile, err := certs.EnsureSelfSigned(filepath.Join(t.TempDir(), "a"), []string{"localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := certs.NewManager(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.CheckInterval = 0
+
+	first, err := m.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Invalid files keep the current certificate
+	if err := os.WriteFile(certFile, []byte("invalid"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, certFile, time.Now().Add(time.Minute))
+	if cert, _ := m.GetCertificate(&tls.ClientHelloInfo{}); cert != first {
+		t.Error("Expected the current certificate to be kept")
+	}
+
+	// Replaced files are picked up
+	newCert, newKey, err := certs.EnsureSelfSigned(filepath.Join(t.TempDir(), "b"), []string{"localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	copyFile(t, newCert, certFile)
+	copyFile(t, newKey, keyFile)
+	touch(t, certFile, time.Now().Add(2*time.Minute))
+
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(cert.Certificate[0], first.Certificate[0]) {
+		t.Error("Expected the new certificate to be loaded")
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	certFile, keyFile, err := certs.EnsureSelfSigned(t.TempDir(), []string{"localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := certs.NewManager(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := m.TLSConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ClientAuth != tls.NoClientCert {
+		t.Errorf("Expected no client certificates, got %v", cfg.ClientAuth)
+	}
+
+	if cfg, err = m.TLSConfig(certFile); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ClientAuth != tls.VerifyClientCertIfGiven {
+		t.Errorf("Expected client certificates to be verified, got %v", cfg.ClientAuth)
+	}
+
+	if _, err := m.TLSConfig(keyFile); err == nil {
+		t.Error("Expected an error for a client ca file without certificates")
+	}
+}
+
+func TestLoadCertificates(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, err := certs.EnsureSelfSigned(dir, []string{"localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys in the same file are skipped
+	pem, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := filepath.Join(dir, "bundle.pem")
+	if err := os.WriteFile(bundle, append(append(pem, key...), pem...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := certs.LoadCertificates(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Errorf("Expected 2 certificates, got %d", len(list))
+	}
+
+	if _, err := certs.LoadCertificates(keyFile); !errors.Is(err, certs.ErrNoCertificates) {
+		t.Errorf("Expected ErrNoCertificates, got %v", err)
+	}
+}
+
+func touch(t *testing.T, file string, mtime time.Time) {
+	t.Helper()
+
+	if err := os.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+
+	b, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
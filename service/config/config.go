@@ -0,0 +1,445 @@
+// Package config loads the beenfard configuration from a YAML file, environment variables and flags.
+// Flags take precedence over environment variables, which take precedence over the file.
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service/certs"
+	"github.com/jacobalberty/beenfar/service/profile"
+	"gopkg.in/yaml.v3"
+)
+
+// Prefix of all environment variables
+const EnvPrefix = "BEENFAR_"
+
+// Log levels accepted by LogLevel
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// Config is the configuration of beenfard
+type Config struct {
+	Listen ListenConfig `yaml:"listen"`
+	// DataDir holds generated state such as the UniFi key
+	DataDir  string `yaml:"data_dir"`
+	LogLevel string `yaml:"log_level"`
+	// InformInterval is how often adopted devices are told to check in
+	InformInterval time.Duration `yaml:"inform_interval"`
+	// DrainTimeout is how long shutdown waits for in-flight requests and background workers
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+	TLS          TLSConfig     `yaml:"tls"`
+	OpenWRT      OpenWRTConfig `yaml:"openwrt"`
+	EdgeOS       EdgeOSConfig  `yaml:"edgeos"`
+	Profile      ProfileConfig `yaml:"profile"`
+	// AdminPassword is the password of the admin created on first run, a random one is generated if empty
+	AdminPassword string `yaml:"admin_password"`
+	// UnifiKey is the hex encoded key adopted UniFi devices are given, it is generated and saved to DataDir if empty
+	UnifiKey string `yaml:"unifi_key"`
+}
+
+// ListenConfig holds the listen addresses, an empty address disables a listener
+type ListenConfig struct {
+	// Inform serves /inform to devices over plain HTTP
+	Inform string `yaml:"inform"`
+	// API serves the management api over HTTPS
+	API string `yaml:"api"`
+	// APIRedirect redirects plain HTTP requests to the api
+	APIRedirect string `yaml:"api_redirect"`
+	// Portal serves the guest portal
+	Portal string `yaml:"portal"`
+	// Firmware serves firmware downloads to devices
+	Firmware string `yaml:"firmware"`
+}
+
+// TLSConfig holds the certificate the api is served with, a self-signed certificate is generated in the data directory if empty.
+// The files are reloaded when they change.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile enables client certificate authentication, certificates signed by these CAs authenticate as the user named by their common name
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// OpenWRTConfig holds the settings of the OpenWRT driver
+type OpenWRTConfig struct {
+	// Uplink is the port of the routers wired networks are bridged to
+	Uplink string `yaml:"uplink"`
+}
+
+// EdgeOSConfig holds the settings of the EdgeOS driver
+type EdgeOSConfig struct {
+	// Interface is the port of the routers networks are served on
+	Interface string `yaml:"interface"`
+	// WAN is the port of the routers facing the internet
+	WAN string `yaml:"wan"`
+}
+
+// ProfileConfig holds the settings of the wifi profiles mobile devices are provisioned with
+type ProfileConfig struct {
+	// Organization is shown as the issuer of Apple profiles
+	Organization string `yaml:"organization"`
+	// SigningCertFile and SigningKeyFile sign Apple profiles, the certificate file may hold intermediates after the certificate
+	SigningCertFile string `yaml:"signing_cert_file"`
+	SigningKeyFile  string `yaml:"signing_key_file"`
+	// RadiusCAFile holds the certificates WPA enterprise clients trust the radius server with
+	RadiusCAFile string `yaml:"radius_ca_file"`
+	// RadiusServerNames are the names the radius server certificate may have
+	RadiusServerNames []string `yaml:"radius_server_names"`
+	// PasspointDomain is the home domain and realm of Passpoint subscriptions, they are not available if empty
+	PasspointDomain string `yaml:"passpoint_domain"`
+}
+
+// Default returns the configuration used for settings that are not set anywhere else
+func Default() Config {
+	return Config{
+		Listen:         ListenConfig{Inform: ":8080", API: ":8443"},
+		DataDir:        ".",
+		LogLevel:       "info",
+		InformInterval: 10 * time.Second,
+		DrainTimeout:   30 * time.Second,
+		OpenWRT:        OpenWRTConfig{Uplink: "eth0"},
+		EdgeOS:         EdgeOSConfig{Interface: "eth1", WAN: "eth0"},
+		Profile:        ProfileConfig{Organization: "beenfar"},
+	}
+}
+
+// A setting can be set by a flag and an environment variable, secrets have no flag so they do not show up in process lists
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+var settings = []setting{
+	{"listen-inform", "LISTEN_INFORM", "address to serve device informs on", func(c *Config, v string) error {
+		c.Listen.Inform = v
+		return nil
+	}},
+	{"listen-api", "LISTEN_API", "address to serve the management api on", func(c *Config, v string) error {
+		c.Listen.API = v
+		return nil
+	}},
+	{"listen-api-redirect", "LISTEN_API_REDIRECT", "address to redirect plain HTTP to the api on, disabled if empty", func(c *Config, v string) error {
+		c.Listen.APIRedirect = v
+		return nil
+	}},
+	{"listen-portal", "LISTEN_PORTAL", "address to serve the guest portal on, disabled if empty", func(c *Config, v string) error {
+		c.Listen.Portal = v
+		return nil
+	}},
+	{"listen-firmware", "LISTEN_FIRMWARE", "address to serve firmware downloads on, disabled if empty", func(c *Config, v string) error {
+		c.Listen.Firmware = v
+		return nil
+	}},
+	{"data-dir", "DATA_DIR", "directory for generated state", func(c *Config, v string) error {
+		c.DataDir = v
+		return nil
+	}},
+	{"log-level", "LOG_LEVEL", "log level, one of " + strings.Join(LogLevels, ", "), func(c *Config, v string) error {
+		c.LogLevel = v
+		return nil
+	}},
+	{"inform-interval", "INFORM_INTERVAL", "how often adopted devices check in, such as 10s", func(c *Config, v string) (err error) {
+		c.InformInterval, err = time.ParseDuration(v)
+		return err
+	}},
+	{"drain-timeout", "DRAIN_TIMEOUT", "how long shutdown waits for in-flight requests, such as 30s", func(c *Config, v string) (err error) {
+		c.DrainTimeout, err = time.ParseDuration(v)
+		return err
+	}},
+	{"tls-cert", "TLS_CERT", "TLS certificate file", func(c *Config, v string) error {
+		c.TLS.CertFile = v
+		return nil
+	}},
+	{"tls-key", "TLS_KEY", "TLS private key file", func(c *Config, v string) error {
+		c.TLS.KeyFile = v
+		return nil
+	}},
+	{"tls-client-ca", "TLS_CLIENT_CA", "CA certificates that sign client certificates, client certificates are not accepted if empty", func(c *Config, v string) error {
+		c.TLS.ClientCAFile = v
+		return nil
+	}},
+	{"openwrt-uplink", "OPENWRT_UPLINK", "port of OpenWRT routers wired networks are bridged to", func(c *Config, v string) error {
+		c.OpenWRT.Uplink = v
+		return nil
+	}},
+	{"edgeos-interface", "EDGEOS_INTERFACE", "port of EdgeOS routers networks are served on", func(c *Config, v string) error {
+		c.EdgeOS.Interface = v
+		return nil
+	}},
+	{"edgeos-wan", "EDGEOS_WAN", "port of EdgeOS routers facing the internet", func(c *Config, v string) error {
+		c.EdgeOS.WAN = v
+		return nil
+	}},
+	{"profile-organization", "PROFILE_ORGANIZATION", "organization shown as the issuer of Apple profiles", func(c *Config, v string) error {
+		c.Profile.Organization = v
+		return nil
+	}},
+	{"profile-signing-cert", "PROFILE_SIGNING_CERT", "certificate Apple profiles are signed with, profiles are not signed if empty", func(c *Config, v string) error {
+		c.Profile.SigningCertFile = v
+		return nil
+	}},
+	{"profile-signing-key", "PROFILE_SIGNING_KEY", "private key of the profile signing certificate", func(c *Config, v string) error {
+		c.Profile.SigningKeyFile = v
+		return nil
+	}},
+	{"profile-radius-ca", "PROFILE_RADIUS_CA", "CA certificates WPA enterprise clients trust the radius server with", func(c *Config, v string) error {
+		c.Profile.RadiusCAFile = v
+		return nil
+	}},
+	{"profile-radius-server-names", "PROFILE_RADIUS_SERVER_NAMES", "comma separated names the radius server certificate may have", func(c *Config, v string) error {
+		c.Profile.RadiusServerNames = splitList(v)
+		return nil
+	}},
+	{"profile-passpoint-domain", "PROFILE_PASSPOINT_DOMAIN", "home domain of Passpoint subscriptions, they are not available if empty", func(c *Config, v string) error {
+		c.Profile.PasspointDomain = v
+		return nil
+	}},
+	{"", "ADMIN_PASSWORD", "", func(c *Config, v string) error {
+		c.AdminPassword = v
+		return nil
+	}},
+	{"", "UNIFI_KEY", "", func(c *Config, v string) error {
+		c.UnifiKey = v
+		return nil
+	}},
+}
+
+// Options are the command line options that are not settings
+type Options struct {
+	// ConfigFile is the YAML file that was loaded, if any
+	ConfigFile string
+	// CheckConfig asks to validate the configuration and exit
+	CheckConfig bool
+}
+
+// Load parses args (without the program name) and reads the configuration file
+// given by -config or BEENFAR_CONFIG. getenv is usually os.Getenv.
+func Load(name string, args []string, getenv func(string) string, output io.Writer) (Config, Options, error) {
+	var (
+		cfg  = Default()
+		opts Options
+		fs   = flag.NewFlagSet(name, flag.ContinueOnError)
+	)
+	fs.SetOutput(output)
+
+	fs.StringVar(&opts.ConfigFile, "config", "", "YAML configuration file, also read from "+EnvPrefix+"CONFIG")
+	fs.BoolVar(&opts.CheckConfig, "check-config", false, "validate the configuration and exit")
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		if s.flag != "" {
+			values[s.flag] = fs.String(s.flag, "", s.usage+", also read from "+EnvPrefix+s.env)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, opts, err
+	}
+	if fs.NArg() != 0 {
+		return cfg, opts, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	if opts.ConfigFile == "" {
+		opts.ConfigFile = getenv(EnvPrefix + "CONFIG")
+	}
+	if opts.ConfigFile != "" {
+		if err := cfg.readFile(opts.ConfigFile); err != nil {
+			return cfg, opts, err
+		}
+	}
+
+	for _, s := range settings {
+		if v := getenv(EnvPrefix + s.env); v != "" {
+			if err := s.set(&cfg, v); err != nil {
+				return cfg, opts, fmt.Errorf("%s%s: %w", EnvPrefix, s.env, err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && err == nil {
+				if serr := s.set(&cfg, *values[s.flag]); serr != nil {
+					err = fmt.Errorf("-%s: %w", s.flag, serr)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return cfg, opts, err
+	}
+
+	return cfg, opts, cfg.Validate()
+}
+
+// readFile reads a YAML configuration file over c, unknown keys are rejected
+func (c *Config) readFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks the configuration without touching the file system
+func (c Config) Validate() error {
+	var errs []string
+
+	if c.Listen.Inform == "" {
+		errs = append(errs, "listen.inform must not be empty")
+	}
+	if c.Listen.API == "" {
+		errs = append(errs, "listen.api must not be empty")
+	}
+	seen := make(map[string]string)
+	for _, l := range []struct{ name, addr string }{
+		{"inform", c.Listen.Inform},
+		{"api", c.Listen.API},
+		{"api_redirect", c.Listen.APIRedirect},
+		{"portal", c.Listen.Portal},
+		{"firmware", c.Listen.Firmware},
+	} {
+		if l.addr == "" {
+			continue
+		}
+		if other, ok := seen[l.addr]; ok {
+			errs = append(errs, fmt.Sprintf("listen.%s and listen.%s both use %s", other, l.name, l.addr))
+		}
+		seen[l.addr] = l.name
+	}
+	if c.DataDir == "" {
+		errs = append(errs, "data_dir must not be empty")
+	}
+	if !validLogLevel(c.LogLevel) {
+		errs = append(errs, fmt.Sprintf("log_level must be one of %s", strings.Join(LogLevels, ", ")))
+	}
+	if c.Listen.APIRedirect != "" && c.APIPort() == "" {
+		errs = append(errs, "listen.api must include a port to redirect to")
+	}
+	if c.InformInterval < time.Second {
+		errs = append(errs, "inform_interval must be at least 1s")
+	}
+	if c.DrainTimeout <= 0 {
+		errs = append(errs, "drain_timeout must be positive")
+	}
+	if c.OpenWRT.Uplink == "" {
+		errs = append(errs, "openwrt.uplink must not be empty")
+	}
+	if c.EdgeOS.Interface == "" {
+		errs = append(errs, "edgeos.interface must not be empty")
+	}
+	if c.EdgeOS.WAN == "" {
+		errs = append(errs, "edgeos.wan must not be empty")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, "tls.cert_file and tls.key_file must be set together")
+	}
+	if (c.Profile.SigningCertFile == "") != (c.Profile.SigningKeyFile == "") {
+		errs = append(errs, "profile.signing_cert_file and profile.signing_key_file must be set together")
+	}
+	for _, name := range c.Profile.RadiusServerNames {
+		if name == "" || strings.ContainsAny(name, " \t") {
+			errs = append(errs, fmt.Sprintf("profile.radius_server_names: invalid name %q", name))
+		}
+	}
+	if c.UnifiKey != "" {
+		if key, err := hex.DecodeString(c.UnifiKey); err != nil || len(key) != 16 {
+			errs = append(errs, "unifi_key must be 32 hex digits")
+		}
+	}
+
+	if len(errs) != 0 {
+		return errors.New("invalid configuration: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, empty elements are dropped
+func splitList(v string) []string {
+	var list []string
+	for _, element := range strings.Split(v, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			list = append(list, element)
+		}
+	}
+	return list
+}
+
+func validLogLevel(level string) bool {
+	for _, l := range LogLevels {
+		if level == l {
+			return true
+		}
+	}
+	return false
+}
+
+// Key returns the decoded UniFi key, nil if none is set
+func (c Config) Key() []byte {
+	key, err := hex.DecodeString(c.UnifiKey)
+	if err != nil || len(key) == 0 {
+		return nil
+	}
+	return key
+}
+
+// APIPort returns the port of the api listener
+func (c Config) APIPort() string {
+	_, port, err := net.SplitHostPort(c.Listen.API)
+	if err != nil {
+		return ""
+	}
+	return port
+}
+
+// Check validates the configuration and makes sure the files it refers to can be used
+func (c Config) Check() error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	if c.TLS.CertFile != "" {
+		if _, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile); err != nil {
+			return fmt.Errorf("invalid TLS certificate: %w", err)
+		}
+	}
+	if c.TLS.ClientCAFile != "" {
+		if _, err := certs.LoadCertPool(c.TLS.ClientCAFile); err != nil {
+			return fmt.Errorf("invalid client ca: %w", err)
+		}
+	}
+	if c.Profile.SigningCertFile != "" {
+		if _, err := profile.LoadSigner(c.Profile.SigningCertFile, c.Profile.SigningKeyFile); err != nil {
+			return fmt.Errorf("invalid profile signing certificate: %w", err)
+		}
+	}
+	if c.Profile.RadiusCAFile != "" {
+		if _, err := certs.LoadCertificates(c.Profile.RadiusCAFile); err != nil {
+			return fmt.Errorf("invalid radius ca: %w", err)
+		}
+	}
+
+	if info, err := os.Stat(c.DataDir); err == nil && !info.IsDir() {
+		return fmt.Errorf("data_dir %s is not a directory", c.DataDir)
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("data_dir: %w", err)
+	}
+	return nil
+}
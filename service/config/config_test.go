@@ -0,0 +1,147 @@
+package config_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service/config"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "beenfar.yaml")
+	err := os.WriteFile(file, []byte(`
+listen:
+  api: ":9000"
+data_dir: /from/file
+log_level: warn
+inform_interval: 20s
+admin_password: secret
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"BEENFAR_CONFIG":                      file,
+		"BEENFAR_DATA_DIR":                    "/from/env",
+		"BEENFAR_INFORM_INTERVAL":             "30s",
+		"BEENFAR_PROFILE_RADIUS_SERVER_NAMES": "radius.example.com, ,radius2.example.com",
+	}
+
+	cfg, opts, err := config.Load("beenfard", []string{"-inform-interval", "40s"}, func(k string) string { return env[k] }, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.ConfigFile != file {
+		t.Errorf("Expected config file %s, got %s", file, opts.ConfigFile)
+	}
+
+	// Defaults are kept, the file overrides defaults, env overrides the file and flags override env
+	if cfg.Listen.API != ":9000" {
+		t.Errorf("Expected api address from the file, got %s", cfg.Listen.API)
+	}
+	if cfg.Listen.Inform != ":8080" {
+		t.Errorf("Expected the default inform address, got %s", cfg.Listen.Inform)
+	}
+	if cfg.LogLevel != "warn" {
+		t.Errorf("Expected log level from the file, got %s", cfg.LogLevel)
+	}
+	if cfg.AdminPassword != "secret" {
+		t.Errorf("Expected admin password from the file, got %q", cfg.AdminPassword)
+	}
+	if cfg.DataDir != "/from/env" {
+		t.Errorf("Expected data dir from env, got %s", cfg.DataDir)
+	}
+	if cfg.InformInterval != 40*time.Second {
+		t.Errorf("Expected inform interval from flags, got %s", cfg.InformInterval)
+	}
+	if cfg.TLS.CertFile != "" {
+		t.Errorf("Expected no TLS certificate, got %s", cfg.TLS.CertFile)
+	}
+	if names := strings.Join(cfg.Profile.RadiusServerNames, " "); names != "radius.example.com radius2.example.com" {
+		t.Errorf("Expected radius server names from env, got %q", names)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	noEnv := func(string) string { return "" }
+
+	file := filepath.Join(t.TempDir(), "beenfar.yaml")
+	if err := os.WriteFile(file, []byte("listen_address: :8080\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{"unknown key", []string{"-config", file}, "listen_address"},
+		{"log level", []string{"-log-level", "verbose"}, "log_level"},
+		{"interval", []string{"-inform-interval", "1ms"}, "inform_interval"},
+		{"duration", []string{"-inform-interval", "often"}, "inform-interval"},
+		{"drain timeout", []string{"-drain-timeout", "0s"}, "drain_timeout"},
+		{"openwrt uplink", []string{"-openwrt-uplink", ""}, "openwrt.uplink"},
+		{"edgeos interface", []string{"-edgeos-interface", ""}, "edgeos.interface"},
+		{"edgeos wan", []string{"-edgeos-wan", ""}, "edgeos.wan"},
+		{"tls", []string{"-tls-cert", "cert.pem"}, "tls.key_file"},
+		{"profile signing", []string{"-profile-signing-key", "key.pem"}, "profile.signing_cert_file"},
+		{"arguments", []string{"extra"}, "unexpected arguments"},
+		{"shared address", []string{"-listen-portal", ":8080"}, "listen.inform and listen.portal"},
+		{"redirect port", []string{"-listen-api", "localhost", "-listen-api-redirect", ":80"}, "listen.api must include a port"},
+	} {
+		_, _, err := config.Load("beenfard", tc.args, noEnv, io.Discard)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: Expected an error mentioning %q, got %v", tc.name, tc.err, err)
+		}
+	}
+
+	env := map[string]string{"BEENFAR_UNIFI_KEY": "abcd"}
+	if _, _, err := config.Load("beenfard", nil, func(k string) string { return env[k] }, io.Discard); err == nil || !strings.Contains(err.Error(), "unifi_key") {
+		t.Errorf("Expected an error mentioning unifi_key, got %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg := config.Default()
+	cfg.DataDir = dir
+	if err := cfg.Check(); err != nil {
+		t.Errorf("Expected the default configuration to be valid, got %v", err)
+	}
+
+	cfg.TLS.CertFile = filepath.Join(dir, "missing.pem")
+	cfg.TLS.KeyFile = filepath.Join(dir, "missing.key")
+	if err := cfg.Check(); err == nil {
+		t.Error("Expected missing TLS files to be rejected")
+	}
+
+	cfg = config.Default()
+	cfg.DataDir = dir
+	cfg.TLS.ClientCAFile = filepath.Join(dir, "missing-ca.pem")
+	if err := cfg.Check(); err == nil {
+		t.Error("Expected a missing client ca file to be rejected")
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg = config.Default()
+	cfg.DataDir = dir
+	cfg.Profile.RadiusCAFile = file
+	if err := cfg.Check(); err == nil {
+		t.Error("Expected a radius ca file without certificates to be rejected")
+	}
+
+	cfg = config.Default()
+	cfg.DataDir = file
+	if err := cfg.Check(); err == nil {
+		t.Error("Expected a data dir that is a file to be rejected")
+	}
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// Media type of the audit log export, one json object per line
+const jsonLinesMediaType = "application/x-ndjson"
+
+// AuditRequest records a change made by the authenticated user
+func AuditRequest(audit *model.AuditLog, r *http.Request, action, target string, before, after any) {
+	var actor string
+	if user, ok := currentUser(r); ok {
+		actor = user.Username
+	}
+	AuditAs(audit, r, actor, action, target, before, after)
+}
+
+// AuditAs records a change made by an explicit actor, such as a device or a failed login
+func AuditAs(audit *model.AuditLog, r *http.Request, actor, action, target string, before, after any) {
+	_, err := audit.Append(model.AuditEntry{
+		Actor:    actor,
+		Action:   action,
+		Target:   target,
+		SourceIP: SourceIP(r),
+		Changes:  model.AuditDiff(before, after),
+	})
+	if err != nil {
+		logging.FromContext(r.Context()).Error("error writing audit log", "error", err)
+	}
+}
+
+// SourceIP returns the address of the client that made the request
+func SourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Returns the audit log filtered by the since, until and actor query parameters
+func (h *HttpHandler) GetAuditList(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid Filter", err.Error())
+		return
+	}
+
+	entries := h.audit.Query(filter)
+	entryList := make([]*model.AuditEntry, 0, len(entries))
+	for _, entry := range entries {
+		entry := entry
+		entryList = append(entryList, &entry)
+	}
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayload(w, entryList); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Exports the audit log as JSON lines, accepts the same filters as GetAuditList
+func (h *HttpHandler) GetAuditExport(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid Filter", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonLinesMediaType)
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	enc := json.NewEncoder(w)
+	for _, entry := range h.audit.Query(filter) {
+		if err := enc.Encode(entry); err != nil {
+			logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+			return
+		}
+	}
+}
+
+// auditFilter parses the audit query parameters.
+// Times are accepted as unix timestamps or RFC 3339.
+func auditFilter(r *http.Request) (model.AuditFilter, error) {
+	var (
+		filter model.AuditFilter
+		err    error
+		query  = r.URL.Query()
+	)
+
+	filter.Actor = query.Get("actor")
+	if filter.Since, err = parseTime(query.Get("since")); err != nil {
+		return filter, err
+	}
+	if filter.Until, err = parseTime(query.Get("until")); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if unix, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,142 @@
+package controller_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+const testRemoteAddr = "192.0.2.1:1234"
+
+func TestAuditLog(t *testing.T) {
+	var (
+		h        *service.BeenFarService
+		testWifi model.WifiNetworkConfig
+	)
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
+	admin := authorize(t, h)
+
+	createUser(t, admin, "operator", model.RoleOperator)
+	operator := authorizeAs(t, h, "operator")
+
+	response := send(t, admin, "POST", "/api/wifi", &model.WifiNetworkConfig{
+		Ssid:         "audited",
+		SecurityType: model.WifiSecurityTypeWpaPersonal,
+		SecurityKey:  "supersecret",
+	})
+	if err := jsonapi.UnmarshalPayload(response.Body, &testWifi); err != nil {
+		t.Fatal(err)
+	}
+	id := testWifi.ID
+
+	patch := `{"data":{"type":"wifi","id":"` + id + `","attributes":{"security_key":"evenmoresecret"}}}`
+	if response = sendRaw(t, admin, "PATCH", "/api/wifi/"+id, patch); response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	patch = `{"data":{"type":"wifi","id":"` + id + `","attributes":{"hidden":true}}}`
+	if response = sendRaw(t, operator, "PATCH", "/api/wifi/"+id, patch); response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	if response = send(t, operator, "DELETE", "/api/wifi/"+id, nil); response.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, response.Code)
+	}
+
+	// Only admins may read the audit log
+	if response = send(t, operator, "GET", "/api/audit", nil); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+
+	response = send(t, admin, "GET", "/api/audit/export", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	body := response.Body.String()
+	if strings.Contains(body, "supersecret") || strings.Contains(body, "evenmoresecret") || strings.Contains(body, testPassword) {
+		t.Errorf("Expected secrets to be redacted from the audit log:\n%s", body)
+	}
+
+	var entries []model.AuditEntry
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		var entry model.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+
+	var actions []string
+	for _, entry := range entries {
+		actions = append(actions, entry.Actor+" "+entry.Action)
+	}
+	expected := []string{
+		"admin session.login",
+		"admin user.create",
+		"operator session.login",
+		"admin wifi.create",
+		"admin wifi.update",
+		"operator wifi.update",
+		"operator wifi.delete",
+	}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Fatalf("Expected audit entries %v, got %v", expected, actions)
+	}
+
+	keyChange := entries[4]
+	if keyChange.Target != "wifi/"+id {
+		t.Errorf("Expected target %s, got %s", "wifi/"+id, keyChange.Target)
+	}
+	if len(keyChange.Changes) != 1 || keyChange.Changes[0].Field != "security_key" ||
+		keyChange.Changes[0].Before != model.AuditRedacted || keyChange.Changes[0].After != model.AuditRedacted {
+		t.Errorf("Expected a redacted security_key change, got %+v", keyChange.Changes)
+	}
+	if keyChange.SourceIP != "192.0.2.1" {
+		t.Errorf("Expected source ip %s, got %s", "192.0.2.1", keyChange.SourceIP)
+	}
+
+	hiddenChange := entries[5]
+	if len(hiddenChange.Changes) != 1 || hiddenChange.Changes[0].Field != "hidden" || hiddenChange.Changes[0].After != true {
+		t.Errorf("Expected a hidden change, got %+v", hiddenChange.Changes)
+	}
+
+	// Filter by actor
+	response = send(t, admin, "GET", "/api/audit?actor=operator", nil)
+	list, err := jsonapi.UnmarshalManyPayload(response.Body, reflect.TypeOf(new(model.AuditEntry)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 {
+		t.Errorf("Expected 3 entries by operator, got %d", len(list))
+	}
+
+	// Filter by time
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	response = send(t, admin, "GET", "/api/audit?since="+future, nil)
+	if list, err = jsonapi.UnmarshalManyPayload(response.Body, reflect.TypeOf(new(model.AuditEntry))); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 0 {
+		t.Errorf("Expected no entries in the future, got %d", len(list))
+	}
+
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	response = send(t, admin, "GET", "/api/audit/export?until="+past, nil)
+	if response.Body.Len() != 0 {
+		t.Errorf("Expected no entries an hour ago, got %s", response.Body.String())
+	}
+
+	if response = send(t, admin, "GET", "/api/audit?since=yesterday", nil); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, response.Code)
+	}
+}
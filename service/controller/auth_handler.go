@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// Name of the cookie holding the session secret
+const SessionCookie = "beenfar_session"
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	siteContextKey
+)
+
+type AuthHandler struct {
+	users *model.Users
+	sites *model.Sites
+	audit *model.AuditLog
+}
+
+func (h *AuthHandler) Init(router chi.Router, users *model.Users, sites *model.Sites, audit *model.AuditLog) {
+	h.users = users
+	h.sites = sites
+	h.audit = audit
+
+	router.Post("/api/login", h.PostLogin)
+	router.Post("/api/logout", h.PostLogout)
+
+	router.Group(func(r chi.Router) {
+		r.Use(h.Authenticate)
+		r.Get("/api/token", h.GetTokenList)
+		r.Post("/api/token", h.PostToken)
+		r.Delete("/api/token/{id:^[[:xdigit:]]{24}$}", h.DeleteToken)
+		r.Get("/api/session", h.GetSession)
+		r.Patch("/api/user/{id:^[[:xdigit:]]{24}$}", h.PatchUser)
+
+		admin := r.With(RequireRole(model.RoleAdmin))
+		admin.Get("/api/user", h.GetUserList)
+		admin.Post("/api/user", h.PostUser)
+		admin.Delete("/api/user/{id:^[[:xdigit:]]{24}$}", h.DeleteUser)
+	})
+}
+
+// Authenticate is middleware that rejects requests without a valid session cookie, api token or client certificate.
+// Api tokens are passed as "Authorization: Bearer <token>". Client certificates authenticate as the user named
+// by their common name, they are only present if the listener verifies them.
+func (h *AuthHandler) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			user model.User
+			err  = model.ErrSessionNotFound
+		)
+
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			scheme, secret, _ := strings.Cut(auth, " ")
+			if strings.EqualFold(scheme, "Bearer") {
+				user, err = h.users.TokenUser(strings.TrimSpace(secret))
+			}
+		} else if r.TLS != nil && len(r.TLS.VerifiedChains) != 0 {
+			user, err = h.users.GetByUsername(r.TLS.VerifiedChains[0][0].Subject.CommonName)
+		} else if cookie, cerr := r.Cookie(SessionCookie); cerr == nil {
+			user, err = h.users.SessionUser(cookie.Value)
+		}
+
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="beenfar"`)
+			WriteError(w, http.StatusUnauthorized, "Unauthorized", "A valid session or api token is required")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
+	})
+}
+
+// RequireRole is middleware that only allows users with at least the given role, it must run after Authenticate
+// and on routes of a site after SiteContext or DeviceSite
+func RequireRole(role model.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := currentUser(r)
+			if !ok {
+				WriteError(w, http.StatusUnauthorized, "Unauthorized", "A valid session or api token is required")
+				return
+			}
+			if !siteRole(r, user).Allows(role) {
+				WriteError(w, http.StatusForbidden, "Forbidden", "User "+user.Username+" is not allowed to perform this operation")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// hasRole checks if the authenticated user has at least the given role
+func hasRole(r *http.Request, role model.Role) bool {
+	user, ok := currentUser(r)
+	return ok && siteRole(r, user).Allows(role)
+}
+
+// siteRole returns the role of a user for a request. Users limited to some sites only have their role
+// on requests scoped to one of those sites by SiteContext or DeviceSite, they can only read everywhere else.
+func siteRole(r *http.Request, user model.User) model.Role {
+	site := currentSite(r)
+	if len(user.Sites) == 0 || site != "" && user.InSite(site) {
+		return user.Role
+	}
+	return model.RoleReadOnly
+}
+
+// currentUser returns the user authenticated by Authenticate
+func currentUser(r *http.Request) (model.User, bool) {
+	user, ok := r.Context().Value(userContextKey).(model.User)
+	return user, ok
+}
+
+// Logs in with a username and password and starts a session
+func (h *AuthHandler) PostLogin(w http.ResponseWriter, r *http.Request) {
+	login := new(model.User)
+	if err := jsonapi.UnmarshalPayload(r.Body, login); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.users.Authenticate(login.Username, login.Password)
+	if err != nil {
+		AuditAs(h.audit, r, login.Username, "session.login_failed", "user/"+login.Username, nil, nil)
+		WriteError(w, http.StatusUnauthorized, "Login Failed", err.Error())
+		return
+	}
+
+	secret, err := h.users.NewSession(user.ID)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Login Failed", err.Error())
+		return
+	}
+	AuditAs(h.audit, r, user.Username, "session.login", "user/"+user.ID, nil, nil)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    secret,
+		Path:     "/api",
+		Expires:  time.Now().Add(model.SessionLifetime),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &user); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+	}
+}
+
+// Ends the current session
+func (h *AuthHandler) PostLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		if user, err := h.users.SessionUser(cookie.Value); err == nil {
+			AuditAs(h.audit, r, user.Username, "session.logout", "user/"+user.ID, nil, nil)
+		}
+		h.users.EndSession(cookie.Value)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Path:     "/api",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Returns the api tokens of the current user, secrets are not included
+func (h *AuthHandler) GetTokenList(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+
+	tokens := h.users.Tokens(user.ID)
+	tokenList := make([]*model.APIToken, 0, len(tokens))
+	for _, token := range tokens {
+		token := token
+		tokenList = append(tokenList, &token)
+	}
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayload(w, tokenList); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Creates an api token for the current user, the response is the only time the secret is returned
+func (h *AuthHandler) PostToken(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+
+	request := new(model.APIToken)
+	if err := jsonapi.UnmarshalPayload(r.Body, request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, err := h.users.NewToken(user.ID, request.Name)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Error creating token", err.Error())
+		return
+	}
+	AuditRequest(h.audit, r, "token.create", "token/"+token.ID, nil, token)
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	w.WriteHeader(http.StatusCreated)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &token); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+	}
+}
+
+// Revokes one of the current user's api tokens
+func (h *AuthHandler) DeleteToken(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+
+	id := chi.URLParam(r, "id")
+	if err := h.users.DeleteToken(user.ID, id); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, model.ErrTokenNotFound) {
+			status = http.StatusNotFound
+		}
+		WriteError(w, status, "Error revoking token", err.Error())
+		return
+	}
+	AuditRequest(h.audit, r, "token.delete", "token/"+id, nil, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
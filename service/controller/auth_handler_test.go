@@ -0,0 +1,344 @@
+package controller_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service"
+	"github.com/jacobalberty/beenfar/service/controller"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+const testPassword = "password"
+
+func TestAuthRequired(t *testing.T) {
+	var (
+		h        *service.BeenFarService
+		response *httptest.ResponseRecorder
+	)
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
+
+	for _, route := range []struct{ method, path string }{
+		{"GET", "/api/device"},
+		{"POST", "/api/device/adopt/deadbeef0000"},
+		{"DELETE", "/api/device/deadbeef0000"},
+		{"GET", "/api/wifi"},
+		{"POST", "/api/wifi"},
+		{"GET", "/api/token"},
+	} {
+		req, err := http.NewRequest(route.method, route.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response = executeRequest(h, req)
+		if response.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s: Expected status %d, got %d", route.method, route.path, http.StatusUnauthorized, response.Code)
+		}
+	}
+
+	// Invalid tokens and sessions are rejected too
+	req, err := http.NewRequest("GET", "/api/device", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer invalid")
+
+	response = executeRequest(h, req)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, response.Code)
+	}
+
+	if req, err = http.NewRequest("GET", "/api/device", nil); err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: controller.SessionCookie, Value: "invalid"})
+
+	response = executeRequest(h, req)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, response.Code)
+	}
+
+	// Devices do not authenticate, /inform is only served by the inform listener
+	if req, err = http.NewRequest("POST", "/inform", bytes.NewBuffer(make([]byte, 40))); err != nil {
+		t.Fatal(err)
+	}
+
+	response = executeRequest(h.Handler(service.ListenerInform), req)
+	if response.Code == http.StatusUnauthorized {
+		t.Errorf("Expected /inform to not require authentication")
+	}
+
+	if req, err = http.NewRequest("POST", "/inform", bytes.NewBuffer(make([]byte, 40))); err != nil {
+		t.Fatal(err)
+	}
+
+	response = executeRequest(h, req)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected /inform to not be served by the api, got status %d", response.Code)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	var (
+		h        *service.BeenFarService
+		response *httptest.ResponseRecorder
+		user     model.User
+	)
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
+
+	// Wrong password
+	response = login(t, h, service.BootstrapAdmin, "wrong")
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, response.Code)
+	}
+
+	// Unknown user
+	response = login(t, h, "nobody", testPassword)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, response.Code)
+	}
+
+	response = login(t, h, service.BootstrapAdmin, testPassword)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+
+	if err := jsonapi.UnmarshalPayload(response.Body, &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != service.BootstrapAdmin {
+		t.Errorf("Expected username %s, got %s", service.BootstrapAdmin, user.Username)
+	}
+	if user.Password != "" {
+		t.Error("Expected the password to not be returned")
+	}
+
+	cookie := sessionCookie(t, response)
+	if !cookie.HttpOnly {
+		t.Error("Expected the session cookie to be HttpOnly")
+	}
+
+	// The session grants access to the api
+	req, err := http.NewRequest("GET", "/api/device", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(cookie)
+
+	response = executeRequest(h, req)
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+
+	// Log out
+	if req, err = http.NewRequest("POST", "/api/logout", nil); err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(cookie)
+
+	response = executeRequest(h, req)
+	if response.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, response.Code)
+	}
+
+	// The session is no longer valid
+	if req, err = http.NewRequest("GET", "/api/device", nil); err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(cookie)
+
+	response = executeRequest(h, req)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, response.Code)
+	}
+}
+
+func TestAPIToken(t *testing.T) {
+	var (
+		h        *service.BeenFarService
+		bTmp     bytes.Buffer
+		response *httptest.ResponseRecorder
+		token    model.APIToken
+	)
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
+	api := authorize(t, h)
+
+	if err := jsonapi.MarshalPayload(&bTmp, &model.APIToken{Name: "automation"}); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", "/api/token", &bTmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response = executeRequest(api, req)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, response.Code)
+	}
+
+	if err = jsonapi.UnmarshalPayload(response.Body, &token); err != nil {
+		t.Fatal(err)
+	}
+	if token.Token == "" {
+		t.Fatal("Expected the token secret to be returned on creation")
+	}
+
+	// The token grants access without a session
+	if req, err = http.NewRequest("GET", "/api/token", nil); err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+
+	response = executeRequest(h, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+
+	tokens, err := jsonapi.UnmarshalManyPayload(response.Body, reflect.TypeOf(new(model.APIToken)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 {
+		t.Fatalf("Expected 1 token, got %d", len(tokens))
+	}
+	if listed := tokens[0].(*model.APIToken); listed.Token != "" || listed.Name != "automation" {
+		t.Errorf("Expected token %q without secret, got %+v", "automation", listed)
+	}
+
+	// Revoke the token
+	if req, err = http.NewRequest("DELETE", "/api/token/"+token.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	response = executeRequest(api, req)
+	if response.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, response.Code)
+	}
+
+	if req, err = http.NewRequest("GET", "/api/device", nil); err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+
+	response = executeRequest(h, req)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, response.Code)
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	var (
+		h        *service.BeenFarService
+		response *httptest.ResponseRecorder
+	)
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
+
+	// The TLS listener has already verified the chain against the client CA
+	verified := func(commonName string) *tls.ConnectionState {
+		return &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: commonName}}}},
+		}
+	}
+
+	req, err := http.NewRequest("GET", "/api/device", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.TLS = verified(service.BootstrapAdmin)
+
+	response = executeRequest(h, req)
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+
+	// Certificates for unknown users and unverified certificates are rejected
+	if req, err = http.NewRequest("GET", "/api/device", nil); err != nil {
+		t.Fatal(err)
+	}
+	req.TLS = verified("unknown")
+
+	response = executeRequest(h, req)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, response.Code)
+	}
+
+	if req, err = http.NewRequest("GET", "/api/device", nil); err != nil {
+		t.Fatal(err)
+	}
+	req.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: service.BootstrapAdmin}}},
+	}
+
+	response = executeRequest(h, req)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, response.Code)
+	}
+}
+
+// login posts a username and password to /api/login
+func login(t *testing.T, h http.Handler, username, password string) *httptest.ResponseRecorder {
+	var bTmp bytes.Buffer
+	t.Helper()
+
+	if err := jsonapi.MarshalPayload(&bTmp, &model.User{Username: username, Password: password}); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", "/api/login", &bTmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return executeRequest(h, req)
+}
+
+// sessionCookie returns the session cookie set by a login response
+func sessionCookie(t *testing.T, response *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+
+	for _, cookie := range response.Result().Cookies() {
+		if cookie.Name == controller.SessionCookie {
+			return cookie
+		}
+	}
+	t.Fatal("Expected a session cookie")
+	return nil
+}
+
+// authorize logs in as the bootstrap admin and returns a handler that sends the session with every request
+func authorize(t *testing.T, h http.Handler) http.Handler {
+	t.Helper()
+	return authorizeAs(t, h, service.BootstrapAdmin)
+}
+
+// authorizeAs logs in as username with testPassword and returns a handler that sends the session with every request
+func authorizeAs(t *testing.T, h http.Handler, username string) http.Handler {
+	t.Helper()
+
+	response := login(t, h, username, testPassword)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d logging in, got %d", http.StatusOK, response.Code)
+	}
+	cookie := sessionCookie(t, response)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.AddCookie(cookie)
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// Returns the dhcp reservations of a network sorted by address
+func (h *HttpHandler) GetDHCPReservationList(w http.ResponseWriter, r *http.Request) {
+	network := chi.URLParam(r, "id")
+	if _, err := siteConfig(r).GetNetwork(network); err != nil {
+		writeNetworkError(w, network, err)
+		return
+	}
+
+	reservations := siteConfig(r).DHCPReservationList(model.NetworkID(network))
+	reservationList := make([]*model.DHCPReservation, 0, len(reservations))
+	for _, reservation := range reservations {
+		reservation := reservation
+		reservationList = append(reservationList, &reservation)
+	}
+	writePayload(w, r, http.StatusOK, reservationList)
+}
+
+// Returns a dhcp reservation of a network by ID
+func (h *HttpHandler) GetDHCPReservation(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := h.networkReservation(w, r)
+	if !ok {
+		return
+	}
+	writePayload(w, r, http.StatusOK, &reservation)
+}
+
+// Creates a dhcp reservation in a network using model.DHCPReservation
+func (h *HttpHandler) PostDHCPReservation(w http.ResponseWriter, r *http.Request) {
+	network := chi.URLParam(r, "id")
+	request := new(model.DHCPReservation)
+	if err := jsonapi.UnmarshalPayload(r.Body, request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.ID != "" {
+		WriteError(w, http.StatusForbidden, "Client Generated ID", "DHCP reservation IDs are assigned by the server")
+		return
+	}
+	if request.Network != "" && string(request.Network) != network {
+		WriteError(w, http.StatusConflict, "Network Mismatch", "Reservation network "+string(request.Network)+" does not match "+network)
+		return
+	}
+	request.Network = model.NetworkID(network)
+	if err := request.Validate(); err != nil {
+		WriteValidationErrors(w, "Invalid DHCP Reservation", err)
+		return
+	}
+
+	reservation, err := siteConfig(r).AddDHCPReservation(*request)
+	if errors.Is(err, model.ErrNetworkNotFound) {
+		writeNetworkError(w, network, err)
+		return
+	} else if err != nil {
+		writeReservationError(w, "", err)
+		return
+	}
+	h.objectChanged(r, "dhcp_reservation", reservation.ID, "create", nil, reservation)
+
+	w.Header().Set("Location", sitePath(r, "/network/"+network+"/reservation/"+reservation.ID))
+	writePayload(w, r, http.StatusCreated, &reservation)
+}
+
+// Partially updates a dhcp reservation, attributes missing from the request are left unchanged.
+// Reservations can not be moved to another network.
+func (h *HttpHandler) PatchDHCPReservation(w http.ResponseWriter, r *http.Request) {
+	current, ok := h.networkReservation(w, r)
+	if !ok {
+		return
+	}
+
+	reservation := current
+	if err := jsonapi.UnmarshalPayload(r.Body, &reservation); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if reservation.ID != current.ID {
+		WriteError(w, http.StatusConflict, "DHCP Reservation ID Mismatch", "DHCP reservation ID "+reservation.ID+" does not match "+current.ID)
+		return
+	}
+	if reservation.Network != current.Network {
+		WriteError(w, http.StatusConflict, "Network Mismatch", "Reservations can not be moved to another network")
+		return
+	}
+	if err := reservation.Validate(); err != nil {
+		WriteValidationErrors(w, "Invalid DHCP Reservation", err)
+		return
+	}
+
+	reservation, err := siteConfig(r).UpdateDHCPReservation(current.ID, reservation)
+	if err != nil {
+		writeReservationError(w, current.ID, err)
+		return
+	}
+	h.objectChanged(r, "dhcp_reservation", current.ID, "update", current, reservation)
+	writePayload(w, r, http.StatusOK, &reservation)
+}
+
+// Deletes a dhcp reservation
+func (h *HttpHandler) DeleteDHCPReservation(w http.ResponseWriter, r *http.Request) {
+	current, ok := h.networkReservation(w, r)
+	if !ok {
+		return
+	}
+	reservation, err := siteConfig(r).DeleteDHCPReservation(current.ID)
+	if err != nil {
+		writeReservationError(w, current.ID, err)
+		return
+	}
+	h.objectChanged(r, "dhcp_reservation", current.ID, "delete", reservation, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Returns the dhcp leases routers reported for a network sorted by address
+func (h *HttpHandler) GetNetworkLeases(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	leases, err := siteConfig(r).NetworkLeases(id, h.devices.Leases(currentSite(r)))
+	if err != nil {
+		writeNetworkError(w, id, err)
+		return
+	}
+
+	leaseList := make([]*model.DHCPLease, 0, len(leases))
+	for _, lease := range leases {
+		lease := lease
+		leaseList = append(leaseList, &lease)
+	}
+	writePayload(w, r, http.StatusOK, leaseList)
+}
+
+// networkReservation returns the reservation addressed by the request, reservations of other
+// networks are not found. If it does not exist an error is written and ok is false.
+func (h *HttpHandler) networkReservation(w http.ResponseWriter, r *http.Request) (reservation model.DHCPReservation, ok bool) {
+	network, id := chi.URLParam(r, "id"), chi.URLParam(r, "reservation")
+	reservation, err := siteConfig(r).GetDHCPReservation(id)
+	if err == nil && string(reservation.Network) != network {
+		err = model.ErrDHCPReservationNotFound
+	}
+	if err != nil {
+		writeReservationError(w, id, err)
+		return reservation, false
+	}
+	return reservation, true
+}
+
+// writeReservationError maps errors returned by the dhcp reservation methods of model.ConfigData to responses
+func writeReservationError(w http.ResponseWriter, id string, err error) {
+	switch {
+	case errors.Is(err, model.ErrDHCPReservationNotFound):
+		WriteError(w, http.StatusNotFound, "DHCP Reservation Not Found", "DHCP reservation with ID "+id+" does not exist in this network")
+	case errors.Is(err, model.ErrDuplicateDHCPReservation):
+		WriteError(w, http.StatusConflict, "DHCP Reservation Already Exists", "The client or hostname already has a reservation in this network")
+	case errors.Is(err, model.ErrDHCPAddressInUse):
+		WriteError(w, http.StatusConflict, "Address In Use", "The address is used by the gateway or another reservation")
+	case errors.Is(err, model.ErrDHCPNotServed):
+		WriteError(w, http.StatusUnprocessableEntity, "No DHCP Server", "Reservations need a network with a dhcp server")
+	case errors.Is(err, model.ErrAddressOutsideNetwork):
+		WriteError(w, http.StatusUnprocessableEntity, "Address Outside Network", "The reserved address must be a host address of the network")
+	case errors.Is(err, model.ErrDHCPReservationPolicy):
+		WriteError(w, http.StatusUnprocessableEntity, "Reservation Policy Violated", "The reservation policy of the network does not allow the address relative to the dhcp range")
+	default:
+		WriteError(w, http.StatusInternalServerError, "DHCP Reservation Error", err.Error())
+	}
+}
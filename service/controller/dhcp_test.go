@@ -0,0 +1,119 @@
+package controller_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+func TestDHCPReservations(t *testing.T) {
+	t.Parallel()
+
+	h := service.NewBeenFarService(service.WithAdminPassword(testPassword))
+	api := authorize(t, h)
+	createUser(t, api, "reader", model.RoleReadOnly)
+
+	var lan model.NetworkConfig
+	response := send(t, api, "POST", "/api/network", &model.NetworkConfig{
+		Name:            "lan",
+		GatewayIPSubnet: "192.168.1.1/24",
+		DHCPConfig:      model.DHCPConfig{DHCPMode: model.DHCPModeServer, DHCPStart: "192.168.1.100", DHCPStop: "192.168.1.199"},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+	}
+	if err := jsonapi.UnmarshalPayload(response.Body, &lan); err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/network/" + lan.ID + "/reservation"
+
+	reservation := &model.DHCPReservation{MAC: "00:11:22:AA:BB:CC", IP: "192.168.1.10", Hostname: "nas"}
+	if response := send(t, authorizeAs(t, h, "reader"), "POST", path, reservation); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+	response = send(t, api, "POST", path, reservation)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+	}
+	var nas model.DHCPReservation
+	if err := jsonapi.UnmarshalPayload(response.Body, &nas); err != nil {
+		t.Fatal(err)
+	}
+	if response.Header().Get("Location") != path+"/"+nas.ID {
+		t.Errorf("Expected the location of the reservation, got %q", response.Header().Get("Location"))
+	}
+	if nas.Network != model.NetworkID(lan.ID) || nas.MAC != "00:11:22:aa:bb:cc" {
+		t.Errorf("Expected the reservation in lan with a normalized MAC address, got %+v", nas)
+	}
+
+	for name, test := range map[string]struct {
+		reservation *model.DHCPReservation
+		status      int
+	}{
+		"duplicate client":   {&model.DHCPReservation{MAC: "00:11:22:aa:bb:cc", IP: "192.168.1.11"}, http.StatusConflict},
+		"reserved address":   {&model.DHCPReservation{MAC: "00:11:22:33:44:55", IP: "192.168.1.10"}, http.StatusConflict},
+		"inside the range":   {&model.DHCPReservation{MAC: "00:11:22:33:44:55", IP: "192.168.1.150"}, http.StatusUnprocessableEntity},
+		"outside the subnet": {&model.DHCPReservation{MAC: "00:11:22:33:44:55", IP: "10.0.0.10"}, http.StatusUnprocessableEntity},
+		"invalid MAC":        {&model.DHCPReservation{MAC: "nas", IP: "192.168.1.11"}, http.StatusUnprocessableEntity},
+	} {
+		if response := send(t, api, "POST", path, test.reservation); response.Code != test.status {
+			t.Errorf("Expected status %d for %s, got %d: %s", test.status, name, response.Code, response.Body)
+		}
+	}
+
+	response = send(t, authorizeAs(t, h, "reader"), "GET", path, nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	list, err := jsonapi.UnmarshalManyPayload(response.Body, reflect.TypeOf(new(model.DHCPReservation)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].(*model.DHCPReservation).ID != nas.ID {
+		t.Errorf("Expected only the nas reservation, got %v", list)
+	}
+
+	// A network change that moves the range over a reserved address is refused
+	lan.DHCPConfig.DHCPStart = "192.168.1.2"
+	if response := send(t, api, "PATCH", "/api/network/"+lan.ID, &lan); response.Code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusConflict, response.Code, response.Body)
+	}
+
+	response = sendRaw(t, api, "PATCH", path+"/"+nas.ID, `{"data":{"type":"dhcp_reservation","id":"`+nas.ID+`","attributes":{"ip":"192.168.1.20"}}}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, response.Code, response.Body)
+	}
+	var moved model.DHCPReservation
+	if err := jsonapi.UnmarshalPayload(response.Body, &moved); err != nil {
+		t.Fatal(err)
+	}
+	if moved.IP != "192.168.1.20" || moved.Hostname != "nas" {
+		t.Errorf("Expected only the address of the reservation to change, got %+v", moved)
+	}
+
+	response = send(t, authorizeAs(t, h, "reader"), "GET", "/api/network/"+lan.ID+"/leases", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	leases, err := jsonapi.UnmarshalManyPayload(response.Body, reflect.TypeOf(new(model.DHCPLease)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leases) != 0 {
+		t.Errorf("Expected no leases without adopted routers, got %v", leases)
+	}
+	if response := send(t, api, "GET", "/api/network/000000000000000000000000/leases", nil); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, response.Code)
+	}
+
+	if response := send(t, api, "DELETE", path+"/"+nas.ID, nil); response.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, response.Code)
+	}
+	if response := send(t, api, "GET", path+"/"+nas.ID, nil); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, response.Code)
+	}
+}
@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service/driver"
+	"github.com/jacobalberty/beenfar/service/event"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+type DriverHandler struct {
+	drivers *driver.Registry
+	devices *model.Devices
+	audit   *model.AuditLog
+	events  *event.Bus
+}
+
+// Init registers the driver api, the router is expected to authenticate requests
+func (h *DriverHandler) Init(router chi.Router, drivers *driver.Registry, devices *model.Devices, audit *model.AuditLog, events *event.Bus) {
+	h.drivers = drivers
+	h.devices = devices
+	h.audit = audit
+	h.events = events
+
+	operator := router.With(DeviceSite(devices), RequireRole(model.RoleOperator))
+	router.Get("/api/driver", h.GetDrivers)
+	// Rendered configurations include wifi keys
+	operator.Get("/api/device/{mac:^([[:xdigit:]]{2}[:-]?){6}$}/config", h.GetDeviceConfig)
+	operator.Post("/api/device/{mac:^([[:xdigit:]]{2}[:-]?){6}$}/command/{command}", h.PostDeviceCommand)
+}
+
+// Returns every registered driver with its discovery method, capabilities and commands
+func (h *DriverHandler) GetDrivers(w http.ResponseWriter, r *http.Request) {
+	drivers := h.drivers.List()
+	infos := make([]*driver.Info, 0, len(drivers))
+	for _, d := range drivers {
+		info := d.Info()
+		infos = append(infos, &info)
+	}
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, infos); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+	}
+}
+
+// deviceDriver returns the device addressed by the request and its driver.
+// If either is unknown an error response is written and ok is false.
+func (h *DriverHandler) deviceDriver(w http.ResponseWriter, r *http.Request) (device model.Device, d driver.Driver, ok bool) {
+	mac := chi.URLParam(r, "mac")
+	device, err := h.devices.Get(mac)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "Device Not Found", "Device with MAC "+mac+" does not exist")
+		return device, nil, false
+	}
+	if d, ok = h.drivers.Get(device.Driver); !ok {
+		WriteError(w, http.StatusNotImplemented, "Unknown Driver", "Device "+mac+" is managed by the unknown driver "+device.Driver)
+	}
+	return device, d, ok
+}
+
+// Returns the configuration the driver of a device renders for it
+func (h *DriverHandler) GetDeviceConfig(w http.ResponseWriter, r *http.Request) {
+	device, d, ok := h.deviceDriver(w, r)
+	if !ok {
+		return
+	}
+
+	config, err := d.Render(device)
+	switch {
+	case errors.Is(err, driver.ErrNotSupported):
+		WriteError(w, http.StatusNotImplemented, "Not Supported", "The "+device.Driver+" driver does not render configurations")
+		return
+	case err != nil:
+		WriteError(w, http.StatusInternalServerError, "Error Rendering Config", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := w.Write(config); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+	}
+}
+
+// deviceCommand is recorded in the audit log and published when a device accepted a command
+type deviceCommand struct {
+	Command driver.Command `json:"command" jsonapi:"attr,command"`
+}
+
+// Tells a device to take an action, see driver.Info for the commands each driver accepts
+func (h *DriverHandler) PostDeviceCommand(w http.ResponseWriter, r *http.Request) {
+	device, d, ok := h.deviceDriver(w, r)
+	if !ok {
+		return
+	}
+
+	command := driver.Command(chi.URLParam(r, "command"))
+	if !d.Info().Accepts(command) {
+		WriteError(w, http.StatusNotImplemented, "Not Supported", "The "+device.Driver+" driver does not accept the command "+string(command))
+		return
+	}
+
+	err := d.Command(device, command)
+	switch {
+	case errors.Is(err, driver.ErrNotSupported):
+		WriteError(w, http.StatusNotImplemented, "Not Supported", err.Error())
+		return
+	case errors.Is(err, model.ErrDeviceNotFound):
+		WriteError(w, http.StatusConflict, "Device Not Adopted", err.Error())
+		return
+	case err != nil:
+		logging.FromContext(r.Context()).ForDevice(device.Mac).Warn("command failed", "command", command, "error", err)
+		WriteError(w, http.StatusBadGateway, "Command Failed", err.Error())
+		return
+	}
+	AuditRequest(h.audit, r, "device.command", "device/"+device.Mac, nil, deviceCommand{Command: command})
+	h.events.Publish(event.CommandAcked, "device/"+device.Mac, deviceCommand{Command: command})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,136 @@
+package controller_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service"
+	"github.com/jacobalberty/beenfar/service/driver"
+	"github.com/jacobalberty/beenfar/service/event"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// testDriver registers a route on the inform and api listeners
+type testDriver struct {
+	deps driver.Deps
+}
+
+func (d *testDriver) Info() driver.Info {
+	return driver.Info{
+		Name:         "test",
+		Discovery:    driver.DiscoveryManual,
+		Capabilities: []driver.Capability{driver.CapabilityStats},
+		Commands:     []driver.Command{driver.CommandReboot},
+	}
+}
+
+func (d *testDriver) Init(deps driver.Deps, routers driver.Routers) error {
+	d.deps = deps
+	routers[driver.ListenerInform].Get("/test/agent", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	routers[driver.ListenerAPI].Get("/api/test", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return nil
+}
+
+func (d *testDriver) Render(model.Device) ([]byte, error) {
+	return nil, driver.ErrNotSupported
+}
+
+func (d *testDriver) Command(model.Device, driver.Command) error {
+	return nil
+}
+
+// testDevice is a device of the test driver
+type testDevice string
+
+func (d testDevice) GetMac() string { return string(d) }
+func (d testDevice) Refresh()       {}
+func (d testDevice) Adopt() error   { return nil }
+func (d testDevice) Delete() error  { return nil }
+
+func TestDrivers(t *testing.T) {
+	var (
+		h  *service.BeenFarService
+		td = &testDriver{}
+	)
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword), service.WithDrivers(td))
+	api := authorize(t, h)
+
+	if td.deps.Devices == nil || td.deps.Logger == nil {
+		t.Fatalf("Expected the driver to be initialized with the service, got %+v", td.deps)
+	}
+
+	req, err := http.NewRequest("GET", "/test/agent", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response := executeRequest(h.Handler(service.ListenerInform), req); response.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, response.Code)
+	}
+
+	// Driver routes on the api require authentication
+	if response := send(t, h, "GET", "/api/test", nil); response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, response.Code)
+	}
+	if response := send(t, api, "GET", "/api/test", nil); response.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, response.Code)
+	}
+
+	response := send(t, api, "GET", "/api/driver", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	// jsonapi cannot unmarshal the typed slices of driver.Info
+	var payload struct {
+		Data []struct {
+			ID         string
+			Attributes struct {
+				Discovery    driver.Discovery
+				Capabilities []driver.Capability
+				Commands     []driver.Command
+			}
+		}
+	}
+	if err := json.NewDecoder(response.Body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, item := range payload.Data {
+		info := driver.Info{Name: item.ID, Discovery: item.Attributes.Discovery, Capabilities: item.Attributes.Capabilities, Commands: item.Attributes.Commands}
+		names = append(names, info.Name)
+		if info.Name == "test" && (info.Discovery != driver.DiscoveryManual || !info.Accepts(driver.CommandReboot) || !info.Supports(driver.CapabilityStats)) {
+			t.Errorf("Expected the test driver info, got %+v", info)
+		}
+	}
+	if len(names) != 2 || names[0] != "test" || names[1] != "unifi" {
+		t.Errorf("Expected the test and unifi drivers, got %v", names)
+	}
+
+	// Accepted commands are published
+	events := eventStream(t, api, "/api/events?type=command.acked")
+	device := model.Device{Driver: "test"}
+	device.Init(testDevice("deadbeef0042"))
+	td.deps.Devices.SavePending(device)
+	if response := send(t, api, "POST", "/api/device/adopt/deadbeef0042", nil); response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	if response := send(t, api, "POST", "/api/device/deadbeef0042/command/reboot", nil); response.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, response.Code, response.Body)
+	}
+	select {
+	case e := <-events:
+		data, _ := e.Data.(map[string]any)
+		if e.Type != event.CommandAcked || e.Target != "device/deadbeef0042" || data["command"] != string(driver.CommandReboot) {
+			t.Errorf("Expected %s of %s on device/deadbeef0042, got %s of %v on %s", event.CommandAcked, driver.CommandReboot, e.Type, e.Data, e.Target)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %s", event.CommandAcked)
+	}
+}
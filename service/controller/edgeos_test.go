@@ -0,0 +1,213 @@
+package controller_test
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service"
+	"github.com/jacobalberty/beenfar/service/adapter/edgeos"
+	"github.com/jacobalberty/beenfar/service/adapter/edgeos/edgeostest"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+func TestEdgeOS(t *testing.T) {
+	const (
+		mac     = "deadbeef0030"
+		running = "interfaces {\n    ethernet eth1 {\n        address 192.168.0.1/24\n    }\n}\n"
+	)
+	var h *service.BeenFarService
+	t.Parallel()
+
+	h = service.NewBeenFarService(
+		service.WithAdminPassword(testPassword),
+		service.WithDrivers(edgeos.NewDriver(edgeos.Config{Interface: "eth1"})),
+	)
+	api := authorize(t, h)
+	createUser(t, api, "operator", model.RoleOperator)
+	createUser(t, api, "reader", model.RoleReadOnly)
+	operator := authorizeAs(t, h, "operator")
+	reader := authorizeAs(t, h, "reader")
+
+	response := send(t, api, "POST", "/api/network", &model.NetworkConfig{
+		Name:            "lan",
+		GatewayIPSubnet: "192.168.1.1/24",
+		DHCPConfig: model.DHCPConfig{
+			DHCPMode:       model.DHCPModeServer,
+			DHCPStart:      "192.168.1.100",
+			DHCPStop:       "192.168.1.200",
+			DHCPNameServer: model.DHCPNameServer{Auto: true},
+			DHCPGateway:    model.DHCPGateway{Auto: true},
+		},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+	}
+
+	// Routers are added by uploading their running config
+	path := "/api/edgeos/" + mac + "/config"
+	if response := sendRaw(t, operator, "PUT", path, running); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+	if response := sendRaw(t, api, "PUT", path, "interfaces {\n"); response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, response.Code)
+	}
+	if response := send(t, operator, "GET", "/api/edgeos/"+mac+"/diff", nil); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown router, got %d", http.StatusNotFound, response.Code)
+	}
+	if response := sendRaw(t, api, "PUT", path, running); response.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, response.Code, response.Body)
+	}
+	if body := send(t, api, "GET", "/api/device", nil).Body.String(); !strings.Contains(body, `"driver":"edgeos"`) {
+		t.Errorf("Expected a pending EdgeOS router, got %s", body)
+	}
+
+	response = send(t, api, "GET", "/api/device/"+mac+"/config", nil)
+	if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), "shared-network-name lan {") {
+		t.Errorf("Expected the rendered config.boot, got status %d: %s", response.Code, response.Body)
+	}
+
+	// The diff previews the commands that apply the rendered config
+	if response := send(t, reader, "GET", "/api/edgeos/"+mac+"/diff", nil); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+	response = send(t, operator, "GET", "/api/edgeos/"+mac+"/diff", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	commands := strings.Split(strings.TrimSpace(response.Body.String()), "\n")
+	if commands[0] != "delete interfaces ethernet eth1 address 192.168.0.1/24" {
+		t.Errorf("Expected the old address to be deleted first, got %q", commands)
+	}
+	for _, expected := range []string{
+		"set interfaces ethernet eth1 address 192.168.1.1/24",
+		"set service dns forwarding listen-on eth1",
+	} {
+		if !strings.Contains(response.Body.String(), expected+"\n") {
+			t.Errorf("Expected %q in the diff, got %s", expected, response.Body)
+		}
+	}
+
+	// A MAC known to another driver is not taken over
+	const unifiMac = "deadbeef0031"
+	req, err := http.NewRequest("POST", "/inform", bytes.NewBuffer(informPacket(t, unifiMac)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	executeRequest(h.Handler(service.ListenerInform), req)
+	if response := sendRaw(t, api, "PUT", "/api/edgeos/"+unifiMac+"/config", running); response.Code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, response.Code)
+	}
+}
+
+func TestEdgeOSSSH(t *testing.T) {
+	const mac = "deadbeef0032"
+	var target edgeos.SSHTarget
+	t.Parallel()
+
+	router, err := edgeostest.NewRouter("ubnt", "router password", "interfaces {\n    ethernet eth1 {\n        address 192.168.0.1/24\n    }\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close()
+	router.ConfirmUnit = 10 * time.Millisecond
+
+	dataDir := t.TempDir()
+	newService := func() *service.BeenFarService {
+		return service.NewBeenFarService(
+			service.WithAdminPassword(testPassword),
+			service.WithDataDir(dataDir),
+			service.WithDrivers(edgeos.NewDriver(edgeos.Config{Interface: "eth1"})),
+		)
+	}
+	h := newService()
+	api := authorize(t, h)
+	createUser(t, api, "operator", model.RoleOperator)
+	operator := authorizeAs(t, h, "operator")
+
+	path := "/api/edgeos/" + mac + "/ssh"
+	request := &edgeos.SSHTarget{Address: router.Addr, Username: "ubnt", Password: "router password"}
+	if response := send(t, operator, "PUT", path, request); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+	if response := send(t, api, "PUT", path, &edgeos.SSHTarget{Address: "router", HostKey: "md5"}); response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, response.Code)
+	}
+	response := send(t, api, "PUT", path, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, response.Code, response.Body)
+	}
+	if strings.Contains(response.Body.String(), "router password") {
+		t.Errorf("Expected the password to be left out, got %s", response.Body)
+	}
+
+	// The password is only saved encrypted
+	saved, err := os.ReadFile(filepath.Join(dataDir, "edgeos.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(saved), "router password") || !strings.Contains(string(saved), "sealed_password") {
+		t.Errorf("Expected a sealed password, got %s", saved)
+	}
+
+	// The diff reads the running config from the router
+	if response := send(t, api, "POST", "/api/network", &model.NetworkConfig{Name: "lan", GatewayIPSubnet: "192.168.1.1/24"}); response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, response.Code)
+	}
+	response = send(t, operator, "GET", "/api/edgeos/"+mac+"/diff", nil)
+	if response.Code != http.StatusOK || !strings.HasPrefix(response.Body.String(), "delete interfaces ethernet eth1 address 192.168.0.1/24\n") {
+		t.Errorf("Expected the diff against the router, got status %d: %s", response.Code, response.Body)
+	}
+
+	command := "/api/device/" + mac + "/command/provision"
+	if response := send(t, operator, "POST", command, nil); response.Code != http.StatusConflict {
+		t.Errorf("Expected status %d before adoption, got %d", http.StatusConflict, response.Code)
+	}
+	if response := send(t, api, "POST", "/api/device/adopt/"+mac, nil); response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	if response := send(t, operator, "POST", command, nil); response.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, response.Code, response.Body)
+	}
+	if saved := router.Saved(); !strings.Contains(saved, "address 192.168.1.1/24") || strings.Contains(saved, "192.168.0.1") {
+		t.Errorf("Expected the new address to be saved, got:\n%s", saved)
+	}
+
+	// The host key is pinned on first use and kept with the encrypted password across restarts
+	h = newService()
+	api = authorize(t, h)
+	response = send(t, api, "GET", path, nil)
+	if err := jsonapi.UnmarshalPayload(response.Body, &target); err != nil {
+		t.Fatal(err)
+	}
+	if target.HostKey != router.HostKey || target.Password != "" {
+		t.Errorf("Expected host key %s without a password, got %+v", router.HostKey, target)
+	}
+	if response := send(t, api, "GET", "/api/edgeos/"+mac+"/diff", nil); response.Code != http.StatusOK || response.Body.Len() != 0 {
+		t.Errorf("Expected an empty diff after a restart, got status %d: %s", response.Code, response.Body)
+	}
+
+	// A change that cuts the router off is rolled back
+	if response := send(t, api, "POST", "/api/device/adopt/"+mac, nil); response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	if response := send(t, api, "POST", "/api/network", &model.NetworkConfig{Name: "lan", GatewayIPSubnet: "192.168.2.1/24"}); response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, response.Code)
+	}
+	router.CutOffOnCommit()
+	if response := send(t, api, "POST", command, nil); response.Code != http.StatusBadGateway {
+		t.Errorf("Expected status %d, got %d", http.StatusBadGateway, response.Code)
+	}
+
+	if response := send(t, api, "DELETE", path, nil); response.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, response.Code)
+	}
+	if response := send(t, api, "POST", command, nil); response.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status %d without a target, got %d", http.StatusNotImplemented, response.Code)
+	}
+}
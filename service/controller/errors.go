@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// errorSource points at the part of the request document that caused an error
+type errorSource struct {
+	Pointer string `json:"pointer,omitempty"`
+}
+
+// errorObject is a jsonapi.ErrorObject with the source member jsonapi does not provide
+type errorObject struct {
+	*jsonapi.ErrorObject
+	Source *errorSource `json:"source,omitempty"`
+}
+
+type errorsPayload struct {
+	Errors []*errorObject `json:"errors"`
+}
+
+// WriteError writes a single jsonapi error object with the given status code
+func WriteError(w http.ResponseWriter, status int, title, detail string) {
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	w.WriteHeader(status)
+	if err := jsonapi.MarshalErrors(w, []*jsonapi.ErrorObject{{
+		Title:  title,
+		Detail: detail,
+		Status: strconv.Itoa(status),
+	}}); err != nil {
+		logging.Default().Warn("error writing response", "error", err)
+	}
+}
+
+// WriteValidationErrors writes a 422 response with one error object per invalid field.
+// Errors that are not model.ValidationErrors are written as a single error without a source.
+func WriteValidationErrors(w http.ResponseWriter, title string, err error) {
+	var (
+		verrs   model.ValidationErrors
+		payload errorsPayload
+		status  = strconv.Itoa(http.StatusUnprocessableEntity)
+	)
+
+	if errors.As(err, &verrs) {
+		for _, fe := range verrs {
+			payload.Errors = append(payload.Errors, &errorObject{
+				ErrorObject: &jsonapi.ErrorObject{
+					Title:  title,
+					Detail: fe.Detail,
+					Status: status,
+				},
+				Source: &errorSource{Pointer: fieldPointer(fe.Field)},
+			})
+		}
+	} else {
+		payload.Errors = append(payload.Errors, &errorObject{
+			ErrorObject: &jsonapi.ErrorObject{
+				Title:  title,
+				Detail: err.Error(),
+				Status: status,
+			},
+		})
+	}
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	if err := json.NewEncoder(w).Encode(&payload); err != nil {
+		logging.Default().Warn("error writing response", "error", err)
+	}
+}
+
+// fieldPointer converts a model field name to a JSON pointer into a jsonapi document
+func fieldPointer(field string) string {
+	if field == "id" {
+		return "/data/id"
+	}
+	return "/data/attributes/" + field
+}
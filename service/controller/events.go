@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service/event"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// How often a comment is sent on idle event streams so proxies keep them open
+const eventKeepAlive = 30 * time.Second
+
+// configChange is the data of event.ConfigChanged, the changed object itself
+// is left out so secrets are not broadcast to every subscriber
+type configChange struct {
+	Action string `json:"action"`
+	// Site is the ID of the site the change was made in, empty for sites created or deleted
+	Site string `json:"site,omitempty"`
+}
+
+// Streams events as Server-Sent Events.
+// The type query parameter takes a comma separated list of event types to receive, all events are sent without it.
+// Users limited to some sites only receive the events of those sites. The stream ends once the user is changed or
+// deleted, clients reconnect with the role and sites the user has then.
+func (h *HttpHandler) GetEvents(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteError(w, http.StatusInternalServerError, "Streaming Unsupported", "The connection does not support streaming")
+		return
+	}
+
+	var types []event.Type
+	for _, param := range r.URL.Query()["type"] {
+		for _, t := range strings.Split(param, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types = append(types, event.Type(t))
+			}
+		}
+	}
+
+	sub := h.events.Subscribe(types...)
+	defer h.events.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-sub.Events():
+			if !ok || !h.userUnchanged(user) {
+				return
+			}
+			if !h.eventVisible(user, e) {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// userUnchanged checks that a user still exists with the role, sites and password it had when its stream started
+func (h *HttpHandler) userUnchanged(user model.User) bool {
+	current, err := h.users.Get(user.ID)
+	return err == nil && current.Role == user.Role && equalSites(current.Sites, user.Sites) &&
+		bytes.Equal(current.PasswordHash, user.PasswordHash)
+}
+
+// eventVisible checks if an event is about a site the user is in. Pending devices are in no site
+// yet so their events are sent to every user, changes to objects of every site such as users are not
+// sent to users limited to some sites.
+func (h *HttpHandler) eventVisible(user model.User, e event.Event) bool {
+	if len(user.Sites) == 0 {
+		return true
+	}
+
+	kind, id, _ := strings.Cut(e.Target, "/")
+	switch data := e.Data.(type) {
+	case configChange:
+		if data.Site != "" {
+			return user.InSite(data.Site)
+		}
+		return kind == "site" && user.InSite(id)
+	case deviceSiteChange:
+		return user.InSite(data.Site)
+	}
+	if kind != "device" {
+		return false
+	}
+	device, err := h.devices.Get(id)
+	return err == nil && (device.Site == "" || user.InSite(device.Site))
+}
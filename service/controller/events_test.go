@@ -0,0 +1,221 @@
+package controller_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service"
+	"github.com/jacobalberty/beenfar/service/adapter/unifi"
+	"github.com/jacobalberty/beenfar/service/event"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+func TestEventStream(t *testing.T) {
+	var h *service.BeenFarService
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
+	api := authorize(t, h)
+
+	events := eventStream(t, api, "/api/events?type=device.pending,config.changed")
+
+	// A new device asks to be adopted
+	req, err := http.NewRequest("POST", "/inform", bytes.NewBuffer(informPacket(t, "deadbeef0000")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	executeRequest(h.Handler(service.ListenerInform), req)
+
+	// Adoption is filtered out
+	if response := send(t, api, "POST", "/api/device/adopt/deadbeef0000", nil); response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+
+	if response := send(t, api, "POST", "/api/wifi", &model.WifiNetworkConfig{Ssid: "test"}); response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, response.Code)
+	}
+
+	expected := []struct {
+		t      event.Type
+		target string
+	}{
+		{event.DevicePending, "device/deadbeef0000"},
+		{event.ConfigChanged, "wifi/"},
+	}
+	for _, ex := range expected {
+		select {
+		case e := <-events:
+			if e.Type != ex.t || !strings.HasPrefix(e.Target, ex.target) {
+				t.Errorf("Expected %s on %s, got %s on %s", ex.t, ex.target, e.Type, e.Target)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", ex.t)
+		}
+	}
+}
+
+func TestEventStreamAuth(t *testing.T) {
+	var h *service.BeenFarService
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
+
+	req, err := http.NewRequest("GET", "/api/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response := executeRequest(h, req); response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, response.Code)
+	}
+}
+
+func TestEventStreamSites(t *testing.T) {
+	var h *service.BeenFarService
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
+	api := authorize(t, h)
+	if response := send(t, api, "POST", "/api/site", &model.Site{ID: "office", Name: "Office"}); response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+	}
+	response := send(t, api, "POST", "/api/user", &model.User{Username: "office", Password: testPassword, Role: model.RoleAdmin, Sites: []string{"office"}})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+	}
+	var office model.User
+	if err := jsonapi.UnmarshalPayload(response.Body, &office); err != nil {
+		t.Fatal(err)
+	}
+
+	events := eventStream(t, authorizeAs(t, h, "office"), "/api/events")
+
+	// Only the change in the office site is sent to the office admin
+	for _, path := range []string{"/api/wifi", "/api/site/office/wifi"} {
+		if response := send(t, api, "POST", path, &model.WifiNetworkConfig{Ssid: "test"}); response.Code != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+		}
+	}
+	select {
+	case e := <-events:
+		data, _ := e.Data.(map[string]any)
+		if e.Type != event.ConfigChanged || data["site"] != "office" {
+			t.Errorf("Expected a change in the office site, got %s with %v", e.Type, e.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the change in the office site")
+	}
+
+	// The stream of a deleted user ends instead of sending further events
+	if response := send(t, api, "DELETE", "/api/user/"+office.ID, nil); response.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, response.Code)
+	}
+	if response := send(t, api, "POST", "/api/site/office/wifi", &model.WifiNetworkConfig{Ssid: "after"}); response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+	}
+	select {
+	case e, ok := <-events:
+		if ok {
+			t.Errorf("Expected the stream to end, got %s with %v", e.Type, e.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the stream to end")
+	}
+}
+
+// eventStream opens an event stream through handler and returns the events read from it
+func eventStream(t *testing.T, handler http.Handler, path string) <-chan event.Event {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected content type %s, got %s", "text/event-stream", ct)
+	}
+
+	events := make(chan event.Event)
+	go func() {
+		defer close(events)
+		var name string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				var e event.Event
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+					t.Error(err)
+					return
+				}
+				if string(e.Type) != name {
+					t.Errorf("Expected event name %s to match type %s", name, e.Type)
+				}
+				events <- e
+			}
+		}
+	}()
+	return events
+}
+
+// informPacket builds an inform packet from a device that has not been adopted
+func informPacket(t *testing.T, mac string) []byte {
+	t.Helper()
+
+	return informPayloadPacket(t, mac, struct {
+		Mac string `json:"mac"`
+	}{
+		Mac: mac,
+	})
+}
+
+// testUnifiKey is the controller key of services adopted devices inform
+var testUnifiKey = []byte("beenfar-test-key")
+
+// informPayloadPacket builds an inform packet encrypted with the default key
+func informPayloadPacket(t *testing.T, mac string, payload any) []byte {
+	t.Helper()
+
+	return informKeyPacket(t, mac, nil, payload)
+}
+
+// informKeyPacket builds an inform packet encrypted with key, the default key if nil
+func informKeyPacket(t *testing.T, mac string, key []byte, payload any) []byte {
+	var (
+		ipd unifi.InformPD
+		ib  unifi.InformBuilder
+	)
+	t.Helper()
+
+	ipd.Magic = 1414414933
+	ipd.Version = 1
+	ipd.Mac = mac
+	ipd.Flags = 0b1001
+	ipd.DataVersion = 0
+
+	ib.Init(ipd)
+	ib.Key = key
+
+	b, err := ib.BuildResponse(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,423 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service/event"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// Returns all firewall groups sorted by name
+func (h *HttpHandler) GetFirewallGroupList(w http.ResponseWriter, r *http.Request) {
+	groups := siteConfig(r).FirewallGroupList()
+	groupList := make([]*model.FirewallGroup, 0, len(groups))
+	for _, group := range groups {
+		group := group
+		groupList = append(groupList, &group)
+	}
+	writePayload(w, r, http.StatusOK, groupList)
+}
+
+// Returns a firewall group by ID
+func (h *HttpHandler) GetFirewallGroup(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	group, err := siteConfig(r).GetFirewallGroup(id)
+	if err != nil {
+		writeFirewallError(w, "Firewall Group", id, err)
+		return
+	}
+	writePayload(w, r, http.StatusOK, &group)
+}
+
+// Creates a firewall group using model.FirewallGroup
+func (h *HttpHandler) PostFirewallGroup(w http.ResponseWriter, r *http.Request) {
+	request := new(model.FirewallGroup)
+	if err := jsonapi.UnmarshalPayload(r.Body, request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.ID != "" {
+		WriteError(w, http.StatusForbidden, "Client Generated ID", "Firewall group IDs are assigned by the server")
+		return
+	}
+	if err := request.Validate(); err != nil {
+		WriteValidationErrors(w, "Invalid Firewall Group", err)
+		return
+	}
+
+	group, err := siteConfig(r).AddFirewallGroup(*request)
+	if err != nil {
+		writeFirewallError(w, "Firewall Group", "", err)
+		return
+	}
+	h.objectChanged(r, "firewall_group", group.ID, "create", nil, group)
+
+	w.Header().Set("Location", sitePath(r, "/firewall/group/"+group.ID))
+	writePayload(w, r, http.StatusCreated, &group)
+}
+
+// Partially updates a firewall group, attributes missing from the request are left unchanged
+func (h *HttpHandler) PatchFirewallGroup(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	current, err := siteConfig(r).GetFirewallGroup(id)
+	if err != nil {
+		writeFirewallError(w, "Firewall Group", id, err)
+		return
+	}
+
+	group := current
+	if err := jsonapi.UnmarshalPayload(r.Body, &group); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if group.ID != id {
+		WriteError(w, http.StatusConflict, "Firewall Group ID Mismatch", "Firewall group ID "+group.ID+" does not match "+id)
+		return
+	}
+	if err := group.Validate(); err != nil {
+		WriteValidationErrors(w, "Invalid Firewall Group", err)
+		return
+	}
+
+	if group, err = siteConfig(r).UpdateFirewallGroup(id, group); err != nil {
+		writeFirewallError(w, "Firewall Group", id, err)
+		return
+	}
+	h.objectChanged(r, "firewall_group", id, "update", current, group)
+	writePayload(w, r, http.StatusOK, &group)
+}
+
+// Deletes a firewall group, groups used by firewall rules can not be deleted
+func (h *HttpHandler) DeleteFirewallGroup(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	group, err := siteConfig(r).DeleteFirewallGroup(id)
+	if err != nil {
+		writeFirewallError(w, "Firewall Group", id, err)
+		return
+	}
+	h.objectChanged(r, "firewall_group", id, "delete", group, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Returns all firewall rules in the order they are matched
+func (h *HttpHandler) GetFirewallRuleList(w http.ResponseWriter, r *http.Request) {
+	rules := siteConfig(r).FirewallRuleList()
+	ruleList := make([]*model.FirewallRule, 0, len(rules))
+	for _, rule := range rules {
+		rule := rule
+		ruleList = append(ruleList, &rule)
+	}
+	writePayload(w, r, http.StatusOK, ruleList)
+}
+
+// Returns a firewall rule by ID
+func (h *HttpHandler) GetFirewallRule(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	rule, err := siteConfig(r).GetFirewallRule(id)
+	if err != nil {
+		writeFirewallError(w, "Firewall Rule", id, err)
+		return
+	}
+	writePayload(w, r, http.StatusOK, &rule)
+}
+
+// Creates a firewall rule using model.FirewallRule, it is inserted at its index or appended without one
+func (h *HttpHandler) PostFirewallRule(w http.ResponseWriter, r *http.Request) {
+	request := new(model.FirewallRule)
+	if err := jsonapi.UnmarshalPayload(r.Body, request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.ID != "" {
+		WriteError(w, http.StatusForbidden, "Client Generated ID", "Firewall rule IDs are assigned by the server")
+		return
+	}
+	if err := request.Validate(); err != nil {
+		WriteValidationErrors(w, "Invalid Firewall Rule", err)
+		return
+	}
+
+	rule, err := siteConfig(r).AddFirewallRule(*request)
+	if err != nil {
+		writeFirewallError(w, "Firewall Rule", "", err)
+		return
+	}
+	h.objectChanged(r, "firewall_rule", rule.ID, "create", nil, rule)
+
+	w.Header().Set("Location", sitePath(r, "/firewall/rule/"+rule.ID))
+	writePayload(w, r, http.StatusCreated, &rule)
+}
+
+// Partially updates a firewall rule, attributes missing from the request are left unchanged.
+// Changing the index moves the rule.
+func (h *HttpHandler) PatchFirewallRule(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	current, err := siteConfig(r).GetFirewallRule(id)
+	if err != nil {
+		writeFirewallError(w, "Firewall Rule", id, err)
+		return
+	}
+
+	rule := current
+	if err := jsonapi.UnmarshalPayload(r.Body, &rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rule.ID != id {
+		WriteError(w, http.StatusConflict, "Firewall Rule ID Mismatch", "Firewall rule ID "+rule.ID+" does not match "+id)
+		return
+	}
+	if err := rule.Validate(); err != nil {
+		WriteValidationErrors(w, "Invalid Firewall Rule", err)
+		return
+	}
+
+	if rule, err = siteConfig(r).UpdateFirewallRule(id, rule); err != nil {
+		writeFirewallError(w, "Firewall Rule", id, err)
+		return
+	}
+	h.objectChanged(r, "firewall_rule", id, "update", current, rule)
+	writePayload(w, r, http.StatusOK, &rule)
+}
+
+// Deletes a firewall rule, the following rules move up
+func (h *HttpHandler) DeleteFirewallRule(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	rule, err := siteConfig(r).DeleteFirewallRule(id)
+	if err != nil {
+		writeFirewallError(w, "Firewall Rule", id, err)
+		return
+	}
+	h.objectChanged(r, "firewall_rule", id, "delete", rule, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Returns all port forwards sorted by name
+func (h *HttpHandler) GetPortForwardList(w http.ResponseWriter, r *http.Request) {
+	forwards := siteConfig(r).PortForwardList()
+	forwardList := make([]*model.PortForward, 0, len(forwards))
+	for _, forward := range forwards {
+		forward := forward
+		forwardList = append(forwardList, &forward)
+	}
+	writePayload(w, r, http.StatusOK, forwardList)
+}
+
+// Returns a port forward by ID
+func (h *HttpHandler) GetPortForward(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	forward, err := siteConfig(r).GetPortForward(id)
+	if err != nil {
+		writeFirewallError(w, "Port Forward", id, err)
+		return
+	}
+	writePayload(w, r, http.StatusOK, &forward)
+}
+
+// Creates a port forward using model.PortForward
+func (h *HttpHandler) PostPortForward(w http.ResponseWriter, r *http.Request) {
+	request := new(model.PortForward)
+	if err := jsonapi.UnmarshalPayload(r.Body, request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.ID != "" {
+		WriteError(w, http.StatusForbidden, "Client Generated ID", "Port forward IDs are assigned by the server")
+		return
+	}
+	if err := request.Validate(); err != nil {
+		WriteValidationErrors(w, "Invalid Port Forward", err)
+		return
+	}
+
+	forward, err := siteConfig(r).AddPortForward(*request)
+	if err != nil {
+		writeFirewallError(w, "Port Forward", "", err)
+		return
+	}
+	h.objectChanged(r, "port_forward", forward.ID, "create", nil, forward)
+
+	w.Header().Set("Location", sitePath(r, "/firewall/forward/"+forward.ID))
+	writePayload(w, r, http.StatusCreated, &forward)
+}
+
+// Partially updates a port forward, attributes missing from the request are left unchanged
+func (h *HttpHandler) PatchPortForward(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	current, err := siteConfig(r).GetPortForward(id)
+	if err != nil {
+		writeFirewallError(w, "Port Forward", id, err)
+		return
+	}
+
+	forward := current
+	if err := jsonapi.UnmarshalPayload(r.Body, &forward); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if forward.ID != id {
+		WriteError(w, http.StatusConflict, "Port Forward ID Mismatch", "Port forward ID "+forward.ID+" does not match "+id)
+		return
+	}
+	if err := forward.Validate(); err != nil {
+		WriteValidationErrors(w, "Invalid Port Forward", err)
+		return
+	}
+
+	if forward, err = siteConfig(r).UpdatePortForward(id, forward); err != nil {
+		writeFirewallError(w, "Port Forward", id, err)
+		return
+	}
+	h.objectChanged(r, "port_forward", id, "update", current, forward)
+	writePayload(w, r, http.StatusOK, &forward)
+}
+
+// Deletes a port forward
+func (h *HttpHandler) DeletePortForward(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	forward, err := siteConfig(r).DeletePortForward(id)
+	if err != nil {
+		writeFirewallError(w, "Port Forward", id, err)
+		return
+	}
+	h.objectChanged(r, "port_forward", id, "delete", forward, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Returns all nat rules in the order they are matched
+func (h *HttpHandler) GetNATRuleList(w http.ResponseWriter, r *http.Request) {
+	rules := siteConfig(r).NATRuleList()
+	ruleList := make([]*model.NATRule, 0, len(rules))
+	for _, rule := range rules {
+		rule := rule
+		ruleList = append(ruleList, &rule)
+	}
+	writePayload(w, r, http.StatusOK, ruleList)
+}
+
+// Returns a nat rule by ID
+func (h *HttpHandler) GetNATRule(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	rule, err := siteConfig(r).GetNATRule(id)
+	if err != nil {
+		writeFirewallError(w, "NAT Rule", id, err)
+		return
+	}
+	writePayload(w, r, http.StatusOK, &rule)
+}
+
+// Creates a nat rule using model.NATRule, it is inserted at its index or appended without one
+func (h *HttpHandler) PostNATRule(w http.ResponseWriter, r *http.Request) {
+	request := new(model.NATRule)
+	if err := jsonapi.UnmarshalPayload(r.Body, request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.ID != "" {
+		WriteError(w, http.StatusForbidden, "Client Generated ID", "NAT rule IDs are assigned by the server")
+		return
+	}
+	if err := request.Validate(); err != nil {
+		WriteValidationErrors(w, "Invalid NAT Rule", err)
+		return
+	}
+
+	rule, err := siteConfig(r).AddNATRule(*request)
+	if err != nil {
+		writeFirewallError(w, "NAT Rule", "", err)
+		return
+	}
+	h.objectChanged(r, "nat_rule", rule.ID, "create", nil, rule)
+
+	w.Header().Set("Location", sitePath(r, "/firewall/nat/"+rule.ID))
+	writePayload(w, r, http.StatusCreated, &rule)
+}
+
+// Partially updates a nat rule, attributes missing from the request are left unchanged.
+// Changing the index moves the rule.
+func (h *HttpHandler) PatchNATRule(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	current, err := siteConfig(r).GetNATRule(id)
+	if err != nil {
+		writeFirewallError(w, "NAT Rule", id, err)
+		return
+	}
+
+	rule := current
+	if err := jsonapi.UnmarshalPayload(r.Body, &rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rule.ID != id {
+		WriteError(w, http.StatusConflict, "NAT Rule ID Mismatch", "NAT rule ID "+rule.ID+" does not match "+id)
+		return
+	}
+	if err := rule.Validate(); err != nil {
+		WriteValidationErrors(w, "Invalid NAT Rule", err)
+		return
+	}
+
+	if rule, err = siteConfig(r).UpdateNATRule(id, rule); err != nil {
+		writeFirewallError(w, "NAT Rule", id, err)
+		return
+	}
+	h.objectChanged(r, "nat_rule", id, "update", current, rule)
+	writePayload(w, r, http.StatusOK, &rule)
+}
+
+// Deletes a nat rule, the following rules move up
+func (h *HttpHandler) DeleteNATRule(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	rule, err := siteConfig(r).DeleteNATRule(id)
+	if err != nil {
+		writeFirewallError(w, "NAT Rule", id, err)
+		return
+	}
+	h.objectChanged(r, "nat_rule", id, "delete", rule, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// objectChanged records a change of a configuration object in the audit log and publishes it
+func (h *HttpHandler) objectChanged(r *http.Request, kind, id, action string, before, after any) {
+	AuditRequest(h.audit, r, kind+"."+action, kind+"/"+id, before, after)
+	h.events.Publish(event.ConfigChanged, kind+"/"+id, configChange{Action: action, Site: currentSite(r)})
+}
+
+// writePayload writes a jsonapi document with the given status
+func writePayload(w http.ResponseWriter, r *http.Request, status int, payload any) {
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	w.WriteHeader(status)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, payload); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+	}
+}
+
+// writeFirewallError maps errors returned by the firewall methods of model.ConfigData to responses,
+// kind names the object such as Firewall Rule
+func writeFirewallError(w http.ResponseWriter, kind, id string, err error) {
+	switch {
+	case errors.Is(err, model.ErrFirewallGroupNotFound) && kind == "Firewall Group",
+		errors.Is(err, model.ErrFirewallRuleNotFound),
+		errors.Is(err, model.ErrPortForwardNotFound),
+		errors.Is(err, model.ErrNATRuleNotFound):
+		WriteError(w, http.StatusNotFound, kind+" Not Found", kind+" with ID "+id+" does not exist")
+	case errors.Is(err, model.ErrNetworkNotFound):
+		WriteError(w, http.StatusUnprocessableEntity, "Unknown Network", kind+" refers to a network that does not exist")
+	case errors.Is(err, model.ErrFirewallGroupNotFound):
+		WriteError(w, http.StatusUnprocessableEntity, "Unknown Firewall Group", kind+" refers to a firewall group that does not exist")
+	case errors.Is(err, model.ErrFirewallGroupType):
+		WriteError(w, http.StatusUnprocessableEntity, "Wrong Firewall Group Type", "Address groups can only match addresses and port groups only ports")
+	case errors.Is(err, model.ErrAddressOutsideNetwork):
+		WriteError(w, http.StatusUnprocessableEntity, "Address Outside Network", "The forward address must be inside the network of the port forward")
+	case errors.Is(err, model.ErrDuplicateFirewallGroupName):
+		WriteError(w, http.StatusConflict, "Firewall Group Already Exists", "A firewall group with this name already exists")
+	case errors.Is(err, model.ErrFirewallGroupInUse):
+		WriteError(w, http.StatusConflict, "Firewall Group In Use", "Firewall group with ID "+id+" is used by firewall rules")
+	default:
+		WriteError(w, http.StatusInternalServerError, kind+" Error", err.Error())
+	}
+}
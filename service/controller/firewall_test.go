@@ -0,0 +1,135 @@
+package controller_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+func TestFirewall(t *testing.T) {
+	t.Parallel()
+
+	h := service.NewBeenFarService(service.WithAdminPassword(testPassword))
+	api := authorize(t, h)
+	createUser(t, api, "reader", model.RoleReadOnly)
+
+	var lan model.NetworkConfig
+	response := send(t, api, "POST", "/api/network", &model.NetworkConfig{Name: "lan", GatewayIPSubnet: "192.168.1.1/24"})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+	}
+	if err := jsonapi.UnmarshalPayload(response.Body, &lan); err != nil {
+		t.Fatal(err)
+	}
+
+	var servers model.FirewallGroup
+	group := &model.FirewallGroup{Name: "servers", Type: model.FirewallGroupAddress, Members: []string{"192.168.1.10"}}
+	if response := send(t, authorizeAs(t, h, "reader"), "POST", "/api/firewall/group", group); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+	response = send(t, api, "POST", "/api/firewall/group", group)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+	}
+	if err := jsonapi.UnmarshalPayload(response.Body, &servers); err != nil {
+		t.Fatal(err)
+	}
+	if response.Header().Get("Location") != "/api/firewall/group/"+servers.ID {
+		t.Errorf("Expected the location of the group, got %q", response.Header().Get("Location"))
+	}
+	if response := send(t, api, "POST", "/api/firewall/group", group); response.Code != http.StatusConflict {
+		t.Errorf("Expected status %d for a duplicate name, got %d", http.StatusConflict, response.Code)
+	}
+
+	// Rules are inserted at their index and only reference existing networks and groups
+	addRule := func(name string, index int) model.FirewallRule {
+		t.Helper()
+		response := send(t, api, "POST", "/api/firewall/rule", &model.FirewallRule{
+			Name: name, Index: index, Action: model.FirewallActionDrop, Protocol: model.ProtocolAll,
+			Source: model.ZoneWAN, Destination: lan.ID, DestinationGroup: servers.ID,
+		})
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+		}
+		var rule model.FirewallRule
+		if err := jsonapi.UnmarshalPayload(response.Body, &rule); err != nil {
+			t.Fatal(err)
+		}
+		return rule
+	}
+	first := addRule("first", 0)
+	addRule("second", 1)
+
+	response = send(t, authorizeAs(t, h, "reader"), "GET", "/api/firewall/rule", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	list, err := jsonapi.UnmarshalManyPayload(response.Body, reflect.TypeOf(new(model.FirewallRule)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].(*model.FirewallRule).Name != "second" || list[1].(*model.FirewallRule).Index != 2 {
+		t.Errorf("Expected the second rule inserted first, got %v", list)
+	}
+
+	response = sendRaw(t, api, "PATCH", "/api/firewall/rule/"+first.ID, `{"data":{"type":"firewall_rule","id":"`+first.ID+`","attributes":{"index":1}}}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, response.Code, response.Body)
+	}
+	var moved model.FirewallRule
+	if err := jsonapi.UnmarshalPayload(response.Body, &moved); err != nil {
+		t.Fatal(err)
+	}
+	if moved.Index != 1 || moved.Name != "first" || moved.DestinationGroup != servers.ID {
+		t.Errorf("Expected only the index of the first rule to change, got %+v", moved)
+	}
+
+	for name, rule := range map[string]*model.FirewallRule{
+		"unknown network": {Name: "x", Action: model.FirewallActionDrop, Protocol: model.ProtocolAll, Source: "000000000000000000000000", Destination: model.ZoneWAN},
+		"unknown group":   {Name: "x", Action: model.FirewallActionDrop, Protocol: model.ProtocolAll, Source: lan.ID, Destination: model.ZoneWAN, SourceGroup: "000000000000000000000000"},
+		"invalid rule":    {Name: "x", Action: "allow", Protocol: model.ProtocolAll, Source: lan.ID, Destination: model.ZoneWAN},
+	} {
+		if response := send(t, api, "POST", "/api/firewall/rule", rule); response.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected status %d for %s, got %d: %s", http.StatusUnprocessableEntity, name, response.Code, response.Body)
+		}
+	}
+
+	if response := send(t, api, "DELETE", "/api/firewall/group/"+servers.ID, nil); response.Code != http.StatusConflict {
+		t.Errorf("Expected status %d deleting a group in use, got %d", http.StatusConflict, response.Code)
+	}
+	if response := send(t, api, "DELETE", "/api/network/"+lan.ID, nil); response.Code != http.StatusConflict {
+		t.Errorf("Expected status %d deleting a network in use, got %d", http.StatusConflict, response.Code)
+	}
+
+	// Port forwards go to an address inside their network
+	forward := &model.PortForward{Name: "web", Protocol: model.ProtocolTCP, Port: "443", Network: model.NetworkID(lan.ID), ForwardAddress: "10.0.0.5"}
+	if response := send(t, api, "POST", "/api/firewall/forward", forward); response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusUnprocessableEntity, response.Code, response.Body)
+	}
+	forward.ForwardAddress = "192.168.1.10"
+	if response := send(t, api, "POST", "/api/firewall/forward", forward); response.Code != http.StatusCreated {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+	}
+
+	var nat model.NATRule
+	response = send(t, api, "POST", "/api/firewall/nat", &model.NATRule{Name: "lan", Type: model.NATMasquerade, Network: model.NetworkID(lan.ID)})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+	}
+	if err := jsonapi.UnmarshalPayload(response.Body, &nat); err != nil {
+		t.Fatal(err)
+	}
+	if nat.Index != 1 {
+		t.Errorf("Expected the nat rule at index 1, got %d", nat.Index)
+	}
+	if response := send(t, api, "DELETE", "/api/firewall/nat/"+nat.ID, nil); response.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, response.Code)
+	}
+	if response := send(t, api, "GET", "/api/firewall/nat/"+nat.ID, nil); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, response.Code)
+	}
+}
@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type FirmwareHandler struct {
+	files http.Handler
+}
+
+// Init serves the firmware images in dir under /firmware/, nothing is served if dir is empty
+func (h *FirmwareHandler) Init(router chi.Router, dir string) {
+	if dir == "" {
+		h.files = http.NotFoundHandler()
+	} else {
+		h.files = http.StripPrefix("/firmware/", http.FileServer(http.Dir(dir)))
+	}
+
+	router.Get("/firmware/*", h.GetFirmware)
+	router.Head("/firmware/*", h.GetFirmware)
+}
+
+// Serves a firmware image, directories are not listed
+func (h *FirmwareHandler) GetFirmware(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	h.files.ServeHTTP(w, r)
+}
@@ -0,0 +1,68 @@
+package controller_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service"
+)
+
+func TestListeners(t *testing.T) {
+	var (
+		h   *service.BeenFarService
+		dir = t.TempDir()
+	)
+	t.Parallel()
+
+	if err := os.MkdirAll(filepath.Join(dir, "firmware", "U7PG2"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "firmware", "U7PG2", "firmware.bin"), []byte("image"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword), service.WithDataDir(dir), service.WithAPIPort("8443"))
+
+	for _, tc := range []struct {
+		listener, method, path string
+		status                 int
+	}{
+		{service.ListenerFirmware, "GET", "/firmware/U7PG2/firmware.bin", http.StatusOK},
+		{service.ListenerFirmware, "GET", "/firmware/U7PG2/", http.StatusNotFound},
+		{service.ListenerFirmware, "GET", "/firmware/../unifi.key", http.StatusNotFound},
+		{service.ListenerFirmware, "GET", "/api/device", http.StatusNotFound},
+		{service.ListenerInform, "GET", "/api/device", http.StatusNotFound},
+		{service.ListenerInform, "GET", "/firmware/U7PG2/firmware.bin", http.StatusNotFound},
+		{service.ListenerPortal, "POST", "/inform", http.StatusNotFound},
+		{service.ListenerAPI, "GET", "/firmware/U7PG2/firmware.bin", http.StatusNotFound},
+		{service.ListenerAPI, "GET", "/api/device", http.StatusUnauthorized},
+		{service.ListenerPortal, "GET", "/healthz", http.StatusOK},
+	} {
+		response := send(t, h.Handler(tc.listener), tc.method, tc.path, nil)
+		if response.Code != tc.status {
+			t.Errorf("%s %s %s: Expected status %d, got %d", tc.listener, tc.method, tc.path, tc.status, response.Code)
+		}
+		if tc.status == http.StatusOK && tc.listener == service.ListenerFirmware && response.Body.String() != "image" {
+			t.Errorf("Expected the firmware image, got %q", response.Body.String())
+		}
+	}
+
+	// Plain HTTP requests to the api are sent to the HTTPS listener
+	req, err := http.NewRequest("GET", "http://example.com:8080/api/device?page=2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := executeRequest(h.Handler(service.ListenerAPIRedirect), req)
+	if response.Code != http.StatusPermanentRedirect {
+		t.Errorf("Expected status %d, got %d", http.StatusPermanentRedirect, response.Code)
+	}
+	if location := response.Header().Get("Location"); location != "https://example.com:8443/api/device?page=2" {
+		t.Errorf("Expected redirect to %s, got %s", "https://example.com:8443/api/device?page=2", location)
+	}
+
+	if h.Handler("unknown") != nil {
+		t.Error("Expected no handler for an unknown listener")
+	}
+}
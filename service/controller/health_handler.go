@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jacobalberty/beenfar/service/health"
+	"github.com/jacobalberty/beenfar/service/logging"
+)
+
+type HealthHandler struct {
+	health *health.Registry
+}
+
+// Init registers the probes, they are meant to be mounted outside of the authenticated api
+func (h *HealthHandler) Init(router chi.Router, registry *health.Registry) {
+	h.health = registry
+
+	router.Get("/healthz", h.GetHealth)
+	router.Get("/readyz", h.GetReady)
+}
+
+// Liveness probe, fails only if a component stopped
+func (h *HealthHandler) GetHealth(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, r, h.health.Liveness())
+}
+
+// Readiness probe, fails until every component is up
+func (h *HealthHandler) GetReady(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, r, h.health.Readiness())
+}
+
+func writeHealth(w http.ResponseWriter, r *http.Request, report health.Report) {
+	status := http.StatusOK
+	if report.Status != health.StatusUp {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+	}
+}
@@ -0,0 +1,133 @@
+package controller_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service"
+	"github.com/jacobalberty/beenfar/service/health"
+)
+
+func TestHealth(t *testing.T) {
+	var h *service.BeenFarService
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
+
+	probe := func(path string) (int, health.Report) {
+		t.Helper()
+
+		var report health.Report
+		response := send(t, h, "GET", path, nil)
+		if err := json.NewDecoder(response.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		return response.Code, report
+	}
+
+	// Probes do not require authentication, the service is alive but not ready until it is listening
+	code, report := probe("/healthz")
+	if code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if _, ok := report.Components["listener.api"]; !ok {
+		t.Errorf("Expected the api listener to be reported, got %+v", report.Components)
+	}
+
+	code, report = probe("/readyz")
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, code)
+	}
+	if c := report.Components["listener.api"]; c.Status != health.StatusDown || c.Error == "" {
+		t.Errorf("Expected the api listener to be down with an error, got %+v", c)
+	}
+
+	listeners := make(map[string]net.Listener)
+	for _, listener := range []string{service.ListenerAPI, service.ListenerInform} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[listener] = l
+	}
+	stop := run(t, h, listeners)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if code, report = probe("/readyz"); code == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the service to become ready, got %+v", report)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, name := range []string{"storage", "listener.api", "listener.inform", "janitor", "webhooks"} {
+		if c := report.Components[name]; c.Status != health.StatusUp {
+			t.Errorf("Expected %s to be up, got %+v", name, c)
+		}
+	}
+
+	resp, err := http.Get("http://" + listeners[service.ListenerInform].Addr().String() + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d from the inform listener, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	// A stopped service fails both probes and no longer listens
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+	if code, report = probe("/healthz"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the service to not be alive, got %+v", report)
+	}
+	if _, err := http.Get("http://" + listeners[service.ListenerInform].Addr().String() + "/readyz"); err == nil {
+		t.Error("Expected the inform listener to be closed")
+	}
+
+	if err := h.Run(context.Background(), map[string]net.Listener{"unknown": nil}); err == nil {
+		t.Error("Expected an error for an unknown listener")
+	}
+}
+
+// run runs the service until the returned function or the test cleanup stops it
+func run(t *testing.T, h *service.BeenFarService, listeners map[string]net.Listener) (stop func() error) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- h.Run(ctx, listeners)
+	}()
+
+	var (
+		once sync.Once
+		err  error
+	)
+	stop = func() error {
+		once.Do(func() {
+			cancel()
+			select {
+			case err = <-done:
+			case <-time.After(10 * time.Second):
+				err = errors.New("timed out stopping the service")
+			}
+		})
+		return err
+	}
+	t.Cleanup(func() {
+		if err := stop(); err != nil {
+			t.Error(err)
+		}
+	})
+	return stop
+}
@@ -1,58 +1,132 @@
 package controller
 
 import (
-	"bytes"
-	"log"
+	"errors"
 	"net/http"
+	"net/url"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service/event"
+	"github.com/jacobalberty/beenfar/service/logging"
 	"github.com/jacobalberty/beenfar/service/model"
 )
 
 type HttpHandler struct {
-	devices    *model.Devices
-	configData *model.ConfigData
-	mux        *chi.Mux
+	devices  *model.Devices
+	sites    *model.Sites
+	users    *model.Users
+	audit    *model.AuditLog
+	events   *event.Bus
+	webhooks *model.Webhooks
+	mux      chi.Router
 }
 
 func (h *HttpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.mux.ServeHTTP(w, r)
 }
 
-func (h *HttpHandler) Init(router *chi.Mux, configData *model.ConfigData, devices *model.Devices) {
+func (h *HttpHandler) Init(router chi.Router, sites *model.Sites, users *model.Users, devices *model.Devices, audit *model.AuditLog, events *event.Bus, webhooks *model.Webhooks) {
 
 	h.mux = router
-	h.configData = configData
+	h.sites = sites
+	h.users = users
 	h.devices = devices
+	h.audit = audit
+	h.events = events
+	h.webhooks = webhooks
 
 	// Unstable apis
-	h.mux.Post("/api/device/adopt/{mac:^([[:xdigit:]]{2}[:-]?){6}$}", h.PostDeviceAdopt)
-	h.mux.Delete("/api/device/{mac:^([[:xdigit:]]{2}[:-]?){6}$}", h.DeleteDevice)
-	h.mux.Get("/api/device", h.GetDeviceList)
-	h.mux.Get("/api/wifi", h.GetWifiList)
-	h.mux.Get("/api/wifi/{ssid:^[[:alnum:] ]+$}", h.GetWifiBySSID)
-	h.mux.Post("/api/wifi", h.PostWifi)
-	h.mux.Put("/api/wifi/{ssid:^[[:alnum:] ]+$}", h.PutWifi)
-	h.mux.Delete("/api/wifi/{ssid:^[[:alnum:] ]+$}", h.DeleteWifi)
+	// Reads are open to every authenticated user, see RequireRole for everything else
+	admin := h.mux.With(RequireRole(model.RoleAdmin))
+
+	h.mux.Get("/api/site", h.GetSiteList)
+	admin.Post("/api/site", h.PostSite)
+	h.mux.With(SiteContext(sites)).Get(SitePrefix, h.GetSite)
+	h.mux.With(SiteContext(sites), RequireRole(model.RoleAdmin)).Patch(SitePrefix, h.PatchSite)
+	admin.Delete(SitePrefix, h.DeleteSite)
+
+	// The api of the default site is also served without the site prefix
+	for _, prefix := range []string{"/api", SitePrefix} {
+		site := h.mux.With(SiteContext(sites))
+		admin := site.With(RequireRole(model.RoleAdmin))
+		operator := site.With(RequireRole(model.RoleOperator))
+
+		admin.Post(prefix+"/device/adopt/{mac:^([[:xdigit:]]{2}[:-]?){6}$}", h.PostDeviceAdopt)
+		admin.Delete(prefix+"/device/{mac:^([[:xdigit:]]{2}[:-]?){6}$}", h.DeleteDevice)
+		admin.Post(prefix+"/device/{mac:^([[:xdigit:]]{2}[:-]?){6}$}/move/{to:^[a-z0-9][a-z0-9-]*$}", h.PostDeviceMove)
+		site.Get(prefix+"/device", h.GetDeviceList)
+		site.Get(prefix+"/wifi", h.GetWifiList)
+		operator.Post(prefix+"/wifi", h.PostWifi)
+		site.Get(prefix+"/wifi/{id:^[[:xdigit:]]{24}$}", h.GetWifi)
+		operator.Put(prefix+"/wifi/{id:^[[:xdigit:]]{24}$}", h.PutWifi)
+		operator.Patch(prefix+"/wifi/{id:^[[:xdigit:]]{24}$}", h.PatchWifi)
+		operator.Delete(prefix+"/wifi/{id:^[[:xdigit:]]{24}$}", h.DeleteWifi)
+		site.Get(prefix+"/wifi/ssid/{ssid}", h.GetWifi)
+		operator.Put(prefix+"/wifi/ssid/{ssid}", h.PutWifi)
+		operator.Patch(prefix+"/wifi/ssid/{ssid}", h.PatchWifi)
+		operator.Delete(prefix+"/wifi/ssid/{ssid}", h.DeleteWifi)
+		site.Get(prefix+"/network", h.GetNetworkList)
+		operator.Post(prefix+"/network", h.PostNetwork)
+		site.Get(prefix+"/network/{id:^[[:xdigit:]]{24}$}", h.GetNetwork)
+		operator.Patch(prefix+"/network/{id:^[[:xdigit:]]{24}$}", h.PatchNetwork)
+		operator.Delete(prefix+"/network/{id:^[[:xdigit:]]{24}$}", h.DeleteNetwork)
+		site.Get(prefix+"/network/{id:^[[:xdigit:]]{24}$}/leases", h.GetNetworkLeases)
+		site.Get(prefix+"/network/{id:^[[:xdigit:]]{24}$}/reservation", h.GetDHCPReservationList)
+		operator.Post(prefix+"/network/{id:^[[:xdigit:]]{24}$}/reservation", h.PostDHCPReservation)
+		site.Get(prefix+"/network/{id:^[[:xdigit:]]{24}$}/reservation/{reservation:^[[:xdigit:]]{24}$}", h.GetDHCPReservation)
+		operator.Patch(prefix+"/network/{id:^[[:xdigit:]]{24}$}/reservation/{reservation:^[[:xdigit:]]{24}$}", h.PatchDHCPReservation)
+		operator.Delete(prefix+"/network/{id:^[[:xdigit:]]{24}$}/reservation/{reservation:^[[:xdigit:]]{24}$}", h.DeleteDHCPReservation)
+		site.Get(prefix+"/firewall/group", h.GetFirewallGroupList)
+		operator.Post(prefix+"/firewall/group", h.PostFirewallGroup)
+		site.Get(prefix+"/firewall/group/{id:^[[:xdigit:]]{24}$}", h.GetFirewallGroup)
+		operator.Patch(prefix+"/firewall/group/{id:^[[:xdigit:]]{24}$}", h.PatchFirewallGroup)
+		operator.Delete(prefix+"/firewall/group/{id:^[[:xdigit:]]{24}$}", h.DeleteFirewallGroup)
+		site.Get(prefix+"/firewall/rule", h.GetFirewallRuleList)
+		operator.Post(prefix+"/firewall/rule", h.PostFirewallRule)
+		site.Get(prefix+"/firewall/rule/{id:^[[:xdigit:]]{24}$}", h.GetFirewallRule)
+		operator.Patch(prefix+"/firewall/rule/{id:^[[:xdigit:]]{24}$}", h.PatchFirewallRule)
+		operator.Delete(prefix+"/firewall/rule/{id:^[[:xdigit:]]{24}$}", h.DeleteFirewallRule)
+		site.Get(prefix+"/firewall/forward", h.GetPortForwardList)
+		operator.Post(prefix+"/firewall/forward", h.PostPortForward)
+		site.Get(prefix+"/firewall/forward/{id:^[[:xdigit:]]{24}$}", h.GetPortForward)
+		operator.Patch(prefix+"/firewall/forward/{id:^[[:xdigit:]]{24}$}", h.PatchPortForward)
+		operator.Delete(prefix+"/firewall/forward/{id:^[[:xdigit:]]{24}$}", h.DeletePortForward)
+		site.Get(prefix+"/firewall/nat", h.GetNATRuleList)
+		operator.Post(prefix+"/firewall/nat", h.PostNATRule)
+		site.Get(prefix+"/firewall/nat/{id:^[[:xdigit:]]{24}$}", h.GetNATRule)
+		operator.Patch(prefix+"/firewall/nat/{id:^[[:xdigit:]]{24}$}", h.PatchNATRule)
+		operator.Delete(prefix+"/firewall/nat/{id:^[[:xdigit:]]{24}$}", h.DeleteNATRule)
+	}
+
+	h.mux.Get("/api/events", h.GetEvents)
+	admin.Get("/api/audit", h.GetAuditList)
+	admin.Get("/api/audit/export", h.GetAuditExport)
+	admin.Get("/api/webhook", h.GetWebhookList)
+	admin.Post("/api/webhook", h.PostWebhook)
+	admin.Get("/api/webhook/{id:^[[:xdigit:]]{24}$}", h.GetWebhook)
+	admin.Patch("/api/webhook/{id:^[[:xdigit:]]{24}$}", h.PatchWebhook)
+	admin.Delete("/api/webhook/{id:^[[:xdigit:]]{24}$}", h.DeleteWebhook)
+	admin.Get("/api/webhook/{id:^[[:xdigit:]]{24}$}/deliveries", h.GetWebhookDeliveries)
 
 }
 
-// Gets a list of all devices
+// Gets a list of the devices adopted in the site and every pending device
 func (h *HttpHandler) GetDeviceList(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", jsonapi.MediaType)
-	if err := jsonapi.MarshalPayloadWithoutIncluded(w, h.devices); err != nil {
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, h.devices.SiteSnapshot(currentSite(r))); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 
 }
 
-// Adopts a device by MAC address
+// Adopts a device by MAC address into the site
 func (h *HttpHandler) PostDeviceAdopt(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", jsonapi.MediaType)
 
-	mac := chi.URLParam(r, "mac")
-	if err := h.devices.Adopt(mac); err != nil {
+	mac, site := chi.URLParam(r, "mac"), currentSite(r)
+	// The site can not be deleted while the device is adopted into it
+	if err := h.sites.Use(func() error { return h.devices.Adopt(mac, site) }, site); err != nil {
 		if err2 := jsonapi.MarshalErrors(w, []*jsonapi.ErrorObject{{
 			Title:  "Error adopting device",
 			Detail: err.Error(),
@@ -62,14 +136,20 @@ func (h *HttpHandler) PostDeviceAdopt(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	AuditRequest(h.audit, r, "device.adopt", "device/"+mac, nil, deviceSiteChange{Site: site})
+	h.events.Publish(event.DeviceAdopted, "device/"+mac, deviceSiteChange{Site: site})
 	w.WriteHeader(http.StatusOK)
 }
 
-// Forgets a network device by MAC address
+// Forgets a network device of the site by MAC address
 func (h *HttpHandler) DeleteDevice(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", jsonapi.MediaType)
 
 	mac := chi.URLParam(r, "mac")
+	if device, err := h.devices.Get(mac); err == nil && device.Site != "" && device.Site != currentSite(r) {
+		WriteError(w, http.StatusNotFound, "Device Not Found", "Device with MAC "+mac+" is not adopted in this site")
+		return
+	}
 	if err := h.devices.Delete(mac); err != nil {
 		if err2 := jsonapi.MarshalErrors(w, []*jsonapi.ErrorObject{{
 			Title:  "Error forgetting device",
@@ -80,77 +160,138 @@ func (h *HttpHandler) DeleteDevice(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	AuditRequest(h.audit, r, "device.forget", "device/"+mac, nil, nil)
+	h.events.Publish(event.DeviceForgotten, "device/"+mac, deviceSiteChange{Site: currentSite(r)})
 	w.WriteHeader(http.StatusNoContent)
 }
 
 // Creates a new wifi network using model.WifiNetworkConfig
 func (h *HttpHandler) PostWifi(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", jsonapi.MediaType)
-
 	WifiNetwork := new(model.WifiNetworkConfig)
 	if err := jsonapi.UnmarshalPayload(r.Body, WifiNetwork); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if _, ok := h.configData.WifiNetworks[WifiNetwork.Ssid]; ok {
-		if err := jsonapi.MarshalErrors(w, []*jsonapi.ErrorObject{{
-			Title:  "Wifi Network Already Exists",
-			Detail: "Wifi network with SSID " + WifiNetwork.Ssid + " already exists",
-			Status: "409",
-		}}); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	if WifiNetwork.ID != "" {
+		WriteError(w, http.StatusForbidden, "Client Generated ID", "Wifi network IDs are assigned by the server")
+		return
+	}
+
+	if WifiNetwork.SecurityKey != "" && !hasRole(r, model.RoleAdmin) {
+		WriteError(w, http.StatusForbidden, "Forbidden", "Only admins may set security keys")
+		return
+	}
+
+	if err := WifiNetwork.Validate(); err != nil {
+		WriteValidationErrors(w, "Invalid Wifi Network", err)
+		return
+	}
+
+	network, err := siteConfig(r).AddWifiNetwork(*WifiNetwork)
+	if err != nil {
+		writeWifiError(w, WifiNetwork.ID, WifiNetwork.Ssid, err)
+		return
 	}
+	h.objectChanged(r, "wifi", network.ID, "create", nil, network)
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	w.Header().Set("Location", sitePath(r, "/wifi/"+network.ID))
 	w.WriteHeader(http.StatusCreated)
-	h.configData.WifiNetworks[WifiNetwork.Ssid] = *WifiNetwork
+	redactWifi(r, &network)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &network); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+	}
 }
 
 // Update existing wifi network using model.WifiNetworkConfig
 func (h *HttpHandler) PutWifi(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", jsonapi.MediaType)
+	id, ok := wifiID(siteConfig(r), w, r)
+	if !ok {
+		return
+	}
 
-	ssid := chi.URLParam(r, "ssid")
 	WifiNetwork := new(model.WifiNetworkConfig)
 	if err := jsonapi.UnmarshalPayload(r.Body, WifiNetwork); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if _, ok := h.configData.WifiNetworks[ssid]; !ok {
-		if err := jsonapi.MarshalErrors(w, []*jsonapi.ErrorObject{{
-			Title:  "Wifi Network Not Found",
-			Detail: "Wifi network with SSID " + WifiNetwork.Ssid + " does not exist",
-			Status: "404",
-		}}); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	h.updateWifi(w, r, id, WifiNetwork)
+}
+
+// Partially update an existing wifi network, attributes missing from the request are left unchanged
+func (h *HttpHandler) PatchWifi(w http.ResponseWriter, r *http.Request) {
+	id, ok := wifiID(siteConfig(r), w, r)
+	if !ok {
+		return
+	}
+
+	network, err := siteConfig(r).GetWifiNetwork(id)
+	if err != nil {
+		writeWifiError(w, id, "", err)
+		return
+	}
+
+	if err := jsonapi.UnmarshalPayload(r.Body, &network); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.updateWifi(w, r, id, &network)
+}
+
+func (h *HttpHandler) updateWifi(w http.ResponseWriter, r *http.Request, id string, WifiNetwork *model.WifiNetworkConfig) {
+	if WifiNetwork.ID != "" && WifiNetwork.ID != id {
+		WriteError(w, http.StatusConflict, "Wifi Network ID Mismatch", "Wifi network ID "+WifiNetwork.ID+" does not match "+id)
 		return
 	}
+
+	current, err := siteConfig(r).GetWifiNetwork(id)
+	if err != nil {
+		writeWifiError(w, id, WifiNetwork.Ssid, err)
+		return
+	}
+	if WifiNetwork.SecurityKey != current.SecurityKey && !hasRole(r, model.RoleAdmin) {
+		WriteError(w, http.StatusForbidden, "Forbidden", "Only admins may change security keys")
+		return
+	}
+
+	if err := WifiNetwork.Validate(); err != nil {
+		WriteValidationErrors(w, "Invalid Wifi Network", err)
+		return
+	}
+
+	network, err := siteConfig(r).UpdateWifiNetwork(id, *WifiNetwork)
+	if err != nil {
+		writeWifiError(w, id, WifiNetwork.Ssid, err)
+		return
+	}
+	h.objectChanged(r, "wifi", id, "update", current, network)
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
 	w.WriteHeader(http.StatusOK)
-	if WifiNetwork.Ssid != ssid {
-		delete(h.configData.WifiNetworks, ssid)
+	redactWifi(r, &network)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &network); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
 	}
-	h.configData.WifiNetworks[WifiNetwork.Ssid] = *WifiNetwork
 }
 
-// deletes a wifi network by SSID
+// deletes a wifi network by ID or SSID
 func (h *HttpHandler) DeleteWifi(w http.ResponseWriter, r *http.Request) {
-	ssid := chi.URLParam(r, "ssid")
-	if _, ok := h.configData.WifiNetworks[ssid]; !ok {
-		w.Header().Set("Content-Type", jsonapi.MediaType)
-		if err := jsonapi.MarshalErrors(w, []*jsonapi.ErrorObject{{
-			Title:  "Wifi Network Not Found",
-			Detail: "Wifi network with SSID " + ssid + " does not exist",
-			Status: "404",
-		}}); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	id, ok := wifiID(siteConfig(r), w, r)
+	if !ok {
 		return
 	}
 
+	network, err := siteConfig(r).DeleteWifiNetwork(id)
+	if err != nil {
+		writeWifiError(w, id, "", err)
+		return
+	}
+	h.objectChanged(r, "wifi", id, "delete", network, nil)
+
 	w.WriteHeader(http.StatusNoContent)
-	delete(h.configData.WifiNetworks, ssid)
 }
 
 // Returns a list of all wifi networks
@@ -158,9 +299,11 @@ func (h *HttpHandler) GetWifiList(w http.ResponseWriter, r *http.Request) {
 	var (
 		networkList []*model.WifiNetworkConfig
 	)
-	networkList = make([]*model.WifiNetworkConfig, 0, len(h.configData.WifiNetworks))
-	for _, network := range h.configData.WifiNetworks {
+	networks := siteConfig(r).WifiNetworkList()
+	networkList = make([]*model.WifiNetworkConfig, 0, len(networks))
+	for _, network := range networks {
 		network := network
+		redactWifi(r, &network)
 		networkList = append(networkList, &network)
 	}
 
@@ -170,32 +313,76 @@ func (h *HttpHandler) GetWifiList(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Returns a wifi network with the given SSID
-func (h *HttpHandler) GetWifiBySSID(w http.ResponseWriter, r *http.Request) {
-	var (
-		buf bytes.Buffer
-	)
-	w.Header().Set("Content-Type", jsonapi.MediaType)
+// Returns a wifi network with the given ID or SSID
+func (h *HttpHandler) GetWifi(w http.ResponseWriter, r *http.Request) {
+	id, ok := wifiID(siteConfig(r), w, r)
+	if !ok {
+		return
+	}
 
-	ssid := chi.URLParam(r, "ssid")
-	if _, ok := h.configData.WifiNetworks[ssid]; !ok {
-		if err := jsonapi.MarshalErrors(&buf, []*jsonapi.ErrorObject{{
-			Title:  "Wifi Network Not Found",
-			Detail: "Wifi network with SSID " + ssid + " does not exist",
-			Status: "404",
-		}}); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		w.WriteHeader(http.StatusNotFound)
-		_, err := w.Write(buf.Bytes())
-		if err != nil {
-			log.Println(err.Error())
-		}
+	network, err := siteConfig(r).GetWifiNetwork(id)
+	if err != nil {
+		writeWifiError(w, id, "", err)
 		return
 	}
-	network := h.configData.WifiNetworks[ssid]
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	redactWifi(r, &network)
 	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &network); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
+
+// redactWifi removes the security key for users that are only allowed to read
+func redactWifi(r *http.Request, network *model.WifiNetworkConfig) {
+	if !hasRole(r, model.RoleOperator) {
+		network.SecurityKey = ""
+	}
+}
+
+// wifiID returns the ID of the wifi network addressed by the request, either
+// directly by ID or by its URL encoded SSID. If no network matches an error
+// response is written and ok is false.
+func wifiID(configData *model.ConfigData, w http.ResponseWriter, r *http.Request) (id string, ok bool) {
+	if id = chi.URLParam(r, "id"); id != "" {
+		return id, true
+	}
+
+	ssid, err := urlParam(r, "ssid")
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid SSID", err.Error())
+		return "", false
+	}
+
+	network, err := configData.GetWifiNetworkBySsid(ssid)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "Wifi Network Not Found", "Wifi network with SSID "+ssid+" does not exist")
+		return "", false
+	}
+	return network.ID, true
+}
+
+// urlParam returns a decoded URL parameter.
+// chi matches against the raw path when the request path contains escapes that
+// can not be normalized (such as %2F), in that case the parameter is still escaped.
+func urlParam(r *http.Request, key string) (string, error) {
+	param := chi.URLParam(r, key)
+	if r.URL.RawPath == "" {
+		return param, nil
+	}
+	return url.PathUnescape(param)
+}
+
+// writeWifiError maps errors returned by the wifi methods of model.ConfigData to responses
+func writeWifiError(w http.ResponseWriter, id, ssid string, err error) {
+	switch {
+	case errors.Is(err, model.ErrWifiNetworkNotFound):
+		WriteError(w, http.StatusNotFound, "Wifi Network Not Found", "Wifi network with ID "+id+" does not exist")
+	case errors.Is(err, model.ErrDuplicateSsid):
+		WriteError(w, http.StatusConflict, "Wifi Network Already Exists", "Wifi network with SSID "+ssid+" already exists")
+	case errors.Is(err, model.ErrNetworkNotFound):
+		WriteError(w, http.StatusUnprocessableEntity, "Unknown Network", "Wifi network "+ssid+" is bridged into a network that does not exist")
+	default:
+		WriteError(w, http.StatusInternalServerError, "Wifi Network Error", err.Error())
+	}
+}
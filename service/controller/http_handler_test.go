@@ -2,9 +2,12 @@ package controller_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/google/jsonapi"
@@ -24,15 +27,16 @@ func TestWifiNetworkList(t *testing.T) {
 
 	t.Parallel()
 
-	h = service.NewBeenFarService()
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
 	h.Init()
+	api := authorize(t, h)
 
 	// Get an empty wifi network list
 	if req, err = http.NewRequest("GET", "/api/wifi", nil); err != nil {
 		t.Fatal(err)
 	}
 
-	response = executeRequest(h, req)
+	response = executeRequest(api, req)
 	if response.Code != http.StatusOK {
 		t.Errorf("Expected status code %v, got %v", http.StatusOK, response.Code)
 	}
@@ -69,7 +73,7 @@ func TestWifiNetworkList(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		response = executeRequest(h, req)
+		response = executeRequest(api, req)
 		if response.Code != http.StatusCreated {
 			t.Errorf("Expected status code %v, got %v", http.StatusCreated, response.Code)
 		}
@@ -81,7 +85,7 @@ func TestWifiNetworkList(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	response = executeRequest(h, req)
+	response = executeRequest(api, req)
 	if response.Code != http.StatusOK {
 		t.Errorf("Expected status code %v, got %v", http.StatusOK, response.Code)
 	}
@@ -96,13 +100,13 @@ func TestWifiNetworkList(t *testing.T) {
 
 	// Delete the wifi network list
 	for _, wifiNetwork := range wifiNetworks {
-		ssid := wifiNetwork.(*model.WifiNetworkConfig).Ssid
-		req, err = http.NewRequest("DELETE", "/api/wifi/"+ssid, nil)
+		id := wifiNetwork.(*model.WifiNetworkConfig).ID
+		req, err = http.NewRequest("DELETE", "/api/wifi/"+id, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		response = executeRequest(h, req)
+		response = executeRequest(api, req)
 		if response.Code != http.StatusNoContent {
 			t.Errorf("Expected status code %v, got %v", http.StatusNoContent, response.Code)
 		}
@@ -119,23 +123,25 @@ func TestWifiNetwork(t *testing.T) {
 	)
 	t.Parallel()
 
-	h = service.NewBeenFarService()
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
 	h.Init()
+	api := authorize(t, h)
 
-	// With a non-existent SSID
-	req, err := http.NewRequest("GET", "/api/wifi/test", nil)
+	// With a non-existent ID
+	req, err := http.NewRequest("GET", "/api/wifi/000000000000000000000000", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	response = executeRequest(h, req)
+	response = executeRequest(api, req)
 	if response.Code != http.StatusNotFound {
 		t.Errorf("Expected status %d, got %d", http.StatusNotFound, response.Code)
 	}
 
 	testWifi = model.WifiNetworkConfig{
-		Ssid:        "test",
-		SecurityKey: "key",
+		Ssid:         "test",
+		SecurityType: model.WifiSecurityTypeWpaPersonal,
+		SecurityKey:  "password",
 	}
 
 	err = jsonapi.MarshalPayload(&bTmp, &testWifi)
@@ -149,18 +155,28 @@ func TestWifiNetwork(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	response = executeRequest(h, req)
+	response = executeRequest(api, req)
 	if response.Code != http.StatusCreated {
 		t.Errorf("Expected status %d, got %d", http.StatusCreated, response.Code)
 	}
 
+	err = jsonapi.UnmarshalPayload(response.Body, &testWifi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := testWifi.ID
+	if id == "" {
+		t.Fatal("Expected the created wifi network to have an ID")
+	}
+
 	// Get the Wifi network
-	req, err = http.NewRequest("GET", "/api/wifi/test", nil)
+	req, err = http.NewRequest("GET", "/api/wifi/"+id, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	response = executeRequest(h, req)
+	response = executeRequest(api, req)
 	if response.Code != http.StatusOK {
 		t.Errorf("Expected status %d, got %d", http.StatusOK, response.Code)
 	}
@@ -173,35 +189,35 @@ func TestWifiNetwork(t *testing.T) {
 	if testWifi.Ssid != "test" {
 		t.Errorf("Expected SSID %s, got %s", "test", testWifi.Ssid)
 	}
-	if testWifi.SecurityKey != "key" {
-		t.Errorf("Expected SecurityKey %s, got %s", "key", testWifi.SecurityKey)
+	if testWifi.SecurityKey != "password" {
+		t.Errorf("Expected SecurityKey %s, got %s", "password", testWifi.SecurityKey)
 	}
 
 	// Update the Wifi network
-	testWifi.SecurityKey = "newkey"
+	testWifi.SecurityKey = "newpassword"
 
 	err = jsonapi.MarshalPayload(&bTmp, &testWifi)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	req, err = http.NewRequest("PUT", "/api/wifi/test", &bTmp)
+	req, err = http.NewRequest("PUT", "/api/wifi/"+id, &bTmp)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	response = executeRequest(h, req)
+	response = executeRequest(api, req)
 	if response.Code != http.StatusOK {
 		t.Errorf("Expected status %d, got %d", http.StatusOK, response.Code)
 	}
 
 	// Get the Wifi network
-	req, err = http.NewRequest("GET", "/api/wifi/test", nil)
+	req, err = http.NewRequest("GET", "/api/wifi/"+id, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	response = executeRequest(h, req)
+	response = executeRequest(api, req)
 	if response.Code != http.StatusOK {
 		t.Errorf("Expected status %d, got %d", http.StatusOK, response.Code)
 	}
@@ -214,33 +230,405 @@ func TestWifiNetwork(t *testing.T) {
 	if testWifi.Ssid != "test" {
 		t.Errorf("Expected SSID %s, got %s", "test", testWifi.Ssid)
 	}
-	if testWifi.SecurityKey != "newkey" {
-		t.Errorf("Expected SecurityKey %s, got %s", "newkey", testWifi.SecurityKey)
+	if testWifi.SecurityKey != "newpassword" {
+		t.Errorf("Expected SecurityKey %s, got %s", "newpassword", testWifi.SecurityKey)
 	}
 
 	// Delete the Wifi network
-	req, err = http.NewRequest("DELETE", "/api/wifi/test", nil)
+	req, err = http.NewRequest("DELETE", "/api/wifi/"+id, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	response = executeRequest(h, req)
+	response = executeRequest(api, req)
 	if response.Code != http.StatusNoContent {
 		t.Errorf("Expected status %d, got %d", http.StatusOK, response.Code)
 	}
 
 	// Get the Wifi network
-	req, err = http.NewRequest("GET", "/api/wifi/test", nil)
+	req, err = http.NewRequest("GET", "/api/wifi/"+id, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	response = executeRequest(h, req)
+	response = executeRequest(api, req)
 	if response.Code != http.StatusNotFound {
 		t.Errorf("Expected status %d, got %d", http.StatusNotFound, response.Code)
 	}
 }
 
+func TestWifiNetworkValidation(t *testing.T) {
+	var (
+		h        *service.BeenFarService
+		bTmp     bytes.Buffer
+		response *httptest.ResponseRecorder
+		payload  struct {
+			Errors []struct {
+				Status string `json:"status"`
+				Source struct {
+					Pointer string `json:"pointer"`
+				} `json:"source"`
+			} `json:"errors"`
+		}
+	)
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
+	api := authorize(t, h)
+
+	// WPA-Personal with a short key
+	err := jsonapi.MarshalPayload(&bTmp, &model.WifiNetworkConfig{
+		Ssid:         "test",
+		SecurityType: model.WifiSecurityTypeWpaPersonal,
+		SecurityKey:  "abc",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", "/api/wifi", &bTmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response = executeRequest(api, req)
+	if response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, response.Code)
+	}
+
+	if err = json.NewDecoder(response.Body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(payload.Errors) != 1 {
+		t.Fatalf("Expected 1 error, got %v", payload.Errors)
+	}
+	if payload.Errors[0].Status != "422" {
+		t.Errorf("Expected status %s, got %s", "422", payload.Errors[0].Status)
+	}
+	if payload.Errors[0].Source.Pointer != "/data/attributes/security_key" {
+		t.Errorf("Expected pointer %s, got %s", "/data/attributes/security_key", payload.Errors[0].Source.Pointer)
+	}
+
+	// Nothing should have been stored
+	if n := len(wifiList(t, api)); n != 0 {
+		t.Errorf("Expected empty wifi network list, got %d networks", n)
+	}
+}
+
+func TestWifiNetworkMalformed(t *testing.T) {
+	var (
+		h    *service.BeenFarService
+		bTmp bytes.Buffer
+	)
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
+	api := authorize(t, h)
+
+	if err := jsonapi.MarshalPayload(&bTmp, &model.WifiNetworkConfig{Ssid: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", "/api/wifi", &bTmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response := executeRequest(api, req); response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, response.Code)
+	}
+	id := wifiList(t, api)[0].ID
+
+	for _, method := range []string{"POST", "PUT", "PATCH"} {
+		path := "/api/wifi/" + id
+		if method == "POST" {
+			path = "/api/wifi"
+		}
+		req, err := http.NewRequest(method, path, bytes.NewBufferString("{not jsonapi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response := executeRequest(api, req); response.Code != http.StatusBadRequest {
+			t.Errorf("%s: Expected status %d, got %d", method, http.StatusBadRequest, response.Code)
+		}
+	}
+}
+
+func TestWifiNetworkConflict(t *testing.T) {
+	var (
+		h        *service.BeenFarService
+		bTmp     bytes.Buffer
+		response *httptest.ResponseRecorder
+	)
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
+	api := authorize(t, h)
+
+	for _, expected := range []int{http.StatusCreated, http.StatusConflict} {
+		bTmp.Reset()
+		err := jsonapi.MarshalPayload(&bTmp, &model.WifiNetworkConfig{
+			Ssid:         "test",
+			SecurityType: model.WifiSecurityTypeWpaPersonal,
+			SecurityKey:  "password",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest("POST", "/api/wifi", &bTmp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response = executeRequest(api, req)
+		if response.Code != expected {
+			t.Errorf("Expected status %d, got %d", expected, response.Code)
+		}
+	}
+
+	networks := wifiList(t, api)
+	if len(networks) != 1 {
+		t.Fatalf("Expected 1 wifi network, got %d", len(networks))
+	}
+	if networks[0].SecurityKey != "password" {
+		t.Errorf("Expected SecurityKey %s, got %s", "password", networks[0].SecurityKey)
+	}
+}
+
+func TestWifiNetworkPatch(t *testing.T) {
+	var (
+		h        *service.BeenFarService
+		response *httptest.ResponseRecorder
+		testWifi model.WifiNetworkConfig
+	)
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
+	api := authorize(t, h)
+
+	ids := make(map[string]string)
+	for _, ssid := range []string{"first", "second"} {
+		var bTmp bytes.Buffer
+		err := jsonapi.MarshalPayload(&bTmp, &model.WifiNetworkConfig{
+			Ssid:         ssid,
+			SecurityType: model.WifiSecurityTypeWpaPersonal,
+			SecurityKey:  "password",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest("POST", "/api/wifi", &bTmp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response = executeRequest(api, req)
+		if response.Code != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d", http.StatusCreated, response.Code)
+		}
+		if err = jsonapi.UnmarshalPayload(response.Body, &testWifi); err != nil {
+			t.Fatal(err)
+		}
+		ids[ssid] = testWifi.ID
+	}
+
+	// Rename the first network, the security key must be left alone
+	patch := `{"data":{"type":"wifi","id":"` + ids["first"] + `","attributes":{"ssid":"renamed"}}}`
+	req, err := http.NewRequest("PATCH", "/api/wifi/"+ids["first"], bytes.NewBufferString(patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response = executeRequest(api, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+
+	if err = jsonapi.UnmarshalPayload(response.Body, &testWifi); err != nil {
+		t.Fatal(err)
+	}
+	if testWifi.ID != ids["first"] {
+		t.Errorf("Expected ID %s, got %s", ids["first"], testWifi.ID)
+	}
+	if testWifi.Ssid != "renamed" {
+		t.Errorf("Expected SSID %s, got %s", "renamed", testWifi.Ssid)
+	}
+	if testWifi.SecurityKey != "password" {
+		t.Errorf("Expected SecurityKey %s, got %s", "password", testWifi.SecurityKey)
+	}
+
+	// Renaming onto an existing SSID conflicts
+	patch = `{"data":{"type":"wifi","id":"` + ids["first"] + `","attributes":{"ssid":"second"}}}`
+	if req, err = http.NewRequest("PATCH", "/api/wifi/"+ids["first"], bytes.NewBufferString(patch)); err != nil {
+		t.Fatal(err)
+	}
+
+	response = executeRequest(api, req)
+	if response.Code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, response.Code)
+	}
+
+	// An invalid partial update is rejected
+	patch = `{"data":{"type":"wifi","id":"` + ids["first"] + `","attributes":{"security_key":"abc"}}}`
+	if req, err = http.NewRequest("PATCH", "/api/wifi/"+ids["first"], bytes.NewBufferString(patch)); err != nil {
+		t.Fatal(err)
+	}
+
+	response = executeRequest(api, req)
+	if response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, response.Code)
+	}
+
+	// Mismatched IDs conflict
+	patch = `{"data":{"type":"wifi","id":"` + ids["second"] + `","attributes":{"hidden":true}}}`
+	if req, err = http.NewRequest("PATCH", "/api/wifi/"+ids["first"], bytes.NewBufferString(patch)); err != nil {
+		t.Fatal(err)
+	}
+
+	response = executeRequest(api, req)
+	if response.Code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, response.Code)
+	}
+
+	// Unknown IDs are not found
+	if req, err = http.NewRequest("PATCH", "/api/wifi/000000000000000000000000", bytes.NewBufferString(patch)); err != nil {
+		t.Fatal(err)
+	}
+
+	response = executeRequest(api, req)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, response.Code)
+	}
+}
+
+func TestWifiNetworkSsidEncoding(t *testing.T) {
+	var (
+		h        *service.BeenFarService
+		response *httptest.ResponseRecorder
+		testWifi model.WifiNetworkConfig
+	)
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
+	api := authorize(t, h)
+
+	tests := []struct {
+		ssid     string
+		expected int
+	}{
+		{"Cafe-Guest", http.StatusCreated},
+		{"Bob's_Wifi", http.StatusCreated},
+		{"Café ☕", http.StatusCreated},
+		{"100% / free?#", http.StatusCreated},
+		{" padded ", http.StatusCreated},
+		{strings.Repeat("📶", 8), http.StatusCreated},
+		{strings.Repeat("📶", 9), http.StatusUnprocessableEntity},
+		{"line\nbreak", http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		var bTmp bytes.Buffer
+		err := jsonapi.MarshalPayload(&bTmp, &model.WifiNetworkConfig{
+			Ssid: tt.ssid,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest("POST", "/api/wifi", &bTmp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response = executeRequest(api, req)
+		if response.Code != tt.expected {
+			t.Errorf("%q: Expected status %d, got %d", tt.ssid, tt.expected, response.Code)
+		}
+		if tt.expected != http.StatusCreated {
+			continue
+		}
+
+		if err = jsonapi.UnmarshalPayload(response.Body, &testWifi); err != nil {
+			t.Fatal(err)
+		}
+		id := testWifi.ID
+
+		// Look the network up by its encoded SSID
+		req, err = http.NewRequest("GET", "/api/wifi/ssid/"+url.PathEscape(tt.ssid), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response = executeRequest(api, req)
+		if response.Code != http.StatusOK {
+			t.Fatalf("%q: Expected status %d, got %d", tt.ssid, http.StatusOK, response.Code)
+		}
+
+		testWifi = model.WifiNetworkConfig{}
+		if err = jsonapi.UnmarshalPayload(response.Body, &testWifi); err != nil {
+			t.Fatal(err)
+		}
+		if testWifi.ID != id {
+			t.Errorf("%q: Expected ID %s, got %s", tt.ssid, id, testWifi.ID)
+		}
+		if testWifi.Ssid != tt.ssid {
+			t.Errorf("Expected SSID %q, got %q", tt.ssid, testWifi.Ssid)
+		}
+	}
+
+	if n := len(wifiList(t, api)); n != 6 {
+		t.Errorf("Expected 6 wifi networks, got %d", n)
+	}
+
+	// Delete by SSID
+	req, err := http.NewRequest("DELETE", "/api/wifi/ssid/"+url.PathEscape("100% / free?#"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response = executeRequest(api, req)
+	if response.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, response.Code)
+	}
+
+	req, err = http.NewRequest("GET", "/api/wifi/ssid/"+url.PathEscape("100% / free?#"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response = executeRequest(api, req)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, response.Code)
+	}
+}
+
+// wifiList fetches all wifi networks from the api
+func wifiList(t *testing.T, h http.Handler) []*model.WifiNetworkConfig {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", "/api/wifi", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := executeRequest(h, req)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status code %v, got %v", http.StatusOK, response.Code)
+	}
+
+	networks, err := jsonapi.UnmarshalManyPayload(response.Body, reflect.TypeOf(new(model.WifiNetworkConfig)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := make([]*model.WifiNetworkConfig, 0, len(networks))
+	for _, network := range networks {
+		list = append(list, network.(*model.WifiNetworkConfig))
+	}
+	return list
+}
+
 func executeRequest(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
 	rr := httptest.NewRecorder()
 	h.ServeHTTP(rr, req)
@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+type LogHandler struct {
+	logger *logging.Logger
+	audit  *model.AuditLog
+}
+
+// Init registers the log settings api, the router is expected to authenticate requests
+func (h *LogHandler) Init(router chi.Router, logger *logging.Logger, audit *model.AuditLog) {
+	h.logger = logger
+	h.audit = audit
+
+	admin := router.With(RequireRole(model.RoleAdmin))
+	admin.Get("/api/log", h.GetLogSettings)
+	admin.Patch("/api/log", h.PatchLogSettings)
+}
+
+func (h *LogHandler) settings() model.LogSettings {
+	return model.LogSettings{
+		ID:           model.LogSettingsID,
+		Level:        h.logger.Level().String(),
+		DebugDevices: h.logger.DebugDevices(),
+	}
+}
+
+// Returns the current log level and debugged devices
+func (h *LogHandler) GetLogSettings(w http.ResponseWriter, r *http.Request) {
+	settings := h.settings()
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &settings); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Changes the log level or the debugged devices, attributes missing from the request are left unchanged
+func (h *LogHandler) PatchLogSettings(w http.ResponseWriter, r *http.Request) {
+	current := h.settings()
+
+	settings := current
+	if err := jsonapi.UnmarshalPayload(r.Body, &settings); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if settings.ID != model.LogSettingsID {
+		WriteError(w, http.StatusConflict, "Log Settings ID Mismatch", "Log settings ID "+settings.ID+" does not match "+model.LogSettingsID)
+		return
+	}
+	if err := settings.Validate(); err != nil {
+		WriteValidationErrors(w, "Invalid Log Settings", err)
+		return
+	}
+
+	level, _ := logging.ParseLevel(settings.Level)
+	h.logger.SetLevel(level)
+	h.logger.SetDebugDevices(settings.DebugDevices)
+
+	settings = h.settings()
+	AuditRequest(h.audit, r, "log.update", "log", current, settings)
+	logging.FromContext(r.Context()).Info("log settings changed", "level", settings.Level, "debug_devices", settings.DebugDevices)
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &settings); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// RequestLogger is middleware that gives every request a logger with its request ID, see logging.FromContext.
+// It expects middleware.RequestID to run first, the ID is returned in the X-Request-Id header.
+func RequestLogger(logger *logging.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := middleware.GetReqID(r.Context())
+			l := logger.With("request_id", id)
+			if id != "" {
+				w.Header().Set(middleware.RequestIDHeader, id)
+			}
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			start := time.Now()
+			next.ServeHTTP(ww, r.WithContext(logging.NewContext(r.Context(), l)))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			l.Debug("request", "method", r.Method, "path", r.URL.Path, "status", status, "duration", time.Since(start), "remote", SourceIP(r))
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package controller_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+func TestLogSettings(t *testing.T) {
+	var (
+		h        *service.BeenFarService
+		buf      bytes.Buffer
+		settings model.LogSettings
+	)
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword), service.WithUnifiKey(testUnifiKey), service.WithLogger(logging.New(&buf, logging.LevelInfo)))
+	api := authorize(t, h)
+	createUser(t, api, "operator", model.RoleOperator)
+
+	response := send(t, api, "GET", "/api/log", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	if err := jsonapi.UnmarshalPayload(response.Body, &settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings.Level != "info" || len(settings.DebugDevices) != 0 {
+		t.Errorf("Expected level info without debugged devices, got %+v", settings)
+	}
+	if id := response.Header().Get("X-Request-Id"); id == "" {
+		t.Error("Expected a request ID")
+	}
+
+	// Only admins change log settings
+	if response := send(t, authorizeAs(t, h, "operator"), "PATCH", "/api/log", &model.LogSettings{ID: model.LogSettingsID, Level: "debug"}); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+	if response := send(t, api, "PATCH", "/api/log", &model.LogSettings{ID: model.LogSettingsID, Level: "verbose", DebugDevices: []string{"nope"}}); response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, response.Code)
+	}
+
+	// Debug a single device, the level is left unchanged
+	const mac = "deadbeef0003"
+	response = sendRaw(t, api, "PATCH", "/api/log", `{"data":{"type":"log_settings","id":"log","attributes":{"debug_devices":["DE:AD:BE:EF:00:03"]}}}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, response.Code, response.Body)
+	}
+	if err := jsonapi.UnmarshalPayload(response.Body, &settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings.Level != "info" || len(settings.DebugDevices) != 1 || settings.DebugDevices[0] != mac {
+		t.Errorf("Expected %s to be debugged at level info, got %+v", mac, settings)
+	}
+
+	inform := func(mac string, key []byte, payload any) {
+		t.Helper()
+		req, err := http.NewRequest("POST", "/inform", bytes.NewBuffer(informKeyPacket(t, mac, key, payload)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		executeRequest(h.Handler(service.ListenerInform), req)
+	}
+	for _, m := range []string{mac, "deadbeef0004"} {
+		inform(m, nil, map[string]string{"mac": m})
+		if response := send(t, api, "POST", "/api/device/adopt/"+m, nil); response.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+		}
+		inform(m, testUnifiKey, map[string]any{"mac": m, "uptime": 60, "x_authkey": "0123456789abcdef"})
+	}
+
+	var dumps []map[string]any
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var rec map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("Expected JSON log records, got %q", scanner.Text())
+		}
+		if rec["msg"] == "inform payload" {
+			dumps = append(dumps, rec)
+		}
+	}
+	if len(dumps) != 1 || dumps[0]["mac"] != mac || dumps[0]["component"] != service.ListenerInform || dumps[0]["request_id"] == "" {
+		t.Fatalf("Expected a single payload dump for %s, got %v", mac, dumps)
+	}
+	payload := dumps[0]["payload"].(map[string]any)
+	if payload["uptime"] != float64(60) || payload["x_authkey"] != logging.Redacted {
+		t.Errorf("Expected the payload with its key redacted, got %v", payload)
+	}
+}
+
+func TestBootstrapPasswordNotLogged(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		dir = t.TempDir()
+	)
+	t.Parallel()
+
+	service.NewBeenFarService(service.WithDataDir(dir), service.WithLogger(logging.New(&buf, logging.LevelDebug)))
+
+	password, err := os.ReadFile(filepath.Join(dir, "admin.password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "admin.password")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the password file to only be readable by its owner, got %v", err)
+	}
+	if strings.Contains(buf.String(), strings.TrimSpace(string(password))) {
+		t.Errorf("Expected the password to not be logged, got %s", buf.String())
+	}
+	key, err := os.ReadFile(filepath.Join(dir, "unifi.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), strings.TrimSpace(string(key))) {
+		t.Errorf("Expected the UniFi key to not be logged, got %s", buf.String())
+	}
+}
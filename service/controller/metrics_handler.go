@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/metrics"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+type MetricsHandler struct {
+	devices  *model.Devices
+	registry *metrics.Registry
+}
+
+func (h *MetricsHandler) Init(router chi.Router, devices *model.Devices, registry *metrics.Registry) {
+	h.devices = devices
+	h.registry = registry
+
+	registry.Register(h.collectDevices)
+
+	router.Get("/metrics", h.GetMetrics)
+}
+
+// Returns controller and device metrics in the Prometheus text format
+func (h *MetricsHandler) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	if _, err := h.registry.WriteTo(w); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+	}
+}
+
+// collectDevices writes the device counts and the statistics of every adopted device
+func (h *MetricsHandler) collectDevices(w *metrics.Writer) {
+	devices := h.devices.Snapshot()
+
+	w.Family("beenfar_devices", "Number of devices by adoption state.", metrics.TypeGauge)
+	w.Sample("beenfar_devices", float64(len(devices.Adopted)), metrics.Label{Name: "state", Value: "adopted"})
+	w.Sample("beenfar_devices", float64(len(devices.Pending)), metrics.Label{Name: "state", Value: "pending"})
+
+	w.Family("beenfar_device_up", "Whether an adopted device is checking in.", metrics.TypeGauge)
+	for _, d := range devices.Adopted {
+		w.Sample("beenfar_device_up", boolValue(d.Online), deviceLabel(d))
+	}
+
+	gauges := []struct {
+		name, help string
+		value      func(s *model.DeviceStats) float64
+	}{
+		{"beenfar_device_uptime_seconds", "Device uptime in seconds.", func(s *model.DeviceStats) float64 { return float64(s.Uptime) }},
+		{"beenfar_device_clients", "Number of clients connected to a device.", func(s *model.DeviceStats) float64 { return float64(s.Clients) }},
+		{"beenfar_device_cpu_ratio", "Device cpu utilization.", func(s *model.DeviceStats) float64 { return s.CPU }},
+		{"beenfar_device_memory_ratio", "Device memory utilization.", func(s *model.DeviceStats) float64 { return s.Memory }},
+	}
+	for _, g := range gauges {
+		w.Family(g.name, g.help, metrics.TypeGauge)
+		for _, d := range devices.Adopted {
+			if d.Stats != nil {
+				w.Sample(g.name, g.value(d.Stats), deviceLabel(d))
+			}
+		}
+	}
+
+	w.Family("beenfar_device_port_up", "Whether a device port has link.", metrics.TypeGauge)
+	for _, d := range devices.Adopted {
+		if d.Stats != nil {
+			for _, p := range d.Stats.Ports {
+				w.Sample("beenfar_device_port_up", boolValue(p.Up), deviceLabel(d), metrics.Label{Name: "port", Value: p.Name})
+			}
+		}
+	}
+
+	for _, dir := range []struct {
+		name, help string
+		value      func(p model.PortStats) uint64
+	}{
+		{"beenfar_device_port_receive_bytes_total", "Bytes received on a device port.", func(p model.PortStats) uint64 { return p.RxBytes }},
+		{"beenfar_device_port_transmit_bytes_total", "Bytes transmitted on a device port.", func(p model.PortStats) uint64 { return p.TxBytes }},
+	} {
+		w.Family(dir.name, dir.help, metrics.TypeCounter)
+		for _, d := range devices.Adopted {
+			if d.Stats != nil {
+				for _, p := range d.Stats.Ports {
+					w.Sample(dir.name, float64(dir.value(p)), deviceLabel(d), metrics.Label{Name: "port", Value: p.Name})
+				}
+			}
+		}
+	}
+
+	for _, dir := range []struct {
+		name, help string
+		value      func(r model.RadioStats) uint64
+	}{
+		{"beenfar_device_radio_receive_bytes_total", "Bytes received on a device radio.", func(r model.RadioStats) uint64 { return r.RxBytes }},
+		{"beenfar_device_radio_transmit_bytes_total", "Bytes transmitted on a device radio.", func(r model.RadioStats) uint64 { return r.TxBytes }},
+	} {
+		w.Family(dir.name, dir.help, metrics.TypeCounter)
+		for _, d := range devices.Adopted {
+			if d.Stats != nil {
+				for _, r := range d.Stats.Radios {
+					w.Sample(dir.name, float64(dir.value(r)), deviceLabel(d), metrics.Label{Name: "radio", Value: r.Name})
+				}
+			}
+		}
+	}
+
+	w.Family("beenfar_device_radio_clients", "Number of clients connected to a device radio.", metrics.TypeGauge)
+	for _, d := range devices.Adopted {
+		if d.Stats != nil {
+			for _, r := range d.Stats.Radios {
+				w.Sample("beenfar_device_radio_clients", float64(r.Clients), deviceLabel(d), metrics.Label{Name: "radio", Value: r.Name})
+			}
+		}
+	}
+}
+
+func deviceLabel(d model.Device) metrics.Label {
+	return metrics.Label{Name: "mac", Value: d.GetMac()}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,101 @@
+package controller_test
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service"
+	"github.com/jacobalberty/beenfar/service/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	var h *service.BeenFarService
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword), service.WithUnifiKey(testUnifiKey))
+	api := authorize(t, h)
+
+	// Scraping requires authentication
+	if response := send(t, h, "GET", "/metrics", nil); response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, response.Code)
+	}
+
+	const mac = "deadbeef0001"
+	inform := func(packet []byte) {
+		t.Helper()
+		req, err := http.NewRequest("POST", "/inform", bytes.NewBuffer(packet))
+		if err != nil {
+			t.Fatal(err)
+		}
+		executeRequest(h.Handler(service.ListenerInform), req)
+	}
+
+	inform(informPacket(t, mac))
+	if response := send(t, api, "POST", "/api/device/adopt/"+mac, nil); response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+
+	inform(informKeyPacket(t, mac, testUnifiKey, map[string]any{
+		"mac":     mac,
+		"uptime":  3600,
+		"num_sta": 7,
+		"system-stats": map[string]any{
+			"cpu": "12.5",
+			"mem": "50",
+		},
+		"port_table": []map[string]any{
+			{"port_idx": 1, "name": "Port 1", "up": true, "rx_bytes": 1000, "tx_bytes": 2000},
+		},
+		"radio_table": []map[string]any{
+			{"name": "wifi0", "radio": "ng"},
+			{"name": "wifi1", "radio": "na"},
+		},
+		"vap_table": []map[string]any{
+			{"radio": "ng", "essid": "a", "num_sta": 3, "rx_bytes": 10, "tx_bytes": 20},
+			{"radio": "ng", "essid": "b", "num_sta": 1, "rx_bytes": 5, "tx_bytes": 5},
+			{"radio": "na", "essid": "a", "num_sta": 3, "rx_bytes": 30, "tx_bytes": 40},
+		},
+	}))
+
+	// Packets shorter than the header can not be decoded
+	inform([]byte("short"))
+	// Adopted devices have to use the controller key
+	inform(informPacket(t, mac))
+
+	response := send(t, api, "GET", "/metrics", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	if ct := response.Header().Get("Content-Type"); ct != metrics.ContentType {
+		t.Errorf("Expected content type %s, got %s", metrics.ContentType, ct)
+	}
+
+	body := response.Body.String()
+	for _, line := range []string{
+		`beenfar_informs_total 4`,
+		`beenfar_inform_decode_failures_total{reason="authentication"} 1`,
+		`beenfar_inform_decode_failures_total{reason="malformed"} 1`,
+		`beenfar_devices{state="adopted"} 1`,
+		`beenfar_devices{state="pending"} 0`,
+		`beenfar_device_up{mac="deadbeef0001"} 1`,
+		`beenfar_device_uptime_seconds{mac="deadbeef0001"} 3600`,
+		`beenfar_device_clients{mac="deadbeef0001"} 7`,
+		`beenfar_device_cpu_ratio{mac="deadbeef0001"} 0.125`,
+		`beenfar_device_memory_ratio{mac="deadbeef0001"} 0.5`,
+		`beenfar_device_port_up{mac="deadbeef0001",port="Port 1"} 1`,
+		`beenfar_device_port_receive_bytes_total{mac="deadbeef0001",port="Port 1"} 1000`,
+		`beenfar_device_port_transmit_bytes_total{mac="deadbeef0001",port="Port 1"} 2000`,
+		`beenfar_device_radio_receive_bytes_total{mac="deadbeef0001",radio="ng"} 15`,
+		`beenfar_device_radio_transmit_bytes_total{mac="deadbeef0001",radio="na"} 40`,
+		`beenfar_device_radio_clients{mac="deadbeef0001",radio="ng"} 4`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected metrics to contain %q", line)
+		}
+	}
+	if t.Failed() {
+		t.Log(body)
+	}
+}
@@ -0,0 +1,13 @@
+package controller
+
+import "net/http"
+
+// LimitBody is middleware that stops reading request bodies after n bytes
+func LimitBody(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
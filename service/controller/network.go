@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// Returns all wired networks sorted by name
+func (h *HttpHandler) GetNetworkList(w http.ResponseWriter, r *http.Request) {
+	networks := siteConfig(r).NetworkList()
+	networkList := make([]*model.NetworkConfig, 0, len(networks))
+	for _, network := range networks {
+		network := network
+		networkList = append(networkList, &network)
+	}
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayload(w, networkList); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Returns a wired network by ID
+func (h *HttpHandler) GetNetwork(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	network, err := siteConfig(r).GetNetwork(id)
+	if err != nil {
+		writeNetworkError(w, id, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &network); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Creates a wired network using model.NetworkConfig
+func (h *HttpHandler) PostNetwork(w http.ResponseWriter, r *http.Request) {
+	request := new(model.NetworkConfig)
+	if err := jsonapi.UnmarshalPayload(r.Body, request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if request.ID != "" {
+		WriteError(w, http.StatusForbidden, "Client Generated ID", "Network IDs are assigned by the server")
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		WriteValidationErrors(w, "Invalid Network", err)
+		return
+	}
+
+	network, err := siteConfig(r).AddNetwork(*request)
+	if err != nil {
+		writeNetworkError(w, "", err)
+		return
+	}
+	h.objectChanged(r, "network", network.ID, "create", nil, network)
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	w.Header().Set("Location", sitePath(r, "/network/"+network.ID))
+	w.WriteHeader(http.StatusCreated)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &network); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+	}
+}
+
+// Partially updates a wired network, attributes missing from the request are left unchanged.
+// The dhcp and ipv6 settings are replaced as a whole.
+func (h *HttpHandler) PatchNetwork(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	current, err := siteConfig(r).GetNetwork(id)
+	if err != nil {
+		writeNetworkError(w, id, err)
+		return
+	}
+
+	network := current
+	if err := jsonapi.UnmarshalPayload(r.Body, &network); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if network.ID != id {
+		WriteError(w, http.StatusConflict, "Network ID Mismatch", "Network ID "+network.ID+" does not match "+id)
+		return
+	}
+
+	if err := network.Validate(); err != nil {
+		WriteValidationErrors(w, "Invalid Network", err)
+		return
+	}
+
+	if network, err = siteConfig(r).UpdateNetwork(id, network); err != nil {
+		writeNetworkError(w, id, err)
+		return
+	}
+	h.objectChanged(r, "network", id, "update", current, network)
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &network); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+	}
+}
+
+// Deletes a wired network, networks wifi networks are bridged into or firewall rules use can not be deleted
+func (h *HttpHandler) DeleteNetwork(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	network, err := siteConfig(r).DeleteNetwork(id)
+	if err != nil {
+		writeNetworkError(w, id, err)
+		return
+	}
+	h.objectChanged(r, "network", id, "delete", network, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeNetworkError maps errors returned by the network methods of model.ConfigData to responses
+func writeNetworkError(w http.ResponseWriter, id string, err error) {
+	switch {
+	case errors.Is(err, model.ErrNetworkNotFound):
+		WriteError(w, http.StatusNotFound, "Network Not Found", "Network with ID "+id+" does not exist")
+	case errors.Is(err, model.ErrDuplicateNetworkName):
+		WriteError(w, http.StatusConflict, "Network Already Exists", "A network with this name already exists")
+	case errors.Is(err, model.ErrDuplicateVlan):
+		WriteError(w, http.StatusConflict, "VLAN In Use", "Another network already uses this VLAN")
+	case errors.Is(err, model.ErrNetworkInUse):
+		WriteError(w, http.StatusConflict, "Network In Use", "Network with ID "+id+" still has wifi networks bridged into it or is used by firewall rules")
+	case errors.Is(err, model.ErrDHCPReservationConflict):
+		WriteError(w, http.StatusConflict, "Reservations Do Not Fit", err.Error())
+	case errors.Is(err, model.ErrAddressOutsideNetwork):
+		WriteError(w, http.StatusConflict, "Port Forwards Do Not Fit", err.Error())
+	default:
+		WriteError(w, http.StatusInternalServerError, "Network Error", err.Error())
+	}
+}
@@ -0,0 +1,114 @@
+package controller_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+func TestNetwork(t *testing.T) {
+	var (
+		h       *service.BeenFarService
+		network model.NetworkConfig
+	)
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
+	api := authorize(t, h)
+	createUser(t, api, "reader", model.RoleReadOnly)
+
+	guest := &model.NetworkConfig{
+		Name:            "guest",
+		Purpose:         model.NetworkPurposeGuest,
+		Vlan:            20,
+		GatewayIPSubnet: "10.0.20.1/24",
+		DHCPConfig: model.DHCPConfig{
+			DHCPMode:       model.DHCPModeServer,
+			DHCPStart:      "10.0.20.10",
+			DHCPStop:       "10.0.20.50",
+			DHCPNameServer: model.DHCPNameServer{Addresses: []string{"1.1.1.1"}},
+			DHCPGateway:    model.DHCPGateway{Auto: true},
+		},
+	}
+
+	if response := send(t, authorizeAs(t, h, "reader"), "POST", "/api/network", guest); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+
+	response := send(t, api, "POST", "/api/network", guest)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+	}
+	if err := jsonapi.UnmarshalPayload(response.Body, &network); err != nil {
+		t.Fatal(err)
+	}
+	if network.ID == "" || response.Header().Get("Location") != "/api/network/"+network.ID {
+		t.Errorf("Expected an ID and its location, got %q and %q", network.ID, response.Header().Get("Location"))
+	}
+
+	// Nested dhcp settings survive a round trip
+	response = send(t, api, "GET", "/api/network/"+network.ID, nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	network = model.NetworkConfig{}
+	if err := jsonapi.UnmarshalPayload(response.Body, &network); err != nil {
+		t.Fatal(err)
+	}
+	dhcp := network.DHCPConfig
+	if dhcp.DHCPMode != model.DHCPModeServer || dhcp.DHCPStart != "10.0.20.10" || dhcp.DHCPStop != "10.0.20.50" ||
+		len(dhcp.DHCPNameServer.Addresses) != 1 || !dhcp.DHCPGateway.Auto || network.Vlan != 20 {
+		t.Errorf("Expected the network as created, got %+v", network)
+	}
+
+	duplicate := *guest
+	duplicate.Vlan = 30
+	if response := send(t, api, "POST", "/api/network", &duplicate); response.Code != http.StatusConflict {
+		t.Errorf("Expected status %d for a duplicate name, got %d", http.StatusConflict, response.Code)
+	}
+	duplicate.Name = "other"
+	duplicate.Vlan = 20
+	if response := send(t, api, "POST", "/api/network", &duplicate); response.Code != http.StatusConflict {
+		t.Errorf("Expected status %d for a duplicate vlan, got %d", http.StatusConflict, response.Code)
+	}
+
+	invalid := *guest
+	invalid.Name = "br-guest"
+	invalid.DHCPConfig.DHCPStop = "10.0.21.1"
+	response = send(t, api, "POST", "/api/network", &invalid)
+	if response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, response.Code)
+	}
+	for _, pointer := range []string{"/data/attributes/name", "/data/attributes/dhcp_config"} {
+		if !strings.Contains(response.Body.String(), pointer) {
+			t.Errorf("Expected an error on %s, got %s", pointer, response.Body)
+		}
+	}
+
+	// Wifi networks are bridged into existing networks only
+	if response := send(t, api, "POST", "/api/wifi", &model.WifiNetworkConfig{Ssid: "lost", Network: "000000000000000000000000"}); response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, response.Code)
+	}
+	if response := send(t, api, "POST", "/api/wifi", &model.WifiNetworkConfig{Ssid: "guest", Network: model.NetworkID(network.ID)}); response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+	}
+	if response := send(t, api, "DELETE", "/api/network/"+network.ID, nil); response.Code != http.StatusConflict {
+		t.Errorf("Expected status %d deleting a network in use, got %d", http.StatusConflict, response.Code)
+	}
+
+	response = sendRaw(t, api, "PATCH", "/api/network/"+network.ID, `{"data":{"type":"network","id":"`+network.ID+`","attributes":{"vlan":25}}}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, response.Code, response.Body)
+	}
+	network = model.NetworkConfig{}
+	if err := jsonapi.UnmarshalPayload(response.Body, &network); err != nil {
+		t.Fatal(err)
+	}
+	if network.Vlan != 25 || network.Name != "guest" || network.DHCPConfig.DHCPStart != "10.0.20.10" {
+		t.Errorf("Expected only the vlan to change, got %+v", network)
+	}
+}
@@ -0,0 +1,231 @@
+package controller_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service"
+	"github.com/jacobalberty/beenfar/service/adapter/openwrt"
+	"github.com/jacobalberty/beenfar/service/adapter/openwrt/openwrttest"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+func TestOpenWRTAgent(t *testing.T) {
+	const (
+		mac   = "deadbeef0010"
+		key   = "0123456789abcdef0123"
+		other = "fedcba9876543210fedc"
+	)
+	var h *service.BeenFarService
+	t.Parallel()
+
+	h = service.NewBeenFarService(
+		service.WithAdminPassword(testPassword),
+		service.WithInformInterval(30*time.Second),
+		service.WithDrivers(openwrt.NewDriver(openwrt.Config{Uplink: "lan1"})),
+	)
+	api := authorize(t, h)
+	createUser(t, api, "reader", model.RoleReadOnly)
+
+	poll := func(mac, key, etag string) *httptest.ResponseRecorder {
+		t.Helper()
+		req, err := http.NewRequest("GET", "/openwrt/"+mac+"/config", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		return executeRequest(h.Handler(service.ListenerAPI), req)
+	}
+
+	if response := poll(mac, "", ""); response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without a key, got %d", http.StatusUnauthorized, response.Code)
+	}
+
+	// The first poll announces the router and pins its key
+	if response := poll(mac, key, ""); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d before adoption, got %d", http.StatusNotFound, response.Code)
+	}
+	if response := poll(mac, other, ""); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d for another key, got %d", http.StatusForbidden, response.Code)
+	}
+	if body := send(t, api, "GET", "/api/device", nil).Body.String(); !strings.Contains(body, `"driver":"openwrt"`) {
+		t.Errorf("Expected a pending OpenWRT router, got %s", body)
+	}
+	if response := send(t, api, "POST", "/api/device/adopt/"+mac, nil); response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	if response := send(t, api, "POST", "/api/wifi", &model.WifiNetworkConfig{Ssid: "home"}); response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, response.Code)
+	}
+
+	var config openwrt.AgentConfig
+	response := poll(mac, key, "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, response.Code, response.Body)
+	}
+	if err := json.NewDecoder(response.Body).Decode(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Version == "" || config.Interval != 30 || !strings.Contains(config.Files["wireless"], "option ssid 'home'") {
+		t.Errorf("Expected the bundle with the wifi network, got %+v", config)
+	}
+	etag := response.Header().Get("ETag")
+	if etag != `"`+config.Version+`"` {
+		t.Errorf("Expected the version as ETag, got %s", etag)
+	}
+
+	// The bundle holds the wifi keys so it is not served on the plain HTTP inform listener
+	req, err := http.NewRequest("GET", "/openwrt/"+mac+"/config", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	if response := executeRequest(h.Handler(service.ListenerInform), req); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d on the inform listener, got %d", http.StatusNotFound, response.Code)
+	}
+
+	if response := poll(mac, key, etag); response.Code != http.StatusNotModified {
+		t.Errorf("Expected status %d for an unchanged bundle, got %d", http.StatusNotModified, response.Code)
+	}
+	if response := send(t, api, "POST", "/api/wifi", &model.WifiNetworkConfig{Ssid: "guest", Guest: true}); response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, response.Code)
+	}
+	if response := poll(mac, key, etag); response.Code != http.StatusOK || response.Header().Get("ETag") == etag {
+		t.Errorf("Expected a new version after a change, got status %d and %s", response.Code, response.Header().Get("ETag"))
+	}
+
+	// The rendered config can be previewed by operators
+	if response := send(t, authorizeAs(t, h, "reader"), "GET", "/api/device/"+mac+"/config", nil); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+	response = send(t, api, "GET", "/api/device/"+mac+"/config", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	for _, pkg := range openwrt.Packages {
+		if !strings.Contains(response.Body.String(), "package "+pkg+"\n") {
+			t.Errorf("Expected package %s in the preview, got %s", pkg, response.Body)
+		}
+	}
+
+	// A MAC known to another driver is not taken over
+	const unifiMac = "deadbeef0011"
+	req, err = http.NewRequest("POST", "/inform", bytes.NewBuffer(informPacket(t, unifiMac)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	executeRequest(h.Handler(service.ListenerInform), req)
+	if response := poll(unifiMac, key, ""); response.Code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, response.Code)
+	}
+}
+
+func TestOpenWRTRPC(t *testing.T) {
+	const mac = "deadbeef0020"
+	var h *service.BeenFarService
+	t.Parallel()
+
+	rpcd := openwrttest.NewRPCD("root", testPassword)
+	defer rpcd.Close()
+
+	h = service.NewBeenFarService(
+		service.WithAdminPassword(testPassword),
+		service.WithInformInterval(100*time.Millisecond),
+		service.WithDrivers(openwrt.NewDriver(openwrt.Config{Uplink: "lan1"})),
+	)
+	api := authorize(t, h)
+	createUser(t, api, "operator", model.RoleOperator)
+	operator := authorizeAs(t, h, "operator")
+
+	path := "/api/openwrt/" + mac + "/rpc"
+	target := &openwrt.RPCTarget{URL: rpcd.UbusURL(), Username: "root", Password: testPassword}
+	if response := send(t, operator, "PUT", path, target); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+	if response := send(t, api, "PUT", path, &openwrt.RPCTarget{URL: "ftp://router"}); response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, response.Code)
+	}
+	if response := send(t, api, "PUT", path, target); response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, response.Code, response.Body)
+	}
+	if body := send(t, api, "GET", path, nil).Body.String(); strings.Contains(body, testPassword) {
+		t.Errorf("Expected the password to be redacted, got %s", body)
+	}
+
+	// Routers are only pushed to once adopted
+	command := "/api/device/" + mac + "/command/"
+	if response := send(t, operator, "POST", command+"provision", nil); response.Code != http.StatusConflict {
+		t.Errorf("Expected status %d before adoption, got %d", http.StatusConflict, response.Code)
+	}
+	if response := send(t, api, "POST", "/api/device/adopt/"+mac, nil); response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	if response := send(t, api, "POST", "/api/wifi", &model.WifiNetworkConfig{Ssid: "home"}); response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, response.Code)
+	}
+
+	if response := send(t, operator, "POST", command+"provision", nil); response.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, response.Code, response.Body)
+	}
+	found := false
+	for _, section := range rpcd.Config("wireless") {
+		found = found || section.Values["ssid"] == "home"
+	}
+	if !found {
+		t.Errorf("Expected the wifi network to be pushed, got %+v", rpcd.Config("wireless"))
+	}
+	if execs := rpcd.Execs(); len(execs) != 1 || execs[0] != openwrt.ReloadCommand {
+		t.Errorf("Expected %s to be run once, got %v", openwrt.ReloadCommand, execs)
+	}
+
+	if response := send(t, operator, "POST", command+"refresh", nil); response.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, response.Code, response.Body)
+	}
+	body := send(t, api, "GET", "/api/device", nil).Body.String()
+	for _, expected := range []string{`"hostname":"OpenWrt"`, `"online":true`, `"name":"lan","up":true`} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected %s in the device, got %s", expected, body)
+		}
+	}
+
+	if response := send(t, operator, "POST", command+"reboot", nil); response.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status %d, got %d", http.StatusNotImplemented, response.Code)
+	}
+	rpcd.SetPassword("changed")
+	rpcd.ExpireSessions()
+	if response := send(t, operator, "POST", command+"refresh", nil); response.Code != http.StatusBadGateway {
+		t.Errorf("Expected status %d when login fails, got %d", http.StatusBadGateway, response.Code)
+	}
+	rpcd.SetPassword(testPassword)
+
+	// The worker pushes changes without a command
+	run(t, h, nil)
+	if response := send(t, api, "POST", "/api/wifi", &model.WifiNetworkConfig{Ssid: "guest", Guest: true}); response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, response.Code)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(rpcd.Execs()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if execs := rpcd.Execs(); len(execs) != 2 {
+		t.Errorf("Expected a second push, got %v", execs)
+	}
+
+	// Routers without a target and other drivers do not support commands
+	if response := send(t, api, "DELETE", path, nil); response.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, response.Code)
+	}
+	if response := send(t, operator, "POST", command+"provision", nil); response.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status %d without a target, got %d", http.StatusNotImplemented, response.Code)
+	}
+}
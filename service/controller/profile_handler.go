@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/model"
+	"github.com/jacobalberty/beenfar/service/profile"
+)
+
+type ProfileHandler struct {
+	options profile.Options
+}
+
+// Init registers the profile api, the router is expected to authenticate requests
+func (h *ProfileHandler) Init(router chi.Router, sites *model.Sites, options profile.Options) {
+	h.options = options
+
+	// Profiles include the security key
+	operator := router.With(SiteContext(sites), RequireRole(model.RoleOperator))
+	for _, prefix := range []string{"/api", SitePrefix} {
+		operator.Get(prefix+"/wifi/{id:^[[:xdigit:]]{24}$}/profile", h.GetWifiProfile)
+		operator.Get(prefix+"/wifi/ssid/{ssid}/profile", h.GetWifiProfile)
+	}
+}
+
+// Returns a profile joining a wifi network. The format query parameter selects an Apple
+// mobileconfig (the default), the qr payload as text, the qr code as a png or a passpoint subscription.
+func (h *ProfileHandler) GetWifiProfile(w http.ResponseWriter, r *http.Request) {
+	id, ok := wifiID(siteConfig(r), w, r)
+	if !ok {
+		return
+	}
+	network, err := siteConfig(r).GetWifiNetwork(id)
+	if err != nil {
+		writeWifiError(w, id, "", err)
+		return
+	}
+
+	var (
+		data        []byte
+		contentType string
+		extension   string
+	)
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "mobileconfig":
+		data, err = h.options.MobileConfig(network)
+		contentType, extension = profile.MobileConfigContentType, ".mobileconfig"
+	case "qr":
+		var payload string
+		payload, err = profile.QRPayload(network)
+		data, contentType = []byte(payload), "text/plain; charset=utf-8"
+	case "png":
+		data, err = profile.QRCode(network)
+		contentType = "image/png"
+	case "passpoint":
+		data, err = h.options.Passpoint(network)
+		contentType, extension = profile.PasspointContentType, ".xml"
+	default:
+		WriteError(w, http.StatusBadRequest, "Unknown Profile Format", "Profile format "+format+" is not one of mobileconfig, qr, png or passpoint")
+		return
+	}
+	switch {
+	case errors.Is(err, profile.ErrNotSupported):
+		WriteError(w, http.StatusUnprocessableEntity, "Profile Not Supported", err.Error())
+		return
+	case err != nil:
+		WriteError(w, http.StatusInternalServerError, "Error Generating Profile", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-store")
+	if extension != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": network.Ssid + extension}))
+	}
+	if _, err := w.Write(data); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+	}
+}
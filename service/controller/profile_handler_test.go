@@ -0,0 +1,99 @@
+package controller_test
+
+import (
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service"
+	"github.com/jacobalberty/beenfar/service/model"
+	"github.com/jacobalberty/beenfar/service/profile"
+)
+
+func TestWifiProfile(t *testing.T) {
+	t.Parallel()
+
+	h := service.NewBeenFarService(service.WithAdminPassword(testPassword), service.WithProfiles(profile.Options{Domain: "example.com"}))
+	api := authorize(t, h)
+
+	var home, corp model.WifiNetworkConfig
+	response := send(t, api, "POST", "/api/wifi", &model.WifiNetworkConfig{Ssid: "home", SecurityType: model.WifiSecurityTypeWpaPersonal, SecurityKey: "password1"})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, response.Code)
+	}
+	if err := jsonapi.UnmarshalPayload(response.Body, &home); err != nil {
+		t.Fatal(err)
+	}
+	response = send(t, api, "POST", "/api/wifi", &model.WifiNetworkConfig{Ssid: "corp", SecurityType: model.WifiSecurityTypeWpaEnterprise, RadiusProfile: 1})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, response.Code)
+	}
+	if err := jsonapi.UnmarshalPayload(response.Body, &corp); err != nil {
+		t.Fatal(err)
+	}
+
+	// Profiles include the security key so read-only users can not download them
+	createUser(t, api, "reader", model.RoleReadOnly)
+	reader := authorizeAs(t, h, "reader")
+	if response := send(t, reader, "GET", "/api/wifi/"+home.ID+"/profile", nil); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+
+	response = send(t, api, "GET", "/api/wifi/"+home.ID+"/profile", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	if ct := response.Header().Get("Content-Type"); ct != profile.MobileConfigContentType {
+		t.Errorf("Expected content type %s, got %s", profile.MobileConfigContentType, ct)
+	}
+	if cd := response.Header().Get("Content-Disposition"); cd != `attachment; filename=home.mobileconfig` {
+		t.Errorf("Expected the profile to be downloaded as home.mobileconfig, got %s", cd)
+	}
+	if body := response.Body.String(); !strings.Contains(body, "<string>password1</string>") || !strings.Contains(body, "<string>beenfar</string>") {
+		t.Errorf("Expected a profile with the key issued by beenfar, got %s", body)
+	}
+
+	response = send(t, api, "GET", "/api/wifi/ssid/home/profile?format=qr", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	if body := response.Body.String(); body != "WIFI:T:WPA;S:home;P:password1;;" {
+		t.Errorf("Expected the qr payload, got %s", body)
+	}
+
+	response = send(t, api, "GET", "/api/wifi/"+home.ID+"/profile?format=png", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	if ct := response.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Expected content type image/png, got %s", ct)
+	}
+	if _, err := png.Decode(response.Body); err != nil {
+		t.Errorf("Expected a png, got %v", err)
+	}
+
+	response = send(t, api, "GET", "/api/wifi/"+corp.ID+"/profile?format=passpoint", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	if body := response.Body.String(); !strings.Contains(body, "<Value>example.com</Value>") {
+		t.Errorf("Expected a passpoint subscription for example.com, got %s", body)
+	}
+
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{"/api/wifi/" + home.ID + "/profile?format=passpoint", http.StatusUnprocessableEntity},
+		{"/api/wifi/" + corp.ID + "/profile?format=qr", http.StatusUnprocessableEntity},
+		{"/api/wifi/" + home.ID + "/profile?format=pdf", http.StatusBadRequest},
+		{"/api/wifi/000000000000000000000000/profile", http.StatusNotFound},
+		{"/api/wifi/ssid/missing/profile", http.StatusNotFound},
+	} {
+		if response := send(t, api, "GET", tc.path, nil); response.Code != tc.code {
+			t.Errorf("%s: Expected status %d, got %d", tc.path, tc.code, response.Code)
+		}
+	}
+}
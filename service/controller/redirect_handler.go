@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type RedirectHandler struct {
+	port string
+}
+
+// Init redirects every request that is not handled by another route to HTTPS on port
+func (h *RedirectHandler) Init(router chi.Router, port string) {
+	h.port = port
+
+	router.NotFound(h.Redirect)
+	router.MethodNotAllowed(h.Redirect)
+}
+
+// Redirects to the same host and path over HTTPS
+func (h *RedirectHandler) Redirect(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	if h.port != "" && h.port != "443" {
+		host = net.JoinHostPort(host, h.port)
+	} else if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		host = "[" + host + "]"
+	}
+
+	target := "https://" + host + r.URL.RequestURI()
+	http.Redirect(w, r, target, http.StatusPermanentRedirect)
+}
@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service/event"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// SitePrefix is where the api of a site is served, the api of the default site is also served directly under /api
+const SitePrefix = "/api/site/{site:^[a-z0-9][a-z0-9-]*$}"
+
+// siteContext is what SiteContext and DeviceSite store in the request context
+type siteContext struct {
+	id string
+	// config is nil for requests that are only scoped to a site by their device
+	config *model.ConfigData
+}
+
+// SiteContext is middleware that scopes a request to the site in its path, or the default site for
+// paths without one. Unknown sites are not found and users limited to other sites are forbidden.
+// It must run after Authenticate.
+func SiteContext(sites *model.Sites) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := chi.URLParam(r, "site")
+			if id == "" {
+				id = model.DefaultSite
+			}
+			config, err := sites.ConfigData(id)
+			if err != nil {
+				WriteError(w, http.StatusNotFound, "Site Not Found", "Site "+id+" does not exist")
+				return
+			}
+			if user, ok := currentUser(r); !ok || !user.InSite(id) {
+				WriteError(w, http.StatusForbidden, "Forbidden", "User "+user.Username+" is not allowed to access site "+id)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), siteContextKey, siteContext{id: id, config: config})))
+		})
+	}
+}
+
+// DeviceSite is middleware that scopes requests for a device by the mac in their path to the site the device
+// is in, users limited to other sites are forbidden. Requests for unknown or pending devices are in no site.
+// It must run after Authenticate.
+func DeviceSite(devices *model.Devices) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			device, err := devices.Get(strings.ToLower(chi.URLParam(r, "mac")))
+			if err != nil || device.Site == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if user, ok := currentUser(r); !ok || !user.InSite(device.Site) {
+				WriteError(w, http.StatusForbidden, "Forbidden", "User "+user.Username+" is not allowed to access site "+device.Site)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), siteContextKey, siteContext{id: device.Site})))
+		})
+	}
+}
+
+// currentSite returns the ID of the site the request is scoped to, empty if it is in none
+func currentSite(r *http.Request) string {
+	site, _ := r.Context().Value(siteContextKey).(siteContext)
+	return site.id
+}
+
+// siteConfig returns the configuration of the site scoped by SiteContext
+func siteConfig(r *http.Request) *model.ConfigData {
+	site, _ := r.Context().Value(siteContextKey).(siteContext)
+	return site.config
+}
+
+// sitePath returns the path of an object of the site of the request, path is relative to the api of the site.
+// Requests without a site in their path get paths of the default site without one.
+func sitePath(r *http.Request, path string) string {
+	if site := chi.URLParam(r, "site"); site != "" {
+		return "/api/site/" + site + path
+	}
+	return "/api" + path
+}
+
+// Returns the sites the user can access sorted by ID
+func (h *HttpHandler) GetSiteList(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+
+	sites := h.sites.List()
+	siteList := make([]*model.Site, 0, len(sites))
+	for _, site := range sites {
+		site := site
+		if user.InSite(site.ID) {
+			siteList = append(siteList, &site)
+		}
+	}
+	writePayload(w, r, http.StatusOK, siteList)
+}
+
+// Returns a site by ID
+func (h *HttpHandler) GetSite(w http.ResponseWriter, r *http.Request) {
+	site, err := h.sites.Get(currentSite(r))
+	if err != nil {
+		writeSiteError(w, currentSite(r), err)
+		return
+	}
+	writePayload(w, r, http.StatusOK, &site)
+}
+
+// Creates a site with an empty configuration using model.Site, the ID is chosen by the client
+func (h *HttpHandler) PostSite(w http.ResponseWriter, r *http.Request) {
+	request := new(model.Site)
+	if err := jsonapi.UnmarshalPayload(r.Body, request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := request.Validate(); err != nil {
+		WriteValidationErrors(w, "Invalid Site", err)
+		return
+	}
+
+	site, err := h.sites.Add(*request)
+	if err != nil {
+		writeSiteError(w, request.ID, err)
+		return
+	}
+	h.objectChanged(r, "site", site.ID, "create", nil, site)
+
+	w.Header().Set("Location", "/api/site/"+site.ID)
+	writePayload(w, r, http.StatusCreated, &site)
+}
+
+// Renames a site, IDs can not be changed
+func (h *HttpHandler) PatchSite(w http.ResponseWriter, r *http.Request) {
+	id := currentSite(r)
+	current, err := h.sites.Get(id)
+	if err != nil {
+		writeSiteError(w, id, err)
+		return
+	}
+
+	site := current
+	if err := jsonapi.UnmarshalPayload(r.Body, &site); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if site.ID != id {
+		WriteError(w, http.StatusConflict, "Site ID Mismatch", "Site ID "+site.ID+" does not match "+id)
+		return
+	}
+	if err := site.Validate(); err != nil {
+		WriteValidationErrors(w, "Invalid Site", err)
+		return
+	}
+
+	if site, err = h.sites.Update(id, site); err != nil {
+		writeSiteError(w, id, err)
+		return
+	}
+	h.objectChanged(r, "site", id, "update", current, site)
+	writePayload(w, r, http.StatusOK, &site)
+}
+
+// Deletes a site along with its configuration and removes it from the sites of users. Devices have to be
+// moved out or forgotten first and users limited to only this site have to be limited to other sites.
+func (h *HttpHandler) DeleteSite(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "site")
+	site, err := h.sites.Delete(id, func() error {
+		if h.devices.InSite(id) {
+			return model.ErrSiteInUse
+		}
+		return h.users.RemoveSite(id)
+	})
+	if err != nil {
+		writeSiteError(w, id, err)
+		return
+	}
+	h.objectChanged(r, "site", id, "delete", site, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deviceSiteChange is recorded in the audit log when a device changes sites and published with the events
+// of adopted devices that are not looked up by subscribers, such as forgotten devices
+type deviceSiteChange struct {
+	Site string `json:"site" jsonapi:"attr,site"`
+}
+
+// Moves an adopted device of the site to the site in the path. The user needs to be an admin of both sites.
+func (h *HttpHandler) PostDeviceMove(w http.ResponseWriter, r *http.Request) {
+	mac, to := chi.URLParam(r, "mac"), chi.URLParam(r, "to")
+	if device, err := h.devices.Get(mac); err != nil || device.Site != currentSite(r) {
+		WriteError(w, http.StatusNotFound, "Device Not Found", "Device with MAC "+mac+" is not adopted in this site")
+		return
+	}
+	if user, _ := currentUser(r); !user.InSite(to) {
+		WriteError(w, http.StatusForbidden, "Forbidden", "User "+user.Username+" is not allowed to access site "+to)
+		return
+	}
+
+	var from string
+	err := h.sites.Use(func() (err error) {
+		from, err = h.devices.Move(mac, to)
+		return err
+	}, to)
+	switch {
+	case errors.Is(err, model.ErrSiteNotFound):
+		WriteError(w, http.StatusUnprocessableEntity, "Unknown Site", "Site "+to+" does not exist")
+		return
+	case err != nil:
+		WriteError(w, http.StatusNotFound, "Device Not Found", "Device with MAC "+mac+" is not adopted in this site")
+		return
+	}
+	AuditRequest(h.audit, r, "device.move", "device/"+mac, deviceSiteChange{Site: from}, deviceSiteChange{Site: to})
+	h.events.Publish(event.DeviceMoved, "device/"+mac, deviceSiteChange{Site: to})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeSiteError maps errors returned by model.Sites to responses
+func writeSiteError(w http.ResponseWriter, id string, err error) {
+	switch {
+	case errors.Is(err, model.ErrSiteNotFound):
+		WriteError(w, http.StatusNotFound, "Site Not Found", "Site "+id+" does not exist")
+	case errors.Is(err, model.ErrDuplicateSite):
+		WriteError(w, http.StatusConflict, "Site Already Exists", "Site "+id+" already exists")
+	case errors.Is(err, model.ErrDefaultSite):
+		WriteError(w, http.StatusConflict, "Default Site", err.Error())
+	case errors.Is(err, model.ErrSiteInUse):
+		WriteError(w, http.StatusConflict, "Site In Use", "Site "+id+" still has devices, move or forget them first")
+	case errors.Is(err, model.ErrSiteHasUsers):
+		WriteError(w, http.StatusConflict, "Site In Use", "Site "+id+" is the only site of some users, limit them to other sites first")
+	default:
+		WriteError(w, http.StatusInternalServerError, "Site Error", err.Error())
+	}
+}
@@ -0,0 +1,157 @@
+package controller_test
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service"
+	"github.com/jacobalberty/beenfar/service/adapter/openwrt"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+func TestSites(t *testing.T) {
+	const (
+		mac = "deadbeef0030"
+		key = "0123456789abcdef0123"
+	)
+	t.Parallel()
+
+	h := service.NewBeenFarService(
+		service.WithAdminPassword(testPassword),
+		service.WithDrivers(openwrt.NewDriver(openwrt.Config{})),
+	)
+	api := authorize(t, h)
+
+	for name, test := range map[string]struct {
+		site   *model.Site
+		status int
+	}{
+		"invalid id":   {&model.Site{ID: "Office", Name: "Office"}, http.StatusUnprocessableEntity},
+		"default site": {&model.Site{ID: model.DefaultSite, Name: "Default"}, http.StatusConflict},
+	} {
+		if response := send(t, api, "POST", "/api/site", test.site); response.Code != test.status {
+			t.Errorf("Expected status %d for %s, got %d: %s", test.status, name, response.Code, response.Body)
+		}
+	}
+	response := send(t, api, "POST", "/api/site", &model.Site{ID: "office", Name: "Office"})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+	}
+	if response.Header().Get("Location") != "/api/site/office" {
+		t.Errorf("Expected the location of the site, got %q", response.Header().Get("Location"))
+	}
+	if response := send(t, api, "GET", "/api/site/branch/network", nil); response.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown site, got %d", http.StatusNotFound, response.Code)
+	}
+
+	// Sites have their own configuration, the api without a site prefix is the default site
+	response = send(t, api, "POST", "/api/site/office/network", &model.NetworkConfig{Name: "lan", GatewayIPSubnet: "192.168.1.1/24"})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+	}
+	if location := response.Header().Get("Location"); !strings.HasPrefix(location, "/api/site/office/network/") {
+		t.Errorf("Expected the location of the network in the site, got %q", location)
+	}
+	response = send(t, api, "GET", "/api/network", nil)
+	networks, err := jsonapi.UnmarshalManyPayload(response.Body, reflect.TypeOf(new(model.NetworkConfig)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 0 {
+		t.Errorf("Expected no networks in the default site, got %v", networks)
+	}
+
+	// Users can be limited to sites, they only have their role there
+	if response := send(t, api, "POST", "/api/user", &model.User{Username: "x", Password: testPassword, Sites: []string{"branch"}}); response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d for an unknown site, got %d", http.StatusUnprocessableEntity, response.Code)
+	}
+	response = send(t, api, "POST", "/api/user", &model.User{Username: "office", Password: testPassword, Role: model.RoleAdmin, Sites: []string{"office"}})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+	}
+	var officeUser model.User
+	if err := jsonapi.UnmarshalPayload(response.Body, &officeUser); err != nil {
+		t.Fatal(err)
+	}
+	office := authorizeAs(t, h, "office")
+	for _, test := range []struct {
+		method, path string
+		payload      interface{}
+		status       int
+	}{
+		{"GET", "/api/network", nil, http.StatusForbidden},
+		{"POST", "/api/site/office/wifi", &model.WifiNetworkConfig{Ssid: "office"}, http.StatusCreated},
+		{"POST", "/api/site", &model.Site{ID: "branch", Name: "Branch"}, http.StatusForbidden},
+		{"GET", "/api/user", nil, http.StatusForbidden},
+		{"GET", "/api/audit", nil, http.StatusForbidden},
+	} {
+		if response := send(t, office, test.method, test.path, test.payload); response.Code != test.status {
+			t.Errorf("Expected status %d for %s %s, got %d: %s", test.status, test.method, test.path, response.Code, response.Body)
+		}
+	}
+	response = send(t, office, "GET", "/api/site", nil)
+	sites, err := jsonapi.UnmarshalManyPayload(response.Body, reflect.TypeOf(new(model.Site)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sites) != 1 || sites[0].(*model.Site).ID != "office" {
+		t.Errorf("Expected only the office site, got %v", sites)
+	}
+
+	// Pending devices are adopted into a site and rendered with its configuration
+	req, err := http.NewRequest("GET", "/openwrt/"+mac+"/config", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	executeRequest(h.Handler(service.ListenerAPI), req)
+	if response := send(t, office, "POST", "/api/site/office/device/adopt/"+mac, nil); response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, response.Code, response.Body)
+	}
+	if body := send(t, api, "GET", "/api/device", nil).Body.String(); strings.Contains(body, mac) {
+		t.Errorf("Expected the device to be adopted outside the default site, got %s", body)
+	}
+	if body := send(t, office, "GET", "/api/site/office/device", nil).Body.String(); !strings.Contains(body, `"site":"office"`) {
+		t.Errorf("Expected the device in the office site, got %s", body)
+	}
+	if body := send(t, office, "GET", "/api/device/"+mac+"/config", nil).Body.String(); !strings.Contains(body, "option ssid 'office'") {
+		t.Errorf("Expected the configuration of the office site, got %s", body)
+	}
+
+	if response := send(t, api, "DELETE", "/api/site/office", nil); response.Code != http.StatusConflict {
+		t.Errorf("Expected status %d deleting a site with devices, got %d", http.StatusConflict, response.Code)
+	}
+	if response := send(t, office, "POST", "/api/site/office/device/"+mac+"/move/default", nil); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d moving a device out of the sites of the user, got %d", http.StatusForbidden, response.Code)
+	}
+	if response := send(t, api, "POST", "/api/site/office/device/"+mac+"/move/default", nil); response.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, response.Code, response.Body)
+	}
+	if response := send(t, office, "GET", "/api/device/"+mac+"/config", nil); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d for a device of another site, got %d", http.StatusForbidden, response.Code)
+	}
+
+	// Sites that are the only site of a user are kept, other users lose the deleted site
+	if response := send(t, api, "DELETE", "/api/site/office", nil); response.Code != http.StatusConflict {
+		t.Errorf("Expected status %d deleting the only site of a user, got %d", http.StatusConflict, response.Code)
+	}
+	if response := send(t, api, "POST", "/api/site", &model.Site{ID: "branch", Name: "Branch"}); response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+	}
+	officeUser.Sites = []string{"office", "branch"}
+	if response := send(t, api, "PATCH", "/api/user/"+officeUser.ID, &officeUser); response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, response.Code, response.Body)
+	}
+	if response := send(t, api, "DELETE", "/api/site/office", nil); response.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusNoContent, response.Code, response.Body)
+	}
+	if response := send(t, api, "POST", "/api/site", &model.Site{ID: "office", Name: "Office"}); response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+	}
+	if response := send(t, office, "GET", "/api/site/office/network", nil); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d for a site created again with the ID of a deleted site, got %d", http.StatusForbidden, response.Code)
+	}
+}
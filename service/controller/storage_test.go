@@ -0,0 +1,66 @@
+package controller_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+func TestStorage(t *testing.T) {
+	var h *service.BeenFarService
+	t.Parallel()
+
+	dir := t.TempDir()
+	h = service.NewBeenFarService(service.WithDataDir(dir))
+	generated, err := os.ReadFile(filepath.Join(dir, "admin.password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	password := strings.TrimSpace(string(generated))
+	response := login(t, h, service.BootstrapAdmin, password)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d logging in, got %d", http.StatusOK, response.Code)
+	}
+	cookie := sessionCookie(t, response)
+	admin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.AddCookie(cookie)
+		h.ServeHTTP(w, r)
+	})
+
+	createUser(t, admin, "operator", model.RoleOperator)
+	response = send(t, admin, "POST", "/api/wifi", &model.WifiNetworkConfig{
+		Ssid:         "kept",
+		SecurityType: model.WifiSecurityTypeWpaPersonal,
+		SecurityKey:  "supersecret",
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, response.Code)
+	}
+
+	// Stopping the service saves the state
+	if err := run(t, h, nil)(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The admin exists after a restart, so neither its password nor admin.password change
+	h = service.NewBeenFarService(service.WithDataDir(dir), service.WithAdminPassword("ignored"))
+	if kept, err := os.ReadFile(filepath.Join(dir, "admin.password")); err != nil || string(kept) != string(generated) {
+		t.Errorf("Expected admin.password to be kept, got %q: %v", kept, err)
+	}
+	if response = login(t, h, service.BootstrapAdmin, "ignored"); response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, response.Code)
+	}
+	if response = login(t, h, service.BootstrapAdmin, password); response.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+
+	operator := authorizeAs(t, h, "operator")
+	if body := send(t, operator, "GET", "/api/wifi", nil).Body.String(); !strings.Contains(body, `"kept"`) {
+		t.Errorf("Expected the wifi network to be kept, got %s", body)
+	}
+}
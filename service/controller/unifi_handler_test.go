@@ -2,9 +2,15 @@ package controller_test
 
 import (
 	"bytes"
+	"encoding/hex"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/jsonapi"
 	"github.com/jacobalberty/beenfar/service"
@@ -26,15 +32,16 @@ func TestUnifiPending(t *testing.T) {
 
 	t.Parallel()
 
-	h = service.NewBeenFarService()
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
 	h.Init()
+	api := authorize(t, h)
 
 	// Check to make sure no devices are pending or adopted
 	if req, err = http.NewRequest("GET", "/api/device", nil); err != nil {
 		t.Fatal(err)
 	}
 
-	response = executeRequest(h, req)
+	response = executeRequest(api, req)
 	if response.Code != http.StatusOK {
 		t.Errorf("Expected status code %v, got %v", http.StatusOK, response.Code)
 	}
@@ -74,7 +81,7 @@ func TestUnifiPending(t *testing.T) {
 		t.Error(err)
 	}
 
-	response = executeRequest(h, req)
+	response = executeRequest(h.Handler(service.ListenerInform), req)
 
 	// Not adopted yet so we get a 404
 	if response.Code != http.StatusNotFound {
@@ -86,11 +93,16 @@ func TestUnifiPending(t *testing.T) {
 		t.Error(err)
 	}
 
-	response = executeRequest(h, req)
+	response = executeRequest(api, req)
 	if response.Code != http.StatusOK {
 		t.Errorf("Expected status code %v, got %v", http.StatusOK, response.Code)
 	}
 
+	// Devices have no jsonapi tags so the driver is not unmarshalled
+	if body := response.Body.String(); !strings.Contains(body, `"driver":"`+unifi.DriverName+`"`) {
+		t.Errorf("Expected the device to be managed by %s, got %s", unifi.DriverName, body)
+	}
+
 	if err = jsonapi.UnmarshalPayload(response.Body, &devices); err != nil {
 		t.Error(err)
 	}
@@ -108,7 +120,7 @@ func TestUnifiPending(t *testing.T) {
 		t.Error(err)
 	}
 
-	response = executeRequest(h, req)
+	response = executeRequest(api, req)
 	if response.Code != http.StatusOK {
 		t.Errorf("Expected status code %v, got %v", http.StatusOK, response.Code)
 	}
@@ -118,7 +130,7 @@ func TestUnifiPending(t *testing.T) {
 		t.Error(err)
 	}
 
-	response = executeRequest(h, req)
+	response = executeRequest(api, req)
 	if response.Code != http.StatusOK {
 		t.Errorf("Expected status code %v, got %v", http.StatusOK, response.Code)
 	}
@@ -140,7 +152,7 @@ func TestUnifiPending(t *testing.T) {
 		t.Error(err)
 	}
 
-	response = executeRequest(h, req)
+	response = executeRequest(api, req)
 	if response.Code != http.StatusNoContent {
 		t.Errorf("Expected status code %v, got %v", http.StatusOK, response.Code)
 	}
@@ -150,7 +162,7 @@ func TestUnifiPending(t *testing.T) {
 		t.Error(err)
 	}
 
-	response = executeRequest(h, req)
+	response = executeRequest(api, req)
 	if response.Code != http.StatusOK {
 		t.Errorf("Expected status code %v, got %v", http.StatusOK, response.Code)
 	}
@@ -167,3 +179,217 @@ func TestUnifiPending(t *testing.T) {
 		t.Errorf("Expected 0 adopted devices, got %v", len(devices.Adopted))
 	}
 }
+
+func TestUnifiHeartbeat(t *testing.T) {
+	var (
+		h   *service.BeenFarService
+		hb  unifi.InformHeartbeatResponse
+		mac = "deadbeef0002"
+		dir = t.TempDir()
+	)
+	t.Parallel()
+
+	h = service.NewBeenFarService(
+		service.WithAdminPassword(testPassword),
+		service.WithDataDir(dir),
+		service.WithInformInterval(30*time.Second),
+	)
+	api := authorize(t, h)
+
+	// The generated key is kept in the data directory
+	key, err := os.ReadFile(filepath.Join(dir, "unifi.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.NewBeenFarService(service.WithAdminPassword(testPassword), service.WithDataDir(dir))
+	if key2, err := os.ReadFile(filepath.Join(dir, "unifi.key")); err != nil || !bytes.Equal(key, key2) {
+		t.Errorf("Expected the key to be reused, got %v", err)
+	}
+
+	inform := func(packet []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		req, err := http.NewRequest("POST", "/inform", bytes.NewBuffer(packet))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return executeRequest(h.Handler(service.ListenerInform), req)
+	}
+
+	inform(informPacket(t, mac))
+	if response := send(t, api, "POST", "/api/device/adopt/"+mac, nil); response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+
+	// Once adopted the default key and plain informs are rejected
+	plain := unifi.InformBuilder{}
+	plain.Init(unifi.InformPD{Magic: 1414414933, Version: 1, Mac: mac})
+	unencrypted, err := plain.BuildResponse(map[string]string{"mac": mac})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, packet := range [][]byte{informPacket(t, mac), unencrypted} {
+		if response := inform(packet); response.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, response.Code)
+		}
+	}
+
+	// Access points are provisioned with their wifi networks, encrypted with the controller key
+	controllerKey, err := hex.DecodeString(strings.TrimSpace(string(key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var setparam unifi.InformConfigUpdateResponse
+	decodeInformResponse(t, inform(informKeyPacket(t, mac, controllerKey, map[string]string{"mac": mac})), controllerKey, &setparam)
+	if config := send(t, api, "GET", "/api/device/"+mac+"/config", nil).Body.String(); setparam.Type != "setparam" || setparam.SystemConfig != config {
+		t.Errorf("Expected the access point to be provisioned with %q, got %+v", config, setparam)
+	}
+
+	// Once they report the version they are told when to check in next
+	decodeInformResponse(t, inform(informKeyPacket(t, mac, controllerKey, map[string]string{"mac": mac, "cfgversion": setparam.ConfigVersion})), controllerKey, &hb)
+	if hb.Type != "noop" || hb.Interval != 30 {
+		t.Errorf("Expected a noop heartbeat with interval 30, got %+v", hb)
+	}
+}
+
+// decodeInformResponse decodes the response to an inform encrypted with key into v
+func decodeInformResponse(t *testing.T, response *httptest.ResponseRecorder, key []byte, v any) {
+	t.Helper()
+
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	ib, err := unifi.NewInformBuilder(response.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ib.Key = key
+	payload, err := ib.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnifiGateway(t *testing.T) {
+	const mac = "deadbeef0003"
+	t.Parallel()
+
+	h := service.NewBeenFarService(service.WithAdminPassword(testPassword), service.WithUnifiKey(testUnifiKey))
+	api := authorize(t, h)
+
+	response := send(t, api, "POST", "/api/network", &model.NetworkConfig{
+		Name:            "lan",
+		GatewayIPSubnet: "192.168.1.1/24",
+		DHCPConfig: model.DHCPConfig{
+			DHCPMode:       model.DHCPModeServer,
+			DHCPStart:      "192.168.1.100",
+			DHCPStop:       "192.168.1.200",
+			DHCPNameServer: model.DHCPNameServer{Auto: true},
+			DHCPGateway:    model.DHCPGateway{Auto: true},
+		},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body)
+	}
+
+	inform := func(key []byte, payload any) *httptest.ResponseRecorder {
+		t.Helper()
+		req, err := http.NewRequest("POST", "/inform", bytes.NewBuffer(informKeyPacket(t, mac, key, payload)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return executeRequest(h.Handler(service.ListenerInform), req)
+	}
+	decode := func(response *httptest.ResponseRecorder) (setparam unifi.InformConfigUpdateResponse) {
+		t.Helper()
+		decodeInformResponse(t, response, testUnifiKey, &setparam)
+		return setparam
+	}
+
+	inform(nil, map[string]any{"mac": mac, "model": "UGW3"})
+	if response := send(t, api, "POST", "/api/device/adopt/"+mac, nil); response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	stats := map[string]any{
+		"mac":     mac,
+		"model":   "UGW3",
+		"version": "4.4.57.5578372",
+		"uptime":  3600,
+		"wan1":    map[string]any{"ifname": "eth0", "ip": "203.0.113.7", "up": true, "rx_bytes": 1000, "tx_bytes": 500, "rx_bytes-r": 250, "tx_bytes-r": 125},
+	}
+	setparam := decode(inform(testUnifiKey, stats))
+
+	// Devices have no jsonapi tags so the info and stats are checked in the body
+	body := send(t, api, "GET", "/api/device", nil).Body.String()
+	for _, expected := range []string{
+		`"model":"UGW3"`,
+		`"name":"wan1","up":true`,
+		`"address":"203.0.113.7","rx_bytes":1000,"tx_bytes":500,"rx_rate":250,"tx_rate":125`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected %s in the devices, got %s", expected, body)
+		}
+	}
+
+	// Gateways get the networks as an EdgeOS tree with the WAN and NAT
+	response = send(t, api, "GET", "/api/device/"+mac+"/config", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	var cfg struct {
+		Interfaces struct {
+			Ethernet map[string]struct {
+				Address any `json:"address"`
+			} `json:"ethernet"`
+		} `json:"interfaces"`
+		Service struct {
+			Nat struct {
+				Rule map[string]struct {
+					Type string `json:"type"`
+				} `json:"rule"`
+			} `json:"nat"`
+		} `json:"service"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &cfg); err != nil {
+		t.Fatalf("Expected a json system_cfg, got %v: %s", err, response.Body)
+	}
+	if cfg.Interfaces.Ethernet["eth0"].Address != "dhcp" || cfg.Interfaces.Ethernet["eth1"].Address != "192.168.1.1/24" {
+		t.Errorf("Expected the WAN on eth0 and the LAN on eth1, got %s", response.Body)
+	}
+	if cfg.Service.Nat.Rule["6001"].Type != "masquerade" {
+		t.Errorf("Expected the LAN to be masqueraded, got %s", response.Body)
+	}
+
+	// Gateways are provisioned with the configuration until they report its version
+	if setparam.Type != "setparam" || setparam.SystemConfig != response.Body.String() || setparam.ConfigVersion == "" {
+		t.Errorf("Expected the gateway to be provisioned, got %+v", setparam)
+	}
+	if !strings.Contains(setparam.ManagementConfig, "cfgversion="+setparam.ConfigVersion+"\n") || !strings.Contains(setparam.ManagementConfig, "authkey=") {
+		t.Errorf("Expected the version and key in mgmt_cfg, got %q", setparam.ManagementConfig)
+	}
+	stats["cfgversion"] = setparam.ConfigVersion
+	if heartbeat := decode(inform(testUnifiKey, stats)); heartbeat.Type != "noop" {
+		t.Errorf("Expected a heartbeat once the gateway is provisioned, got %+v", heartbeat)
+	}
+
+	// Whether a device is a gateway is decided by the model it reported before adoption
+	const ap = "deadbeef0013"
+	apInform := func(key []byte, payload any) *httptest.ResponseRecorder {
+		t.Helper()
+		req, err := http.NewRequest("POST", "/inform", bytes.NewBuffer(informKeyPacket(t, ap, key, payload)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return executeRequest(h.Handler(service.ListenerInform), req)
+	}
+	apInform(nil, map[string]any{"mac": ap, "model": "U7PG2"})
+	if response := send(t, api, "POST", "/api/device/adopt/"+ap, nil); response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	apConfig := send(t, api, "GET", "/api/device/"+ap+"/config", nil).Body.String()
+	if setparam := decode(apInform(testUnifiKey, map[string]any{"mac": ap, "model": "UGW3"})); setparam.SystemConfig != apConfig {
+		t.Errorf("Expected an access point claiming to be a gateway to get %q, got %+v", apConfig, setparam)
+	}
+}
@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// Returns the currently authenticated user
+func (h *AuthHandler) GetSession(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &user); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Returns a list of all users
+func (h *AuthHandler) GetUserList(w http.ResponseWriter, r *http.Request) {
+	users := h.users.List()
+	userList := make([]*model.User, 0, len(users))
+	for _, user := range users {
+		user := user
+		userList = append(userList, &user)
+	}
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayload(w, userList); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Creates a new user
+func (h *AuthHandler) PostUser(w http.ResponseWriter, r *http.Request) {
+	request := new(model.User)
+	if err := jsonapi.UnmarshalPayload(r.Body, request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var verrs model.ValidationErrors
+	if request.Username == "" {
+		verrs.Add("username", "username must not be empty")
+	}
+	if request.Password == "" {
+		verrs.Add("password", "password must not be empty")
+	}
+	h.validateSites(&verrs, request.Sites)
+	if err := verrs.Err(); err != nil {
+		WriteValidationErrors(w, "Invalid User", err)
+		return
+	}
+
+	hash, err := model.HashPassword(request.Password)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	// The user is limited to its sites from the start, they can not be deleted until it is added
+	var user model.User
+	err = h.sites.Use(func() (err error) {
+		user, err = h.users.Add(request.Username, hash, request.Role, request.Sites)
+		return err
+	}, request.Sites...)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	AuditRequest(h.audit, r, "user.create", "user/"+user.ID, nil, user)
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	w.WriteHeader(http.StatusCreated)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &user); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+	}
+}
+
+// Updates the role, sites or password of a user, every change is validated before any is applied.
+// Users may change their own password given their current one, everything else requires an admin of every site.
+func (h *AuthHandler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	current, _ := currentUser(r)
+
+	id := chi.URLParam(r, "id")
+	user, err := h.users.Get(id)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	request := user
+	if err := jsonapi.UnmarshalPayload(r.Body, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sitesChanged := !equalSites(request.Sites, user.Sites)
+	isAdmin := hasRole(r, model.RoleAdmin)
+	if !isAdmin && (current.ID != id || request.Role != user.Role || sitesChanged) {
+		WriteError(w, http.StatusForbidden, "Forbidden", "User "+current.Username+" is not allowed to perform this operation")
+		return
+	}
+	if request.Username != user.Username {
+		WriteError(w, http.StatusForbidden, "Forbidden", "Usernames can not be changed")
+		return
+	}
+
+	self := current.ID == id
+	var verrs model.ValidationErrors
+	if sitesChanged {
+		h.validateSites(&verrs, request.Sites)
+	}
+	if request.Password != "" && self && request.CurrentPassword == "" {
+		verrs.Add("current_password", "current_password is required to change your own password")
+	}
+	if err := verrs.Err(); err != nil {
+		WriteValidationErrors(w, "Invalid User", err)
+		return
+	}
+
+	var hash []byte
+	if request.Password != "" {
+		// A session or token left open is not enough to take over an account
+		if self {
+			if _, err := h.users.Authenticate(user.Username, request.CurrentPassword); err != nil {
+				WriteError(w, http.StatusForbidden, "Forbidden", "The current password is incorrect")
+				return
+			}
+		}
+		if hash, err = model.HashPassword(request.Password); err != nil {
+			writeUserError(w, err)
+			return
+		}
+	}
+
+	// The sites are locked so none of them is deleted before the user is limited to it
+	before := user
+	err = h.sites.Use(func() (err error) {
+		user, err = h.users.Update(id, request.Role, request.Sites, hash)
+		return err
+	}, request.Sites...)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	// The password is only set on the audited copy so the change is recorded, redacted
+	after := user
+	after.Password = request.Password
+	AuditRequest(h.audit, r, "user.update", "user/"+id, before, after)
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &user); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Deletes a user
+func (h *AuthHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	user, err := h.users.Get(id)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	if err := h.users.Delete(id); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	AuditRequest(h.audit, r, "user.delete", "user/"+id, user, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateSites adds an error for every site a user is limited to that does not exist
+func (h *AuthHandler) validateSites(verrs *model.ValidationErrors, sites []string) {
+	for _, site := range sites {
+		if _, err := h.sites.Get(site); err != nil {
+			verrs.Add("sites", "site %q does not exist", site)
+		}
+	}
+}
+
+// equalSites checks if two lists of site IDs are the same
+func equalSites(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeUserError maps errors returned by model.Users to responses
+func writeUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, model.ErrUserNotFound):
+		WriteError(w, http.StatusNotFound, "User Not Found", err.Error())
+	case errors.Is(err, model.ErrUserExists), errors.Is(err, model.ErrLastAdmin):
+		WriteError(w, http.StatusConflict, "User Conflict", err.Error())
+	case errors.Is(err, model.ErrInvalidRole):
+		WriteValidationErrors(w, "Invalid User", model.ValidationErrors{{Field: "role", Detail: err.Error()}})
+	case errors.Is(err, model.ErrSiteNotFound):
+		WriteValidationErrors(w, "Invalid User", model.ValidationErrors{{Field: "sites", Detail: err.Error()}})
+	default:
+		WriteError(w, http.StatusInternalServerError, "User Error", err.Error())
+	}
+}
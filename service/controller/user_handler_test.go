@@ -0,0 +1,274 @@
+package controller_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+func TestRoles(t *testing.T) {
+	var (
+		h        *service.BeenFarService
+		testWifi model.WifiNetworkConfig
+	)
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
+	admin := authorize(t, h)
+
+	createUser(t, admin, "operator", model.RoleOperator)
+	createUser(t, admin, "readonly", model.RoleReadOnly)
+	operator := authorizeAs(t, h, "operator")
+	readonly := authorizeAs(t, h, "readonly")
+
+	// Admins may set security keys
+	response := send(t, admin, "POST", "/api/wifi", &model.WifiNetworkConfig{
+		Ssid:         "secured",
+		SecurityType: model.WifiSecurityTypeWpaPersonal,
+		SecurityKey:  "password",
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, response.Code)
+	}
+	if err := jsonapi.UnmarshalPayload(response.Body, &testWifi); err != nil {
+		t.Fatal(err)
+	}
+	id := testWifi.ID
+
+	// Operators may manage networks but not keys
+	if response = send(t, operator, "POST", "/api/wifi", &model.WifiNetworkConfig{Ssid: "open"}); response.Code != http.StatusCreated {
+		t.Errorf("Expected status %d, got %d", http.StatusCreated, response.Code)
+	}
+	response = send(t, operator, "POST", "/api/wifi", &model.WifiNetworkConfig{
+		Ssid:         "operator",
+		SecurityType: model.WifiSecurityTypeWpaPersonal,
+		SecurityKey:  "password",
+	})
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+
+	patch := `{"data":{"type":"wifi","id":"` + id + `","attributes":{"hidden":true}}}`
+	if response = sendRaw(t, operator, "PATCH", "/api/wifi/"+id, patch); response.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	patch = `{"data":{"type":"wifi","id":"` + id + `","attributes":{"security_key":"newpassword"}}}`
+	if response = sendRaw(t, operator, "PATCH", "/api/wifi/"+id, patch); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+	if response = sendRaw(t, admin, "PATCH", "/api/wifi/"+id, patch); response.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+
+	// Only admins may adopt or forget devices
+	for _, api := range []http.Handler{operator, readonly} {
+		if response = send(t, api, "POST", "/api/device/adopt/deadbeef0000", nil); response.Code != http.StatusForbidden {
+			t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+		}
+		if response = send(t, api, "DELETE", "/api/device/deadbeef0000", nil); response.Code != http.StatusForbidden {
+			t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+		}
+	}
+
+	// Read only users can read, without security keys
+	if response = send(t, readonly, "GET", "/api/device", nil); response.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	if response = send(t, readonly, "POST", "/api/wifi", &model.WifiNetworkConfig{Ssid: "readonly"}); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+	if response = send(t, readonly, "DELETE", "/api/wifi/"+id, nil); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+
+	for _, network := range wifiList(t, readonly) {
+		if network.SecurityKey != "" {
+			t.Errorf("Expected security key of %s to be redacted, got %s", network.Ssid, network.SecurityKey)
+		}
+	}
+	testWifi = model.WifiNetworkConfig{}
+	response = send(t, readonly, "GET", "/api/wifi/"+id, nil)
+	if err := jsonapi.UnmarshalPayload(response.Body, &testWifi); err != nil {
+		t.Fatal(err)
+	}
+	if testWifi.SecurityKey != "" {
+		t.Errorf("Expected security key to be redacted, got %s", testWifi.SecurityKey)
+	}
+
+	testWifi = model.WifiNetworkConfig{}
+	response = send(t, operator, "GET", "/api/wifi/"+id, nil)
+	if err := jsonapi.UnmarshalPayload(response.Body, &testWifi); err != nil {
+		t.Fatal(err)
+	}
+	if testWifi.SecurityKey != "newpassword" {
+		t.Errorf("Expected SecurityKey %s, got %s", "newpassword", testWifi.SecurityKey)
+	}
+
+	// Only admins manage users
+	if response = send(t, operator, "GET", "/api/user", nil); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+	if response = send(t, operator, "POST", "/api/user", &model.User{Username: "other", Password: testPassword}); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+}
+
+func TestUserManagement(t *testing.T) {
+	var (
+		h    *service.BeenFarService
+		user model.User
+	)
+	t.Parallel()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
+	admin := authorize(t, h)
+
+	id := createUser(t, admin, "readonly", model.RoleReadOnly)
+	readonly := authorizeAs(t, h, "readonly")
+
+	response := send(t, readonly, "GET", "/api/session", nil)
+	if err := jsonapi.UnmarshalPayload(response.Body, &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != id || user.Role != model.RoleReadOnly {
+		t.Errorf("Expected session of %s as read only, got %+v", id, user)
+	}
+
+	// Users can not raise their own role, a forbidden change does not change the password either
+	patch := `{"data":{"type":"user","id":"` + id + `","attributes":{"role":2,"password":"changed","current_password":"` + testPassword + `"}}}`
+	if response = sendRaw(t, readonly, "PATCH", "/api/user/"+id, patch); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+	if response = login(t, h, "readonly", testPassword); response.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+
+	// Users changing their own password have to give their current password
+	patch = `{"data":{"type":"user","id":"` + id + `","attributes":{"password":"changed"}}}`
+	if response = sendRaw(t, readonly, "PATCH", "/api/user/"+id, patch); response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, response.Code)
+	}
+	patch = `{"data":{"type":"user","id":"` + id + `","attributes":{"password":"changed","current_password":"wrong"}}}`
+	if response = sendRaw(t, readonly, "PATCH", "/api/user/"+id, patch); response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+
+	// A new password ends the sessions and revokes the api tokens of the user
+	response = send(t, readonly, "POST", "/api/token", &model.APIToken{Name: "automation"})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, response.Code)
+	}
+	var token model.APIToken
+	if err := jsonapi.UnmarshalPayload(response.Body, &token); err != nil {
+		t.Fatal(err)
+	}
+	patch = `{"data":{"type":"user","id":"` + id + `","attributes":{"password":"changed","current_password":"` + testPassword + `"}}}`
+	if response = sendRaw(t, readonly, "PATCH", "/api/user/"+id, patch); response.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	if response = send(t, readonly, "GET", "/api/session", nil); response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d with the old session, got %d", http.StatusUnauthorized, response.Code)
+	}
+	req, err := http.NewRequest("GET", "/api/session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	if response = executeRequest(h, req); response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d with the old token, got %d", http.StatusUnauthorized, response.Code)
+	}
+	if response = login(t, h, "readonly", "changed"); response.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+
+	// Admins set the passwords of other users without knowing them
+	patch = `{"data":{"type":"user","id":"` + id + `","attributes":{"password":"` + testPassword + `"}}}`
+	if response = sendRaw(t, admin, "PATCH", "/api/user/"+id, patch); response.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	readonly = authorizeAs(t, h, "readonly")
+
+	// Role changes apply to existing sessions
+	patch = `{"data":{"type":"user","id":"` + id + `","attributes":{"role":1}}}`
+	if response = sendRaw(t, admin, "PATCH", "/api/user/"+id, patch); response.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	if response = send(t, readonly, "POST", "/api/wifi", &model.WifiNetworkConfig{Ssid: "promoted"}); response.Code != http.StatusCreated {
+		t.Errorf("Expected status %d, got %d", http.StatusCreated, response.Code)
+	}
+
+	// Invalid roles are rejected along with the rest of the changes
+	patch = `{"data":{"type":"user","id":"` + id + `","attributes":{"role":7,"password":"changed"}}}`
+	if response = sendRaw(t, admin, "PATCH", "/api/user/"+id, patch); response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, response.Code)
+	}
+	if response = login(t, h, "readonly", testPassword); response.Code != http.StatusOK {
+		t.Errorf("Expected the password to be unchanged, got status %d", response.Code)
+	}
+
+	// The last admin can not be demoted
+	response = send(t, admin, "GET", "/api/session", nil)
+	if err := jsonapi.UnmarshalPayload(response.Body, &user); err != nil {
+		t.Fatal(err)
+	}
+	patch = `{"data":{"type":"user","id":"` + user.ID + `","attributes":{"role":0}}}`
+	if response = sendRaw(t, admin, "PATCH", "/api/user/"+user.ID, patch); response.Code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, response.Code)
+	}
+	if response = send(t, admin, "DELETE", "/api/user/"+user.ID, nil); response.Code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, response.Code)
+	}
+
+	// Deleting a user ends their sessions
+	if response = send(t, admin, "DELETE", "/api/user/"+id, nil); response.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, response.Code)
+	}
+	if response = send(t, readonly, "GET", "/api/device", nil); response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, response.Code)
+	}
+}
+
+// createUser creates a user with testPassword and returns its ID
+func createUser(t *testing.T, h http.Handler, username string, role model.Role) string {
+	var user model.User
+	t.Helper()
+
+	response := send(t, h, "POST", "/api/user", &model.User{Username: username, Password: testPassword, Role: role})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d creating user, got %d", http.StatusCreated, response.Code)
+	}
+	if err := jsonapi.UnmarshalPayload(response.Body, &user); err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+// send marshals payload as a jsonapi document, a nil payload sends no body
+func send(t *testing.T, h http.Handler, method, path string, payload interface{}) *httptest.ResponseRecorder {
+	var bTmp bytes.Buffer
+	t.Helper()
+
+	if payload != nil {
+		if err := jsonapi.MarshalPayload(&bTmp, payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sendRaw(t, h, method, path, bTmp.String())
+}
+
+// sendRaw sends body as is from testRemoteAddr
+func sendRaw(t *testing.T, h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, err := http.NewRequest(method, path, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = testRemoteAddr
+	return executeRequest(h, req)
+}
@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// Returns all webhooks, secrets are not included
+func (h *HttpHandler) GetWebhookList(w http.ResponseWriter, r *http.Request) {
+	hooks := h.webhooks.List()
+	hookList := make([]*model.Webhook, 0, len(hooks))
+	for _, hook := range hooks {
+		hook := hook
+		hook.Secret = ""
+		hookList = append(hookList, &hook)
+	}
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayload(w, hookList); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Returns a webhook by ID, the secret is not included
+func (h *HttpHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	hook, err := h.webhooks.Get(id)
+	if err != nil {
+		writeWebhookError(w, id, err)
+		return
+	}
+
+	hook.Secret = ""
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &hook); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Creates a webhook, a secret is generated if none is given.
+// The response is the only time the secret is returned.
+func (h *HttpHandler) PostWebhook(w http.ResponseWriter, r *http.Request) {
+	request := new(model.Webhook)
+	if err := jsonapi.UnmarshalPayload(r.Body, request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if request.ID != "" {
+		WriteError(w, http.StatusForbidden, "Client Generated ID", "Webhook IDs are assigned by the server")
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		WriteValidationErrors(w, "Invalid Webhook", err)
+		return
+	}
+
+	hook, err := h.webhooks.Add(*request)
+	if err != nil {
+		writeWebhookError(w, "", err)
+		return
+	}
+	AuditRequest(h.audit, r, "webhook.create", "webhook/"+hook.ID, nil, hook)
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	w.Header().Set("Location", "/api/webhook/"+hook.ID)
+	w.WriteHeader(http.StatusCreated)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &hook); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+	}
+}
+
+// Partially updates a webhook, attributes missing from the request are left unchanged
+func (h *HttpHandler) PatchWebhook(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	current, err := h.webhooks.Get(id)
+	if err != nil {
+		writeWebhookError(w, id, err)
+		return
+	}
+
+	hook := current
+	if err := jsonapi.UnmarshalPayload(r.Body, &hook); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if hook.ID != id {
+		WriteError(w, http.StatusConflict, "Webhook ID Mismatch", "Webhook ID "+hook.ID+" does not match "+id)
+		return
+	}
+	if hook.Secret == "" {
+		hook.Secret = current.Secret
+	}
+
+	if err := hook.Validate(); err != nil {
+		WriteValidationErrors(w, "Invalid Webhook", err)
+		return
+	}
+
+	if hook, err = h.webhooks.Update(id, hook); err != nil {
+		writeWebhookError(w, id, err)
+		return
+	}
+	AuditRequest(h.audit, r, "webhook.update", "webhook/"+id, current, hook)
+
+	hook.Secret = ""
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayloadWithoutIncluded(w, &hook); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing response", "error", err)
+	}
+}
+
+// Deletes a webhook along with its delivery log
+func (h *HttpHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	hook, err := h.webhooks.Delete(id)
+	if err != nil {
+		writeWebhookError(w, id, err)
+		return
+	}
+	AuditRequest(h.audit, r, "webhook.delete", "webhook/"+id, hook, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Returns the recent delivery attempts of a webhook, oldest first
+func (h *HttpHandler) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	deliveries, err := h.webhooks.Deliveries(id)
+	if err != nil {
+		writeWebhookError(w, id, err)
+		return
+	}
+
+	deliveryList := make([]*model.WebhookDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		delivery := delivery
+		deliveryList = append(deliveryList, &delivery)
+	}
+
+	w.Header().Set("Content-Type", jsonapi.MediaType)
+	if err := jsonapi.MarshalPayload(w, deliveryList); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writeWebhookError maps errors returned by model.Webhooks to responses
+func writeWebhookError(w http.ResponseWriter, id string, err error) {
+	if errors.Is(err, model.ErrWebhookNotFound) {
+		WriteError(w, http.StatusNotFound, "Webhook Not Found", "Webhook with ID "+id+" does not exist")
+		return
+	}
+	WriteError(w, http.StatusInternalServerError, "Webhook Error", err.Error())
+}
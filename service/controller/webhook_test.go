@@ -0,0 +1,153 @@
+package controller_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/jsonapi"
+	"github.com/jacobalberty/beenfar/service"
+	"github.com/jacobalberty/beenfar/service/event"
+	"github.com/jacobalberty/beenfar/service/model"
+	"github.com/jacobalberty/beenfar/service/webhook"
+)
+
+func TestWebhook(t *testing.T) {
+	var (
+		h        *service.BeenFarService
+		response *httptest.ResponseRecorder
+		hook     model.Webhook
+	)
+	t.Parallel()
+
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+	received := make(chan delivery, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- delivery{r.Header, body}
+	}))
+	defer receiver.Close()
+
+	h = service.NewBeenFarService(service.WithAdminPassword(testPassword))
+	run(t, h, nil)
+	api := authorize(t, h)
+
+	// Unknown event types and invalid urls are rejected
+	response = send(t, api, "POST", "/api/webhook", &model.Webhook{URL: "ftp://example.com", Events: []string{"nope"}})
+	if response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, response.Code)
+	}
+
+	response = send(t, api, "POST", "/api/webhook", &model.Webhook{
+		URL:    receiver.URL,
+		Secret: "s3cret",
+		Events: []string{string(event.ConfigChanged)},
+	})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, response.Code)
+	}
+	if err := jsonapi.UnmarshalPayload(response.Body, &hook); err != nil {
+		t.Fatal(err)
+	}
+	if hook.Secret != "s3cret" {
+		t.Errorf("Expected the secret to be returned on creation, got %q", hook.Secret)
+	}
+
+	// Secrets are not returned afterwards
+	response = send(t, api, "GET", "/api/webhook", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	hooks, err := jsonapi.UnmarshalManyPayload(response.Body, reflect.TypeOf(new(model.Webhook)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 1 {
+		t.Fatalf("Expected 1 webhook, got %d", len(hooks))
+	}
+	if listed := hooks[0].(*model.Webhook); listed.Secret != "" || listed.URL != receiver.URL {
+		t.Errorf("Expected webhook %s without secret, got %+v", receiver.URL, listed)
+	}
+
+	// Changing config delivers a signed event
+	response = send(t, api, "POST", "/api/wifi", &model.WifiNetworkConfig{Ssid: "Hooked"})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, response.Code)
+	}
+
+	var d delivery
+	select {
+	case d = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a webhook delivery")
+	}
+	if e := d.header.Get(webhook.HeaderEvent); e != string(event.ConfigChanged) {
+		t.Errorf("Expected event %s, got %s", event.ConfigChanged, e)
+	}
+	expected := webhook.Sign("s3cret", d.header.Get(webhook.HeaderTimestamp), d.body)
+	if sig := d.header.Get(webhook.HeaderSignature); sig != expected {
+		t.Errorf("Expected signature %s, got %s", expected, sig)
+	}
+
+	// The delivery is logged
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		response = send(t, api, "GET", "/api/webhook/"+hook.ID+"/deliveries", nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+		}
+		deliveries, err := jsonapi.UnmarshalManyPayload(response.Body, reflect.TypeOf(new(model.WebhookDelivery)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) == 1 {
+			if logged := deliveries[0].(*model.WebhookDelivery); !logged.Success || logged.StatusCode != http.StatusOK {
+				t.Errorf("Expected a successful delivery, got %+v", logged)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 1 delivery, got %d", len(deliveries))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Disabled webhooks receive nothing
+	response = sendRaw(t, api, "PATCH", "/api/webhook/"+hook.ID,
+		`{"data":{"type":"webhook","id":"`+hook.ID+`","attributes":{"disabled":true}}}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, response.Code)
+	}
+
+	response = send(t, api, "DELETE", "/api/wifi/ssid/Hooked", nil)
+	if response.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, response.Code)
+	}
+	select {
+	case <-received:
+		t.Error("Expected no delivery to a disabled webhook")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Only admins manage webhooks
+	createUser(t, api, "operator", model.RoleOperator)
+	response = send(t, authorizeAs(t, h, "operator"), "GET", "/api/webhook", nil)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+
+	response = send(t, api, "DELETE", "/api/webhook/"+hook.ID, nil)
+	if response.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, response.Code)
+	}
+	response = send(t, api, "GET", "/api/webhook/"+hook.ID, nil)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, response.Code)
+	}
+}
@@ -0,0 +1,189 @@
+// Package driver defines how device types plug into beenfar
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jacobalberty/beenfar/service/event"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/metrics"
+	"github.com/jacobalberty/beenfar/service/model"
+	"github.com/jacobalberty/beenfar/service/secret"
+)
+
+var (
+	ErrNotSupported    = errors.New("not supported by driver")
+	ErrDuplicateDriver = errors.New("driver already registered")
+)
+
+// Listeners drivers can register routes on
+const (
+	// ListenerInform serves devices checking in
+	ListenerInform = "inform"
+	// ListenerAPI serves the management api, routes on it require authentication
+	ListenerAPI = "api"
+	// ListenerAgent serves device agents over the TLS of the api listener, routes on it are not
+	// authenticated as api users so devices authenticate themselves
+	ListenerAgent = "agent"
+	// ListenerPortal serves the guest portal
+	ListenerPortal = "portal"
+	// ListenerFirmware serves firmware downloads to devices
+	ListenerFirmware = "firmware"
+)
+
+// Routers holds the router of each listener by name
+type Routers map[string]chi.Router
+
+// Discovery is how a driver learns about new devices
+type Discovery string
+
+const (
+	// Devices announce themselves and show up as pending
+	DiscoveryInform Discovery = "inform"
+	// Devices are added by address and polled
+	DiscoveryManual Discovery = "manual"
+)
+
+// A Capability is something devices of a driver can be managed for
+type Capability string
+
+const (
+	// Devices report statistics
+	CapabilityStats Capability = "stats"
+	// Devices broadcast the configured wifi networks
+	CapabilityWifi Capability = "wifi"
+	// Devices can be upgraded with firmware
+	CapabilityFirmware Capability = "firmware"
+)
+
+// A Command is an action a device can be told to take
+type Command string
+
+const (
+	CommandReboot    Command = "reboot"
+	CommandLocate    Command = "locate"
+	CommandProvision Command = "provision"
+	// Read the status of the device now instead of waiting for it
+	CommandRefresh Command = "refresh"
+)
+
+// Info describes a driver
+type Info struct {
+	// Name identifies the driver, it is stored with every device the driver manages
+	Name         string       `jsonapi:"primary,driver"`
+	Discovery    Discovery    `jsonapi:"attr,discovery"`
+	Capabilities []Capability `jsonapi:"attr,capabilities"`
+	Commands     []Command    `jsonapi:"attr,commands"`
+}
+
+// Supports checks if the driver has a capability
+func (i Info) Supports(c Capability) bool {
+	for _, known := range i.Capabilities {
+		if known == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Accepts checks if the driver can send a command
+func (i Info) Accepts(c Command) bool {
+	for _, known := range i.Commands {
+		if known == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Deps are the parts of the service drivers work with
+type Deps struct {
+	// Sites holds the configuration devices are rendered with, by the site they are in
+	Sites   *model.Sites
+	Devices *model.Devices
+	Audit   *model.AuditLog
+	Events  *event.Bus
+	Metrics *metrics.Registry
+	Logger  *logging.Logger
+	// Secrets encrypts credentials drivers keep for devices
+	Secrets *secret.Box
+	// DataDir is where drivers save their state, empty if nothing is saved
+	DataDir string
+	// InformInterval is how often devices are expected to check in
+	InformInterval time.Duration
+}
+
+// A Driver manages one type of device
+type Driver interface {
+	Info() Info
+	// Init is called once per service, drivers register their routes on the listeners they use
+	Init(deps Deps, routers Routers) error
+	// Render returns the configuration of an adopted device, ErrNotSupported if the driver has no renderer
+	Render(d model.Device) ([]byte, error)
+	// Command tells a device to take an action, ErrNotSupported for commands missing from Info
+	Command(d model.Device, c Command) error
+}
+
+// A Worker is a driver with background work such as polling devices, it runs for as long as the service does
+type Worker interface {
+	Driver
+	// Run returns once ctx is done
+	Run(ctx context.Context)
+}
+
+// Registry holds the drivers of a service by name
+type Registry struct {
+	drivers map[string]Driver
+
+	mu sync.RWMutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		drivers: make(map[string]Driver),
+	}
+}
+
+// Register adds a driver, names have to be unique
+func (r *Registry) Register(d Driver) error {
+	name := d.Info().Name
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.drivers[name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateDriver, name)
+	}
+	r.drivers[name] = d
+	return nil
+}
+
+// Get returns the driver with the given name
+func (r *Registry) Get(name string) (Driver, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	d, ok := r.drivers[name]
+	return d, ok
+}
+
+// List returns every driver sorted by name
+func (r *Registry) List() []Driver {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	drivers := make([]Driver, 0, len(r.drivers))
+	for _, d := range r.drivers {
+		drivers = append(drivers, d)
+	}
+	sort.Slice(drivers, func(i, j int) bool {
+		return drivers[i].Info().Name < drivers[j].Info().Name
+	})
+	return drivers
+}
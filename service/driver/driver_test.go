@@ -0,0 +1,43 @@
+package driver_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service/driver"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+type namedDriver string
+
+func (d namedDriver) Info() driver.Info                          { return driver.Info{Name: string(d)} }
+func (d namedDriver) Init(driver.Deps, driver.Routers) error     { return nil }
+func (d namedDriver) Render(model.Device) ([]byte, error)        { return nil, driver.ErrNotSupported }
+func (d namedDriver) Command(model.Device, driver.Command) error { return driver.ErrNotSupported }
+
+func TestRegistry(t *testing.T) {
+	r := driver.NewRegistry()
+	for _, name := range []string{"unifi", "edgeos", "openwrt"} {
+		if err := r.Register(namedDriver(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Register(namedDriver("unifi")); !errors.Is(err, driver.ErrDuplicateDriver) {
+		t.Errorf("Expected %v, got %v", driver.ErrDuplicateDriver, err)
+	}
+
+	var names []string
+	for _, d := range r.List() {
+		names = append(names, d.Info().Name)
+	}
+	if len(names) != 3 || names[0] != "edgeos" || names[1] != "openwrt" || names[2] != "unifi" {
+		t.Errorf("Expected drivers sorted by name, got %v", names)
+	}
+
+	if d, ok := r.Get("openwrt"); !ok || d.Info().Name != "openwrt" {
+		t.Errorf("Expected the openwrt driver, got %v", d)
+	}
+	if _, ok := r.Get("missing"); ok {
+		t.Error("Expected no driver for an unknown name")
+	}
+}
@@ -0,0 +1,157 @@
+package event
+
+import (
+	"sync"
+	"time"
+)
+
+// Type identifies what happened, it is also the SSE event name
+type Type string
+
+const (
+	// A device requested adoption
+	DevicePending Type = "device.pending"
+	// A pending device was adopted
+	DeviceAdopted Type = "device.adopted"
+	// An adopted device was forgotten
+	DeviceForgotten Type = "device.forgotten"
+	// An adopted device was moved to another site
+	DeviceMoved Type = "device.moved"
+	// An adopted device checked in after being offline
+	DeviceOnline Type = "device.online"
+	// An adopted device stopped checking in
+	DeviceOffline Type = "device.offline"
+	// Configuration was created, updated or deleted
+	ConfigChanged Type = "config.changed"
+	// A device accepted a command sent through the api
+	CommandAcked Type = "command.acked"
+)
+
+// Types lists every event type
+var Types = []Type{
+	DevicePending,
+	DeviceAdopted,
+	DeviceForgotten,
+	DeviceMoved,
+	DeviceOnline,
+	DeviceOffline,
+	ConfigChanged,
+	CommandAcked,
+}
+
+// Valid checks if t is a known event type
+func (t Type) Valid() bool {
+	for _, known := range Types {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Number of events buffered per subscription before new events are dropped
+const subscriptionBuffer = 64
+
+// An Event is published on a Bus
+type Event struct {
+	ID     uint64 `json:"id"`
+	Type   Type   `json:"type"`
+	Time   int64  `json:"time"`
+	Target string `json:"target"`
+	Data   any    `json:"data,omitempty"`
+}
+
+// Bus delivers published events to every matching subscription
+type Bus struct {
+	subs   map[*Subscription]struct{}
+	nextID uint64
+
+	mu sync.Mutex
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish an event to all subscribers.
+// Publish never blocks, subscribers that fall behind miss events and are told so if they subscribed with SubscribeWithDrops.
+func (b *Bus) Publish(t Type, target string, data any) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	e := Event{
+		ID:     b.nextID,
+		Type:   t,
+		Time:   time.Now().Unix(),
+		Target: target,
+		Data:   data,
+	}
+
+	for s := range b.subs {
+		if !s.matches(t) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			if s.dropped != nil {
+				s.dropped(e)
+			}
+		}
+	}
+	return e
+}
+
+// Subscribe to events of the given types, or all events if no types are given
+func (b *Bus) Subscribe(types ...Type) *Subscription {
+	return b.SubscribeWithDrops(nil, types...)
+}
+
+// SubscribeWithDrops subscribes like Subscribe and calls dropped with every event missed because the
+// subscription fell behind. dropped is called with the bus locked, it must not block or publish.
+func (b *Bus) SubscribeWithDrops(dropped func(Event), types ...Type) *Subscription {
+	s := &Subscription{
+		c:       make(chan Event, subscriptionBuffer),
+		types:   make(map[Type]bool, len(types)),
+		dropped: dropped,
+	}
+	for _, t := range types {
+		s.types[t] = true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subs[s] = struct{}{}
+	return s
+}
+
+// Unsubscribe stops delivery to a subscription and closes its channel
+func (b *Bus) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.c)
+	}
+}
+
+// A Subscription receives events from a Bus
+type Subscription struct {
+	c       chan Event
+	types   map[Type]bool
+	dropped func(Event)
+}
+
+// Events returns the channel events are delivered on, it is closed by Unsubscribe
+func (s *Subscription) Events() <-chan Event {
+	return s.c
+}
+
+func (s *Subscription) matches(t Type) bool {
+	return len(s.types) == 0 || s.types[t]
+}
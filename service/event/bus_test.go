@@ -0,0 +1,60 @@
+package event_test
+
+import (
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service/event"
+)
+
+func TestBus(t *testing.T) {
+	bus := event.NewBus()
+
+	all := bus.Subscribe()
+	devices := bus.Subscribe(event.DevicePending, event.DeviceAdopted)
+
+	bus.Publish(event.DevicePending, "device/deadbeef0000", nil)
+	bus.Publish(event.ConfigChanged, "wifi/1", nil)
+	bus.Publish(event.DeviceAdopted, "device/deadbeef0000", nil)
+
+	for _, expected := range []event.Type{event.DevicePending, event.ConfigChanged, event.DeviceAdopted} {
+		if e := <-all.Events(); e.Type != expected {
+			t.Errorf("Expected %s, got %s", expected, e.Type)
+		}
+	}
+	for _, expected := range []event.Type{event.DevicePending, event.DeviceAdopted} {
+		if e := <-devices.Events(); e.Type != expected {
+			t.Errorf("Expected %s, got %s", expected, e.Type)
+		}
+	}
+
+	// Slow subscribers drop events instead of blocking publishers
+	dropped := 0
+	counted := bus.SubscribeWithDrops(func(event.Event) { dropped++ })
+	for i := 0; i < 1000; i++ {
+		bus.Publish(event.ConfigChanged, "wifi/1", nil)
+	}
+
+	bus.Unsubscribe(all)
+	n := 0
+	for range all.Events() {
+		n++
+	}
+	if n == 0 || n == 1000 {
+		t.Errorf("Expected some but not all events to be buffered, got %d", n)
+	}
+
+	bus.Unsubscribe(counted)
+	buffered := 0
+	for range counted.Events() {
+		buffered++
+	}
+	if buffered+dropped != 1000 || dropped == 0 {
+		t.Errorf("Expected every event to be buffered or dropped, got %d buffered and %d dropped", buffered, dropped)
+	}
+
+	select {
+	case e := <-devices.Events():
+		t.Errorf("Expected no events, got %v", e)
+	default:
+	}
+}
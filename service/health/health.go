@@ -0,0 +1,84 @@
+// Package health tracks the state of service components for liveness and readiness probes
+package health
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrNotStarted = errors.New("not started")
+	ErrStopped    = errors.New("stopped")
+)
+
+// Component and overall statuses
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Component is the state of a single component
+type Component struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report is the state of every component, Status is only up if all components are up
+type Report struct {
+	Status     string               `json:"status"`
+	Components map[string]Component `json:"components"`
+}
+
+// Registry holds the last reported state of each component
+type Registry struct {
+	components map[string]error
+
+	mu sync.RWMutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		components: make(map[string]error),
+	}
+}
+
+// Set the state of a component, a nil error marks it up
+func (r *Registry) Set(name string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.components[name] = err
+}
+
+// Readiness reports the service as up once every component is up
+func (r *Registry) Readiness() Report {
+	return r.report(func(err error) bool { return err != nil })
+}
+
+// Liveness reports the service as down only if a component stopped, components
+// that have not started yet do not fail liveness probes
+func (r *Registry) Liveness() Report {
+	return r.report(func(err error) bool { return errors.Is(err, ErrStopped) })
+}
+
+// report returns the state of every component, the overall status is down if failed returns true for any of them
+func (r *Registry) report(failed func(error) bool) Report {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	report := Report{
+		Status:     StatusUp,
+		Components: make(map[string]Component, len(r.components)),
+	}
+	for name, err := range r.components {
+		c := Component{Status: StatusUp}
+		if err != nil {
+			c = Component{Status: StatusDown, Error: err.Error()}
+		}
+		if failed(err) {
+			report.Status = StatusDown
+		}
+		report.Components[name] = c
+	}
+	return report
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service/health"
+	"github.com/jacobalberty/beenfar/service/logging"
+)
+
+// How long shutdown waits for stages to drain unless set by WithDrainTimeout
+const DefaultDrainTimeout = 30 * time.Second
+
+// ShutdownSignals are the signals beenfard shuts down gracefully on
+var ShutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// A Stage is a part of the service that is started and stopped by a Lifecycle
+type Stage struct {
+	// Name is the component the stage is reported as by the health checks
+	Name string
+	// Start returns once the stage is running, fail stops the lifecycle if the stage fails later on
+	Start func(fail func(error)) error
+	// Stop returns once the stage has drained, it gives up when ctx is done
+	Stop func(ctx context.Context) error
+}
+
+// Lifecycle starts stages in order and stops them in reverse order
+type Lifecycle struct {
+	// DrainTimeout bounds how long stopping all stages may take
+	DrainTimeout time.Duration
+	Logger       *logging.Logger
+
+	stages []Stage
+	health *health.Registry
+}
+
+func NewLifecycle(registry *health.Registry, stages ...Stage) *Lifecycle {
+	for _, s := range stages {
+		registry.Set(s.Name, health.ErrNotStarted)
+	}
+	return &Lifecycle{
+		DrainTimeout: DefaultDrainTimeout,
+		Logger:       logging.Default(),
+		stages:       stages,
+		health:       registry,
+	}
+}
+
+// Run starts every stage and blocks until ctx is done or a stage fails, the started stages are then stopped.
+// It returns the error of the stage that failed, otherwise the first error stopping a stage.
+func (l *Lifecycle) Run(ctx context.Context) error {
+	var (
+		err     error
+		started int
+		failed  = make(chan error, 1)
+	)
+
+	for _, s := range l.stages {
+		name := s.Name
+		fail := func(err error) {
+			select {
+			case failed <- fmt.Errorf("%s: %w", name, err):
+			default:
+			}
+		}
+		if s.Start != nil {
+			if err = s.Start(fail); err != nil {
+				err = fmt.Errorf("starting %s: %w", name, err)
+				break
+			}
+		}
+		l.health.Set(name, nil)
+		l.Logger.Debug("started", "component", name)
+		started++
+	}
+
+	if err == nil {
+		select {
+		case <-ctx.Done():
+		case err = <-failed:
+		}
+	}
+
+	drain, cancel := context.WithTimeout(context.Background(), l.DrainTimeout)
+	defer cancel()
+
+	for i := started - 1; i >= 0; i-- {
+		s := l.stages[i]
+		if s.Stop != nil {
+			if serr := s.Stop(drain); serr != nil && err == nil {
+				err = fmt.Errorf("stopping %s: %w", s.Name, serr)
+			}
+		}
+		l.health.Set(s.Name, health.ErrStopped)
+		l.Logger.Debug("stopped", "component", s.Name)
+	}
+	return err
+}
+
+// WorkerStage runs a background worker until it is stopped
+func WorkerStage(name string, worker func(context.Context)) Stage {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	return Stage{
+		Name: name,
+		Start: func(func(error)) error {
+			go func() {
+				defer close(done)
+				worker(ctx)
+			}()
+			return nil
+		},
+		Stop: func(drain context.Context) error {
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-drain.Done():
+				return drain.Err()
+			}
+		},
+	}
+}
+
+// ServerStage serves h on l, stopping it waits for in-flight requests to finish.
+// Requests that never finish on their own, such as event streams, have their context cancelled when it stops.
+func ServerStage(name string, h http.Handler, l net.Listener) Stage {
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := &http.Server{
+		Handler:     h,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	srv.RegisterOnShutdown(cancel)
+
+	return Stage{
+		Name: name,
+		Start: func(fail func(error)) error {
+			go func() {
+				if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+					fail(err)
+				}
+			}()
+			return nil
+		},
+		Stop: func(drain context.Context) error {
+			defer cancel()
+			if err := srv.Shutdown(drain); err != nil {
+				srv.Close()
+				return err
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,187 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service"
+	"github.com/jacobalberty/beenfar/service/health"
+)
+
+// recorder records the order stages are started and stopped in
+type recorder struct {
+	calls []string
+	mu    sync.Mutex
+}
+
+func (r *recorder) stage(name string, startErr error) service.Stage {
+	return service.Stage{
+		Name: name,
+		Start: func(func(error)) error {
+			r.record("start " + name)
+			return startErr
+		},
+		Stop: func(context.Context) error {
+			r.record("stop " + name)
+			return nil
+		},
+	}
+}
+
+func (r *recorder) record(call string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, call)
+}
+
+func TestLifecycleOrder(t *testing.T) {
+	var rec recorder
+	registry := health.NewRegistry()
+	lc := service.NewLifecycle(registry, rec.stage("storage", nil), rec.stage("worker", nil), rec.stage("listener", nil))
+
+	if report := registry.Readiness(); report.Status != health.StatusDown {
+		t.Errorf("Expected the stages to not be ready before Run, got %+v", report)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- lc.Run(ctx)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for registry.Readiness().Status != health.StatusUp {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the stages to start, got %+v", registry.Readiness())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"start storage", "start worker", "start listener", "stop listener", "stop worker", "stop storage"}
+	if !reflect.DeepEqual(rec.calls, expected) {
+		t.Errorf("Expected %v, got %v", expected, rec.calls)
+	}
+	if report := registry.Liveness(); report.Status != health.StatusDown {
+		t.Errorf("Expected the stopped stages to not be alive, got %+v", report)
+	}
+}
+
+func TestLifecycleStartFailure(t *testing.T) {
+	var rec recorder
+	errStart := errors.New("address in use")
+	lc := service.NewLifecycle(health.NewRegistry(), rec.stage("storage", nil), rec.stage("listener", errStart), rec.stage("other", nil))
+
+	// Stages that started are stopped again, later stages are never started
+	if err := lc.Run(context.Background()); !errors.Is(err, errStart) {
+		t.Errorf("Expected %v, got %v", errStart, err)
+	}
+	expected := []string{"start storage", "start listener", "stop storage"}
+	if !reflect.DeepEqual(rec.calls, expected) {
+		t.Errorf("Expected %v, got %v", expected, rec.calls)
+	}
+}
+
+func TestLifecycleStageFailure(t *testing.T) {
+	var rec recorder
+	errServe := errors.New("accept failed")
+	failing := service.Stage{
+		Name: "listener",
+		Start: func(fail func(error)) error {
+			go fail(errServe)
+			return nil
+		},
+	}
+	lc := service.NewLifecycle(health.NewRegistry(), rec.stage("storage", nil), failing)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := lc.Run(ctx); !errors.Is(err, errServe) {
+		t.Errorf("Expected %v, got %v", errServe, err)
+	}
+	if expected := []string{"start storage", "stop storage"}; !reflect.DeepEqual(rec.calls, expected) {
+		t.Errorf("Expected %v, got %v", expected, rec.calls)
+	}
+}
+
+func TestServerStageDrain(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		timeout time.Duration
+		finish  bool
+		err     error
+	}{
+		{"in-flight requests finish", 5 * time.Second, true, nil},
+		{"drain timeout", 50 * time.Millisecond, false, context.DeadlineExceeded},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			started := make(chan struct{})
+			release := make(chan struct{})
+			defer close(release)
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				<-release
+			})
+
+			lc := service.NewLifecycle(health.NewRegistry(), service.ServerStage("listener", h, l))
+			lc.DrainTimeout = tc.timeout
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() {
+				done <- lc.Run(ctx)
+			}()
+
+			responses := make(chan error, 1)
+			go func() {
+				resp, err := http.Get("http://" + l.Addr().String())
+				if err == nil {
+					resp.Body.Close()
+				}
+				responses <- err
+			}()
+			<-started
+
+			// Shutting down waits for the request
+			cancel()
+			select {
+			case err := <-done:
+				if tc.finish {
+					t.Fatalf("Expected Run to wait for the request, got %v", err)
+				}
+				if !errors.Is(err, tc.err) {
+					t.Errorf("Expected %v, got %v", tc.err, err)
+				}
+				return
+			case <-time.After(100 * time.Millisecond):
+				if !tc.finish {
+					t.Fatal("Expected Run to give up draining")
+				}
+			}
+
+			release <- struct{}{}
+			if err := <-responses; err != nil {
+				t.Errorf("Expected the in-flight request to finish, got %v", err)
+			}
+			if err := <-done; err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+// Package logging writes leveled, structured log records as JSON lines
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Level is the importance of a record, records below the level of a Logger are dropped
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+var ErrUnknownLevel = errors.New("unknown log level")
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// ParseLevel parses the name of a level as returned by Level.String
+func ParseLevel(s string) (Level, error) {
+	for _, l := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("%w %q", ErrUnknownLevel, s)
+}
+
+// Value written in place of secrets
+const Redacted = "[redacted]"
+
+// Parts of field names whose values are never written
+var secretNames = []string{"password", "passphrase", "secret", "token", "key", "authorization", "cookie"}
+
+// Endings of field names holding keys that are public
+var publicNames = []string{"host_key", "hostkey", "public_key", "publickey", "pubkey"}
+
+// IsSecret checks if a field name is likely to hold a secret
+func IsSecret(name string) bool {
+	name = strings.ToLower(name)
+	for _, p := range publicNames {
+		if strings.HasSuffix(name, p) {
+			return false
+		}
+	}
+	for _, s := range secretNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// handler is shared by a Logger and every logger derived from it
+type handler struct {
+	w     io.Writer
+	level int64
+	// debug holds the devices logged at debug level regardless of the level
+	debug map[string]bool
+
+	mu      sync.Mutex
+	debugMu sync.RWMutex
+}
+
+type field struct {
+	key   string
+	value any
+}
+
+// Logger writes records with the fields it was created with
+type Logger struct {
+	h      *handler
+	fields []field
+	mac    string
+}
+
+// New creates a logger writing records of at least level to w
+func New(w io.Writer, level Level) *Logger {
+	return &Logger{
+		h: &handler{
+			w:     w,
+			level: int64(level),
+			debug: make(map[string]bool),
+		},
+	}
+}
+
+// Discard returns a logger that writes nothing
+func Discard() *Logger {
+	return New(io.Discard, LevelError+1)
+}
+
+// With returns a logger that adds the given key value pairs to every record
+func (l *Logger) With(kv ...any) *Logger {
+	c := *l
+	c.fields = append(append([]field(nil), l.fields...), fields(kv)...)
+	return &c
+}
+
+// ForDevice returns a logger for records about a device, it logs at debug level while the device is debugged
+func (l *Logger) ForDevice(mac string) *Logger {
+	c := l.With("mac", mac)
+	c.mac = NormalizeMac(mac)
+	return c
+}
+
+// Level returns the minimum level of records that are written
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt64(&l.h.level))
+}
+
+// SetLevel changes the level of this logger and every logger sharing its output
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt64(&l.h.level, int64(level))
+}
+
+// DebugDevices returns the MAC addresses of the debugged devices
+func (l *Logger) DebugDevices() []string {
+	l.h.debugMu.RLock()
+	defer l.h.debugMu.RUnlock()
+
+	macs := make([]string, 0, len(l.h.debug))
+	for mac := range l.h.debug {
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+	return macs
+}
+
+// SetDebugDevices replaces the devices that are logged at debug level
+func (l *Logger) SetDebugDevices(macs []string) {
+	debug := make(map[string]bool, len(macs))
+	for _, mac := range macs {
+		debug[NormalizeMac(mac)] = true
+	}
+
+	l.h.debugMu.Lock()
+	defer l.h.debugMu.Unlock()
+
+	l.h.debug = debug
+}
+
+// NormalizeMac converts a MAC address to lower case hex digits without separators
+func NormalizeMac(mac string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", "-", "").Replace(mac))
+}
+
+// Enabled checks if records of level are written
+func (l *Logger) Enabled(level Level) bool {
+	if level >= l.Level() {
+		return true
+	}
+	if l.mac == "" {
+		return false
+	}
+
+	l.h.debugMu.RLock()
+	defer l.h.debugMu.RUnlock()
+
+	return l.h.debug[l.mac]
+}
+
+func (l *Logger) Debug(msg string, kv ...any) { l.Log(LevelDebug, msg, kv...) }
+func (l *Logger) Info(msg string, kv ...any)  { l.Log(LevelInfo, msg, kv...) }
+func (l *Logger) Warn(msg string, kv ...any)  { l.Log(LevelWarn, msg, kv...) }
+func (l *Logger) Error(msg string, kv ...any) { l.Log(LevelError, msg, kv...) }
+
+// Log writes a record with the given key value pairs if level is enabled
+func (l *Logger) Log(level Level, msg string, kv ...any) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	writeValue(&buf, time.Now().UTC().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeValue(&buf, level.String())
+	buf.WriteString(`,"msg":`)
+	writeValue(&buf, msg)
+	// Copy the fields, loggers derived from l may share their backing array
+	fs := append(append(make([]field, 0, len(l.fields)+len(kv)/2), l.fields...), fields(kv)...)
+	for _, f := range fs {
+		buf.WriteByte(',')
+		writeValue(&buf, f.key)
+		buf.WriteByte(':')
+		if IsSecret(f.key) {
+			writeValue(&buf, Redacted)
+		} else {
+			writeValue(&buf, f.value)
+		}
+	}
+	buf.WriteString("}\n")
+
+	l.h.mu.Lock()
+	defer l.h.mu.Unlock()
+
+	l.h.w.Write(buf.Bytes())
+}
+
+// Writer returns a writer that logs every line written to it at level, it is used to capture the log package
+func (l *Logger) Writer(level Level) io.Writer {
+	return lineWriter{l, level}
+}
+
+type lineWriter struct {
+	l     *Logger
+	level Level
+}
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		w.l.Log(w.level, line)
+	}
+	return len(p), nil
+}
+
+// fields pairs up keys and values, a value without a key is logged as !BADKEY which is redacted like other keys
+func fields(kv []any) []field {
+	fs := make([]field, 0, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok || i+1 == len(kv) {
+			fs = append(fs, field{"!BADKEY", kv[i]})
+			i--
+			continue
+		}
+		fs = append(fs, field{key, kv[i+1]})
+	}
+	return fs
+}
+
+func writeValue(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case error:
+		writeValue(buf, v.Error())
+		return
+	case time.Duration:
+		writeValue(buf, v.String())
+		return
+	case json.RawMessage:
+		writeValue(buf, redactJSON(v))
+		return
+	case fmt.Stringer:
+		writeValue(buf, v.String())
+		return
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(b)
+}
+
+// redactJSON replaces the values of secret object members in a JSON document, invalid JSON is logged as a string
+func redactJSON(raw json.RawMessage) any {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return string(raw)
+	}
+	return redact(v)
+}
+
+func redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			if IsSecret(k) {
+				v[k] = Redacted
+			} else {
+				v[k] = redact(e)
+			}
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = redact(e)
+		}
+	}
+	return v
+}
+
+var defaultLogger atomic.Value
+
+func init() {
+	defaultLogger.Store(New(os.Stderr, LevelInfo))
+}
+
+// Default returns the logger used where no other logger is available
+func Default() *Logger {
+	return defaultLogger.Load().(*Logger)
+}
+
+// SetDefault replaces the logger returned by Default
+func SetDefault(l *Logger) {
+	defaultLogger.Store(l)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying l
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return Default()
+}
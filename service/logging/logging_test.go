@@ -0,0 +1,149 @@
+package logging_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service/logging"
+)
+
+// records decodes the JSON lines written to buf
+func records(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var recs []map[string]any
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var rec map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("Expected a JSON record, got %q: %v", scanner.Text(), err)
+		}
+		recs = append(recs, rec)
+	}
+	buf.Reset()
+	return recs
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := logging.New(&buf, logging.LevelInfo).With("component", "test")
+
+	l.Debug("dropped")
+	l.Info("started", "listener", "api", "error", errors.New("boom"))
+	l.Warn("odd", "value")
+
+	recs := records(t, &buf)
+	if len(recs) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(recs))
+	}
+	for k, v := range map[string]any{"level": "info", "msg": "started", "component": "test", "listener": "api", "error": "boom"} {
+		if recs[0][k] != v {
+			t.Errorf("Expected %s to be %v, got %v", k, v, recs[0][k])
+		}
+	}
+	if _, ok := recs[0]["time"]; !ok {
+		t.Error("Expected a time")
+	}
+	// There is no telling what a value without a key holds
+	if recs[1]["!BADKEY"] != logging.Redacted {
+		t.Errorf("Expected a value without key to be redacted, got %v", recs[1])
+	}
+
+	l.SetLevel(logging.LevelDebug)
+	l.Debug("kept")
+	if recs := records(t, &buf); len(recs) != 1 || recs[0]["level"] != "debug" {
+		t.Errorf("Expected a debug record after changing the level, got %v", recs)
+	}
+}
+
+func TestLoggerSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	l := logging.New(&buf, logging.LevelInfo)
+
+	payload := json.RawMessage(`{"mac":"deadbeef0000","x_authkey":"abc","vap_table":[{"x_passphrase":"hunter22"}]}`)
+	l.Info("secrets", "password", "hunter2", "unifi_key", "00112233", "payload", payload)
+
+	out := buf.String()
+	for _, secret := range []string{"hunter2", "00112233", "abc"} {
+		if strings.Contains(out, `"`+secret) {
+			t.Errorf("Expected %s to be redacted, got %s", secret, out)
+		}
+	}
+	rec := records(t, &buf)[0]
+	if mac := rec["payload"].(map[string]any)["mac"]; mac != "deadbeef0000" {
+		t.Errorf("Expected the payload to be logged, got %v", rec["payload"])
+	}
+}
+
+func TestIsSecret(t *testing.T) {
+	for name, secret := range map[string]bool{
+		"password":     true,
+		"security_key": true,
+		"x_authkey":    true,
+		"unifi_key":    true,
+		"host_key":     false,
+		"public_key":   false,
+		"HostKey":      false,
+		"mac":          false,
+	} {
+		if got := logging.IsSecret(name); got != secret {
+			t.Errorf("Expected IsSecret(%q) to be %t, got %t", name, secret, got)
+		}
+	}
+}
+
+func TestDebugDevices(t *testing.T) {
+	var buf bytes.Buffer
+	l := logging.New(&buf, logging.LevelInfo)
+
+	l.SetDebugDevices([]string{"DE:AD:BE:EF:00:00"})
+	if macs := l.DebugDevices(); !reflect.DeepEqual(macs, []string{"deadbeef0000"}) {
+		t.Errorf("Expected normalized MAC addresses, got %v", macs)
+	}
+
+	l.ForDevice("deadbeef0000").Debug("debugged")
+	l.ForDevice("deadbeef0001").Debug("dropped")
+	l.Debug("dropped")
+
+	recs := records(t, &buf)
+	if len(recs) != 1 || recs[0]["mac"] != "deadbeef0000" {
+		t.Errorf("Expected only the debugged device to be logged, got %v", recs)
+	}
+
+	l.SetDebugDevices(nil)
+	l.ForDevice("deadbeef0000").Debug("dropped")
+	if recs := records(t, &buf); len(recs) != 0 {
+		t.Errorf("Expected nothing to be logged, got %v", recs)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	l := logging.New(&buf, logging.LevelInfo)
+
+	std := log.New(l.Writer(logging.LevelWarn), "", 0)
+	std.Print("http: TLS handshake error")
+
+	recs := records(t, &buf)
+	if len(recs) != 1 || recs[0]["msg"] != "http: TLS handshake error" || recs[0]["level"] != "warn" {
+		t.Errorf("Expected a warning, got %v", recs)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, name := range []string{"debug", "info", "warn", "error"} {
+		level, err := logging.ParseLevel(name)
+		if err != nil || level.String() != name {
+			t.Errorf("Expected %s to parse, got %v %v", name, level, err)
+		}
+	}
+	if _, err := logging.ParseLevel("verbose"); !errors.Is(err, logging.ErrUnknownLevel) {
+		t.Errorf("Expected %v, got %v", logging.ErrUnknownLevel, err)
+	}
+}
@@ -1,40 +1,489 @@
 package service
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jacobalberty/beenfar/service/adapter/unifi"
 	"github.com/jacobalberty/beenfar/service/controller"
+	"github.com/jacobalberty/beenfar/service/driver"
+	"github.com/jacobalberty/beenfar/service/event"
+	"github.com/jacobalberty/beenfar/service/health"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/metrics"
 	"github.com/jacobalberty/beenfar/service/model"
+	"github.com/jacobalberty/beenfar/service/profile"
+	"github.com/jacobalberty/beenfar/service/secret"
+	"github.com/jacobalberty/beenfar/service/webhook"
 )
 
-func NewBeenFarService() *BeenFarService {
+// Username of the admin created when no users exist
+const BootstrapAdmin = "admin"
+
+// An Option configures a BeenFarService
+type Option func(*BeenFarService)
+
+// WithAdminPassword sets the password of the bootstrap admin, a random one is generated otherwise.
+// It is only used while there are no users.
+func WithAdminPassword(password string) Option {
+	return func(b *BeenFarService) {
+		b.adminPassword = password
+	}
+}
+
+// WithDataDir sets the directory generated state such as the UniFi key is kept in, nothing is saved otherwise
+func WithDataDir(dir string) Option {
+	return func(b *BeenFarService) {
+		b.dataDir = dir
+	}
+}
+
+// WithUnifiKey sets the key given to adopted UniFi devices, it is loaded from the data directory or generated otherwise
+func WithUnifiKey(key []byte) Option {
+	return func(b *BeenFarService) {
+		b.unifiKey = key
+	}
+}
+
+// WithListeners sets the listeners that will be served, each one has to be bound before the service is ready.
+// The inform and api listeners are expected if this is not set.
+func WithListeners(listeners ...string) Option {
+	return func(b *BeenFarService) {
+		b.listeners = listeners
+	}
+}
+
+// WithAPIPort sets the port the api redirect listener sends clients to
+func WithAPIPort(port string) Option {
+	return func(b *BeenFarService) {
+		b.apiPort = port
+	}
+}
+
+// WithInformInterval sets how often adopted devices check in
+func WithInformInterval(interval time.Duration) Option {
+	return func(b *BeenFarService) {
+		b.informInterval = interval
+	}
+}
+
+// WithDrivers registers drivers for more types of devices, the UniFi driver is always registered
+func WithDrivers(drivers ...driver.Driver) Option {
+	return func(b *BeenFarService) {
+		b.extraDrivers = append(b.extraDrivers, drivers...)
+	}
+}
+
+// WithLogger sets the logger of the service, the default logger is used otherwise.
+// The log settings api changes the level of this logger.
+func WithLogger(logger *logging.Logger) Option {
+	return func(b *BeenFarService) {
+		b.log = logger
+	}
+}
+
+// WithDrainTimeout sets how long Run waits for in-flight requests and background workers when shutting down
+func WithDrainTimeout(timeout time.Duration) Option {
+	return func(b *BeenFarService) {
+		b.drainTimeout = timeout
+	}
+}
+
+// WithProfiles sets how wifi profiles for mobile devices are generated
+func WithProfiles(options profile.Options) Option {
+	return func(b *BeenFarService) {
+		b.profiles = options
+	}
+}
+
+func NewBeenFarService(opts ...Option) *BeenFarService {
 	var bfs = &BeenFarService{
-		configData: model.NewConfigData(),
-		devices:    model.NewDevices(),
+		informInterval: DefaultInformInterval,
+		drainTimeout:   DefaultDrainTimeout,
+		listeners:      []string{ListenerInform, ListenerAPI},
+		sites:          model.NewSites(),
+		devices:        model.NewDevices(),
+		users:          model.NewUsers(),
+		audit:          model.NewAuditLog(),
+		events:         event.NewBus(),
+		webhooks:       model.NewWebhooks(),
+		health:         health.NewRegistry(),
+		log:            logging.Default(),
+	}
+	for _, opt := range opts {
+		opt(bfs)
+	}
+	// Nothing is ready until Run has started it
+	for _, name := range []string{componentStorage, componentJanitor, componentWebhooks} {
+		bfs.health.Set(name, health.ErrNotStarted)
+	}
+	for _, listener := range bfs.listeners {
+		bfs.health.Set(listenerComponent(listener), health.ErrNotStarted)
 	}
+	bfs.loadUnifiKey()
+	bfs.loadSecretKey()
+	bfs.loadState()
+	bfs.bootstrap()
 	bfs.Init()
+
 	return bfs
 }
 
+// Names of the components reported by the health checks
+const (
+	componentStorage  = "storage"
+	componentJanitor  = "janitor"
+	componentWebhooks = "webhooks"
+)
+
+// Listeners, each one has its own router
+const (
+	// ListenerInform serves /inform to devices
+	ListenerInform = driver.ListenerInform
+	// ListenerAPI serves the management api and metrics
+	ListenerAPI = driver.ListenerAPI
+	// ListenerAPIRedirect redirects plain HTTP requests to the api listener
+	ListenerAPIRedirect = "api-redirect"
+	// ListenerPortal serves the guest portal
+	ListenerPortal = driver.ListenerPortal
+	// ListenerFirmware serves firmware downloads to devices
+	ListenerFirmware = driver.ListenerFirmware
+)
+
+// Listeners lists every listener
+var Listeners = []string{ListenerInform, ListenerAPI, ListenerAPIRedirect, ListenerPortal, ListenerFirmware}
+
+func listenerComponent(listener string) string {
+	return "listener." + listener
+}
+
+func driverComponent(name string) string {
+	return "driver." + name
+}
+
 type BeenFarService struct {
-	configData *model.ConfigData
-	devices    *model.Devices
-	h          *chi.Mux
+	sites    *model.Sites
+	devices  *model.Devices
+	users    *model.Users
+	audit    *model.AuditLog
+	events   *event.Bus
+	webhooks *model.Webhooks
+	metrics  *metrics.Registry
+	health   *health.Registry
+	routers  map[string]*chi.Mux
+	drivers  *driver.Registry
+	storage  *storage
+	log      *logging.Logger
+
+	adminPassword  string
+	dataDir        string
+	unifiKey       []byte
+	secrets        *secret.Box
+	informInterval time.Duration
+	drainTimeout   time.Duration
+	listeners      []string
+	apiPort        string
+	extraDrivers   []driver.Driver
+	profiles       profile.Options
 }
 
 // Initialize the BeenFar service and register all devices and handlers
 func (b *BeenFarService) Init() {
-	b.h = chi.NewRouter()
+	b.metrics = metrics.NewRegistry()
+	b.drivers = driver.NewRegistry()
+	for _, d := range append([]driver.Driver{unifi.NewDriver(unifi.Config{Key: b.unifiKey})}, b.extraDrivers...) {
+		if err := b.drivers.Register(d); err != nil {
+			b.fatal("error registering driver", "error", err)
+		}
+	}
+	b.routers = make(map[string]*chi.Mux, len(Listeners))
+	for _, listener := range Listeners {
+		router := chi.NewRouter()
+		router.Use(middleware.RequestID, controller.RequestLogger(b.log.With("component", listener)), middleware.Recoverer)
+
+		// Every listener answers probes so they can be checked wherever the probe runs
+		probes := &controller.HealthHandler{}
+		probes.Init(router, b.health)
+
+		b.routers[listener] = router
+	}
+
+	api := b.routers[ListenerAPI]
+	auth := &controller.AuthHandler{}
+	auth.Init(api, b.users, b.sites, b.audit)
+
+	api.Group(func(r chi.Router) {
+		r.Use(auth.Authenticate)
+
+		h := &controller.HttpHandler{}
+		h.Init(r, b.sites, b.users, b.devices, b.audit, b.events, b.webhooks)
+
+		m := &controller.MetricsHandler{}
+		m.Init(r, b.devices, b.metrics)
 
-	h := &controller.HttpHandler{}
-	h.Init(b.h, b.configData, b.devices)
+		l := &controller.LogHandler{}
+		l.Init(r, b.log, b.audit)
 
-	unifi := &controller.UnifiHandler{}
-	unifi.Init(b.h, b.configData, b.devices)
+		d := &controller.DriverHandler{}
+		d.Init(r, b.drivers, b.devices, b.audit, b.events)
 
+		p := &controller.ProfileHandler{}
+		p.Init(r, b.sites, b.profiles)
+	})
+
+	redirect := &controller.RedirectHandler{}
+	redirect.Init(b.routers[ListenerAPIRedirect], b.apiPort)
+
+	firmware := &controller.FirmwareHandler{}
+	firmware.Init(b.routers[ListenerFirmware], b.firmwareDir())
+
+	b.initDrivers(auth.Authenticate)
 }
 
+// initDrivers lets every driver register its routes, drivers only get the listeners devices and the api are served on
+func (b *BeenFarService) initDrivers(authenticate func(http.Handler) http.Handler) {
+	routers := make(driver.Routers)
+	for _, listener := range []string{ListenerInform, ListenerPortal, ListenerFirmware} {
+		routers[listener] = b.routers[listener]
+	}
+	routers[driver.ListenerAgent] = b.routers[ListenerAPI]
+	// Driver routes on the api are authenticated like the rest of the api, routes for a device are in its site
+	b.routers[ListenerAPI].Group(func(r chi.Router) {
+		r.Use(authenticate, controller.DeviceSite(b.devices))
+		routers[ListenerAPI] = r
+	})
+
+	deps := driver.Deps{
+		Sites:          b.sites,
+		Devices:        b.devices,
+		Audit:          b.audit,
+		Events:         b.events,
+		Metrics:        b.metrics,
+		Secrets:        b.secrets,
+		DataDir:        b.dataDir,
+		InformInterval: b.informInterval,
+	}
+	for _, d := range b.drivers.List() {
+		name := d.Info().Name
+		deps.Logger = b.log.With("driver", name)
+		if err := d.Init(deps, routers); err != nil {
+			b.fatal("error initializing driver", "driver", name, "error", err)
+		}
+		if _, ok := d.(driver.Worker); ok {
+			b.health.Set(driverComponent(name), health.ErrNotStarted)
+		}
+	}
+}
+
+// Name of the directory in the data directory holding firmware images
+const firmwareDir = "firmware"
+
+func (b *BeenFarService) firmwareDir() string {
+	if b.dataDir == "" {
+		return ""
+	}
+	return filepath.Join(b.dataDir, firmwareDir)
+}
+
+// ServeHTTP serves the management api, use Handler for the other listeners
 func (b *BeenFarService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	b.h.ServeHTTP(w, r)
+	b.routers[ListenerAPI].ServeHTTP(w, r)
+}
+
+// Handler returns the router of a listener, nil for unknown listeners
+func (b *BeenFarService) Handler(listener string) http.Handler {
+	router, ok := b.routers[listener]
+	if !ok {
+		return nil
+	}
+	return router
+}
+
+// Run starts storage, the background workers including those of drivers and then the listeners, keyed by listener name.
+// Once ctx is done or a listener fails it stops them in reverse order: listeners drain their
+// in-flight requests, workers finish and storage saves the state last.
+func (b *BeenFarService) Run(ctx context.Context, listeners map[string]net.Listener) error {
+	for name := range listeners {
+		if b.Handler(name) == nil {
+			return fmt.Errorf("unknown listener %q", name)
+		}
+	}
+
+	stages := []Stage{
+		b.storageStage(),
+		WorkerStage(componentJanitor, b.janitor),
+		WorkerStage(componentWebhooks, b.dispatcher().Run),
+	}
+	for _, d := range b.drivers.List() {
+		if w, ok := d.(driver.Worker); ok {
+			stages = append(stages, WorkerStage(driverComponent(d.Info().Name), w.Run))
+		}
+	}
+	for _, name := range Listeners {
+		if l, ok := listeners[name]; ok {
+			stages = append(stages, ServerStage(listenerComponent(name), b.Handler(name), l))
+		}
+	}
+
+	lc := NewLifecycle(b.health, stages...)
+	lc.DrainTimeout = b.drainTimeout
+	lc.Logger = b.log
+	return lc.Run(ctx)
+}
+
+func (b *BeenFarService) dispatcher() *webhook.Dispatcher {
+	d := webhook.NewDispatcher(b.webhooks, b.events)
+	d.Logger = b.log.With("component", componentWebhooks)
+	return d
+}
+
+// fatal logs an error the service cannot start without and exits
+func (b *BeenFarService) fatal(msg string, kv ...any) {
+	b.log.Error(msg, kv...)
+	os.Exit(1)
+}
+
+// How often adopted devices check in unless set by WithInformInterval
+const DefaultInformInterval = 10 * time.Second
+
+// How often adopted devices are checked for having gone offline
+const janitorInterval = 10 * time.Second
+
+// Number of missed informs after which a device is offline
+const offlineAfterInforms = 6
+
+// janitor marks adopted devices that stopped checking in as offline
+func (b *BeenFarService) janitor(ctx context.Context) {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, mac := range b.devices.ExpireOffline(offlineAfterInforms * b.informInterval) {
+				b.log.ForDevice(mac).Info("device offline", "component", componentJanitor)
+				b.events.Publish(event.DeviceOffline, "device/"+mac, nil)
+			}
+		}
+	}
+}
+
+// Name of the file in the data directory holding the UniFi key
+const unifiKeyFile = "unifi.key"
+
+// loadUnifiKey reads the UniFi key from the data directory, a new key is generated and saved if there is none
+func (b *BeenFarService) loadUnifiKey() {
+	if len(b.unifiKey) != 0 {
+		return
+	}
+	b.unifiKey = b.loadKey(unifiKeyFile, 16)
+}
+
+// Name of the file in the data directory holding the key credentials are encrypted with
+const secretKeyFile = "secret.key"
+
+// loadSecretKey reads the key credentials are encrypted with from the data directory, a new key is generated and saved if there is none
+func (b *BeenFarService) loadSecretKey() {
+	var err error
+	if b.secrets, err = secret.NewBox(b.loadKey(secretKeyFile, secret.KeySize)); err != nil {
+		b.fatal("invalid secret key", "file", secretKeyFile, "error", err)
+	}
+}
+
+// loadKey reads a hex encoded key of size bytes from file in the data directory,
+// a new key is generated and saved if there is none
+func (b *BeenFarService) loadKey(file string, size int) []byte {
+	var path string
+	if b.dataDir != "" {
+		path = filepath.Join(b.dataDir, file)
+		if encoded, err := os.ReadFile(path); err == nil {
+			key, err := hex.DecodeString(strings.TrimSpace(string(encoded)))
+			if err != nil || len(key) != size {
+				b.fatal("invalid key", "file", path)
+			}
+			return key
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			b.fatal("error reading key", "file", path, "error", err)
+		}
+	}
+
+	key := make([]byte, size)
+	if _, err := rand.Read(key); err != nil {
+		b.fatal("error generating key", "file", file, "error", err)
+	}
+	if path == "" {
+		b.log.Warn("generated a key that will not be kept after a restart without a data directory", "file", file)
+		return key
+	}
+
+	if err := os.MkdirAll(b.dataDir, 0o700); err != nil {
+		b.fatal("error creating data directory", "error", err)
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+		b.fatal("error saving key", "file", path, "error", err)
+	}
+	b.log.Info("generated new key", "file", path)
+	return key
+}
+
+// Name of the file in the data directory a generated admin password is written to
+const adminPasswordFile = "admin.password"
+
+// bootstrap creates the admin user when there are no users, such as on first run.
+// A generated password is written to the data directory instead of the log.
+func (b *BeenFarService) bootstrap() {
+	if b.users.Len() != 0 {
+		return
+	}
+
+	password := b.adminPassword
+	if password == "" {
+		secret := make([]byte, 12)
+		if _, err := rand.Read(secret); err != nil {
+			b.fatal("error generating admin password", "error", err)
+		}
+		password = hex.EncodeToString(secret)
+	}
+
+	hash, err := model.HashPassword(password)
+	if err != nil {
+		b.fatal("error hashing admin password", "error", err)
+	}
+	if _, err := b.users.Add(BootstrapAdmin, hash, model.RoleAdmin, nil); err != nil {
+		b.fatal("error creating admin user", "user", BootstrapAdmin, "error", err)
+	}
+	// The admin is saved right away so its password stays valid even if the service does not stop cleanly
+	if err := b.storage.save(); err != nil {
+		b.fatal("error saving state", "error", err)
+	}
+	if b.adminPassword != "" {
+		return
+	}
+
+	if b.dataDir == "" {
+		b.log.Warn("generated an admin password that cannot be shown without a data directory, set the admin password instead", "user", BootstrapAdmin)
+		return
+	}
+	path := filepath.Join(b.dataDir, adminPasswordFile)
+	if err := os.MkdirAll(b.dataDir, 0o700); err != nil {
+		b.fatal("error creating data directory", "error", err)
+	}
+	if err := os.WriteFile(path, []byte(password+"\n"), 0o600); err != nil {
+		b.fatal("error saving admin password", "error", err)
+	}
+	b.log.Info("created admin user, its password is in the data directory", "user", BootstrapAdmin, "file", path)
 }
@@ -0,0 +1,236 @@
+// Package metrics exposes controller and device metrics in the Prometheus text format
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Content type of the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metric types
+const (
+	TypeCounter = "counter"
+	TypeGauge   = "gauge"
+)
+
+// A Label is a name and value pair attached to a sample
+type Label struct {
+	Name  string
+	Value string
+}
+
+// A Collector writes metrics computed at scrape time, such as gauges read from a store
+type Collector func(w *Writer)
+
+// Registry holds the counters of the controller and the collectors run on every scrape
+type Registry struct {
+	counters   []*CounterVec
+	collectors []Collector
+
+	mu sync.Mutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounter registers a counter with the given label names
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]*counterValue),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.counters = append(r.counters, c)
+	return c
+}
+
+// Register a collector
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteTo writes all metrics in the text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	counters := append([]*CounterVec(nil), r.counters...)
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	mw := &Writer{w: bufio.NewWriter(w)}
+	for _, c := range counters {
+		c.write(mw)
+	}
+	for _, c := range collectors {
+		c(mw)
+	}
+	if mw.err == nil {
+		mw.err = mw.w.Flush()
+	}
+	return mw.n, mw.err
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// CounterVec is a counter partitioned by label values
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	values map[string]*counterValue
+
+	mu sync.Mutex
+}
+
+// Inc adds one to the counter with the given label values
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds delta to the counter with the given label values, negative deltas are ignored
+func (c *CounterVec) Add(delta float64, values ...string) {
+	if delta < 0 || len(values) != len(c.labels) {
+		return
+	}
+
+	key := strings.Join(values, "\xff")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.values[key]
+	if !ok {
+		v = &counterValue{labels: append([]string(nil), values...)}
+		c.values[key] = v
+	}
+	v.value += delta
+}
+
+// Value returns the counter with the given label values
+func (c *CounterVec) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if v, ok := c.values[strings.Join(values, "\xff")]; ok {
+		return v.value
+	}
+	return 0
+}
+
+func (c *CounterVec) write(w *Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w.Family(c.name, c.help, TypeCounter)
+
+	// Counters without labels always have a sample so they start at zero
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		w.Sample(c.name, 0)
+		return
+	}
+
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := c.values[k]
+		labels := make([]Label, len(c.labels))
+		for i, name := range c.labels {
+			labels[i] = Label{Name: name, Value: v.labels[i]}
+		}
+		w.Sample(c.name, v.value, labels...)
+	}
+}
+
+// Writer writes metric families and samples, the first error stops all further writes
+type Writer struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+// Family writes the HELP and TYPE lines that precede the samples of a metric
+func (w *Writer) Family(name, help, typ string) {
+	w.write("# HELP " + name + " " + escapeHelp(help) + "\n")
+	w.write("# TYPE " + name + " " + typ + "\n")
+}
+
+// Sample writes a single sample
+func (w *Writer) Sample(name string, value float64, labels ...Label) {
+	var b strings.Builder
+
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(l.Name)
+			b.WriteString(`="`)
+			b.WriteString(escapeLabel(l.Value))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatValue(value))
+	b.WriteByte('\n')
+
+	w.write(b.String())
+}
+
+func (w *Writer) write(s string) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.w.WriteString(s)
+	w.n += int64(n)
+	w.err = err
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
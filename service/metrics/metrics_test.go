@@ -0,0 +1,49 @@
+package metrics_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service/metrics"
+)
+
+func TestRegistry(t *testing.T) {
+	var buf bytes.Buffer
+
+	r := metrics.NewRegistry()
+	informs := r.NewCounter("test_informs_total", "Informs received")
+	failures := r.NewCounter("test_failures_total", "Failures by reason", "reason")
+	failures.Inc("padding")
+	failures.Add(2, "authentication")
+	failures.Add(-1, "authentication")
+	failures.Inc("too", "many")
+
+	r.Register(func(w *metrics.Writer) {
+		w.Family("test_device_up", "Device \\ state\nup or down", metrics.TypeGauge)
+		w.Sample("test_device_up", 1, metrics.Label{Name: "name", Value: "a \"quoted\"\nname"})
+	})
+
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP test_informs_total Informs received
+# TYPE test_informs_total counter
+test_informs_total 0
+# HELP test_failures_total Failures by reason
+# TYPE test_failures_total counter
+test_failures_total{reason="authentication"} 2
+test_failures_total{reason="padding"} 1
+# HELP test_device_up Device \\ state\nup or down
+# TYPE test_device_up gauge
+test_device_up{name="a \"quoted\"\nname"} 1
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	informs.Inc()
+	if v := informs.Value(); v != 1 {
+		t.Errorf("Expected 1, got %v", v)
+	}
+}
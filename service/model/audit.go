@@ -0,0 +1,197 @@
+package model
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Value recorded in place of fields tagged audit:"secret"
+const AuditRedacted = "[redacted]"
+
+// An AuditEntry records a single change made through the api or by a device
+type AuditEntry struct {
+	ID       string        `jsonapi:"primary,audit" json:"id"`
+	Time     int64         `jsonapi:"attr,time" json:"time"`
+	Actor    string        `jsonapi:"attr,actor" json:"actor"`
+	Action   string        `jsonapi:"attr,action" json:"action"`
+	Target   string        `jsonapi:"attr,target" json:"target"`
+	SourceIP string        `jsonapi:"attr,source_ip,omitempty" json:"source_ip,omitempty"`
+	Changes  []AuditChange `jsonapi:"attr,changes,omitempty" json:"changes,omitempty"`
+}
+
+// An AuditChange is the before and after value of a single field
+type AuditChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before,omitempty"`
+	After  any    `json:"after,omitempty"`
+}
+
+// AuditFilter selects audit entries, zero values match everything
+type AuditFilter struct {
+	Since time.Time
+	Until time.Time
+	Actor string
+}
+
+func (f AuditFilter) matches(e AuditEntry) bool {
+	t := time.Unix(e.Time, 0)
+	if !f.Since.IsZero() && t.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && t.After(f.Until) {
+		return false
+	}
+	if f.Actor != "" && f.Actor != e.Actor {
+		return false
+	}
+	return true
+}
+
+// AuditLog is an append-only log of audit entries
+type AuditLog struct {
+	entries []AuditEntry
+
+	mu sync.RWMutex
+}
+
+func NewAuditLog() *AuditLog {
+	return &AuditLog{}
+}
+
+// MarshalJSON saves every entry of the log
+func (a *AuditLog) MarshalJSON() ([]byte, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return json.Marshal(a.entries)
+}
+
+// UnmarshalJSON replaces the log with saved entries
+func (a *AuditLog) UnmarshalJSON(data []byte) error {
+	var entries []AuditEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.entries = entries
+	return nil
+}
+
+// Append an entry to the log, the ID and time are filled in if empty
+func (a *AuditLog) Append(entry AuditEntry) (AuditEntry, error) {
+	if entry.ID == "" {
+		id, err := NewID()
+		if err != nil {
+			return AuditEntry{}, err
+		}
+		entry.ID = id
+	}
+	if entry.Time == 0 {
+		entry.Time = time.Now().Unix()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.entries = append(a.entries, entry)
+	return entry, nil
+}
+
+// Query returns the entries matching the filter, oldest first
+func (a *AuditLog) Query(filter AuditFilter) []AuditEntry {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var list []AuditEntry
+	for _, e := range a.entries {
+		if filter.matches(e) {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// AuditDiff compares the jsonapi fields of two values of the same struct type.
+// Either value may be nil for objects that were created or deleted.
+// Fields tagged audit:"secret" only record that they changed.
+func AuditDiff(before, after any) []AuditChange {
+	var (
+		changes []AuditChange
+		bv      = auditValue(before)
+		av      = auditValue(after)
+		t       reflect.Type
+	)
+
+	switch {
+	case bv.IsValid():
+		t = bv.Type()
+	case av.IsValid():
+		t = av.Type()
+	default:
+		return nil
+	}
+	if t.Kind() != reflect.Struct || (bv.IsValid() && av.IsValid() && bv.Type() != av.Type()) {
+		return nil
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		args := strings.Split(field.Tag.Get("jsonapi"), ",")
+		if len(args) < 2 || (args[0] != "attr" && args[0] != "primary") {
+			continue
+		}
+
+		var b, a any
+		if bv.IsValid() {
+			b = bv.Field(i).Interface()
+		}
+		if av.IsValid() {
+			a = av.Field(i).Interface()
+		}
+		if reflect.DeepEqual(b, a) {
+			continue
+		}
+		// Zero values of created or deleted objects are not worth recording
+		if !bv.IsValid() && av.Field(i).IsZero() || !av.IsValid() && bv.Field(i).IsZero() {
+			continue
+		}
+
+		name := args[1]
+		if args[0] == "primary" {
+			name = "id"
+		}
+		change := AuditChange{Field: name, Before: b, After: a}
+		if field.Tag.Get("audit") == "secret" {
+			change.Before = redactAudit(bv, i)
+			change.After = redactAudit(av, i)
+		}
+		changes = append(changes, change)
+	}
+
+	return changes
+}
+
+func auditValue(v any) reflect.Value {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return reflect.Value{}
+		}
+		rv = rv.Elem()
+	}
+	return rv
+}
+
+// redactAudit hides a secret field, empty values are left empty so unset secrets can be told apart
+func redactAudit(v reflect.Value, i int) any {
+	if !v.IsValid() || v.Field(i).IsZero() {
+		return nil
+	}
+	return AuditRedacted
+}
@@ -0,0 +1,73 @@
+package model_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+func TestAuditDiff(t *testing.T) {
+	before := model.WifiNetworkConfig{
+		ID:           "id",
+		Ssid:         "before",
+		SecurityType: model.WifiSecurityTypeWpaPersonal,
+		SecurityKey:  "password",
+	}
+	after := before
+	after.Ssid = "after"
+	after.SecurityKey = "newpassword"
+
+	changes := model.AuditDiff(before, &after)
+	expected := []model.AuditChange{
+		{Field: "ssid", Before: "before", After: "after"},
+		{Field: "security_key", Before: model.AuditRedacted, After: model.AuditRedacted},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, changes)
+	}
+
+	// Created objects only record fields that are set
+	changes = model.AuditDiff(nil, model.WifiNetworkConfig{ID: "id", Ssid: "created"})
+	expected = []model.AuditChange{
+		{Field: "id", After: "id"},
+		{Field: "ssid", After: "created"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, changes)
+	}
+
+	if changes = model.AuditDiff(before, before); len(changes) != 0 {
+		t.Errorf("Expected no changes, got %+v", changes)
+	}
+}
+
+func TestAuditLogQuery(t *testing.T) {
+	audit := model.NewAuditLog()
+	now := time.Now()
+
+	for i, actor := range []string{"alice", "bob", "alice"} {
+		_, err := audit.Append(model.AuditEntry{
+			Actor:  actor,
+			Action: "test",
+			Time:   now.Add(time.Duration(i) * time.Minute).Unix(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := len(audit.Query(model.AuditFilter{})); n != 3 {
+		t.Errorf("Expected 3 entries, got %d", n)
+	}
+	if n := len(audit.Query(model.AuditFilter{Actor: "alice"})); n != 2 {
+		t.Errorf("Expected 2 entries, got %d", n)
+	}
+	if n := len(audit.Query(model.AuditFilter{Since: now.Add(30 * time.Second)})); n != 2 {
+		t.Errorf("Expected 2 entries, got %d", n)
+	}
+	if n := len(audit.Query(model.AuditFilter{Actor: "alice", Until: now.Add(30 * time.Second)})); n != 1 {
+		t.Errorf("Expected 1 entry, got %d", n)
+	}
+}
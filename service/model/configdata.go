@@ -1,11 +1,945 @@
 package model
 
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrWifiNetworkNotFound = errors.New("wifi network not found")
+	ErrNetworkNotFound     = errors.New("network not found")
+)
+
 type ConfigData struct {
-	WifiNetworks map[string]WifiNetworkConfig `json:"wifi_networks"`
+	WifiNetworks   map[string]WifiNetworkConfig `json:"wifi_networks"`
+	Networks       map[string]NetworkConfig     `json:"networks"`
+	FirewallGroups map[string]FirewallGroup     `json:"firewall_groups"`
+	FirewallRules  map[string]FirewallRule      `json:"firewall_rules"`
+	PortForwards   map[string]PortForward       `json:"port_forwards"`
+	NATRules       map[string]NATRule           `json:"nat_rules"`
+	// DHCPReservations are stored by ID, each belongs to the network it names
+	DHCPReservations map[string]DHCPReservation `json:"dhcp_reservations"`
+
+	mu sync.RWMutex
 }
 
 func NewConfigData() *ConfigData {
 	return &ConfigData{
-		WifiNetworks: make(map[string]WifiNetworkConfig),
+		WifiNetworks:     make(map[string]WifiNetworkConfig),
+		Networks:         make(map[string]NetworkConfig),
+		FirewallGroups:   make(map[string]FirewallGroup),
+		FirewallRules:    make(map[string]FirewallRule),
+		PortForwards:     make(map[string]PortForward),
+		NATRules:         make(map[string]NATRule),
+		DHCPReservations: make(map[string]DHCPReservation),
+	}
+}
+
+// configFields are the fields of ConfigData, they are marshaled without its methods
+type configFields ConfigData
+
+// MarshalJSON saves the configuration
+func (c *ConfigData) MarshalJSON() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return json.Marshal((*configFields)(c))
+}
+
+// UnmarshalJSON replaces the configuration with a saved one
+func (c *ConfigData) UnmarshalJSON(data []byte) error {
+	saved := NewConfigData()
+	if err := json.Unmarshal(data, (*configFields)(saved)); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.WifiNetworks = saved.WifiNetworks
+	c.Networks = saved.Networks
+	c.FirewallGroups = saved.FirewallGroups
+	c.FirewallRules = saved.FirewallRules
+	c.PortForwards = saved.PortForwards
+	c.NATRules = saved.NATRules
+	c.DHCPReservations = saved.DHCPReservations
+	return nil
+}
+
+// Returns all wifi networks sorted by SSID
+func (c *ConfigData) WifiNetworkList() []WifiNetworkConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	list := make([]WifiNetworkConfig, 0, len(c.WifiNetworks))
+	for _, network := range c.WifiNetworks {
+		list = append(list, network)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Ssid < list[j].Ssid
+	})
+	return list
+}
+
+// Get a wifi network by ID
+func (c *ConfigData) GetWifiNetwork(id string) (WifiNetworkConfig, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	network, ok := c.WifiNetworks[id]
+	if !ok {
+		return WifiNetworkConfig{}, ErrWifiNetworkNotFound
+	}
+	return network, nil
+}
+
+// Get a wifi network by SSID
+func (c *ConfigData) GetWifiNetworkBySsid(ssid string) (WifiNetworkConfig, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, network := range c.WifiNetworks {
+		if network.Ssid == ssid {
+			return network, nil
+		}
+	}
+	return WifiNetworkConfig{}, ErrWifiNetworkNotFound
+}
+
+// Add a new wifi network and assign it an ID.
+// Returns ErrDuplicateSsid if another network already uses the SSID and
+// ErrNetworkNotFound if it is bridged into a network that does not exist.
+func (c *ConfigData) AddWifiNetwork(network WifiNetworkConfig) (WifiNetworkConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ssidInUse(network.Ssid, "") {
+		return WifiNetworkConfig{}, ErrDuplicateSsid
+	}
+	if !c.networkExists(network.Network) {
+		return WifiNetworkConfig{}, ErrNetworkNotFound
+	}
+
+	id, err := NewID()
+	if err != nil {
+		return WifiNetworkConfig{}, err
+	}
+	network.ID = id
+	c.WifiNetworks[id] = network
+	return network, nil
+}
+
+// Replace the wifi network with the given ID.
+// Returns ErrDuplicateSsid if the network is renamed to an SSID used by another network and
+// ErrNetworkNotFound if it is bridged into a network that does not exist.
+func (c *ConfigData) UpdateWifiNetwork(id string, network WifiNetworkConfig) (WifiNetworkConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.WifiNetworks[id]; !ok {
+		return WifiNetworkConfig{}, ErrWifiNetworkNotFound
+	}
+	if c.ssidInUse(network.Ssid, id) {
+		return WifiNetworkConfig{}, ErrDuplicateSsid
+	}
+	if !c.networkExists(network.Network) {
+		return WifiNetworkConfig{}, ErrNetworkNotFound
+	}
+
+	network.ID = id
+	c.WifiNetworks[id] = network
+	return network, nil
+}
+
+// Delete the wifi network with the given ID and return it
+func (c *ConfigData) DeleteWifiNetwork(id string) (WifiNetworkConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	network, ok := c.WifiNetworks[id]
+	if !ok {
+		return WifiNetworkConfig{}, ErrWifiNetworkNotFound
+	}
+	delete(c.WifiNetworks, id)
+	return network, nil
+}
+
+// Check if an SSID is used by any network other than the one with ID exclude
+func (c *ConfigData) ssidInUse(ssid, exclude string) bool {
+	for id, network := range c.WifiNetworks {
+		if id != exclude && network.Ssid == ssid {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns all networks sorted by name
+func (c *ConfigData) NetworkList() []NetworkConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	list := make([]NetworkConfig, 0, len(c.Networks))
+	for _, network := range c.Networks {
+		list = append(list, network)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Get a network by ID
+func (c *ConfigData) GetNetwork(id string) (NetworkConfig, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	network, ok := c.Networks[id]
+	if !ok {
+		return NetworkConfig{}, ErrNetworkNotFound
+	}
+	return network, nil
+}
+
+// Add a new network and assign it an ID.
+// Returns ErrDuplicateNetworkName or ErrDuplicateVlan if another network already uses the name or VLAN.
+func (c *ConfigData) AddNetwork(network NetworkConfig) (NetworkConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.networkConflict(network, ""); err != nil {
+		return NetworkConfig{}, err
+	}
+
+	id, err := NewID()
+	if err != nil {
+		return NetworkConfig{}, err
+	}
+	network.ID = id
+	c.Networks[id] = network
+	return network, nil
+}
+
+// Replace the network with the given ID.
+// Returns ErrDuplicateNetworkName or ErrDuplicateVlan if another network already uses the name or VLAN
+// ErrDHCPReservationConflict if a dhcp reservation of the network would no longer fit and
+// ErrAddressOutsideNetwork if the address of a port forward into the network would be outside of it.
+func (c *ConfigData) UpdateNetwork(id string, network NetworkConfig) (NetworkConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.Networks[id]; !ok {
+		return NetworkConfig{}, ErrNetworkNotFound
+	}
+	if err := c.networkConflict(network, id); err != nil {
+		return NetworkConfig{}, err
+	}
+	for _, reservation := range c.DHCPReservations {
+		if string(reservation.Network) != id {
+			continue
+		}
+		if err := reservation.fits(network); err != nil {
+			return NetworkConfig{}, fmt.Errorf("%w: %s: %v", ErrDHCPReservationConflict, reservation.IP, err)
+		}
+	}
+	for _, forward := range c.PortForwards {
+		if string(forward.Network) != id {
+			continue
+		}
+		if err := forward.fits(network); err != nil {
+			return NetworkConfig{}, fmt.Errorf("%w: port forward %s to %s", err, forward.Name, forward.ForwardAddress)
+		}
+	}
+
+	network.ID = id
+	c.Networks[id] = network
+	return network, nil
+}
+
+// Delete the network with the given ID and return it, its dhcp reservations are deleted with it.
+// Returns ErrNetworkInUse if a wifi network is bridged into it or a firewall rule, port forward or nat rule uses it.
+func (c *ConfigData) DeleteNetwork(id string) (NetworkConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	network, ok := c.Networks[id]
+	if !ok {
+		return NetworkConfig{}, ErrNetworkNotFound
+	}
+	for _, wifi := range c.WifiNetworks {
+		if string(wifi.Network) == id {
+			return NetworkConfig{}, ErrNetworkInUse
+		}
+	}
+	for _, rule := range c.FirewallRules {
+		if rule.Source == id || rule.Destination == id {
+			return NetworkConfig{}, ErrNetworkInUse
+		}
+	}
+	for _, forward := range c.PortForwards {
+		if string(forward.Network) == id {
+			return NetworkConfig{}, ErrNetworkInUse
+		}
+	}
+	for _, rule := range c.NATRules {
+		if string(rule.Network) == id {
+			return NetworkConfig{}, ErrNetworkInUse
+		}
+	}
+	for reservationID, reservation := range c.DHCPReservations {
+		if string(reservation.Network) == id {
+			delete(c.DHCPReservations, reservationID)
+		}
+	}
+	delete(c.Networks, id)
+	return network, nil
+}
+
+// Check if a network name or VLAN is used by any network other than the one with ID exclude
+func (c *ConfigData) networkConflict(network NetworkConfig, exclude string) error {
+	for id, other := range c.Networks {
+		switch {
+		case id == exclude:
+		case other.Name == network.Name:
+			return ErrDuplicateNetworkName
+		case other.Vlan == network.Vlan:
+			return ErrDuplicateVlan
+		}
+	}
+	return nil
+}
+
+// Check if a wifi network can be bridged into a network, an empty ID is the default network of the device
+func (c *ConfigData) networkExists(id NetworkID) bool {
+	if id == "" {
+		return true
+	}
+	_, ok := c.Networks[string(id)]
+	return ok
+}
+
+// Returns all firewall groups sorted by name
+func (c *ConfigData) FirewallGroupList() []FirewallGroup {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	list := make([]FirewallGroup, 0, len(c.FirewallGroups))
+	for _, group := range c.FirewallGroups {
+		list = append(list, group)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Get a firewall group by ID
+func (c *ConfigData) GetFirewallGroup(id string) (FirewallGroup, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	group, ok := c.FirewallGroups[id]
+	if !ok {
+		return FirewallGroup{}, ErrFirewallGroupNotFound
+	}
+	return group, nil
+}
+
+// Add a new firewall group and assign it an ID.
+// Returns ErrDuplicateFirewallGroupName if another group already uses the name.
+func (c *ConfigData) AddFirewallGroup(group FirewallGroup) (FirewallGroup, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.firewallGroupNameInUse(group.Name, "") {
+		return FirewallGroup{}, ErrDuplicateFirewallGroupName
+	}
+
+	id, err := NewID()
+	if err != nil {
+		return FirewallGroup{}, err
+	}
+	group.ID = id
+	c.FirewallGroups[id] = group
+	return group, nil
+}
+
+// Replace the firewall group with the given ID.
+// Returns ErrDuplicateFirewallGroupName if the group is renamed to a name used by another group and
+// ErrFirewallGroupInUse if the type of a group rules use is changed.
+func (c *ConfigData) UpdateFirewallGroup(id string, group FirewallGroup) (FirewallGroup, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current, ok := c.FirewallGroups[id]
+	if !ok {
+		return FirewallGroup{}, ErrFirewallGroupNotFound
+	}
+	if c.firewallGroupNameInUse(group.Name, id) {
+		return FirewallGroup{}, ErrDuplicateFirewallGroupName
+	}
+	if group.Type != current.Type && c.firewallGroupInUse(id) {
+		return FirewallGroup{}, ErrFirewallGroupInUse
+	}
+
+	group.ID = id
+	c.FirewallGroups[id] = group
+	return group, nil
+}
+
+// Delete the firewall group with the given ID and return it.
+// Returns ErrFirewallGroupInUse if a firewall rule uses it.
+func (c *ConfigData) DeleteFirewallGroup(id string) (FirewallGroup, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	group, ok := c.FirewallGroups[id]
+	if !ok {
+		return FirewallGroup{}, ErrFirewallGroupNotFound
+	}
+	if c.firewallGroupInUse(id) {
+		return FirewallGroup{}, ErrFirewallGroupInUse
+	}
+	delete(c.FirewallGroups, id)
+	return group, nil
+}
+
+// Check if a group name is used by any group other than the one with ID exclude
+func (c *ConfigData) firewallGroupNameInUse(name, exclude string) bool {
+	for id, group := range c.FirewallGroups {
+		if id != exclude && group.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if any firewall rule uses a group
+func (c *ConfigData) firewallGroupInUse(id string) bool {
+	for _, rule := range c.FirewallRules {
+		if rule.SourceGroup == id || rule.DestinationGroup == id || rule.DestinationPortGroup == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns all firewall rules in the order they are matched
+func (c *ConfigData) FirewallRuleList() []FirewallRule {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	list := make([]FirewallRule, 0, len(c.FirewallRules))
+	for _, rule := range c.FirewallRules {
+		list = append(list, rule)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Index < list[j].Index
+	})
+	return list
+}
+
+// Get a firewall rule by ID
+func (c *ConfigData) GetFirewallRule(id string) (FirewallRule, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	rule, ok := c.FirewallRules[id]
+	if !ok {
+		return FirewallRule{}, ErrFirewallRuleNotFound
+	}
+	return rule, nil
+}
+
+// Add a new firewall rule at its index and assign it an ID, the returned rule has its final index.
+// Returns ErrNetworkNotFound if a zone does not exist and ErrFirewallGroupNotFound or
+// ErrFirewallGroupType if a group does not exist or has the wrong type.
+func (c *ConfigData) AddFirewallRule(rule FirewallRule) (FirewallRule, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.checkFirewallRule(rule); err != nil {
+		return FirewallRule{}, err
+	}
+
+	id, err := NewID()
+	if err != nil {
+		return FirewallRule{}, err
+	}
+	rule.ID = id
+	c.FirewallRules[id] = rule
+	c.orderFirewallRules(id, rule.Index)
+	return c.FirewallRules[id], nil
+}
+
+// Replace the firewall rule with the given ID and move it to its index, 0 keeps its position.
+// Returns the same errors as AddFirewallRule.
+func (c *ConfigData) UpdateFirewallRule(id string, rule FirewallRule) (FirewallRule, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current, ok := c.FirewallRules[id]
+	if !ok {
+		return FirewallRule{}, ErrFirewallRuleNotFound
+	}
+	if err := c.checkFirewallRule(rule); err != nil {
+		return FirewallRule{}, err
+	}
+	if rule.Index == 0 {
+		rule.Index = current.Index
+	}
+
+	rule.ID = id
+	c.FirewallRules[id] = rule
+	c.orderFirewallRules(id, rule.Index)
+	return c.FirewallRules[id], nil
+}
+
+// Delete the firewall rule with the given ID and return it, the following rules move up
+func (c *ConfigData) DeleteFirewallRule(id string) (FirewallRule, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rule, ok := c.FirewallRules[id]
+	if !ok {
+		return FirewallRule{}, ErrFirewallRuleNotFound
+	}
+	delete(c.FirewallRules, id)
+	c.orderFirewallRules("", 0)
+	return rule, nil
+}
+
+// Check that the zones and groups of a rule exist
+func (c *ConfigData) checkFirewallRule(rule FirewallRule) error {
+	if !c.zoneExists(rule.Source) || !c.zoneExists(rule.Destination) {
+		return ErrNetworkNotFound
+	}
+	for _, group := range []struct{ id, kind string }{
+		{rule.SourceGroup, FirewallGroupAddress},
+		{rule.DestinationGroup, FirewallGroupAddress},
+		{rule.DestinationPortGroup, FirewallGroupPort},
+	} {
+		if group.id == "" {
+			continue
+		}
+		existing, ok := c.FirewallGroups[group.id]
+		if !ok {
+			return ErrFirewallGroupNotFound
+		}
+		if existing.Type != group.kind {
+			return ErrFirewallGroupType
+		}
+	}
+	return nil
+}
+
+// Check if a zone is the WAN, the router or an existing network
+func (c *ConfigData) zoneExists(zone string) bool {
+	if zone == ZoneWAN || zone == ZoneLocal {
+		return true
+	}
+	_, ok := c.Networks[zone]
+	return ok
+}
+
+// orderFirewallRules moves the rule with ID id to index and numbers the rules from 1
+func (c *ConfigData) orderFirewallRules(id string, index int) {
+	indexes := make(map[string]int, len(c.FirewallRules))
+	for ruleID, rule := range c.FirewallRules {
+		indexes[ruleID] = rule.Index
+	}
+	for ruleID, i := range reorder(indexes, id, index) {
+		rule := c.FirewallRules[ruleID]
+		rule.Index = i
+		c.FirewallRules[ruleID] = rule
+	}
+}
+
+// Returns all port forwards sorted by name
+func (c *ConfigData) PortForwardList() []PortForward {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	list := make([]PortForward, 0, len(c.PortForwards))
+	for _, forward := range c.PortForwards {
+		list = append(list, forward)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Get a port forward by ID
+func (c *ConfigData) GetPortForward(id string) (PortForward, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	forward, ok := c.PortForwards[id]
+	if !ok {
+		return PortForward{}, ErrPortForwardNotFound
+	}
+	return forward, nil
+}
+
+// Add a new port forward and assign it an ID.
+// Returns ErrNetworkNotFound if its network does not exist and ErrAddressOutsideNetwork if the
+// forward address is not inside the network.
+func (c *ConfigData) AddPortForward(forward PortForward) (PortForward, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.checkPortForward(forward); err != nil {
+		return PortForward{}, err
+	}
+
+	id, err := NewID()
+	if err != nil {
+		return PortForward{}, err
+	}
+	forward.ID = id
+	c.PortForwards[id] = forward
+	return forward, nil
+}
+
+// Replace the port forward with the given ID.
+// Returns the same errors as AddPortForward.
+func (c *ConfigData) UpdatePortForward(id string, forward PortForward) (PortForward, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.PortForwards[id]; !ok {
+		return PortForward{}, ErrPortForwardNotFound
+	}
+	if err := c.checkPortForward(forward); err != nil {
+		return PortForward{}, err
+	}
+
+	forward.ID = id
+	c.PortForwards[id] = forward
+	return forward, nil
+}
+
+// Delete the port forward with the given ID and return it
+func (c *ConfigData) DeletePortForward(id string) (PortForward, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	forward, ok := c.PortForwards[id]
+	if !ok {
+		return PortForward{}, ErrPortForwardNotFound
+	}
+	delete(c.PortForwards, id)
+	return forward, nil
+}
+
+// Check that the network of a port forward exists and contains the forward address
+func (c *ConfigData) checkPortForward(forward PortForward) error {
+	network, ok := c.Networks[string(forward.Network)]
+	if !ok {
+		return ErrNetworkNotFound
+	}
+	return forward.fits(network)
+}
+
+// Returns all nat rules in the order they are matched
+func (c *ConfigData) NATRuleList() []NATRule {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	list := make([]NATRule, 0, len(c.NATRules))
+	for _, rule := range c.NATRules {
+		list = append(list, rule)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Index < list[j].Index
+	})
+	return list
+}
+
+// Get a nat rule by ID
+func (c *ConfigData) GetNATRule(id string) (NATRule, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	rule, ok := c.NATRules[id]
+	if !ok {
+		return NATRule{}, ErrNATRuleNotFound
+	}
+	return rule, nil
+}
+
+// Add a new nat rule at its index and assign it an ID, the returned rule has its final index.
+// Returns ErrNetworkNotFound if its network does not exist.
+func (c *ConfigData) AddNATRule(rule NATRule) (NATRule, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.Networks[string(rule.Network)]; !ok {
+		return NATRule{}, ErrNetworkNotFound
+	}
+
+	id, err := NewID()
+	if err != nil {
+		return NATRule{}, err
+	}
+	rule.ID = id
+	c.NATRules[id] = rule
+	c.orderNATRules(id, rule.Index)
+	return c.NATRules[id], nil
+}
+
+// Replace the nat rule with the given ID and move it to its index, 0 keeps its position.
+// Returns ErrNetworkNotFound if its network does not exist.
+func (c *ConfigData) UpdateNATRule(id string, rule NATRule) (NATRule, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current, ok := c.NATRules[id]
+	if !ok {
+		return NATRule{}, ErrNATRuleNotFound
+	}
+	if _, ok := c.Networks[string(rule.Network)]; !ok {
+		return NATRule{}, ErrNetworkNotFound
+	}
+	if rule.Index == 0 {
+		rule.Index = current.Index
+	}
+
+	rule.ID = id
+	c.NATRules[id] = rule
+	c.orderNATRules(id, rule.Index)
+	return c.NATRules[id], nil
+}
+
+// Delete the nat rule with the given ID and return it, the following rules move up
+func (c *ConfigData) DeleteNATRule(id string) (NATRule, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rule, ok := c.NATRules[id]
+	if !ok {
+		return NATRule{}, ErrNATRuleNotFound
+	}
+	delete(c.NATRules, id)
+	c.orderNATRules("", 0)
+	return rule, nil
+}
+
+// orderNATRules moves the rule with ID id to index and numbers the rules from 1
+func (c *ConfigData) orderNATRules(id string, index int) {
+	indexes := make(map[string]int, len(c.NATRules))
+	for ruleID, rule := range c.NATRules {
+		indexes[ruleID] = rule.Index
+	}
+	for ruleID, i := range reorder(indexes, id, index) {
+		rule := c.NATRules[ruleID]
+		rule.Index = i
+		c.NATRules[ruleID] = rule
+	}
+}
+
+// reorder numbers the IDs in indexes from 1 in the order of their index, with id moved to index.
+// An index of 0 or past the end puts id last.
+func reorder(indexes map[string]int, id string, index int) map[string]int {
+	others := make([]string, 0, len(indexes))
+	for other := range indexes {
+		if other != id {
+			others = append(others, other)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		if indexes[others[i]] != indexes[others[j]] {
+			return indexes[others[i]] < indexes[others[j]]
+		}
+		return others[i] < others[j]
+	})
+
+	ordered := others
+	if _, ok := indexes[id]; ok {
+		if index <= 0 || index > len(others) {
+			index = len(others) + 1
+		}
+		ordered = make([]string, 0, len(others)+1)
+		ordered = append(ordered, others[:index-1]...)
+		ordered = append(ordered, id)
+		ordered = append(ordered, others[index-1:]...)
+	}
+
+	result := make(map[string]int, len(ordered))
+	for i, ruleID := range ordered {
+		result[ruleID] = i + 1
+	}
+	return result
+}
+
+// Returns the dhcp reservations of a network sorted by address
+func (c *ConfigData) DHCPReservationList(network NetworkID) []DHCPReservation {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	list := make([]DHCPReservation, 0)
+	for _, reservation := range c.DHCPReservations {
+		if reservation.Network == network {
+			list = append(list, reservation)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, _ := netip.ParseAddr(list[i].IP)
+		b, _ := netip.ParseAddr(list[j].IP)
+		return a.Less(b)
+	})
+	return list
+}
+
+// Get a dhcp reservation by ID
+func (c *ConfigData) GetDHCPReservation(id string) (DHCPReservation, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	reservation, ok := c.DHCPReservations[id]
+	if !ok {
+		return DHCPReservation{}, ErrDHCPReservationNotFound
+	}
+	return reservation, nil
+}
+
+// Add a new dhcp reservation and assign it an ID, the MAC address is stored in lower case with colons.
+// Returns ErrNetworkNotFound if the network does not exist, ErrDuplicateDHCPReservation if the client
+// or hostname already has a reservation in it, ErrDHCPAddressInUse if the address is taken and ErrDHCPNotServed,
+// ErrAddressOutsideNetwork or ErrDHCPReservationPolicy if the network can not hand out the address.
+func (c *ConfigData) AddDHCPReservation(reservation DHCPReservation) (DHCPReservation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	reservation.normalize()
+	if err := c.checkDHCPReservation(reservation, ""); err != nil {
+		return DHCPReservation{}, err
+	}
+	id, err := NewID()
+	if err != nil {
+		return DHCPReservation{}, err
+	}
+	reservation.ID = id
+	c.DHCPReservations[id] = reservation
+	return reservation, nil
+}
+
+// Replace the dhcp reservation with the given ID, it stays in its network.
+// Returns the same errors as AddDHCPReservation.
+func (c *ConfigData) UpdateDHCPReservation(id string, reservation DHCPReservation) (DHCPReservation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current, ok := c.DHCPReservations[id]
+	if !ok {
+		return DHCPReservation{}, ErrDHCPReservationNotFound
+	}
+	reservation.Network = current.Network
+	reservation.normalize()
+	if err := c.checkDHCPReservation(reservation, id); err != nil {
+		return DHCPReservation{}, err
+	}
+	reservation.ID = id
+	c.DHCPReservations[id] = reservation
+	return reservation, nil
+}
+
+// Delete the dhcp reservation with the given ID and return it
+func (c *ConfigData) DeleteDHCPReservation(id string) (DHCPReservation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	reservation, ok := c.DHCPReservations[id]
+	if !ok {
+		return DHCPReservation{}, ErrDHCPReservationNotFound
+	}
+	delete(c.DHCPReservations, id)
+	return reservation, nil
+}
+
+// NetworkLeases returns the leases inside the subnet of the network sorted by address, clients with a
+// reservation in the network are marked. Of leases reported for the same client the one expiring last is kept.
+func (c *ConfigData) NetworkLeases(id string, leases []DHCPLease) ([]DHCPLease, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	network, ok := c.Networks[id]
+	if !ok {
+		return nil, ErrNetworkNotFound
+	}
+	list := make([]DHCPLease, 0)
+	_, subnet, err := net.ParseCIDR(network.GatewayIPSubnet)
+	if err != nil {
+		return list, nil
+	}
+	reserved := make(map[string]bool)
+	for _, reservation := range c.DHCPReservations {
+		if string(reservation.Network) == id {
+			reserved[reservation.MAC] = true
+		}
+	}
+
+	byMAC := make(map[string]int)
+	for _, lease := range leases {
+		ip := net.ParseIP(lease.IP)
+		if ip == nil || !subnet.Contains(ip) {
+			continue
+		}
+		lease.MAC = strings.ToLower(lease.MAC)
+		lease.Reserved = reserved[lease.MAC]
+		if i, ok := byMAC[lease.MAC]; ok {
+			if lease.Expires > list[i].Expires {
+				list[i] = lease
+			}
+			continue
+		}
+		byMAC[lease.MAC] = len(list)
+		list = append(list, lease)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, _ := netip.ParseAddr(list[i].IP)
+		b, _ := netip.ParseAddr(list[j].IP)
+		return a.Less(b)
+	})
+	return list, nil
+}
+
+// Check that a reservation fits its network and no reservation other than exclude uses its MAC, address or hostname
+func (c *ConfigData) checkDHCPReservation(reservation DHCPReservation, exclude string) error {
+	network, ok := c.Networks[string(reservation.Network)]
+	if !ok {
+		return ErrNetworkNotFound
+	}
+	if err := reservation.fits(network); err != nil {
+		return err
+	}
+	for id, other := range c.DHCPReservations {
+		switch {
+		case id == exclude || other.Network != reservation.Network:
+		case other.MAC == reservation.MAC,
+			other.Hostname != "" && strings.EqualFold(other.Hostname, reservation.Hostname):
+			return ErrDuplicateDHCPReservation
+		case other.IP == reservation.IP:
+			return ErrDHCPAddressInUse
+		}
+	}
+	return nil
+}
+
+// NewID generates a random opaque identifier for configuration objects
+func NewID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
+	return hex.EncodeToString(b), nil
 }
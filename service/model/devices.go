@@ -2,7 +2,7 @@ package model
 
 import (
 	"errors"
-	"log"
+	"sync"
 	"time"
 )
 
@@ -21,7 +21,11 @@ func (d *Devices) Init() {
 
 }
 
-func (d *Devices) Adopt(mac string) error {
+// Adopt a pending device into a site
+func (d *Devices) Adopt(mac, site string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	if !d.Pending.Contains(mac) {
 		return ErrDeviceNotFound
 	}
@@ -36,12 +40,19 @@ func (d *Devices) Adopt(mac string) error {
 		return err
 	}
 
+	adopted.Site = site
+	if adopted.Info != nil {
+		adopted.Model = adopted.Info.Model
+	}
 	d.Adopted.Save(adopted)
 	d.Pending.Remove(mac)
 	return nil
 }
 
 func (d *Devices) Delete(mac string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	if !d.Adopted.Contains(mac) {
 		return ErrDeviceNotFound
 	}
@@ -55,9 +66,179 @@ func (d *Devices) Delete(mac string) error {
 	return nil
 }
 
+// Move an adopted device to another site, returns the site it was in
+func (d *Devices) Move(mac, site string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i := range d.Adopted {
+		if d.Adopted[i].GetMac() == mac {
+			from := d.Adopted[i].Site
+			d.Adopted[i].Site = site
+			return from, nil
+		}
+	}
+	return "", ErrDeviceNotFound
+}
+
+// InSite checks if any adopted device is in a site
+func (d *Devices) InSite(site string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for _, device := range d.Adopted {
+		if device.Site == site {
+			return true
+		}
+	}
+	return false
+}
+
+// Save a device requesting adoption, returns true if the device was not pending yet
+func (d *Devices) SavePending(device Device) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.Pending.Save(device)
+}
+
+// Get an adopted or pending device by MAC address
+func (d *Devices) Get(mac string) (Device, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if device := d.Adopted.Get(mac); device != nil {
+		return *device, nil
+	}
+	if device := d.Pending.Get(mac); device != nil {
+		return *device, nil
+	}
+	return Device{}, ErrDeviceNotFound
+}
+
+// Check if a device is adopted
+func (d *Devices) IsAdopted(mac string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.Adopted.Contains(mac)
+}
+
+// Seen records that an adopted device checked in, returns true if the device was offline
+func (d *Devices) Seen(mac string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i := range d.Adopted {
+		if d.Adopted[i].GetMac() == mac {
+			d.Adopted[i].Refresh()
+			wasOffline := !d.Adopted[i].Online
+			d.Adopted[i].Online = true
+			return wasOffline
+		}
+	}
+	return false
+}
+
+// ExpireOffline marks adopted devices that have not checked in within timeout as offline and returns their MACs
+func (d *Devices) ExpireOffline(timeout time.Duration) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var macs []string
+	for i := range d.Adopted {
+		if d.Adopted[i].Online && d.Adopted[i].IsExpired(timeout) {
+			d.Adopted[i].Online = false
+			macs = append(macs, d.Adopted[i].GetMac())
+		}
+	}
+	return macs
+}
+
+// UpdateStats records the latest statistics reported by an adopted device
+func (d *Devices) UpdateStats(mac string, stats DeviceStats) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i := range d.Adopted {
+		if d.Adopted[i].GetMac() == mac {
+			d.Adopted[i].Stats = &stats
+			return nil
+		}
+	}
+	return ErrDeviceNotFound
+}
+
+// UpdateInfo records what an adopted device reports about itself
+func (d *Devices) UpdateInfo(mac string, info DeviceInfo) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i := range d.Adopted {
+		if d.Adopted[i].GetMac() == mac {
+			d.Adopted[i].Info = &info
+			return nil
+		}
+	}
+	return ErrDeviceNotFound
+}
+
+// Leases returns the dhcp leases last reported by the adopted devices of a site, with the device set
+func (d *Devices) Leases(site string) []DHCPLease {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var leases []DHCPLease
+	for _, device := range d.Adopted {
+		if device.Site != site || device.Stats == nil {
+			continue
+		}
+		for _, lease := range device.Stats.Leases {
+			lease.Device = device.GetMac()
+			leases = append(leases, lease)
+		}
+	}
+	return leases
+}
+
+// Snapshot returns a copy of the device lists that is safe to read without locking
+func (d *Devices) Snapshot() *Devices {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	snapshot := &Devices{
+		Adopted: make(adoptedList, len(d.Adopted)),
+		Pending: make(pendingList, len(d.Pending)),
+	}
+	copy(snapshot.Adopted, d.Adopted)
+	copy(snapshot.Pending, d.Pending)
+	return snapshot
+}
+
+// SiteSnapshot is a Snapshot with only the adopted devices of a site, pending devices are in no
+// site yet so all of them are included
+func (d *Devices) SiteSnapshot(site string) *Devices {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	snapshot := &Devices{
+		Adopted: make(adoptedList, 0, len(d.Adopted)),
+		Pending: make(pendingList, len(d.Pending)),
+	}
+	for _, device := range d.Adopted {
+		if device.Site == site {
+			snapshot.Adopted = append(snapshot.Adopted, device)
+		}
+	}
+	copy(snapshot.Pending, d.Pending)
+	return snapshot
+}
+
 type Devices struct {
 	Adopted adoptedList `jsonapi:"attr,adopted,omitempty"`
 	Pending pendingList `jsonapi:"attr,pending,omitempty"`
+
+	mu sync.RWMutex
 }
 
 type adoptedList []Device
@@ -141,21 +322,25 @@ func (a *adoptedList) Save(device Device) {
 	}
 }
 
-// Save device to pending list
-func (p *pendingList) Save(device Device) {
+// Save device to pending list, returns true if the device was not pending yet.
+// The info of a device that is already pending is updated if the device reported any.
+func (p *pendingList) Save(device Device) bool {
 	device.Refresh()
 	found := false
-	for _, d := range *p {
+	for i, d := range *p {
 		if d.GetMac() == device.GetMac() {
 			found = true
+			if device.Info != nil {
+				(*p)[i].Info = device.Info
+			}
 			break
 		}
 	}
 
 	if !found {
-		log.Printf("New adoption request from %v", device.GetMac())
 		*p = append(*p, device)
 	}
+	return !found
 }
 
 type InterfaceDevice interface {
@@ -168,7 +353,73 @@ type InterfaceDevice interface {
 type Device struct {
 	Timestamp int64  `json:"timestamp"`
 	Mac       string `json:"mac"`
-	base      InterfaceDevice
+	Online    bool   `json:"online"`
+	// Driver is the name of the driver managing the device
+	Driver string `json:"driver"`
+	// Site is the ID of the site the device was adopted into, empty while pending
+	Site string `json:"site,omitempty"`
+	// Model is the model the device reported before it was adopted. Unlike the info it is not
+	// changed by the device checking in, drivers decide what to send the device by it.
+	Model string `json:"model,omitempty"`
+	// Info describes the hardware and firmware, nil if the driver can not tell
+	Info *DeviceInfo `json:"info,omitempty"`
+	// Stats are the statistics from the last check in, nil until the device reports any
+	Stats *DeviceStats `json:"stats,omitempty"`
+	base  InterfaceDevice
+}
+
+// DeviceInfo is what a device reports about itself
+type DeviceInfo struct {
+	Hostname string `json:"hostname,omitempty"`
+	Model    string `json:"model,omitempty"`
+	Firmware string `json:"firmware,omitempty"`
+}
+
+// DeviceStats are the statistics a device reports when it checks in
+type DeviceStats struct {
+	// Uptime in seconds
+	Uptime  int64 `json:"uptime"`
+	Clients int   `json:"clients"`
+	// CPU and Memory utilization as a ratio between 0 and 1
+	CPU    float64      `json:"cpu"`
+	Memory float64      `json:"memory"`
+	Ports  []PortStats  `json:"ports,omitempty"`
+	Radios []RadioStats `json:"radios,omitempty"`
+	// Interfaces are the logical interfaces of routers
+	Interfaces []InterfaceStats `json:"interfaces,omitempty"`
+	// Leases are the dhcp leases handed out by routers
+	Leases []DHCPLease `json:"leases,omitempty"`
+}
+
+// InterfaceStats is the state of a logical interface such as lan or wan
+type InterfaceStats struct {
+	Name string `json:"name"`
+	Up   bool   `json:"up"`
+	// Uptime in seconds
+	Uptime int64 `json:"uptime"`
+	// Address is the IPv4 address of the interface, routers report it for their WAN
+	Address string `json:"address,omitempty"`
+	RxBytes uint64 `json:"rx_bytes,omitempty"`
+	TxBytes uint64 `json:"tx_bytes,omitempty"`
+	// Throughput in bytes per second, averaged by the device
+	RxRate float64 `json:"rx_rate,omitempty"`
+	TxRate float64 `json:"tx_rate,omitempty"`
+}
+
+// PortStats are the counters of a wired port
+type PortStats struct {
+	Name    string `json:"name"`
+	Up      bool   `json:"up"`
+	RxBytes uint64 `json:"rx_bytes"`
+	TxBytes uint64 `json:"tx_bytes"`
+}
+
+// RadioStats are the counters of a wireless radio
+type RadioStats struct {
+	Name    string `json:"name"`
+	Clients int    `json:"clients"`
+	RxBytes uint64 `json:"rx_bytes"`
+	TxBytes uint64 `json:"tx_bytes"`
 }
 
 func (d *Device) Init(id InterfaceDevice) {
@@ -189,8 +440,8 @@ func (d Device) GetMac() string {
 	return d.Mac
 }
 
-func (d Device) IsExpired() bool {
-	return time.Now().Unix()-d.Timestamp > 60
+func (d Device) IsExpired(timeout time.Duration) bool {
+	return time.Now().Unix()-d.Timestamp > int64(timeout/time.Second)
 }
 
 func (d Device) Adopt() (Device, error) {
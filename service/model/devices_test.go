@@ -0,0 +1,71 @@
+package model_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+type testDevice string
+
+func (d testDevice) GetMac() string { return string(d) }
+func (d testDevice) Refresh()       {}
+func (d testDevice) Adopt() error   { return nil }
+func (d testDevice) Delete() error  { return nil }
+
+func TestDevicesOnline(t *testing.T) {
+	devices := model.NewDevices()
+
+	var d model.Device
+	d.Init(testDevice("deadbeef0000"))
+
+	if !devices.SavePending(d) {
+		t.Error("Expected the device to be newly pending")
+	}
+	if devices.SavePending(d) {
+		t.Error("Expected the device to already be pending")
+	}
+	if err := devices.Adopt("deadbeef0000", model.DefaultSite); err != nil {
+		t.Fatal(err)
+	}
+	if !devices.InSite(model.DefaultSite) {
+		t.Error("Expected the device to be adopted into the default site")
+	}
+
+	// Adopted devices are online once they check in
+	if !devices.Seen("deadbeef0000") {
+		t.Error("Expected the device to come online")
+	}
+	if devices.Seen("deadbeef0000") {
+		t.Error("Expected the device to already be online")
+	}
+	if macs := devices.ExpireOffline(time.Minute); len(macs) != 0 {
+		t.Errorf("Expected no devices to expire, got %v", macs)
+	}
+
+	// Stop checking in
+	devices.Adopted[0].Timestamp -= 120
+	if macs := devices.ExpireOffline(time.Minute); !reflect.DeepEqual(macs, []string{"deadbeef0000"}) {
+		t.Errorf("Expected deadbeef0000 to expire, got %v", macs)
+	}
+	if devices.Snapshot().Adopted[0].Online {
+		t.Error("Expected the device to be offline")
+	}
+	if !devices.Seen("deadbeef0000") {
+		t.Error("Expected the device to come back online")
+	}
+
+	// Moving a device takes it out of the devices of its old site
+	if from, err := devices.Move("deadbeef0000", "office"); err != nil || from != model.DefaultSite {
+		t.Errorf("Expected the device to move from the default site, got %q, %v", from, err)
+	}
+	if devices.InSite(model.DefaultSite) || len(devices.SiteSnapshot("office").Adopted) != 1 {
+		t.Error("Expected the device to be in the office site only")
+	}
+	if _, err := devices.Move("deadbeef0001", "office"); !errors.Is(err, model.ErrDeviceNotFound) {
+		t.Errorf("Expected %v moving an unknown device, got %v", model.ErrDeviceNotFound, err)
+	}
+}
@@ -0,0 +1,129 @@
+package model
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+)
+
+var (
+	ErrDHCPReservationNotFound = errors.New("dhcp reservation not found")
+	// ErrDuplicateDHCPReservation is returned when the client or hostname already has a reservation in the network
+	ErrDuplicateDHCPReservation = errors.New("duplicate dhcp reservation")
+	// ErrDHCPAddressInUse is returned for reserved addresses of the gateway or another reservation
+	ErrDHCPAddressInUse = errors.New("address is already in use")
+	// ErrDHCPNotServed is returned for reservations in networks without a dhcp server
+	ErrDHCPNotServed = errors.New("network has no dhcp server")
+	// ErrDHCPReservationPolicy is returned for reserved addresses the reservation policy of the network does not allow
+	ErrDHCPReservationPolicy = errors.New("reserved address does not follow the reservation policy")
+	// ErrDHCPReservationConflict is returned for network changes that leave a reservation unable to fit
+	ErrDHCPReservationConflict = errors.New("dhcp reservation does not fit the network")
+)
+
+// Policies of where reserved addresses sit relative to the dhcp range
+const (
+	// DHCPReservationOutsideRange keeps reserved addresses out of the range so they are never
+	// leased to other clients, it is the default
+	DHCPReservationOutsideRange = "outside"
+	// DHCPReservationInsideRange requires reserved addresses inside the range
+	DHCPReservationInsideRange = "inside"
+)
+
+// Maximum length of a reservation hostname, hostnames are a single dns label
+const MaxHostnameLength = 63
+
+// DHCPReservation hands a fixed address to a client of a network by its MAC address
+type DHCPReservation struct {
+	ID string `jsonapi:"primary,dhcp_reservation"`
+	// Network is set from the path the reservation is managed under
+	Network NetworkID `jsonapi:"attr,network"`
+	MAC     string    `jsonapi:"attr,mac"`
+	IP      string    `jsonapi:"attr,ip"`
+	// Hostname is handed to the client and resolved by routers forwarding dns, optional
+	Hostname string `jsonapi:"attr,hostname,omitempty"`
+}
+
+// Validate checks the reservation for values devices can not apply, whether it fits its network is
+// checked by ConfigData
+func (d DHCPReservation) Validate() error {
+	var errs ValidationErrors
+
+	if mac, err := net.ParseMAC(d.MAC); err != nil || len(mac) != 6 {
+		errs.Add("mac", "%q is not a MAC address such as 00:11:22:33:44:55", d.MAC)
+	}
+	if net.ParseIP(d.IP).To4() == nil {
+		errs.Add("ip", "%q is not an IPv4 address", d.IP)
+	}
+	if d.Hostname != "" && !validHostname(d.Hostname) {
+		errs.Add("hostname", "hostname must be at most %d letters, digits and hyphens, not starting or ending with a hyphen", MaxHostnameLength)
+	}
+
+	return errs.Err()
+}
+
+// normalize writes the MAC address in lower case with colons and the address without leading zeros
+func (d *DHCPReservation) normalize() {
+	if mac, err := net.ParseMAC(d.MAC); err == nil {
+		d.MAC = mac.String()
+	}
+	if ip := net.ParseIP(d.IP).To4(); ip != nil {
+		d.IP = ip.String()
+	}
+}
+
+// fits checks that the reserved address can be handed out in the network
+func (d DHCPReservation) fits(network NetworkConfig) error {
+	dhcp := network.DHCPConfig
+	if dhcp.DHCPMode != DHCPModeServer {
+		return ErrDHCPNotServed
+	}
+	gateway, subnet, err := net.ParseCIDR(network.GatewayIPSubnet)
+	if err != nil {
+		return ErrDHCPNotServed
+	}
+
+	ip := net.ParseIP(d.IP).To4()
+	broadcast := make(net.IP, len(subnet.IP))
+	for i := range subnet.IP {
+		broadcast[i] = subnet.IP[i] | ^subnet.Mask[i]
+	}
+	switch {
+	case ip == nil || !subnet.Contains(ip) || ip.Equal(subnet.IP) || ip.Equal(broadcast):
+		return ErrAddressOutsideNetwork
+	case ip.Equal(gateway):
+		return ErrDHCPAddressInUse
+	}
+
+	start, stop := net.ParseIP(dhcp.DHCPStart).To4(), net.ParseIP(dhcp.DHCPStop).To4()
+	inRange := bytes.Compare(ip, start) >= 0 && bytes.Compare(ip, stop) <= 0
+	if inRange != (dhcp.DHCPReservationPolicy == DHCPReservationInsideRange) {
+		return ErrDHCPReservationPolicy
+	}
+	return nil
+}
+
+// DHCPLease is an address a router handed out by dhcp
+type DHCPLease struct {
+	MAC      string `json:"mac" jsonapi:"primary,dhcp_lease"`
+	IP       string `json:"ip" jsonapi:"attr,ip"`
+	Hostname string `json:"hostname,omitempty" jsonapi:"attr,hostname,omitempty"`
+	// Expires is when the lease runs out in unix seconds, 0 if the router does not report it
+	Expires int64 `json:"expires,omitempty" jsonapi:"attr,expires,omitempty"`
+	// Device is the MAC of the router that reported the lease
+	Device string `json:"-" jsonapi:"attr,device"`
+	// Reserved is set when the client has a reservation in the network of the lease
+	Reserved bool `json:"-" jsonapi:"attr,reserved"`
+}
+
+func validHostname(name string) bool {
+	if len(name) == 0 || len(name) > MaxHostnameLength || strings.HasPrefix(name, "-") || strings.HasSuffix(name, "-") {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package model_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+func TestDHCPReservation(t *testing.T) {
+	cd := model.NewConfigData()
+	lan, err := cd.AddNetwork(model.NetworkConfig{
+		Name:            "lan",
+		GatewayIPSubnet: "192.168.1.1/24",
+		DHCPConfig: model.DHCPConfig{
+			DHCPMode:  model.DHCPModeServer,
+			DHCPStart: "192.168.1.100",
+			DHCPStop:  "192.168.1.199",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay, err := cd.AddNetwork(model.NetworkConfig{
+		Name:            "relay",
+		Vlan:            30,
+		GatewayIPSubnet: "10.0.30.1/24",
+		DHCPConfig:      model.DHCPConfig{DHCPMode: model.DHCPModeRelay, DHCPRelayServer: "192.168.1.2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := model.DHCPReservation{MAC: "00:11:22:33:44", IP: "192.168.1.500", Hostname: "-nas"}
+	if err := invalid.Validate(); err == nil || len(err.(model.ValidationErrors)) != 3 {
+		t.Errorf("Expected errors on mac, ip and hostname, got %v", err)
+	}
+
+	nas, err := cd.AddDHCPReservation(model.DHCPReservation{Network: model.NetworkID(lan.ID), MAC: "00-11-22-AA-BB-CC", IP: "192.168.1.10", Hostname: "nas"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nas.MAC != "00:11:22:aa:bb:cc" {
+		t.Errorf("Expected the MAC address in lower case with colons, got %s", nas.MAC)
+	}
+
+	for _, test := range []struct {
+		name        string
+		reservation model.DHCPReservation
+		err         error
+	}{
+		{"unknown network", model.DHCPReservation{Network: "000000000000000000000000", MAC: "00:11:22:33:44:55", IP: "192.168.1.11"}, model.ErrNetworkNotFound},
+		{"relayed network", model.DHCPReservation{Network: model.NetworkID(relay.ID), MAC: "00:11:22:33:44:55", IP: "10.0.30.11"}, model.ErrDHCPNotServed},
+		{"outside the subnet", model.DHCPReservation{Network: model.NetworkID(lan.ID), MAC: "00:11:22:33:44:55", IP: "192.168.2.11"}, model.ErrAddressOutsideNetwork},
+		{"broadcast address", model.DHCPReservation{Network: model.NetworkID(lan.ID), MAC: "00:11:22:33:44:55", IP: "192.168.1.255"}, model.ErrAddressOutsideNetwork},
+		{"gateway address", model.DHCPReservation{Network: model.NetworkID(lan.ID), MAC: "00:11:22:33:44:55", IP: "192.168.1.1"}, model.ErrDHCPAddressInUse},
+		{"inside the range", model.DHCPReservation{Network: model.NetworkID(lan.ID), MAC: "00:11:22:33:44:55", IP: "192.168.1.150"}, model.ErrDHCPReservationPolicy},
+		{"reserved address", model.DHCPReservation{Network: model.NetworkID(lan.ID), MAC: "00:11:22:33:44:55", IP: "192.168.1.10"}, model.ErrDHCPAddressInUse},
+		{"reserved client", model.DHCPReservation{Network: model.NetworkID(lan.ID), MAC: "00:11:22:aa:bb:cc", IP: "192.168.1.11"}, model.ErrDuplicateDHCPReservation},
+		{"reserved hostname", model.DHCPReservation{Network: model.NetworkID(lan.ID), MAC: "00:11:22:33:44:55", IP: "192.168.1.11", Hostname: "NAS"}, model.ErrDuplicateDHCPReservation},
+	} {
+		if _, err := cd.AddDHCPReservation(test.reservation); !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+
+	// The policy can require reserved addresses inside the range, but not while one is outside
+	inside := lan
+	inside.DHCPConfig.DHCPReservationPolicy = model.DHCPReservationInsideRange
+	if _, err := cd.UpdateNetwork(lan.ID, inside); !errors.Is(err, model.ErrDHCPReservationConflict) {
+		t.Errorf("Expected %v, got %v", model.ErrDHCPReservationConflict, err)
+	}
+	nas.IP = "192.168.1.150"
+	if _, err := cd.UpdateDHCPReservation(nas.ID, nas); !errors.Is(err, model.ErrDHCPReservationPolicy) {
+		t.Errorf("Expected %v, got %v", model.ErrDHCPReservationPolicy, err)
+	}
+
+	leases, err := cd.NetworkLeases(lan.ID, []model.DHCPLease{
+		{MAC: "00:11:22:AA:BB:CC", IP: "192.168.1.10", Expires: 100},
+		{MAC: "00:11:22:aa:bb:cc", IP: "192.168.1.10", Expires: 200},
+		{MAC: "00:11:22:33:44:55", IP: "192.168.1.120"},
+		{MAC: "00:11:22:33:44:66", IP: "10.0.30.10"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leases) != 2 || !leases[0].Reserved || leases[0].Expires != 200 || leases[1].Reserved {
+		t.Errorf("Expected the latest lease of the reserved client and one other lease, got %+v", leases)
+	}
+
+	// Reservations are sorted by address, not by its text
+	printer, err := cd.AddDHCPReservation(model.DHCPReservation{Network: model.NetworkID(lan.ID), MAC: "00:11:22:33:44:77", IP: "192.168.1.9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := cd.DHCPReservationList(model.NetworkID(lan.ID)); len(list) != 2 || list[0].ID != printer.ID || list[1].ID != nas.ID {
+		t.Errorf("Expected 192.168.1.9 before 192.168.1.10, got %+v", list)
+	}
+
+	if _, err := cd.DeleteNetwork(lan.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cd.GetDHCPReservation(nas.ID); !errors.Is(err, model.ErrDHCPReservationNotFound) {
+		t.Errorf("Expected the reservation to be deleted with its network, got %v", err)
+	}
+}
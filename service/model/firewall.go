@@ -0,0 +1,332 @@
+package model
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrFirewallGroupNotFound      = errors.New("firewall group not found")
+	ErrFirewallRuleNotFound       = errors.New("firewall rule not found")
+	ErrPortForwardNotFound        = errors.New("port forward not found")
+	ErrNATRuleNotFound            = errors.New("nat rule not found")
+	ErrDuplicateFirewallGroupName = errors.New("duplicate firewall group name")
+	ErrFirewallGroupInUse         = errors.New("firewall group in use")
+	// ErrFirewallGroupType is returned when a rule uses an address group as a port group or the other way around
+	ErrFirewallGroupType = errors.New("firewall group has the wrong type")
+	// ErrAddressOutsideNetwork is returned for port forwards to an address outside their network
+	ErrAddressOutsideNetwork = errors.New("address is outside the network")
+)
+
+// Zones firewall rules filter between besides networks, which are zones named by their ID
+const (
+	ZoneWAN = "wan"
+	// ZoneLocal is the router itself, it can only be a destination
+	ZoneLocal = "local"
+)
+
+// Actions of a firewall rule
+const (
+	FirewallActionAccept = "accept"
+	FirewallActionDrop   = "drop"
+	FirewallActionReject = "reject"
+)
+
+// Protocols rules and port forwards match, ports can only be matched for tcp and udp
+const (
+	ProtocolAll    = "all"
+	ProtocolTCP    = "tcp"
+	ProtocolUDP    = "udp"
+	ProtocolTCPUDP = "tcp_udp"
+	ProtocolICMP   = "icmp"
+)
+
+// Types of a firewall group
+const (
+	FirewallGroupAddress = "address"
+	FirewallGroupPort    = "port"
+)
+
+// Types of a nat rule
+const (
+	// NATMasquerade translates to the address of the WAN port
+	NATMasquerade = "masquerade"
+	// NATSource translates to TranslationAddress
+	NATSource = "source"
+)
+
+// Maximum length of a firewall group name, names are used as group names on devices
+const MaxFirewallGroupNameLength = 24
+
+// Maximum length of the name of a rule, port forward or nat rule, it is only used as a description
+const MaxFirewallNameLength = 64
+
+// Highest position of a firewall or nat rule, devices number rules from it
+const MaxFirewallRules = 999
+
+// FirewallGroup is a named list of addresses or ports rules can match
+type FirewallGroup struct {
+	ID   string `jsonapi:"primary,firewall_group"`
+	Name string `jsonapi:"attr,name"`
+	// Type is FirewallGroupAddress or FirewallGroupPort
+	Type string `jsonapi:"attr,type"`
+	// Members are IPv4 addresses or subnets for address groups, ports or ranges such as 8000-8080 for port groups
+	Members []string `jsonapi:"attr,members"`
+}
+
+// Validate checks the group for values devices can not apply
+func (g FirewallGroup) Validate() error {
+	var errs ValidationErrors
+
+	switch {
+	case len(g.Name) == 0:
+		errs.Add("name", "name must not be empty")
+	case len(g.Name) > MaxFirewallGroupNameLength:
+		errs.Add("name", "name must be at most %d characters, got %d", MaxFirewallGroupNameLength, len(g.Name))
+	case !validNetworkName(g.Name):
+		errs.Add("name", "name must start with a letter and only contain letters, digits and underscores")
+	}
+
+	if len(g.Members) == 0 {
+		errs.Add("members", "a group needs at least one member")
+	}
+	switch g.Type {
+	case FirewallGroupAddress:
+		for _, member := range g.Members {
+			if !validIPv4Address(member) {
+				errs.Add("members", "%q is not an IPv4 address or subnet", member)
+			}
+		}
+	case FirewallGroupPort:
+		for _, member := range g.Members {
+			if !validPortRange(member) {
+				errs.Add("members", "%q is not a port or port range", member)
+			}
+		}
+	default:
+		errs.Add("type", "type must be %s or %s", FirewallGroupAddress, FirewallGroupPort)
+	}
+
+	return errs.Err()
+}
+
+// FirewallRule accepts or blocks traffic from one zone to another
+type FirewallRule struct {
+	ID   string `jsonapi:"primary,firewall_rule"`
+	Name string `jsonapi:"attr,name"`
+	// Index is the position of the rule, the first matching rule applies. 0 appends a new rule,
+	// setting it inserts the rule at that position and moves the following rules down.
+	Index    int    `jsonapi:"attr,index"`
+	Disabled bool   `jsonapi:"attr,disabled"`
+	Action   string `jsonapi:"attr,action"`
+	Protocol string `jsonapi:"attr,protocol"`
+	// Source and Destination are ZoneWAN, a network ID or, for the destination only, ZoneLocal
+	Source      string `jsonapi:"attr,source"`
+	Destination string `jsonapi:"attr,destination"`
+	// Addresses narrow down the zones, either an IPv4 address or subnet or the ID of an address group
+	SourceAddress      string `jsonapi:"attr,source_address,omitempty"`
+	SourceGroup        string `jsonapi:"attr,source_group,omitempty"`
+	DestinationAddress string `jsonapi:"attr,destination_address,omitempty"`
+	DestinationGroup   string `jsonapi:"attr,destination_group,omitempty"`
+	// The destination port is a port or range or the ID of a port group, for tcp and udp only
+	DestinationPort      string `jsonapi:"attr,destination_port,omitempty"`
+	DestinationPortGroup string `jsonapi:"attr,destination_port_group,omitempty"`
+	Log                  bool   `jsonapi:"attr,log"`
+}
+
+// Validate checks the rule for values devices can not apply, the zones and groups are checked by ConfigData
+func (f FirewallRule) Validate() error {
+	var errs ValidationErrors
+
+	validateFirewallName(&errs, f.Name)
+	if f.Index < 0 || f.Index > MaxFirewallRules {
+		errs.Add("index", "index must be between 1 and %d or 0 to append, got %d", MaxFirewallRules, f.Index)
+	}
+	switch f.Action {
+	case FirewallActionAccept, FirewallActionDrop, FirewallActionReject:
+	default:
+		errs.Add("action", "action must be %s, %s or %s", FirewallActionAccept, FirewallActionDrop, FirewallActionReject)
+	}
+	ports := validateProtocol(&errs, f.Protocol)
+
+	switch {
+	case f.Source == "":
+		errs.Add("source", "source must not be empty")
+	case f.Source == ZoneLocal:
+		errs.Add("source", "traffic from the router itself can not be filtered")
+	}
+	switch {
+	case f.Destination == "":
+		errs.Add("destination", "destination must not be empty")
+	case f.Destination == f.Source:
+		errs.Add("destination", "destination must not be the same zone as the source")
+	}
+
+	if f.SourceAddress != "" && f.SourceGroup != "" {
+		errs.Add("source_address", "source_address and source_group can not both be set")
+	} else if f.SourceAddress != "" && !validIPv4Address(f.SourceAddress) {
+		errs.Add("source_address", "source_address must be an IPv4 address or subnet")
+	}
+	if f.DestinationAddress != "" && f.DestinationGroup != "" {
+		errs.Add("destination_address", "destination_address and destination_group can not both be set")
+	} else if f.DestinationAddress != "" && !validIPv4Address(f.DestinationAddress) {
+		errs.Add("destination_address", "destination_address must be an IPv4 address or subnet")
+	}
+
+	switch {
+	case f.DestinationPort == "" && f.DestinationPortGroup == "":
+	case !ports:
+		errs.Add("destination_port", "ports can only be matched for tcp and udp")
+	case f.DestinationPort != "" && f.DestinationPortGroup != "":
+		errs.Add("destination_port", "destination_port and destination_port_group can not both be set")
+	case f.DestinationPort != "" && !validPortRange(f.DestinationPort):
+		errs.Add("destination_port", "destination_port must be a port or port range")
+	}
+
+	return errs.Err()
+}
+
+// PortForward forwards a port of the WAN to an address inside a network
+type PortForward struct {
+	ID       string `jsonapi:"primary,port_forward"`
+	Name     string `jsonapi:"attr,name"`
+	Disabled bool   `jsonapi:"attr,disabled"`
+	// Protocol is tcp, udp or tcp_udp
+	Protocol string `jsonapi:"attr,protocol"`
+	// Port is the port or range opened on the WAN
+	Port string `jsonapi:"attr,port"`
+	// SourceAddress limits who may connect to an IPv4 address or subnet, empty allows everyone
+	SourceAddress string `jsonapi:"attr,source_address,omitempty"`
+	// Network the forward address is in
+	Network        NetworkID `jsonapi:"attr,network"`
+	ForwardAddress string    `jsonapi:"attr,forward_address"`
+	// ForwardPort is the port or range on the forward address, empty keeps Port
+	ForwardPort string `jsonapi:"attr,forward_port,omitempty"`
+	Log         bool   `jsonapi:"attr,log"`
+}
+
+// Validate checks the port forward for values devices can not apply, the network is checked by ConfigData
+func (p PortForward) Validate() error {
+	var errs ValidationErrors
+
+	validateFirewallName(&errs, p.Name)
+	if !validateProtocol(&errs, p.Protocol) {
+		errs.Add("protocol", "port forwards are for tcp and udp only")
+	}
+	if !validPortRange(p.Port) {
+		errs.Add("port", "port must be a port or port range")
+	}
+	if p.SourceAddress != "" && !validIPv4Address(p.SourceAddress) {
+		errs.Add("source_address", "source_address must be an IPv4 address or subnet")
+	}
+	if p.Network == "" {
+		errs.Add("network", "network must not be empty")
+	}
+	if net.ParseIP(p.ForwardAddress).To4() == nil {
+		errs.Add("forward_address", "forward_address must be an IPv4 address")
+	}
+	if p.ForwardPort != "" && !validPortRange(p.ForwardPort) {
+		errs.Add("forward_port", "forward_port must be a port or port range")
+	}
+
+	return errs.Err()
+}
+
+// fits checks that the forward address is in the network
+func (p PortForward) fits(network NetworkConfig) error {
+	_, subnet, err := net.ParseCIDR(network.GatewayIPSubnet)
+	if err != nil || !subnet.Contains(net.ParseIP(p.ForwardAddress)) {
+		return ErrAddressOutsideNetwork
+	}
+	return nil
+}
+
+// NATRule translates the source address of traffic from a network to the WAN
+type NATRule struct {
+	ID   string `jsonapi:"primary,nat_rule"`
+	Name string `jsonapi:"attr,name"`
+	// Index is the position of the rule, ordered the same as FirewallRule.Index
+	Index    int    `jsonapi:"attr,index"`
+	Disabled bool   `jsonapi:"attr,disabled"`
+	Type     string `jsonapi:"attr,type"`
+	// Network whose traffic is translated
+	Network NetworkID `jsonapi:"attr,network"`
+	// TranslationAddress is the IPv4 address NATSource rules translate to
+	TranslationAddress string `jsonapi:"attr,translation_address,omitempty"`
+}
+
+// Validate checks the nat rule for values devices can not apply, the network is checked by ConfigData
+func (n NATRule) Validate() error {
+	var errs ValidationErrors
+
+	validateFirewallName(&errs, n.Name)
+	if n.Index < 0 || n.Index > MaxFirewallRules {
+		errs.Add("index", "index must be between 1 and %d or 0 to append, got %d", MaxFirewallRules, n.Index)
+	}
+	if n.Network == "" {
+		errs.Add("network", "network must not be empty")
+	}
+	switch n.Type {
+	case NATMasquerade:
+		if n.TranslationAddress != "" {
+			errs.Add("translation_address", "masquerade rules translate to the address of the WAN")
+		}
+	case NATSource:
+		if net.ParseIP(n.TranslationAddress).To4() == nil {
+			errs.Add("translation_address", "translation_address must be an IPv4 address")
+		}
+	default:
+		errs.Add("type", "type must be %s or %s", NATMasquerade, NATSource)
+	}
+
+	return errs.Err()
+}
+
+func validateFirewallName(errs *ValidationErrors, name string) {
+	switch {
+	case len(name) == 0:
+		errs.Add("name", "name must not be empty")
+	case len(name) > MaxFirewallNameLength:
+		errs.Add("name", "name must be at most %d characters, got %d", MaxFirewallNameLength, len(name))
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		errs.Add("name", "name must not contain control characters")
+	}
+}
+
+// validateProtocol checks a protocol and returns whether ports can be matched for it
+func validateProtocol(errs *ValidationErrors, protocol string) bool {
+	switch protocol {
+	case ProtocolTCP, ProtocolUDP, ProtocolTCPUDP:
+		return true
+	case ProtocolAll, ProtocolICMP:
+		return false
+	}
+	errs.Add("protocol", "unknown protocol %q", protocol)
+	return true
+}
+
+// validIPv4Address checks for an IPv4 address or subnet
+func validIPv4Address(s string) bool {
+	if strings.Contains(s, "/") {
+		ip, _, err := net.ParseCIDR(s)
+		return err == nil && ip.To4() != nil
+	}
+	return net.ParseIP(s).To4() != nil
+}
+
+// validPortRange checks for a port or a range of ports such as 8000-8080
+func validPortRange(s string) bool {
+	first, last, isRange := strings.Cut(s, "-")
+	from, err := strconv.Atoi(first)
+	if err != nil || from < 1 || from > 65535 {
+		return false
+	}
+	if !isRange {
+		return true
+	}
+	to, err := strconv.Atoi(last)
+	return err == nil && to > from && to <= 65535
+}
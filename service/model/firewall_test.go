@@ -0,0 +1,159 @@
+package model_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+func TestFirewallValidate(t *testing.T) {
+	rule := model.FirewallRule{
+		Name:            "block iot",
+		Action:          model.FirewallActionDrop,
+		Protocol:        model.ProtocolTCP,
+		Source:          "000000000000000000000001",
+		Destination:     model.ZoneLocal,
+		DestinationPort: "22",
+	}
+	with := func(change func(r *model.FirewallRule)) model.FirewallRule {
+		r := rule
+		change(&r)
+		return r
+	}
+	tests := []struct {
+		name   string
+		object interface{ Validate() error }
+		fields []string
+	}{
+		{"valid rule", rule, nil},
+		{"rule from the router", with(func(r *model.FirewallRule) { r.Source, r.Destination = model.ZoneLocal, model.ZoneWAN }), []string{"source"}},
+		{"rule within a zone", with(func(r *model.FirewallRule) { r.Destination = r.Source }), []string{"destination"}},
+		{"port without tcp or udp", with(func(r *model.FirewallRule) { r.Protocol = model.ProtocolICMP }), []string{"destination_port"}},
+		{"reversed port range", with(func(r *model.FirewallRule) { r.DestinationPort = "90-80" }), []string{"destination_port"}},
+		{"address and group", with(func(r *model.FirewallRule) { r.SourceAddress = "10.0.0.0/8"; r.SourceGroup = "x" }), []string{"source_address"}},
+		{"bad action and index", with(func(r *model.FirewallRule) { r.Action = "allow"; r.Index = -1 }), []string{"index", "action"}},
+		{"valid group", model.FirewallGroup{Name: "servers", Type: model.FirewallGroupAddress, Members: []string{"10.0.0.5", "10.0.1.0/24"}}, nil},
+		{"port in address group", model.FirewallGroup{Name: "servers", Type: model.FirewallGroupAddress, Members: []string{"80"}}, []string{"members"}},
+		{"empty port group", model.FirewallGroup{Name: "web ports", Type: model.FirewallGroupPort}, []string{"name", "members"}},
+		{"valid forward", model.PortForward{Name: "web", Protocol: model.ProtocolTCP, Port: "443", Network: "n", ForwardAddress: "10.0.0.5", ForwardPort: "8443"}, nil},
+		{"forward of all protocols", model.PortForward{Name: "web", Protocol: model.ProtocolAll, Port: "0", Network: "n", ForwardAddress: "::1"}, []string{"protocol", "port", "forward_address"}},
+		{"valid source nat", model.NATRule{Name: "office", Type: model.NATSource, Network: "n", TranslationAddress: "203.0.113.9"}, nil},
+		{"masquerade to an address", model.NATRule{Name: "office", Type: model.NATMasquerade, Network: "n", TranslationAddress: "203.0.113.9"}, []string{"translation_address"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.object.Validate()
+			var fields []string
+			var errs model.ValidationErrors
+			if errors.As(err, &errs) {
+				for _, e := range errs {
+					fields = append(fields, e.Field)
+				}
+			} else if err != nil {
+				t.Fatalf("Expected validation errors, got %v", err)
+			}
+			if !reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("Expected errors on %v, got %v", test.fields, err)
+			}
+		})
+	}
+}
+
+func TestFirewallRuleOrder(t *testing.T) {
+	cd := model.NewConfigData()
+	lan, err := cd.AddNetwork(model.NetworkConfig{Name: "lan", GatewayIPSubnet: "192.168.1.1/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	add := func(name string, index int) model.FirewallRule {
+		t.Helper()
+		rule, err := cd.AddFirewallRule(model.FirewallRule{Name: name, Index: index, Action: model.FirewallActionAccept, Protocol: model.ProtocolAll, Source: lan.ID, Destination: model.ZoneWAN})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rule
+	}
+	names := func() []string {
+		var names []string
+		for i, rule := range cd.FirewallRuleList() {
+			if rule.Index != i+1 {
+				t.Errorf("Expected %s to have index %d, got %d", rule.Name, i+1, rule.Index)
+			}
+			names = append(names, rule.Name)
+		}
+		return names
+	}
+
+	a := add("a", 0)
+	add("b", 0)
+	if c := add("c", 1); c.Index != 1 {
+		t.Errorf("Expected the inserted rule to have index 1, got %d", c.Index)
+	}
+	if got := names(); !reflect.DeepEqual(got, []string{"c", "a", "b"}) {
+		t.Errorf("Expected rules c, a, b, got %v", got)
+	}
+
+	a.Index = 3
+	if _, err := cd.UpdateFirewallRule(a.ID, a); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(); !reflect.DeepEqual(got, []string{"c", "b", "a"}) {
+		t.Errorf("Expected rules c, b, a, got %v", got)
+	}
+	if _, err := cd.DeleteFirewallRule(cd.FirewallRuleList()[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(); !reflect.DeepEqual(got, []string{"b", "a"}) {
+		t.Errorf("Expected rules b, a, got %v", got)
+	}
+
+	// Rules only reference existing zones and groups of the right type
+	ports, err := cd.AddFirewallGroup(model.FirewallGroup{Name: "web", Type: model.FirewallGroupPort, Members: []string{"80", "443"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		rule model.FirewallRule
+		err  error
+	}{
+		{model.FirewallRule{Source: "000000000000000000000000", Destination: model.ZoneWAN}, model.ErrNetworkNotFound},
+		{model.FirewallRule{Source: lan.ID, Destination: model.ZoneWAN, SourceGroup: "000000000000000000000000"}, model.ErrFirewallGroupNotFound},
+		{model.FirewallRule{Source: lan.ID, Destination: model.ZoneWAN, SourceGroup: ports.ID}, model.ErrFirewallGroupType},
+	} {
+		if _, err := cd.AddFirewallRule(test.rule); !errors.Is(err, test.err) {
+			t.Errorf("Expected %v, got %v", test.err, err)
+		}
+	}
+
+	a.Index = 0
+	a.DestinationPortGroup = ports.ID
+	if _, err := cd.UpdateFirewallRule(a.ID, a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cd.DeleteFirewallGroup(ports.ID); !errors.Is(err, model.ErrFirewallGroupInUse) {
+		t.Errorf("Expected %v, got %v", model.ErrFirewallGroupInUse, err)
+	}
+	if _, err := cd.DeleteNetwork(lan.ID); !errors.Is(err, model.ErrNetworkInUse) {
+		t.Errorf("Expected %v, got %v", model.ErrNetworkInUse, err)
+	}
+
+	if _, err := cd.AddPortForward(model.PortForward{Name: "web", Network: model.NetworkID(lan.ID), ForwardAddress: "192.168.2.10"}); !errors.Is(err, model.ErrAddressOutsideNetwork) {
+		t.Errorf("Expected %v, got %v", model.ErrAddressOutsideNetwork, err)
+	}
+
+	// The network can not be moved away from its port forwards
+	if _, err := cd.AddPortForward(model.PortForward{Name: "web", Network: model.NetworkID(lan.ID), ForwardAddress: "192.168.1.10"}); err != nil {
+		t.Fatal(err)
+	}
+	moved := lan
+	moved.GatewayIPSubnet = "10.0.0.1/24"
+	if _, err := cd.UpdateNetwork(lan.ID, moved); !errors.Is(err, model.ErrAddressOutsideNetwork) {
+		t.Errorf("Expected %v, got %v", model.ErrAddressOutsideNetwork, err)
+	}
+	if network, err := cd.GetNetwork(lan.ID); err != nil || network.GatewayIPSubnet != lan.GatewayIPSubnet {
+		t.Errorf("Expected network to keep subnet %s, got %s (%v)", lan.GatewayIPSubnet, network.GatewayIPSubnet, err)
+	}
+}
@@ -0,0 +1,34 @@
+package model
+
+import (
+	"encoding/hex"
+
+	"github.com/jacobalberty/beenfar/service/logging"
+)
+
+// ID of the single LogSettings resource
+const LogSettingsID = "log"
+
+// LogSettings are the runtime logging settings
+type LogSettings struct {
+	ID    string `jsonapi:"primary,log_settings"`
+	Level string `jsonapi:"attr,level"`
+	// DebugDevices are logged at debug level whatever the level is, including their decoded informs
+	DebugDevices []string `jsonapi:"attr,debug_devices"`
+}
+
+// Validate checks the level is known and the debugged devices are MAC addresses
+func (s LogSettings) Validate() error {
+	var errs ValidationErrors
+
+	if _, err := logging.ParseLevel(s.Level); err != nil {
+		errs.Add("level", "%v", err)
+	}
+	for _, mac := range s.DebugDevices {
+		if b, err := hex.DecodeString(logging.NormalizeMac(mac)); err != nil || len(b) != 6 {
+			errs.Add("debug_devices", "invalid MAC address %q", mac)
+		}
+	}
+
+	return errs.Err()
+}
@@ -1,9 +1,19 @@
 package model
 
-import "errors"
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
 var (
-	ErrDuplicateSsid = errors.New("duplicate ssid")
+	ErrDuplicateSsid        = errors.New("duplicate ssid")
+	ErrDuplicateNetworkName = errors.New("duplicate network name")
+	ErrDuplicateVlan        = errors.New("duplicate vlan")
+	ErrNetworkInUse         = errors.New("network in use")
 )
 
 // Enum of supported WiFi security modes
@@ -11,6 +21,7 @@ type WifiSecurityType int
 
 const (
 	WifiSecurityTypeOpen WifiSecurityType = iota
+	// WEP keeps its number but is rejected by Validate on every band
 	WifiSecurityTypeWep
 	WifiSecurityTypeWpaPersonal
 	WifiSecurityTypeWpaEnterprise
@@ -31,13 +42,15 @@ type WifiUserGroup int
 
 type RadiusProfileID int
 
-type NetworkID int
+// NetworkID is the ID of a NetworkConfig
+type NetworkID string
 
 // This is the model for the WiFi configuration of an access point
 type WifiNetworkConfig struct {
-	Ssid             string           `jsonapi:"primary,ssid"`
+	ID               string           `jsonapi:"primary,wifi"`
+	Ssid             string           `jsonapi:"attr,ssid"`
 	SecurityType     WifiSecurityType `jsonapi:"attr,security_type"`
-	SecurityKey      string           `jsonapi:"attr,security_key,omitempty"`
+	SecurityKey      string           `jsonapi:"attr,security_key,omitempty" audit:"secret"`
 	Band             WifiBand         `jsonapi:"attr,band"`
 	Network          NetworkID        `jsonapi:"attr,network,omitempty"`
 	Guest            bool             `jsonapi:"attr,guest"`
@@ -47,6 +60,83 @@ type WifiNetworkConfig struct {
 	RadiusProfile    RadiusProfileID  `jsonapi:"attr,radius_profile,omitempty"`
 }
 
+// Maximum length of an SSID in bytes as defined by 802.11
+const MaxSsidLength = 32
+
+// Validate checks the wifi network for values the hardware can not apply
+func (w WifiNetworkConfig) Validate() error {
+	var errs ValidationErrors
+
+	switch {
+	case len(w.Ssid) == 0:
+		errs.Add("ssid", "ssid must not be empty")
+	case len(w.Ssid) > MaxSsidLength:
+		errs.Add("ssid", "ssid must be at most %d bytes, got %d", MaxSsidLength, len(w.Ssid))
+	case !utf8.ValidString(w.Ssid):
+		errs.Add("ssid", "ssid must be valid UTF-8")
+	case strings.IndexFunc(w.Ssid, unicode.IsControl) >= 0:
+		errs.Add("ssid", "ssid must not contain control characters")
+	}
+
+	switch w.SecurityType {
+	case WifiSecurityTypeOpen:
+		if w.SecurityKey != "" {
+			errs.Add("security_key", "open networks can not have a security key")
+		}
+	case WifiSecurityTypeWep:
+		// WEP keys are recovered within minutes and 802.11n and later radios only run it at legacy rates
+		errs.Add("security_type", "wep is not supported on any band, use wpa personal instead")
+	case WifiSecurityTypeWpaPersonal:
+		if !validWpaPassphrase(w.SecurityKey) {
+			errs.Add("security_key", "wpa passphrases must be 8 to 63 printable ASCII characters or 64 hex digits")
+		}
+	case WifiSecurityTypeWpaEnterprise:
+		if w.SecurityKey != "" {
+			errs.Add("security_key", "wpa enterprise networks authenticate against radius and can not have a security key")
+		}
+		if w.RadiusProfile == 0 {
+			errs.Add("radius_profile", "wpa enterprise networks require a radius profile")
+		}
+	default:
+		errs.Add("security_type", "unknown security type %d", w.SecurityType)
+	}
+
+	if w.Band < WifiBandBoth || w.Band > WifiBand5G {
+		errs.Add("band", "unknown band %d", w.Band)
+	}
+
+	return errs.Err()
+}
+
+func validWpaPassphrase(key string) bool {
+	switch {
+	case len(key) == 64:
+		return isHex(key)
+	case len(key) >= 8 && len(key) <= 63:
+		return isPrintableASCII(key)
+	}
+	return false
+}
+
+func isPrintableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
 type NetworkPurpose int
 
 const (
@@ -54,9 +144,13 @@ const (
 	NetworkPurposeGuest
 )
 
+// This is the model of a wired network, wifi networks are bridged into it by ID
 type NetworkConfig struct {
-	Name              string            `jsonapi:"attr,name"`
-	Purpose           NetworkPurpose    `jsonapi:"attr,purpose"`
+	ID      string         `jsonapi:"primary,network"`
+	Name    string         `jsonapi:"attr,name"`
+	Purpose NetworkPurpose `jsonapi:"attr,purpose"`
+	// VLAN tags the network on trunk ports, 0 leaves it untagged
+	Vlan              int               `jsonapi:"attr,vlan"`
 	Interface         int               `jsonapi:"attr,interface"`
 	GatewayIPSubnet   string            `jsonapi:"attr,gateway_ip_subnet"`
 	DomainName        string            `jsonapi:"attr,domain_name"`
@@ -64,12 +158,21 @@ type NetworkConfig struct {
 	IPV6NetworkConfig IPV6NetworkConfig `jsonapi:"attr,ipv6_network_config"`
 }
 
+// Nested structs are marshalled with their json tags and unmarshalled with their jsonapi tags, so they need both
+
 type DHCPConfig struct {
-	DHCPMode       DHCPMode       `jsonapi:"attr,dhcp_mode"`
-	DHCPRange      [2]string      `jsonapi:"attr,dhcp_range"`
-	DHCPNameServer DHCPNameServer `jsonapi:"attr,dhcp_name_server"`
-	DHCPLeaseTime  int            `jsonapi:"attr,dhcp_lease_time"`
-	DHCPGateway    DHCPGateway    `jsonapi:"attr,dhcp_gateway"`
+	DHCPMode DHCPMode `json:"dhcp_mode" jsonapi:"attr,dhcp_mode"`
+	// First and last address handed out, both inside the gateway subnet
+	DHCPStart      string         `json:"dhcp_start" jsonapi:"attr,dhcp_start"`
+	DHCPStop       string         `json:"dhcp_stop" jsonapi:"attr,dhcp_stop"`
+	DHCPNameServer DHCPNameServer `json:"dhcp_name_server" jsonapi:"attr,dhcp_name_server"`
+	// Lease time in seconds, 0 uses the default of the device
+	DHCPLeaseTime int         `json:"dhcp_lease_time" jsonapi:"attr,dhcp_lease_time"`
+	DHCPGateway   DHCPGateway `json:"dhcp_gateway" jsonapi:"attr,dhcp_gateway"`
+	// DHCPRelayServer is the IPv4 address requests are relayed to in relay mode
+	DHCPRelayServer string `json:"dhcp_relay_server,omitempty" jsonapi:"attr,dhcp_relay_server,omitempty"`
+	// DHCPReservationPolicy is DHCPReservationOutsideRange or DHCPReservationInsideRange, empty is outside
+	DHCPReservationPolicy string `json:"dhcp_reservation_policy,omitempty" jsonapi:"attr,dhcp_reservation_policy,omitempty"`
 }
 
 type DHCPMode int
@@ -81,23 +184,161 @@ const (
 )
 
 type DHCPNameServer struct {
-	Auto      bool     `jsonapi:"attr,auto"`
-	Addresses []string `jsonapi:"attr,addresses,omitempty"`
+	Auto      bool     `json:"auto" jsonapi:"attr,auto"`
+	Addresses []string `json:"addresses,omitempty" jsonapi:"attr,addresses,omitempty"`
 }
 
 type DHCPGateway struct {
-	Auto    bool   `jsonapi:"attr,auto"`
-	Address string `jsonapi:"attr,address,omitempty"`
+	Auto    bool   `json:"auto" jsonapi:"attr,auto"`
+	Address string `json:"address,omitempty" jsonapi:"attr,address,omitempty"`
 }
 
+// IPv6 types of a network
+const (
+	IPV6TypeNone = "none"
+	// IPV6TypePD assigns a /64 from the prefix delegated to the WAN port ethN, N is PrefixDelegationInterface
+	IPV6TypePD = "pd"
+)
+
+// Router preference announced in router advertisements
+const (
+	RAPriorityMedium = iota
+	RAPriorityHigh
+	RAPriorityLow
+)
+
+// Highest prefix ID, the delegated prefix is assumed to be at least a /56
+const MaxPrefixID = 255
+
 type IPV6NetworkConfig struct {
-	Type                      string   `jsonapi:"attr,type"`
-	PrefixDelegationInterface int      `jsonapi:"attr,prefix_delegation_interface"`
-	PrefixID                  int      `jsonapi:"attr,prefix_id"`
-	RAEnabled                 bool     `jsonapi:"attr,ra_enabled"`
-	RAPriority                int      `jsonapi:"attr,ra_priority"`
-	RAValidLifetime           int      `jsonapi:"attr,ra_valid_lifetime"`
-	RAPrefferedLifetime       int      `jsonapi:"attr,ra_preferred_lifetime"`
-	RDNSSControlAuto          bool     `jsonapi:"attr,rdnss_control_auto"`
-	RDNSSNameServers          []string `jsonapi:"attr,rdnss_name_servers,omitempty"`
+	// Type is IPV6TypeNone or IPV6TypePD, empty is IPV6TypeNone
+	Type                      string `json:"type" jsonapi:"attr,type"`
+	PrefixDelegationInterface int    `json:"prefix_delegation_interface" jsonapi:"attr,prefix_delegation_interface"`
+	PrefixID                  int    `json:"prefix_id" jsonapi:"attr,prefix_id"`
+	RAEnabled                 bool   `json:"ra_enabled" jsonapi:"attr,ra_enabled"`
+	RAPriority                int    `json:"ra_priority" jsonapi:"attr,ra_priority"`
+	// Lifetimes of the prefix in seconds, 0 uses the default of the device
+	RAValidLifetime     int      `json:"ra_valid_lifetime" jsonapi:"attr,ra_valid_lifetime"`
+	RAPrefferedLifetime int      `json:"ra_preferred_lifetime" jsonapi:"attr,ra_preferred_lifetime"`
+	RDNSSControlAuto    bool     `json:"rdnss_control_auto" jsonapi:"attr,rdnss_control_auto"`
+	RDNSSNameServers    []string `json:"rdnss_name_servers,omitempty" jsonapi:"attr,rdnss_name_servers,omitempty"`
+}
+
+// Maximum length of a network name, names are used in interface names on devices such as br-<name>
+const MaxNetworkNameLength = 12
+
+// Highest VLAN ID that can be used, 4095 is reserved
+const MaxVlan = 4094
+
+// Validate checks the network for values devices can not apply
+func (n NetworkConfig) Validate() error {
+	var errs ValidationErrors
+
+	switch {
+	case len(n.Name) == 0:
+		errs.Add("name", "name must not be empty")
+	case len(n.Name) > MaxNetworkNameLength:
+		errs.Add("name", "name must be at most %d characters, got %d", MaxNetworkNameLength, len(n.Name))
+	case !validNetworkName(n.Name):
+		errs.Add("name", "name must start with a letter and only contain letters, digits and underscores")
+	}
+
+	if n.Purpose < NetworkPurposeCorporate || n.Purpose > NetworkPurposeGuest {
+		errs.Add("purpose", "unknown purpose %d", n.Purpose)
+	}
+	if n.Vlan < 0 || n.Vlan == 1 || n.Vlan > MaxVlan {
+		errs.Add("vlan", "vlan must be 0 for untagged or between 2 and %d, got %d", MaxVlan, n.Vlan)
+	}
+
+	gateway, subnet, err := net.ParseCIDR(n.GatewayIPSubnet)
+	switch {
+	case err != nil || gateway.To4() == nil:
+		errs.Add("gateway_ip_subnet", "gateway_ip_subnet must be an IPv4 address with a prefix length such as 192.168.1.1/24")
+		subnet = nil
+	case gateway.Equal(subnet.IP):
+		errs.Add("gateway_ip_subnet", "gateway_ip_subnet must be a host address, not the network address %s", subnet.IP)
+	}
+
+	dhcp := n.DHCPConfig
+	switch dhcp.DHCPMode {
+	case DHCPModeDisabled:
+	case DHCPModeRelay:
+		if net.ParseIP(dhcp.DHCPRelayServer).To4() == nil {
+			errs.Add("dhcp_config", "dhcp_relay_server must be an IPv4 address")
+		}
+	case DHCPModeServer:
+		start, stop := net.ParseIP(dhcp.DHCPStart).To4(), net.ParseIP(dhcp.DHCPStop).To4()
+		switch {
+		case start == nil || stop == nil:
+			errs.Add("dhcp_config", "dhcp_start and dhcp_stop must be IPv4 addresses")
+		case subnet != nil && (!subnet.Contains(start) || !subnet.Contains(stop)):
+			errs.Add("dhcp_config", "dhcp range must be inside %s", subnet)
+		case bytes.Compare(start, stop) > 0:
+			errs.Add("dhcp_config", "dhcp_start must not be after dhcp_stop")
+		}
+		if dhcp.DHCPLeaseTime < 0 {
+			errs.Add("dhcp_config", "dhcp_lease_time must not be negative")
+		}
+		if !dhcp.DHCPNameServer.Auto {
+			for _, address := range dhcp.DHCPNameServer.Addresses {
+				if net.ParseIP(address) == nil {
+					errs.Add("dhcp_config", "name server %q is not an IP address", address)
+				}
+			}
+		}
+		if !dhcp.DHCPGateway.Auto && dhcp.DHCPGateway.Address != "" && net.ParseIP(dhcp.DHCPGateway.Address).To4() == nil {
+			errs.Add("dhcp_config", "gateway %q is not an IPv4 address", dhcp.DHCPGateway.Address)
+		}
+		switch dhcp.DHCPReservationPolicy {
+		case "", DHCPReservationOutsideRange, DHCPReservationInsideRange:
+		default:
+			errs.Add("dhcp_config", "dhcp_reservation_policy must be %s or %s", DHCPReservationOutsideRange, DHCPReservationInsideRange)
+		}
+	default:
+		errs.Add("dhcp_config", "unknown dhcp mode %d", dhcp.DHCPMode)
+	}
+
+	ipv6 := n.IPV6NetworkConfig
+	switch ipv6.Type {
+	case "", IPV6TypeNone:
+	case IPV6TypePD:
+		if ipv6.PrefixDelegationInterface < 0 {
+			errs.Add("ipv6_network_config", "prefix_delegation_interface must not be negative")
+		}
+		if ipv6.PrefixID < 0 || ipv6.PrefixID > MaxPrefixID {
+			errs.Add("ipv6_network_config", "prefix_id must be between 0 and %d, got %d", MaxPrefixID, ipv6.PrefixID)
+		}
+		if ipv6.RAPriority < RAPriorityMedium || ipv6.RAPriority > RAPriorityLow {
+			errs.Add("ipv6_network_config", "unknown ra_priority %d", ipv6.RAPriority)
+		}
+		if ipv6.RAValidLifetime < 0 || ipv6.RAPrefferedLifetime < 0 {
+			errs.Add("ipv6_network_config", "ra lifetimes must not be negative")
+		} else if ipv6.RAValidLifetime != 0 && ipv6.RAPrefferedLifetime > ipv6.RAValidLifetime {
+			errs.Add("ipv6_network_config", "ra_preferred_lifetime must not be longer than ra_valid_lifetime")
+		}
+		if !ipv6.RDNSSControlAuto {
+			for _, address := range ipv6.RDNSSNameServers {
+				if ip := net.ParseIP(address); ip == nil || ip.To4() != nil {
+					errs.Add("ipv6_network_config", "name server %q is not an IPv6 address", address)
+				}
+			}
+		}
+	default:
+		errs.Add("ipv6_network_config", "unknown ipv6 type %q", ipv6.Type)
+	}
+
+	return errs.Err()
+}
+
+func validNetworkName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case (c >= '0' && c <= '9' || c == '_') && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,295 @@
+package model_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+func TestWifiNetworkConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		wifi   model.WifiNetworkConfig
+		fields []string
+	}{
+		{
+			name: "open",
+			wifi: model.WifiNetworkConfig{Ssid: "open"},
+		},
+		{
+			name:   "empty ssid",
+			wifi:   model.WifiNetworkConfig{},
+			fields: []string{"ssid"},
+		},
+		{
+			name:   "long ssid",
+			wifi:   model.WifiNetworkConfig{Ssid: strings.Repeat("a", 33)},
+			fields: []string{"ssid"},
+		},
+		{
+			name: "utf-8 ssid",
+			wifi: model.WifiNetworkConfig{Ssid: strings.Repeat("📶", 8)},
+		},
+		{
+			name:   "long utf-8 ssid",
+			wifi:   model.WifiNetworkConfig{Ssid: strings.Repeat("📶", 9)},
+			fields: []string{"ssid"},
+		},
+		{
+			name:   "control characters",
+			wifi:   model.WifiNetworkConfig{Ssid: "tab\tseparated"},
+			fields: []string{"ssid"},
+		},
+		{
+			name:   "open with key",
+			wifi:   model.WifiNetworkConfig{Ssid: "open", SecurityKey: "password"},
+			fields: []string{"security_key"},
+		},
+		{
+			name: "wpa personal",
+			wifi: model.WifiNetworkConfig{Ssid: "wpa", SecurityType: model.WifiSecurityTypeWpaPersonal, SecurityKey: "password"},
+		},
+		{
+			name:   "wpa personal short key",
+			wifi:   model.WifiNetworkConfig{Ssid: "wpa", SecurityType: model.WifiSecurityTypeWpaPersonal, SecurityKey: "abc"},
+			fields: []string{"security_key"},
+		},
+		{
+			name: "wpa personal psk",
+			wifi: model.WifiNetworkConfig{Ssid: "wpa", SecurityType: model.WifiSecurityTypeWpaPersonal, SecurityKey: strings.Repeat("0f", 32)},
+		},
+		{
+			name:   "wpa personal bad psk",
+			wifi:   model.WifiNetworkConfig{Ssid: "wpa", SecurityType: model.WifiSecurityTypeWpaPersonal, SecurityKey: strings.Repeat("zz", 32)},
+			fields: []string{"security_key"},
+		},
+		{
+			name:   "wep on 2.4GHz",
+			wifi:   model.WifiNetworkConfig{Ssid: "wep", SecurityType: model.WifiSecurityTypeWep, SecurityKey: "0123456789", Band: model.WifiBand2G},
+			fields: []string{"security_type"},
+		},
+		{
+			name:   "wep on 5GHz",
+			wifi:   model.WifiNetworkConfig{Ssid: "wep", SecurityType: model.WifiSecurityTypeWep, SecurityKey: "abcde", Band: model.WifiBand5G},
+			fields: []string{"security_type"},
+		},
+		{
+			name:   "wep on both bands",
+			wifi:   model.WifiNetworkConfig{Ssid: "wep", SecurityType: model.WifiSecurityTypeWep, SecurityKey: "abcde"},
+			fields: []string{"security_type"},
+		},
+		{
+			name:   "wpa enterprise without radius",
+			wifi:   model.WifiNetworkConfig{Ssid: "eap", SecurityType: model.WifiSecurityTypeWpaEnterprise},
+			fields: []string{"radius_profile"},
+		},
+		{
+			name: "wpa enterprise",
+			wifi: model.WifiNetworkConfig{Ssid: "eap", SecurityType: model.WifiSecurityTypeWpaEnterprise, RadiusProfile: 1},
+		},
+		{
+			name:   "unknown band",
+			wifi:   model.WifiNetworkConfig{Ssid: "band", Band: 7},
+			fields: []string{"band"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var verrs model.ValidationErrors
+
+			err := tt.wifi.Validate()
+			if len(tt.fields) == 0 {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				return
+			}
+
+			if !errors.As(err, &verrs) {
+				t.Fatalf("Expected validation errors, got %v", err)
+			}
+			if len(verrs) != len(tt.fields) {
+				t.Fatalf("Expected %d errors, got %v", len(tt.fields), verrs)
+			}
+			for i, field := range tt.fields {
+				if verrs[i].Field != field {
+					t.Errorf("Expected error on %s, got %s", field, verrs[i].Field)
+				}
+			}
+		})
+	}
+}
+
+func TestNetworkConfigValidate(t *testing.T) {
+	valid := model.NetworkConfig{
+		Name:            "guest",
+		Vlan:            20,
+		GatewayIPSubnet: "10.0.20.1/24",
+		DHCPConfig: model.DHCPConfig{
+			DHCPMode:  model.DHCPModeServer,
+			DHCPStart: "10.0.20.10",
+			DHCPStop:  "10.0.20.50",
+		},
+	}
+	tests := []struct {
+		name   string
+		modify func(n *model.NetworkConfig)
+		fields []string
+	}{
+		{
+			name:   "valid",
+			modify: func(n *model.NetworkConfig) {},
+		},
+		{
+			name:   "untagged without dhcp",
+			modify: func(n *model.NetworkConfig) { n.Vlan = 0; n.DHCPConfig = model.DHCPConfig{} },
+		},
+		{
+			name:   "empty name",
+			modify: func(n *model.NetworkConfig) { n.Name = "" },
+			fields: []string{"name"},
+		},
+		{
+			name:   "long name",
+			modify: func(n *model.NetworkConfig) { n.Name = strings.Repeat("a", 13) },
+			fields: []string{"name"},
+		},
+		{
+			name:   "name with dash",
+			modify: func(n *model.NetworkConfig) { n.Name = "guest-net" },
+			fields: []string{"name"},
+		},
+		{
+			name:   "name starting with a digit",
+			modify: func(n *model.NetworkConfig) { n.Name = "2nd" },
+			fields: []string{"name"},
+		},
+		{
+			name:   "default vlan",
+			modify: func(n *model.NetworkConfig) { n.Vlan = 1 },
+			fields: []string{"vlan"},
+		},
+		{
+			name:   "reserved vlan",
+			modify: func(n *model.NetworkConfig) { n.Vlan = 4095 },
+			fields: []string{"vlan"},
+		},
+		{
+			name:   "subnet without prefix",
+			modify: func(n *model.NetworkConfig) { n.GatewayIPSubnet = "10.0.20.1" },
+			fields: []string{"gateway_ip_subnet"},
+		},
+		{
+			name:   "network address",
+			modify: func(n *model.NetworkConfig) { n.GatewayIPSubnet = "10.0.20.0/24" },
+			fields: []string{"gateway_ip_subnet"},
+		},
+		{
+			name:   "range outside subnet",
+			modify: func(n *model.NetworkConfig) { n.DHCPConfig.DHCPStop = "10.0.21.50" },
+			fields: []string{"dhcp_config"},
+		},
+		{
+			name: "reversed range",
+			modify: func(n *model.NetworkConfig) {
+				n.DHCPConfig.DHCPStart, n.DHCPConfig.DHCPStop = "10.0.20.50", "10.0.20.10"
+			},
+			fields: []string{"dhcp_config"},
+		},
+		{
+			name: "bad name server and gateway",
+			modify: func(n *model.NetworkConfig) {
+				n.DHCPConfig.DHCPNameServer.Addresses = []string{"dns.example"}
+				n.DHCPConfig.DHCPGateway.Address = "::1"
+			},
+			fields: []string{"dhcp_config", "dhcp_config"},
+		},
+		{
+			name:   "unknown dhcp mode",
+			modify: func(n *model.NetworkConfig) { n.DHCPConfig.DHCPMode = 7 },
+			fields: []string{"dhcp_config"},
+		},
+		{
+			name:   "unknown reservation policy",
+			modify: func(n *model.NetworkConfig) { n.DHCPConfig.DHCPReservationPolicy = "anywhere" },
+			fields: []string{"dhcp_config"},
+		},
+		{
+			name: "relay",
+			modify: func(n *model.NetworkConfig) {
+				n.DHCPConfig = model.DHCPConfig{DHCPMode: model.DHCPModeRelay, DHCPRelayServer: "10.0.0.2"}
+			},
+		},
+		{
+			name:   "relay without server",
+			modify: func(n *model.NetworkConfig) { n.DHCPConfig = model.DHCPConfig{DHCPMode: model.DHCPModeRelay} },
+			fields: []string{"dhcp_config"},
+		},
+		{
+			name: "prefix delegation",
+			modify: func(n *model.NetworkConfig) {
+				n.IPV6NetworkConfig = model.IPV6NetworkConfig{
+					Type:                model.IPV6TypePD,
+					PrefixID:            20,
+					RAEnabled:           true,
+					RAPriority:          model.RAPriorityHigh,
+					RAValidLifetime:     86400,
+					RAPrefferedLifetime: 14400,
+					RDNSSNameServers:    []string{"2606:4700:4700::1111"},
+				}
+			},
+		},
+		{
+			name: "bad prefix delegation",
+			modify: func(n *model.NetworkConfig) {
+				n.IPV6NetworkConfig = model.IPV6NetworkConfig{
+					Type:                model.IPV6TypePD,
+					PrefixID:            256,
+					RAPriority:          3,
+					RAValidLifetime:     3600,
+					RAPrefferedLifetime: 7200,
+					RDNSSNameServers:    []string{"1.1.1.1"},
+				}
+			},
+			fields: []string{"ipv6_network_config", "ipv6_network_config", "ipv6_network_config", "ipv6_network_config"},
+		},
+		{
+			name:   "unknown ipv6 type",
+			modify: func(n *model.NetworkConfig) { n.IPV6NetworkConfig.Type = "static" },
+			fields: []string{"ipv6_network_config"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var verrs model.ValidationErrors
+
+			network := valid
+			tt.modify(&network)
+			err := network.Validate()
+			if len(tt.fields) == 0 {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				return
+			}
+
+			if !errors.As(err, &verrs) {
+				t.Fatalf("Expected validation errors, got %v", err)
+			}
+			if len(verrs) != len(tt.fields) {
+				t.Fatalf("Expected %d errors, got %v", len(tt.fields), verrs)
+			}
+			for i, field := range tt.fields {
+				if verrs[i].Field != field {
+					t.Errorf("Expected error on %s, got %s", field, verrs[i].Field)
+				}
+			}
+		})
+	}
+}
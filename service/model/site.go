@@ -0,0 +1,214 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrSiteNotFound  = errors.New("site not found")
+	ErrDuplicateSite = errors.New("site already exists")
+	// ErrDefaultSite is returned when deleting the default site
+	ErrDefaultSite = errors.New("the default site can not be deleted")
+	// ErrSiteInUse is returned when deleting a site that still has devices
+	ErrSiteInUse = errors.New("site still has devices")
+	// ErrSiteHasUsers is returned when deleting the only site some users are limited to
+	ErrSiteHasUsers = errors.New("site is the only site of some users")
+)
+
+// ID of the site that always exists, the api outside of /api/site/{site} manages it
+const DefaultSite = "default"
+
+// Maximum length of a site ID and name
+const (
+	MaxSiteIDLength   = 32
+	MaxSiteNameLength = 64
+)
+
+var siteIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// A Site is a location with its own networks, devices and users, such as an office
+type Site struct {
+	// ID is chosen when the site is created as it is part of the api paths of the site
+	ID   string `jsonapi:"primary,site" json:"id"`
+	Name string `jsonapi:"attr,name" json:"name"`
+}
+
+// Validate checks the ID can be used in paths and the name is set
+func (s Site) Validate() error {
+	var errs ValidationErrors
+
+	if len(s.ID) > MaxSiteIDLength || !siteIDPattern.MatchString(s.ID) {
+		errs.Add("id", "id must be at most %d lower case letters, digits and hyphens, not starting with a hyphen", MaxSiteIDLength)
+	}
+	if s.Name == "" {
+		errs.Add("name", "name must not be empty")
+	} else if len(s.Name) > MaxSiteNameLength {
+		errs.Add("name", "name must be at most %d characters", MaxSiteNameLength)
+	}
+
+	return errs.Err()
+}
+
+// Sites holds every site along with its configuration
+type Sites struct {
+	sites   map[string]Site
+	configs map[string]*ConfigData
+
+	mu sync.RWMutex
+}
+
+// NewSites returns the sites of a new service, only the default site exists
+func NewSites() *Sites {
+	return &Sites{
+		sites:   map[string]Site{DefaultSite: {ID: DefaultSite, Name: "Default"}},
+		configs: map[string]*ConfigData{DefaultSite: NewConfigData()},
+	}
+}
+
+// savedSites is how sites are saved
+type savedSites struct {
+	Sites   map[string]Site        `json:"sites"`
+	Configs map[string]*ConfigData `json:"configs"`
+}
+
+// MarshalJSON saves every site along with its configuration
+func (s *Sites) MarshalJSON() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return json.Marshal(savedSites{Sites: s.sites, Configs: s.configs})
+}
+
+// UnmarshalJSON replaces the sites with saved ones, the default site always exists
+func (s *Sites) UnmarshalJSON(data []byte) error {
+	var saved savedSites
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sites = map[string]Site{DefaultSite: {ID: DefaultSite, Name: "Default"}}
+	s.configs = map[string]*ConfigData{DefaultSite: NewConfigData()}
+	for id, site := range saved.Sites {
+		s.sites[id] = site
+		s.configs[id] = NewConfigData()
+		if cd := saved.Configs[id]; cd != nil {
+			s.configs[id] = cd
+		}
+	}
+	return nil
+}
+
+// Returns all sites sorted by ID
+func (s *Sites) List() []Site {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Site, 0, len(s.sites))
+	for _, site := range s.sites {
+		list = append(list, site)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Get a site by ID
+func (s *Sites) Get(id string) (Site, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	site, ok := s.sites[id]
+	if !ok {
+		return Site{}, ErrSiteNotFound
+	}
+	return site, nil
+}
+
+// ConfigData returns the configuration of a site. Devices that are not adopted yet are in no
+// site, an empty ID returns the configuration of the default site.
+func (s *Sites) ConfigData(id string) (*ConfigData, error) {
+	if id == "" {
+		id = DefaultSite
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cd, ok := s.configs[id]
+	if !ok {
+		return nil, ErrSiteNotFound
+	}
+	return cd, nil
+}
+
+// Use runs f while the sites can not be deleted, such as to adopt a device into a site.
+// ErrSiteNotFound is returned without running f if any of the sites does not exist.
+func (s *Sites) Use(f func() error, ids ...string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, id := range ids {
+		if _, ok := s.sites[id]; !ok {
+			return ErrSiteNotFound
+		}
+	}
+	return f()
+}
+
+// Add a site with an empty configuration
+func (s *Sites) Add(site Site) (Site, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sites[site.ID]; ok {
+		return Site{}, ErrDuplicateSite
+	}
+	s.sites[site.ID] = site
+	s.configs[site.ID] = NewConfigData()
+	return site, nil
+}
+
+// Update the name of a site, IDs can not be changed
+func (s *Sites) Update(id string, site Site) (Site, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.sites[id]
+	if !ok {
+		return Site{}, ErrSiteNotFound
+	}
+	current.Name = site.Name
+	s.sites[id] = current
+	return current, nil
+}
+
+// Delete a site along with its configuration, the default site can not be deleted.
+// release is called before the site is deleted while no Use of it can run, such as to check that no
+// devices are left in it. The site is kept if release returns an error.
+func (s *Sites) Delete(id string, release func() error) (Site, error) {
+	if id == DefaultSite {
+		return Site{}, ErrDefaultSite
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	site, ok := s.sites[id]
+	if !ok {
+		return Site{}, ErrSiteNotFound
+	}
+	if err := release(); err != nil {
+		return Site{}, err
+	}
+	delete(s.sites, id)
+	delete(s.configs, id)
+	return site, nil
+}
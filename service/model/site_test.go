@@ -0,0 +1,62 @@
+package model_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+func TestSites(t *testing.T) {
+	sites := model.NewSites()
+	if _, err := sites.ConfigData(""); err != nil {
+		t.Errorf("Expected devices in no site to get the default configuration, got %v", err)
+	}
+
+	if err := (model.Site{ID: "-Office", Name: ""}).Validate(); err == nil || len(err.(model.ValidationErrors)) != 2 {
+		t.Errorf("Expected errors on id and name, got %v", err)
+	}
+	if _, err := sites.Add(model.Site{ID: "office", Name: "Office"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sites.Add(model.Site{ID: "office", Name: "Other office"}); !errors.Is(err, model.ErrDuplicateSite) {
+		t.Errorf("Expected %v, got %v", model.ErrDuplicateSite, err)
+	}
+
+	// Every site has its own configuration
+	office, err := sites.ConfigData("office")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := office.AddNetwork(model.NetworkConfig{Name: "lan", GatewayIPSubnet: "192.168.1.1/24"}); err != nil {
+		t.Fatal(err)
+	}
+	if cd, _ := sites.ConfigData(model.DefaultSite); len(cd.NetworkList()) != 0 {
+		t.Errorf("Expected the default site to have no networks, got %v", cd.NetworkList())
+	}
+
+	if list := sites.List(); len(list) != 2 || list[0].ID != model.DefaultSite || list[1].ID != "office" {
+		t.Errorf("Expected the default and office sites, got %v", list)
+	}
+	release := func() error { return nil }
+	if _, err := sites.Delete(model.DefaultSite, release); !errors.Is(err, model.ErrDefaultSite) {
+		t.Errorf("Expected %v, got %v", model.ErrDefaultSite, err)
+	}
+	if err := sites.Use(func() error { return nil }, "office", "branch"); !errors.Is(err, model.ErrSiteNotFound) {
+		t.Errorf("Expected %v, got %v", model.ErrSiteNotFound, err)
+	}
+
+	// Sites are kept when they can not be released
+	if _, err := sites.Delete("office", func() error { return model.ErrSiteInUse }); !errors.Is(err, model.ErrSiteInUse) {
+		t.Errorf("Expected %v, got %v", model.ErrSiteInUse, err)
+	}
+	if _, err := sites.Get("office"); err != nil {
+		t.Errorf("Expected the office site to be kept, got %v", err)
+	}
+	if _, err := sites.Delete("office", release); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sites.ConfigData("office"); !errors.Is(err, model.ErrSiteNotFound) {
+		t.Errorf("Expected %v, got %v", model.ErrSiteNotFound, err)
+	}
+}
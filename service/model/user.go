@@ -0,0 +1,492 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUserExists         = errors.New("user already exists")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrSessionNotFound    = errors.New("session not found or expired")
+	ErrTokenNotFound      = errors.New("api token not found")
+	ErrInvalidRole        = errors.New("invalid role")
+	ErrLastAdmin          = errors.New("at least one admin is required")
+)
+
+// Enum of user roles, each role includes the permissions of the roles before it
+type Role int
+
+const (
+	RoleReadOnly Role = iota
+	RoleOperator
+	RoleAdmin
+)
+
+// Allows checks if the role includes the permissions of required
+func (r Role) Allows(required Role) bool {
+	return r >= required
+}
+
+func (r Role) valid() bool {
+	return r >= RoleReadOnly && r <= RoleAdmin
+}
+
+// How long a login session stays valid
+const SessionLifetime = 24 * time.Hour
+
+// A User can log in to the management api
+type User struct {
+	ID       string `jsonapi:"primary,user" json:"id"`
+	Username string `jsonapi:"attr,username" json:"username"`
+	Role     Role   `jsonapi:"attr,role" json:"role"`
+	// Sites are the IDs of the sites the user is limited to, the user has its role in every site if empty
+	Sites []string `jsonapi:"attr,sites,omitempty" json:"sites,omitempty"`
+	// Password is only read from requests, it is never stored or returned
+	Password string `jsonapi:"attr,password,omitempty" json:"-" audit:"secret"`
+	// CurrentPassword is only read from requests, users changing their own password have to give it
+	CurrentPassword string `jsonapi:"attr,current_password,omitempty" json:"-" audit:"secret"`
+	PasswordHash    []byte `json:"password_hash"`
+}
+
+// InSite checks if the user has its role in a site
+func (u User) InSite(site string) bool {
+	if len(u.Sites) == 0 {
+		return true
+	}
+	for _, s := range u.Sites {
+		if s == site {
+			return true
+		}
+	}
+	return false
+}
+
+// An APIToken authenticates automation as the user that created it
+type APIToken struct {
+	ID      string `jsonapi:"primary,token" json:"id"`
+	Name    string `jsonapi:"attr,name" json:"name"`
+	Created int64  `jsonapi:"attr,created" json:"created"`
+	// Token is the secret, it is only returned when the token is created
+	Token  string `jsonapi:"attr,token,omitempty" json:"-" audit:"secret"`
+	UserID string `json:"user_id"`
+	Hash   string `json:"hash"`
+}
+
+type session struct {
+	userID  string
+	expires time.Time
+}
+
+// Users is the local user store along with the sessions and api tokens of those users
+type Users struct {
+	users    map[string]User
+	sessions map[string]session
+	tokens   map[string]APIToken
+
+	mu sync.RWMutex
+}
+
+func NewUsers() *Users {
+	return &Users{
+		users:    make(map[string]User),
+		sessions: make(map[string]session),
+		tokens:   make(map[string]APIToken),
+	}
+}
+
+// savedUsers is how users are saved, sessions are not
+type savedUsers struct {
+	Users  map[string]User     `json:"users"`
+	Tokens map[string]APIToken `json:"tokens"`
+}
+
+// MarshalJSON saves the users along with their password hashes and api tokens, sessions are not saved
+func (u *Users) MarshalJSON() ([]byte, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	return json.Marshal(savedUsers{Users: u.users, Tokens: u.tokens})
+}
+
+// UnmarshalJSON replaces the users and api tokens with saved ones
+func (u *Users) UnmarshalJSON(data []byte) error {
+	saved := savedUsers{Users: make(map[string]User), Tokens: make(map[string]APIToken)}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.users = saved.Users
+	u.tokens = saved.Tokens
+	return nil
+}
+
+// Returns the number of users
+func (u *Users) Len() int {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	return len(u.users)
+}
+
+// Returns all users sorted by username
+func (u *Users) List() []User {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	list := make([]User, 0, len(u.users))
+	for _, user := range u.users {
+		list = append(list, user)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Username < list[j].Username
+	})
+	return list
+}
+
+// HashPassword hashes a password with bcrypt. It is slow on purpose, hash passwords before taking any locks.
+func HashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+// Add a new user with a password hashed by HashPassword, limited to sites or in every site if empty
+func (u *Users) Add(username string, hash []byte, role Role, sites []string) (User, error) {
+	if !role.valid() {
+		return User{}, ErrInvalidRole
+	}
+
+	id, err := NewID()
+	if err != nil {
+		return User{}, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if _, ok := u.byUsername(username); ok {
+		return User{}, ErrUserExists
+	}
+
+	user := User{
+		ID:           id,
+		Username:     username,
+		Role:         role,
+		Sites:        sites,
+		PasswordHash: hash,
+	}
+	u.users[id] = user
+	return user, nil
+}
+
+// Get a user by ID
+func (u *Users) Get(id string) (User, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	user, ok := u.users[id]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// GetByUsername returns the user with the given username
+func (u *Users) GetByUsername(username string) (User, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	user, ok := u.byUsername(username)
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// Update changes the role and sites of a user and, unless hash is nil, the password hashed by HashPassword.
+// Nothing is changed if any change is invalid. A new password ends the sessions and revokes the api tokens
+// of the user. The last admin of every site can not be demoted or limited.
+func (u *Users) Update(id string, role Role, sites []string, hash []byte) (User, error) {
+	if !role.valid() {
+		return User{}, ErrInvalidRole
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	user, ok := u.users[id]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	if user.Role == RoleAdmin && len(user.Sites) == 0 && (role != RoleAdmin || len(sites) != 0) && u.admins() == 1 {
+		return User{}, ErrLastAdmin
+	}
+
+	user.Role = role
+	user.Sites = sites
+	if hash != nil {
+		user.PasswordHash = hash
+		u.revoke(id)
+	}
+	u.users[id] = user
+	return user, nil
+}
+
+// RemoveSite removes a deleted site from the sites of every user. Users would have every site once their
+// last site is removed, so nothing is removed and ErrSiteHasUsers is returned if the site is the only site of a user.
+func (u *Users) RemoveSite(site string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	remaining := make(map[string][]string)
+	for id, user := range u.users {
+		if len(user.Sites) == 0 || !user.InSite(site) {
+			continue
+		}
+		var sites []string
+		for _, s := range user.Sites {
+			if s != site {
+				sites = append(sites, s)
+			}
+		}
+		if len(sites) == 0 {
+			return ErrSiteHasUsers
+		}
+		remaining[id] = sites
+	}
+
+	for id, sites := range remaining {
+		user := u.users[id]
+		user.Sites = sites
+		u.users[id] = user
+	}
+	return nil
+}
+
+// Delete a user along with their sessions and api tokens, the last admin can not be deleted
+func (u *Users) Delete(id string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	user, ok := u.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if user.Role == RoleAdmin && len(user.Sites) == 0 && u.admins() == 1 {
+		return ErrLastAdmin
+	}
+
+	delete(u.users, id)
+	u.revoke(id)
+	return nil
+}
+
+// Authenticate checks a username and password and returns the matching user
+func (u *Users) Authenticate(username, password string) (User, error) {
+	u.mu.RLock()
+	user, ok := u.byUsername(username)
+	u.mu.RUnlock()
+
+	hash := user.PasswordHash
+	if !ok {
+		// Compare against a dummy hash so unknown users take as long as bad passwords
+		hash = dummyHash
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !ok {
+		return User{}, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// NewSession starts a login session for a user and returns its secret
+func (u *Users) NewSession(userID string) (string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return "", err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if _, ok := u.users[userID]; !ok {
+		return "", ErrUserNotFound
+	}
+
+	now := time.Now()
+	// Drop expired sessions while we hold the lock
+	for k, s := range u.sessions {
+		if now.After(s.expires) {
+			delete(u.sessions, k)
+		}
+	}
+
+	u.sessions[hashSecret(secret)] = session{
+		userID:  userID,
+		expires: now.Add(SessionLifetime),
+	}
+	return secret, nil
+}
+
+// SessionUser returns the user a session secret belongs to
+func (u *Users) SessionUser(secret string) (User, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	s, ok := u.sessions[hashSecret(secret)]
+	if !ok || time.Now().After(s.expires) {
+		return User{}, ErrSessionNotFound
+	}
+	user, ok := u.users[s.userID]
+	if !ok {
+		return User{}, ErrSessionNotFound
+	}
+	return user, nil
+}
+
+// EndSession logs a session out
+func (u *Users) EndSession(secret string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	delete(u.sessions, hashSecret(secret))
+}
+
+// NewToken creates an api token for a user, the returned token holds the secret
+func (u *Users) NewToken(userID, name string) (APIToken, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	id, err := NewID()
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if _, ok := u.users[userID]; !ok {
+		return APIToken{}, ErrUserNotFound
+	}
+
+	token := APIToken{
+		ID:      id,
+		Name:    name,
+		Created: time.Now().Unix(),
+		UserID:  userID,
+		Hash:    hashSecret(secret),
+	}
+	u.tokens[id] = token
+
+	token.Token = secret
+	return token, nil
+}
+
+// TokenUser returns the user an api token secret belongs to
+func (u *Users) TokenUser(secret string) (User, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	hash := hashSecret(secret)
+	for _, token := range u.tokens {
+		if token.Hash == hash {
+			if user, ok := u.users[token.UserID]; ok {
+				return user, nil
+			}
+		}
+	}
+	return User{}, ErrTokenNotFound
+}
+
+// Returns the api tokens of a user sorted by creation time
+func (u *Users) Tokens(userID string) []APIToken {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	var list []APIToken
+	for _, token := range u.tokens {
+		if token.UserID == userID {
+			list = append(list, token)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created < list[j].Created
+	})
+	return list
+}
+
+// DeleteToken revokes one of a user's api tokens
+func (u *Users) DeleteToken(userID, id string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	token, ok := u.tokens[id]
+	if !ok || token.UserID != userID {
+		return ErrTokenNotFound
+	}
+	delete(u.tokens, id)
+	return nil
+}
+
+// admins returns the number of admins of every site, admins limited to some sites do not count
+func (u *Users) admins() int {
+	n := 0
+	for _, user := range u.users {
+		if user.Role == RoleAdmin && len(user.Sites) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// revoke ends the sessions and deletes the api tokens of a user
+func (u *Users) revoke(userID string) {
+	for k, s := range u.sessions {
+		if s.userID == userID {
+			delete(u.sessions, k)
+		}
+	}
+	for k, token := range u.tokens {
+		if token.UserID == userID {
+			delete(u.tokens, k)
+		}
+	}
+}
+
+func (u *Users) byUsername(username string) (User, bool) {
+	for _, user := range u.users {
+		if user.Username == username {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("beenfar"), bcrypt.DefaultCost)
+
+// newSecret generates a random secret for sessions and api tokens
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Only hashes of secrets are stored, they are random so a plain sha256 is enough
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
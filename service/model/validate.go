@@ -0,0 +1,44 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A FieldError describes a single invalid field of a model.
+type FieldError struct {
+	// Field is the jsonapi name of the invalid field, "id" for the primary key
+	Field  string
+	Detail string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Detail)
+}
+
+// ValidationErrors is returned by Validate methods and holds every field error found
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	s := make([]string, 0, len(v))
+	for _, e := range v {
+		s = append(s, e.Error())
+	}
+	return "validation failed: " + strings.Join(s, "; ")
+}
+
+// Add a field error to the list
+func (v *ValidationErrors) Add(field, format string, args ...any) {
+	*v = append(*v, FieldError{
+		Field:  field,
+		Detail: fmt.Sprintf(format, args...),
+	})
+}
+
+// Returns nil if no errors were added, so the result can be returned as an error directly
+func (v ValidationErrors) Err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
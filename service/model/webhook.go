@@ -0,0 +1,246 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service/event"
+)
+
+var (
+	ErrWebhookNotFound = errors.New("webhook not found")
+)
+
+// Number of deliveries kept per webhook
+const webhookDeliveryHistory = 100
+
+// A Webhook receives signed POSTs of the events it subscribes to
+type Webhook struct {
+	ID  string `jsonapi:"primary,webhook" json:"id"`
+	URL string `jsonapi:"attr,url" json:"url"`
+	// Secret signs deliveries, it is only returned when the webhook is created
+	Secret string `jsonapi:"attr,secret,omitempty" json:"secret" audit:"secret"`
+	// Events are the event types to deliver, all events are delivered if empty
+	Events []string `jsonapi:"attr,events,omitempty" json:"events,omitempty"`
+	// Disabled webhooks keep their settings and delivery log but receive no events
+	Disabled bool  `jsonapi:"attr,disabled" json:"disabled"`
+	Created  int64 `jsonapi:"attr,created" json:"created"`
+}
+
+// Validate checks the webhook has a usable url and only known event types
+func (w Webhook) Validate() error {
+	var errs ValidationErrors
+
+	u, err := url.Parse(w.URL)
+	switch {
+	case w.URL == "":
+		errs.Add("url", "url must not be empty")
+	case err != nil:
+		errs.Add("url", "invalid url: %v", err)
+	case u.Scheme != "http" && u.Scheme != "https":
+		errs.Add("url", "url must use http or https")
+	case u.Host == "":
+		errs.Add("url", "url must include a host")
+	}
+
+	for _, t := range w.Events {
+		if !event.Type(t).Valid() {
+			errs.Add("events", "unknown event type %q", t)
+		}
+	}
+
+	return errs.Err()
+}
+
+// Wants checks if the webhook subscribes to an event type
+func (w Webhook) Wants(t event.Type) bool {
+	if w.Disabled {
+		return false
+	}
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if event.Type(e) == t {
+			return true
+		}
+	}
+	return false
+}
+
+// A WebhookDelivery is a single attempt at delivering an event to a webhook
+type WebhookDelivery struct {
+	ID        string `jsonapi:"primary,webhook_delivery" json:"id"`
+	WebhookID string `jsonapi:"attr,webhook_id" json:"webhook_id"`
+	EventID   uint64 `jsonapi:"attr,event_id" json:"event_id"`
+	EventType string `jsonapi:"attr,event_type" json:"event_type"`
+	Attempt   int    `jsonapi:"attr,attempt" json:"attempt"`
+	Time      int64  `jsonapi:"attr,time" json:"time"`
+	// StatusCode is the response status, 0 if no response was received
+	StatusCode int    `jsonapi:"attr,status_code" json:"status_code"`
+	Error      string `jsonapi:"attr,error,omitempty" json:"error,omitempty"`
+	Success    bool   `jsonapi:"attr,success" json:"success"`
+}
+
+// Webhooks stores webhook subscriptions and their recent deliveries
+type Webhooks struct {
+	hooks      map[string]Webhook
+	deliveries map[string][]WebhookDelivery
+
+	mu sync.RWMutex
+}
+
+func NewWebhooks() *Webhooks {
+	return &Webhooks{
+		hooks:      make(map[string]Webhook),
+		deliveries: make(map[string][]WebhookDelivery),
+	}
+}
+
+// savedWebhooks is how webhooks are saved along with their delivery logs
+type savedWebhooks struct {
+	Hooks      map[string]Webhook           `json:"webhooks"`
+	Deliveries map[string][]WebhookDelivery `json:"deliveries"`
+}
+
+// MarshalJSON saves every webhook and its delivery log
+func (w *Webhooks) MarshalJSON() ([]byte, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return json.Marshal(savedWebhooks{Hooks: w.hooks, Deliveries: w.deliveries})
+}
+
+// UnmarshalJSON replaces the webhooks and delivery logs with saved ones
+func (w *Webhooks) UnmarshalJSON(data []byte) error {
+	saved := savedWebhooks{Hooks: make(map[string]Webhook), Deliveries: make(map[string][]WebhookDelivery)}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.hooks = saved.Hooks
+	w.deliveries = saved.Deliveries
+	return nil
+}
+
+// Returns all webhooks sorted by creation time
+func (w *Webhooks) List() []Webhook {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	list := make([]Webhook, 0, len(w.hooks))
+	for _, hook := range w.hooks {
+		list = append(list, hook)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created < list[j].Created
+	})
+	return list
+}
+
+// Get a webhook by ID
+func (w *Webhooks) Get(id string) (Webhook, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	hook, ok := w.hooks[id]
+	if !ok {
+		return Webhook{}, ErrWebhookNotFound
+	}
+	return hook, nil
+}
+
+// Add a webhook and assign it an ID, a secret is generated if none is set
+func (w *Webhooks) Add(hook Webhook) (Webhook, error) {
+	id, err := NewID()
+	if err != nil {
+		return Webhook{}, err
+	}
+	if hook.Secret == "" {
+		if hook.Secret, err = newSecret(); err != nil {
+			return Webhook{}, err
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	hook.ID = id
+	hook.Created = time.Now().Unix()
+	w.hooks[id] = hook
+	return hook, nil
+}
+
+// Update replaces a webhook, the creation time is kept
+func (w *Webhooks) Update(id string, hook Webhook) (Webhook, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	current, ok := w.hooks[id]
+	if !ok {
+		return Webhook{}, ErrWebhookNotFound
+	}
+
+	hook.ID = id
+	hook.Created = current.Created
+	w.hooks[id] = hook
+	return hook, nil
+}
+
+// Delete a webhook and its delivery log, returns the deleted webhook
+func (w *Webhooks) Delete(id string) (Webhook, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	hook, ok := w.hooks[id]
+	if !ok {
+		return Webhook{}, ErrWebhookNotFound
+	}
+	delete(w.hooks, id)
+	delete(w.deliveries, id)
+	return hook, nil
+}
+
+// Record a delivery attempt, only the most recent deliveries of each webhook are kept
+func (w *Webhooks) Record(delivery WebhookDelivery) error {
+	id, err := NewID()
+	if err != nil {
+		return err
+	}
+	delivery.ID = id
+	if delivery.Time == 0 {
+		delivery.Time = time.Now().Unix()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.hooks[delivery.WebhookID]; !ok {
+		return ErrWebhookNotFound
+	}
+
+	deliveries := append(w.deliveries[delivery.WebhookID], delivery)
+	if len(deliveries) > webhookDeliveryHistory {
+		deliveries = deliveries[len(deliveries)-webhookDeliveryHistory:]
+	}
+	w.deliveries[delivery.WebhookID] = deliveries
+	return nil
+}
+
+// Deliveries returns the delivery log of a webhook, oldest first
+func (w *Webhooks) Deliveries(id string) ([]WebhookDelivery, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if _, ok := w.hooks[id]; !ok {
+		return nil, ErrWebhookNotFound
+	}
+	return append([]WebhookDelivery(nil), w.deliveries[id]...), nil
+}
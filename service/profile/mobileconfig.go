@@ -0,0 +1,155 @@
+package profile
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// Content type of Apple configuration profiles
+const MobileConfigContentType = "application/x-apple-aspen-config"
+
+// EAP types WPA enterprise profiles accept, PEAP and TTLS with the credentials asked for on install
+var acceptEAPTypes = []any{25, 21}
+
+// MobileConfig returns an Apple configuration profile joining the network, signed if o has a Signer.
+//
+// WPA enterprise profiles trust the radius server by the RadiusCAs, which are installed with the
+// profile, and the RadiusServerNames.
+func (o Options) MobileConfig(w model.WifiNetworkConfig) ([]byte, error) {
+	identifier := identifierPrefix + w.ID
+
+	wifi := dict{
+		{"PayloadType", "com.apple.wifi.managed"},
+		{"PayloadVersion", 1},
+		{"PayloadIdentifier", identifier + ".wifi"},
+		{"PayloadUUID", uuid(identifier + ".wifi")},
+		{"PayloadDisplayName", "Wi-Fi " + w.Ssid},
+		{"SSID_STR", w.Ssid},
+		{"HIDDEN_NETWORK", w.Hidden},
+		{"AutoJoin", true},
+	}
+
+	var content []any
+	switch w.SecurityType {
+	case model.WifiSecurityTypeOpen:
+		wifi = append(wifi, entry{"EncryptionType", "None"})
+	case model.WifiSecurityTypeWep:
+		wifi = append(wifi, entry{"EncryptionType", "WEP"})
+	case model.WifiSecurityTypeWpaPersonal:
+		wifi = append(wifi, entry{"EncryptionType", "WPA"})
+	case model.WifiSecurityTypeWpaEnterprise:
+		wifi = append(wifi, entry{"EncryptionType", "WPA"})
+		eap := dict{
+			{"AcceptEAPTypes", acceptEAPTypes},
+			{"TTLSInnerAuthentication", "MSCHAPv2"},
+		}
+		var anchors []any
+		for i, ca := range o.RadiusCAs {
+			caIdentifier := identifier + ".ca" + strconv.Itoa(i+1)
+			anchors = append(anchors, uuid(caIdentifier))
+			content = append(content, dict{
+				{"PayloadType", "com.apple.security.root"},
+				{"PayloadVersion", 1},
+				{"PayloadIdentifier", caIdentifier},
+				{"PayloadUUID", uuid(caIdentifier)},
+				{"PayloadDisplayName", ca.Subject.CommonName},
+				{"PayloadCertificateFileName", "ca" + strconv.Itoa(i+1) + ".cer"},
+				{"PayloadContent", ca.Raw},
+			})
+		}
+		if len(anchors) != 0 {
+			eap = append(eap, entry{"PayloadCertificateAnchorUUID", anchors})
+		}
+		if len(o.RadiusServerNames) != 0 {
+			var names []any
+			for _, name := range o.RadiusServerNames {
+				names = append(names, name)
+			}
+			eap = append(eap, entry{"TLSTrustedServerNames", names})
+		}
+		wifi = append(wifi, entry{"EAPClientConfiguration", eap})
+	default:
+		return nil, fmt.Errorf("%w: unknown security type %d", ErrNotSupported, w.SecurityType)
+	}
+	if w.SecurityKey != "" {
+		wifi = append(wifi, entry{"Password", w.SecurityKey})
+	}
+
+	profile := dict{
+		{"PayloadType", "Configuration"},
+		{"PayloadVersion", 1},
+		{"PayloadIdentifier", identifier},
+		{"PayloadUUID", uuid(identifier)},
+		{"PayloadDisplayName", "Wi-Fi " + w.Ssid},
+		{"PayloadOrganization", o.organization()},
+		{"PayloadContent", append(content, wifi)},
+	}
+
+	plist := marshalPlist(profile)
+	if o.Signer == nil {
+		return plist, nil
+	}
+	return o.Signer.Sign(plist)
+}
+
+// dict is a property list dictionary, keys are written in order
+type dict []entry
+
+type entry struct {
+	key   string
+	value any
+}
+
+// marshalPlist writes a property list in the XML format, values are dict, []any, string, int, bool or []byte
+func marshalPlist(root dict) []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString(`<plist version="1.0">` + "\n")
+	writePlistValue(&b, root, 0)
+	b.WriteString("</plist>\n")
+	return b.Bytes()
+}
+
+func writePlistValue(b *bytes.Buffer, value any, depth int) {
+	indent := strings.Repeat("\t", depth)
+	switch v := value.(type) {
+	case dict:
+		b.WriteString(indent + "<dict>\n")
+		for _, e := range v {
+			b.WriteString(indent + "\t<key>")
+			xml.EscapeText(b, []byte(e.key))
+			b.WriteString("</key>\n")
+			writePlistValue(b, e.value, depth+1)
+		}
+		b.WriteString(indent + "</dict>\n")
+	case []any:
+		b.WriteString(indent + "<array>\n")
+		for _, element := range v {
+			writePlistValue(b, element, depth+1)
+		}
+		b.WriteString(indent + "</array>\n")
+	case string:
+		b.WriteString(indent + "<string>")
+		xml.EscapeText(b, []byte(v))
+		b.WriteString("</string>\n")
+	case int:
+		b.WriteString(indent + "<integer>" + strconv.Itoa(v) + "</integer>\n")
+	case bool:
+		if v {
+			b.WriteString(indent + "<true/>\n")
+		} else {
+			b.WriteString(indent + "<false/>\n")
+		}
+	case []byte:
+		b.WriteString(indent + "<data>" + base64.StdEncoding.EncodeToString(v) + "</data>\n")
+	default:
+		panic(fmt.Sprintf("profile: unsupported plist value %T", value))
+	}
+}
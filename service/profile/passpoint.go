@@ -0,0 +1,100 @@
+package profile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// Content type of Passpoint subscriptions
+const PasspointContentType = "application/x-passpoint-profile"
+
+// Passpoint subscriptions authenticate with EAP-TTLS and MS-CHAP-V2 inside
+const (
+	passpointEAPType     = 21
+	passpointInnerMethod = "MS-CHAP-V2"
+)
+
+// Management tree of a Hotspot 2.0 PerProviderSubscription, as installed by Android
+type mgmtTree struct {
+	XMLName xml.Name `xml:"MgmtTree"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	VerDTD  string   `xml:"VerDTD"`
+	Node    ppsNode  `xml:"Node"`
+}
+
+type ppsNode struct {
+	NodeName     string        `xml:"NodeName"`
+	RTProperties *rtProperties `xml:"RTProperties,omitempty"`
+	Value        *string       `xml:"Value,omitempty"`
+	Nodes        []ppsNode     `xml:"Node"`
+}
+
+type rtProperties struct {
+	DDFName string `xml:"Type>DDFName"`
+}
+
+func ppsLeaf(name, value string) ppsNode {
+	return ppsNode{NodeName: name, Value: &value}
+}
+
+func ppsInterior(name string, nodes ...ppsNode) ppsNode {
+	return ppsNode{NodeName: name, Nodes: nodes}
+}
+
+// Passpoint returns the Hotspot 2.0 PerProviderSubscription of a WPA enterprise network.
+//
+// The home domain and realm are the Domain of o and the radius server is trusted by the
+// fingerprints of RadiusCAs. The subscription carries no user credentials.
+func (o Options) Passpoint(w model.WifiNetworkConfig) ([]byte, error) {
+	if w.SecurityType != model.WifiSecurityTypeWpaEnterprise {
+		return nil, fmt.Errorf("%w: passpoint subscriptions are only generated for wpa enterprise networks", ErrNotSupported)
+	}
+	if o.Domain == "" {
+		return nil, fmt.Errorf("%w: passpoint subscriptions need a domain", ErrNotSupported)
+	}
+
+	subscription := ppsInterior("i001",
+		ppsInterior("HomeSP",
+			ppsLeaf("FriendlyName", w.Ssid),
+			ppsLeaf("FQDN", o.Domain),
+			ppsInterior("NetworkID", ppsInterior("n001", ppsLeaf("SSID", w.Ssid))),
+		),
+		ppsInterior("Credential",
+			ppsLeaf("Realm", o.Domain),
+			ppsInterior("UsernamePassword",
+				ppsInterior("EAPMethod",
+					ppsLeaf("EAPType", strconv.Itoa(passpointEAPType)),
+					ppsLeaf("InnerMethod", passpointInnerMethod),
+				),
+			),
+		),
+	)
+	if len(o.RadiusCAs) != 0 {
+		roots := ppsInterior("AAAServerTrustRoot")
+		for i, ca := range o.RadiusCAs {
+			sum := sha256.Sum256(ca.Raw)
+			roots.Nodes = append(roots.Nodes, ppsInterior(fmt.Sprintf("r%03d", i+1), ppsLeaf("CertSHA256Fingerprint", hex.EncodeToString(sum[:]))))
+		}
+		subscription.Nodes = append(subscription.Nodes, roots)
+	}
+
+	tree := mgmtTree{
+		Xmlns:  "syncml:dmddf1.2",
+		VerDTD: "1.2",
+		Node: ppsNode{
+			NodeName:     "PerProviderSubscription",
+			RTProperties: &rtProperties{DDFName: "urn:wfa:mo:hotspot2dot0-perprovidersubscription:1.0"},
+			Nodes:        []ppsNode{subscription},
+		},
+	}
+	data, err := xml.MarshalIndent(tree, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,106 @@
+// Package profile generates the profiles mobile devices join wifi networks with: Apple configuration
+// profiles, Wi-Fi QR codes and Passpoint subscriptions
+package profile
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"image/png"
+	"strings"
+
+	"github.com/jacobalberty/beenfar/service/model"
+	"github.com/jacobalberty/beenfar/service/qrcode"
+)
+
+var ErrNotSupported = errors.New("profile not supported")
+
+// Organization profiles are issued by unless set in Options
+const DefaultOrganization = "beenfar"
+
+// Pixels per module of QR code images
+const QRScale = 8
+
+// Prefix of the identifiers of Apple payloads
+const identifierPrefix = "beenfar.wifi."
+
+// Options are the settings shared by every profile
+type Options struct {
+	// Organization is shown as the issuer of Apple profiles
+	Organization string
+	// RadiusCAs are the certificates WPA enterprise clients trust the radius server with
+	RadiusCAs []*x509.Certificate
+	// RadiusServerNames are the names the radius server certificate may have, such as radius.example.com
+	RadiusServerNames []string
+	// Domain is the home domain and realm of Passpoint subscriptions, they are not available if empty
+	Domain string
+	// Signer signs Apple profiles if set
+	Signer *Signer
+}
+
+func (o Options) organization() string {
+	if o.Organization == "" {
+		return DefaultOrganization
+	}
+	return o.Organization
+}
+
+// QRPayload returns the text of a Wi-Fi QR code such as WIFI:T:WPA;S:home;P:secret;;
+// Phone cameras only join personal networks this way, so WPA enterprise networks are not supported.
+func QRPayload(w model.WifiNetworkConfig) (string, error) {
+	var auth string
+	switch w.SecurityType {
+	case model.WifiSecurityTypeOpen:
+		auth = "nopass"
+	case model.WifiSecurityTypeWep:
+		auth = "WEP"
+	case model.WifiSecurityTypeWpaPersonal:
+		auth = "WPA"
+	default:
+		return "", fmt.Errorf("%w: wifi QR codes can not hold wpa enterprise settings", ErrNotSupported)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("WIFI:T:" + auth + ";S:" + qrEscape(w.Ssid) + ";")
+	if w.SecurityKey != "" {
+		sb.WriteString("P:" + qrEscape(w.SecurityKey) + ";")
+	}
+	if w.Hidden {
+		sb.WriteString("H:true;")
+	}
+	sb.WriteString(";")
+	return sb.String(), nil
+}
+
+// QRCode returns the Wi-Fi QR code of the network as a PNG image
+func QRCode(w model.WifiNetworkConfig) ([]byte, error) {
+	payload, err := QRPayload(w)
+	if err != nil {
+		return nil, err
+	}
+	code, err := qrcode.Encode([]byte(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, code.Image(QRScale)); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// qrEscape escapes the characters that separate the fields of a Wi-Fi QR code
+func qrEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, `"`, `\"`, `:`, `\:`).Replace(s)
+}
+
+// uuid returns a name based UUID, so a profile downloaded again replaces the installed one
+func uuid(name string) string {
+	sum := sha1.Sum([]byte(name))
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("%X-%X-%X-%X-%X", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
@@ -0,0 +1,145 @@
+package profile_test
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service/certs"
+	"github.com/jacobalberty/beenfar/service/model"
+	"github.com/jacobalberty/beenfar/service/profile"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+var (
+	personal = model.WifiNetworkConfig{
+		ID:           "000000000000000000000001",
+		Ssid:         "home & garden",
+		SecurityType: model.WifiSecurityTypeWpaPersonal,
+		SecurityKey:  "correct horse",
+		Hidden:       true,
+	}
+	enterprise = model.WifiNetworkConfig{
+		ID:            "000000000000000000000002",
+		Ssid:          "corp",
+		SecurityType:  model.WifiSecurityTypeWpaEnterprise,
+		RadiusProfile: 1,
+	}
+)
+
+func testOptions(t *testing.T) profile.Options {
+	cas, err := certs.LoadCertificates(filepath.Join("testdata", "radius-ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return profile.Options{
+		Organization:      "Example",
+		RadiusCAs:         cas,
+		RadiusServerNames: []string{"radius.example.com"},
+		Domain:            "example.com",
+	}
+}
+
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	file := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(file, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected %s to match, got:\n%s", file, got)
+	}
+}
+
+func TestQRPayload(t *testing.T) {
+	tests := []struct {
+		network model.WifiNetworkConfig
+		payload string
+	}{
+		{model.WifiNetworkConfig{Ssid: "guest"}, "WIFI:T:nopass;S:guest;;"},
+		{model.WifiNetworkConfig{Ssid: "old", SecurityType: model.WifiSecurityTypeWep, SecurityKey: "abcde"}, "WIFI:T:WEP;S:old;P:abcde;;"},
+		{model.WifiNetworkConfig{Ssid: `a;b,c`, SecurityType: model.WifiSecurityTypeWpaPersonal, SecurityKey: `p:w"d\x`}, `WIFI:T:WPA;S:a\;b\,c;P:p\:w\"d\\x;;`},
+		{personal, "WIFI:T:WPA;S:home & garden;P:correct horse;H:true;;"},
+	}
+	for _, test := range tests {
+		payload, err := profile.QRPayload(test.network)
+		if err != nil {
+			t.Errorf("Expected a payload for %s, got %v", test.network.Ssid, err)
+		}
+		if payload != test.payload {
+			t.Errorf("Expected payload %s, got %s", test.payload, payload)
+		}
+	}
+
+	if _, err := profile.QRPayload(enterprise); !errors.Is(err, profile.ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported for wpa enterprise, got %v", err)
+	}
+}
+
+func TestQRCode(t *testing.T) {
+	data, err := profile.QRCode(personal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The payload needs version 4, 33 modules and the quiet zone on both sides
+	if width := img.Bounds().Dx(); width != (33+8)*profile.QRScale {
+		t.Errorf("Expected the image to be %d pixels wide, got %d", (33+8)*profile.QRScale, width)
+	}
+}
+
+func TestMobileConfig(t *testing.T) {
+	opts := testOptions(t)
+
+	data, err := opts.MobileConfig(personal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "personal.mobileconfig.golden", data)
+
+	if data, err = opts.MobileConfig(enterprise); err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "enterprise.mobileconfig.golden", data)
+
+	// Downloading a profile again gives the same identifiers, so it replaces the installed one
+	again, err := opts.MobileConfig(enterprise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Error("Expected the same profile for the same network")
+	}
+}
+
+func TestPasspoint(t *testing.T) {
+	opts := testOptions(t)
+
+	data, err := opts.Passpoint(enterprise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "passpoint.xml.golden", data)
+
+	if _, err := opts.Passpoint(personal); !errors.Is(err, profile.ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported for a personal network, got %v", err)
+	}
+	opts.Domain = ""
+	if _, err := opts.Passpoint(enterprise); !errors.Is(err, profile.ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported without a domain, got %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package profile
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+)
+
+var ErrUnsupportedKey = errors.New("signing key must be RSA or ECDSA")
+
+// Object identifiers of CMS (RFC 5652) and the algorithms profiles are signed with
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSA           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA  = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// Signer signs profiles so devices show who issued them
+type Signer struct {
+	// Chain is the signing certificate followed by its intermediates, they are included in signatures
+	Chain []*x509.Certificate
+	Key   crypto.Signer
+}
+
+// LoadSigner reads a PEM certificate chain, the signing certificate first, and its key
+func LoadSigner(certFile, keyFile string) (*Signer, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	s := &Signer{}
+	for _, der := range pair.Certificate {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		s.Chain = append(s.Chain, cert)
+	}
+	switch key := pair.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		s.Key = key
+	case *ecdsa.PrivateKey:
+		s.Key = key
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	return s, nil
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []algorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      []signerInfo `asn1:"set"`
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,tag:0"`
+}
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type signerInfo struct {
+	Version            int
+	SID                issuerAndSerialNumber
+	DigestAlgorithm    algorithmIdentifier
+	SignedAttrs        asn1.RawValue
+	SignatureAlgorithm algorithmIdentifier
+	Signature          []byte
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// Sign wraps content in a CMS SignedData structure signed with SHA-256, the content is included
+func (s *Signer) Sign(content []byte) ([]byte, error) {
+	if len(s.Chain) == 0 {
+		return nil, errors.New("signer has no certificate")
+	}
+	leaf := s.Chain[0]
+	digest := sha256.Sum256(content)
+
+	var signatureAlgorithm algorithmIdentifier
+	switch s.Key.(type) {
+	case *rsa.PrivateKey:
+		signatureAlgorithm = algorithmIdentifier{Algorithm: oidRSA, Parameters: asn1.NullRawValue}
+	case *ecdsa.PrivateKey:
+		signatureAlgorithm = algorithmIdentifier{Algorithm: oidECDSAWithSHA}
+	default:
+		return nil, ErrUnsupportedKey
+	}
+
+	attrs, err := signedAttributes(digest[:], time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	// The signature covers the attributes encoded as a SET, they are stored with an implicit [0] tag
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: attrs})
+	if err != nil {
+		return nil, err
+	}
+	attrsDigest := sha256.Sum256(set)
+	signature, err := s.Key.Sign(rand.Reader, attrsDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []byte
+	for _, cert := range s.Chain {
+		certs = append(certs, cert.Raw...)
+	}
+	sha := algorithmIdentifier{Algorithm: oidSHA256}
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []algorithmIdentifier{sha},
+		EncapContentInfo: encapsulatedContentInfo{EContentType: oidData, EContent: content},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SID:                issuerAndSerialNumber{Issuer: asn1.RawValue{FullBytes: leaf.RawIssuer}, SerialNumber: leaf.SerialNumber},
+			DigestAlgorithm:    sha,
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs},
+			SignatureAlgorithm: signatureAlgorithm,
+			Signature:          signature,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+}
+
+// signedAttributes encodes the content type, signing time and message digest attributes in the
+// order DER requires for a SET OF, without the SET header
+func signedAttributes(digest []byte, now time.Time) ([]byte, error) {
+	values := []struct {
+		oid   asn1.ObjectIdentifier
+		value any
+	}{
+		{oidContentType, oidData},
+		{oidSigningTime, now},
+		{oidMessageDigest, digest},
+	}
+
+	encoded := make([][]byte, 0, len(values))
+	for _, v := range values {
+		value, err := asn1.Marshal(v.value)
+		if err != nil {
+			return nil, err
+		}
+		der, err := asn1.Marshal(attribute{Type: v.oid, Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: value}})
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, der)
+	}
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+	return bytes.Join(encoded, nil), nil
+}
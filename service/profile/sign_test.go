@@ -0,0 +1,128 @@
+package profile_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service/certs"
+	"github.com/jacobalberty/beenfar/service/profile"
+)
+
+// Just enough of CMS SignedData to check a signature
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo struct {
+		EContentType asn1.ObjectIdentifier
+		EContent     []byte `asn1:"explicit,tag:0"`
+	}
+	Certificates asn1.RawValue
+	SignerInfos  []struct {
+		Version int
+		SID     struct {
+			Issuer       asn1.RawValue
+			SerialNumber *big.Int
+		}
+		DigestAlgorithm    asn1.RawValue
+		SignedAttrs        asn1.RawValue
+		SignatureAlgorithm asn1.RawValue
+		Signature          []byte
+	} `asn1:"set"`
+}
+
+func TestSign(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, err := certs.EnsureSelfSigned(dir, []string{"profiles.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := profile.LoadSigner(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := testOptions(t)
+	opts.Signer = signer
+	signed, err := opts.MobileConfig(personal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Signer = nil
+	plist, err := opts.MobileConfig(personal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(signed, &ci); err != nil {
+		t.Fatal(err)
+	}
+	if !ci.ContentType.Equal(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}) {
+		t.Fatalf("Expected signed data, got %v", ci.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sd.EncapContentInfo.EContent, plist) {
+		t.Error("Expected the signed content to be the profile")
+	}
+	if !bytes.Equal(sd.Certificates.Bytes, signer.Chain[0].Raw) {
+		t.Error("Expected the signing certificate to be included")
+	}
+	if len(sd.SignerInfos) != 1 {
+		t.Fatalf("Expected one signer, got %d", len(sd.SignerInfos))
+	}
+
+	si := sd.SignerInfos[0]
+	if si.SID.SerialNumber.Cmp(signer.Chain[0].SerialNumber) != 0 {
+		t.Errorf("Expected the signer to be identified by serial %s, got %s", signer.Chain[0].SerialNumber, si.SID.SerialNumber)
+	}
+	digest := sha256.Sum256(plist)
+	if !bytes.Contains(si.SignedAttrs.Bytes, digest[:]) {
+		t.Error("Expected the signed attributes to hold the digest of the profile")
+	}
+	// The signature covers the attributes with a SET tag instead of the implicit [0]
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: si.SignedAttrs.Bytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.Chain[0].CheckSignature(x509.ECDSAWithSHA256, set, si.Signature); err != nil {
+		t.Errorf("Expected a valid signature, got %v", err)
+	}
+}
+
+func TestLoadSigner(t *testing.T) {
+	dir := t.TempDir()
+	certFile, _, err := certs.EnsureSelfSigned(dir, []string{"localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := os.MkdirTemp(dir, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := certs.EnsureSelfSigned(other, []string{"localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := profile.LoadSigner(certFile, otherKey); err == nil {
+		t.Error("Expected a key that does not match the certificate to be rejected")
+	}
+	if _, err := profile.LoadSigner(filepath.Join(dir, "missing.pem"), otherKey); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a missing certificate to be reported, got %v", err)
+	}
+}
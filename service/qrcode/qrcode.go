@@ -0,0 +1,255 @@
+// Package qrcode encodes data as QR codes (ISO/IEC 18004) in byte mode with error correction level M
+package qrcode
+
+import (
+	"errors"
+	"image"
+	"image/color"
+)
+
+var ErrTooLong = errors.New("data does not fit in a QR code")
+
+// Width of the light border around the symbol in modules, as required by the standard
+const QuietZone = 4
+
+const (
+	minVersion = 1
+	maxVersion = 40
+)
+
+// Error correction codewords per block and number of blocks at level M, indexed by version
+var (
+	eccPerBlock = [maxVersion + 1]int{-1,
+		10, 16, 26, 18, 24, 16, 18, 22, 22, 26,
+		30, 22, 22, 24, 24, 28, 28, 26, 26, 26,
+		26, 28, 28, 28, 28, 28, 28, 28, 28, 28,
+		28, 28, 28, 28, 28, 28, 28, 28, 28, 28,
+	}
+	eccBlocks = [maxVersion + 1]int{-1,
+		1, 1, 1, 2, 2, 4, 4, 4, 5, 5,
+		5, 8, 9, 9, 10, 10, 11, 13, 14, 16,
+		17, 17, 18, 20, 21, 23, 25, 26, 28, 29,
+		31, 33, 35, 37, 38, 40, 43, 45, 47, 49,
+	}
+)
+
+// Format information bits of error correction level M
+const eccFormatBits = 0
+
+// Code is an encoded QR code
+type Code struct {
+	// Version is the version of the symbol from 1 to 40, it is 17+4*Version modules wide
+	Version int
+	// Size is the width and height of the symbol in modules, without the quiet zone
+	Size int
+	// Mask is the mask pattern applied to the data from 0 to 7
+	Mask int
+
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// Encode encodes data in the smallest version it fits in, with the mask pattern that scores best
+func Encode(data []byte) (*Code, error) {
+	version := minVersion
+	for ; version <= maxVersion; version++ {
+		if 4+countBits(version)+8*len(data) <= dataCodewords(version)*8 {
+			break
+		}
+	}
+	if version > maxVersion {
+		return nil, ErrTooLong
+	}
+
+	var bits bitBuffer
+	bits.append(0b0100, 4)
+	bits.append(uint(len(data)), countBits(version))
+	for _, b := range data {
+		bits.append(uint(b), 8)
+	}
+	capacity := dataCodewords(version) * 8
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := uint(0xEC); len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	c := newCode(version)
+	c.drawFunctionPatterns()
+	c.drawCodewords(addErrorCorrection(bits.bytes(), version))
+
+	best := -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+		if score := c.penalty(); best < 0 || score < best {
+			best, c.Mask = score, mask
+		}
+		c.applyMask(mask)
+	}
+	c.applyMask(c.Mask)
+	c.drawFormatBits(c.Mask)
+	return c, nil
+}
+
+// Black reports whether the module at column x and row y is dark
+func (c *Code) Black(x, y int) bool {
+	return c.modules[y][x]
+}
+
+// Image returns the symbol with its quiet zone, every module is scale pixels wide
+func (c *Code) Image(scale int) *image.Paletted {
+	if scale < 1 {
+		scale = 1
+	}
+	width := (c.Size + 2*QuietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, width, width), color.Palette{color.White, color.Black})
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.modules[y][x] {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				row := img.Pix[((y+QuietZone)*scale+dy)*img.Stride:]
+				for dx := 0; dx < scale; dx++ {
+					row[(x+QuietZone)*scale+dx] = 1
+				}
+			}
+		}
+	}
+	return img
+}
+
+func newCode(version int) *Code {
+	size := 17 + 4*version
+	c := &Code{Version: version, Size: size}
+	c.modules = make([][]bool, size)
+	c.isFunction = make([][]bool, size)
+	for i := range c.modules {
+		c.modules[i] = make([]bool, size)
+		c.isFunction[i] = make([]bool, size)
+	}
+	return c
+}
+
+// countBits returns the length of the character count of byte mode
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// rawModules returns the number of modules that hold codewords, including the remainder bits
+func rawModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		result -= (25*align-10)*align - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+// dataCodewords returns the number of codewords available for data
+func dataCodewords(version int) int {
+	return rawModules(version)/8 - eccPerBlock[version]*eccBlocks[version]
+}
+
+// addErrorCorrection splits data into blocks, appends the error correction of each block and interleaves them
+func addErrorCorrection(data []byte, version int) []byte {
+	var (
+		numBlocks = eccBlocks[version]
+		eccLen    = eccPerBlock[version]
+		raw       = rawModules(version) / 8
+		numShort  = numBlocks - raw%numBlocks
+		shortLen  = raw / numBlocks
+		divisor   = rsDivisor(eccLen)
+		blocks    = make([][]byte, numBlocks)
+	)
+	for i := range blocks {
+		n := shortLen - eccLen
+		if i >= numShort {
+			n++
+		}
+		block := make([]byte, 0, shortLen+1)
+		block = append(block, data[:n]...)
+		// Short blocks get a placeholder so every block has the same length while interleaving
+		if i < numShort {
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, rsRemainder(data[:n], divisor)...)
+		data = data[n:]
+	}
+
+	result := make([]byte, 0, raw)
+	for i := 0; i < shortLen+1; i++ {
+		for j, block := range blocks {
+			if i != shortLen-eccLen || j >= numShort {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// rsDivisor returns the generator polynomial of degree n, without its leading coefficient
+func rsDivisor(n int) []byte {
+	result := make([]byte, n)
+	result[n-1] = 1
+	root := byte(1)
+	for i := 0; i < n; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords of data
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMultiply(d, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	var z uint
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= (uint(y) >> i & 1) * uint(x)
+	}
+	return byte(z)
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value uint, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, value>>i&1 != 0)
+	}
+}
+
+func (b bitBuffer) bytes() []byte {
+	result := make([]byte, len(b)/8)
+	for i, bit := range b {
+		if bit {
+			result[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return result
+}
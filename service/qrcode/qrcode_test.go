@@ -0,0 +1,170 @@
+package qrcode_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service/qrcode"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		length  int
+		version int
+	}{
+		{1, 1},
+		{14, 1},
+		{15, 2},
+		{50, 4},
+		{213, 10},
+		{2331, 40},
+	}
+	for _, test := range tests {
+		c, err := qrcode.Encode([]byte(strings.Repeat("a", test.length)))
+		if err != nil {
+			t.Errorf("Expected %d bytes to encode, got %v", test.length, err)
+			continue
+		}
+		if c.Version != test.version {
+			t.Errorf("Expected %d bytes to need version %d, got %d", test.length, test.version, c.Version)
+		}
+		if c.Size != 17+4*test.version {
+			t.Errorf("Expected version %d to be %d modules wide, got %d", test.version, 17+4*test.version, c.Size)
+		}
+	}
+
+	if _, err := qrcode.Encode(make([]byte, 2332)); !errors.Is(err, qrcode.ErrTooLong) {
+		t.Errorf("Expected ErrTooLong, got %v", err)
+	}
+}
+
+func TestEncodeKnownAnswer(t *testing.T) {
+	// Reference symbol of the payload at level M with mask 2, as encoded by github.com/skip2/go-qrcode
+	expected := []string{
+		"#######...#..###..###.#######",
+		"#.....#....########.#.#.....#",
+		"#.###.#.#..#.#.#....#.#.###.#",
+		"#.###.#.#...#.##.#....#.###.#",
+		"#.###.#.#..##..##..##.#.###.#",
+		"#.....#.#.#......##.#.#.....#",
+		"#######.#.#.#.#.#.#.#.#######",
+		"........#.#.#.#.#.##.........",
+		"#.#####..##.....##..#.#####..",
+		"##.....#...#.###..###.#.#.###",
+		"#.#####...#..####....###.#...",
+		"#.#..#.###.#.#.#...#.##.#..##",
+		"..#.#.#..###..##.#.##..#.##..",
+		"#..###..#.###..##..#.####.###",
+		".####.#######........###..#..",
+		"..#.##.#......#.#.#..#...#...",
+		"..#.###...#.....###.#..#.####",
+		"##...#.###...###.#.####.##.##",
+		"#..#.###.....######.#.###....",
+		"#.#.##....####.#...#..#.#...#",
+		"#.#..######...##.##########..",
+		"........####...###.##...#.#.#",
+		"#######....#.....####.#.#.#..",
+		"#.....#.##.#..#.#...#...##.#.",
+		"#.###.#.#.###...#.#.#######.#",
+		"#.###.#.#..#####.###.....#...",
+		"#.###.#.##.#######.#.#######.",
+		"#.....#....###.#.##.#.#.##.#.",
+		"#######.###.#.##...#....###..",
+	}
+
+	c, err := qrcode.Encode([]byte("wifi:t:wpa;s:home;p:secret;;"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != 3 || c.Mask != 2 {
+		t.Fatalf("Expected version 3 with mask 2, got version %d with mask %d", c.Version, c.Mask)
+	}
+	for y, row := range expected {
+		for x := range row {
+			if dark := row[x] == '#'; c.Black(x, y) != dark {
+				t.Errorf("Expected module %d,%d to be dark %t, got %t", x, y, dark, c.Black(x, y))
+			}
+		}
+	}
+}
+
+func TestFunctionPatterns(t *testing.T) {
+	// Format information of level M by mask, most significant bit first
+	formats := []string{
+		"101010000010010",
+		"101000100100101",
+		"101111001111100",
+		"101101101001011",
+		"100010111111001",
+		"100000011001110",
+		"100111110010111",
+		"100101010100000",
+	}
+
+	c, err := qrcode.Encode([]byte(strings.Repeat("wifi;", 34)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != 9 {
+		t.Fatalf("Expected version 9, got %d", c.Version)
+	}
+
+	// The top left finder is 7 dark modules wide with a light separator
+	for i := 0; i < 7; i++ {
+		if !c.Black(i, 0) || !c.Black(0, i) {
+			t.Errorf("Expected the finder border to be dark at %d", i)
+		}
+	}
+	if c.Black(7, 0) || c.Black(1, 1) || !c.Black(3, 3) {
+		t.Errorf("Expected a finder pattern in the top left corner")
+	}
+	for i := 8; i < c.Size-8; i++ {
+		if c.Black(i, 6) != (i%2 == 0) || c.Black(6, i) != (i%2 == 0) {
+			t.Errorf("Expected alternating timing patterns at %d", i)
+		}
+	}
+
+	var format strings.Builder
+	for _, p := range [][2]int{{0, 8}, {1, 8}, {2, 8}, {3, 8}, {4, 8}, {5, 8}, {7, 8}, {8, 8}, {8, 7}, {8, 5}, {8, 4}, {8, 3}, {8, 2}, {8, 1}, {8, 0}} {
+		format.WriteByte(bit(c.Black(p[0], p[1])))
+	}
+	if got := format.String(); got != formats[c.Mask] {
+		t.Errorf("Expected format information %s for mask %d, got %s", formats[c.Mask], c.Mask, got)
+	}
+
+	var version strings.Builder
+	for i := 17; i >= 0; i-- {
+		version.WriteByte(bit(c.Black(c.Size-11+i%3, i/3)))
+	}
+	if got, want := version.String(), "001001101010011001"; got != want {
+		t.Errorf("Expected version information %s, got %s", want, got)
+	}
+}
+
+func TestImage(t *testing.T) {
+	c, err := qrcode.Encode([]byte("WIFI:T:nopass;S:guest;;"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := c.Image(4)
+	width := (c.Size + 2*qrcode.QuietZone) * 4
+	if got := img.Bounds().Dx(); got != width {
+		t.Errorf("Expected the image to be %d pixels wide, got %d", width, got)
+	}
+	if img.ColorIndexAt(0, 0) != 0 {
+		t.Errorf("Expected the quiet zone to be light")
+	}
+	corner := qrcode.QuietZone * 4
+	if img.ColorIndexAt(corner, corner) != 1 || img.ColorIndexAt(corner+3, corner+3) != 1 {
+		t.Errorf("Expected the first module to be dark and 4 pixels wide")
+	}
+}
+
+func bit(dark bool) byte {
+	if dark {
+		return '1'
+	}
+	return '0'
+}
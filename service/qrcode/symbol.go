@@ -0,0 +1,285 @@
+package qrcode
+
+// Penalty weights of the mask evaluation
+const (
+	penaltyRun    = 3
+	penaltyBlock  = 3
+	penaltyFinder = 40
+	penaltyDark   = 10
+)
+
+// Finder patterns with four light modules on one side, as searched for by the mask evaluation
+var finderLike = [][]bool{
+	{false, false, false, false, true, false, true, true, true, false, true},
+	{true, false, true, true, true, false, true, false, false, false, false},
+}
+
+func (c *Code) setFunction(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.isFunction[y][x] = true
+}
+
+// drawFunctionPatterns draws the finder, timing and alignment patterns, the version and reserves the format bits
+func (c *Code) drawFunctionPatterns() {
+	for i := 0; i < c.Size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	c.drawFinder(3, 3)
+	c.drawFinder(c.Size-4, 3)
+	c.drawFinder(3, c.Size-4)
+
+	positions := alignmentPositions(c.Version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			// The corners are taken by the finder patterns
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			c.drawAlignment(x, y)
+		}
+	}
+
+	c.drawFormatBits(0)
+	c.drawVersion()
+}
+
+// drawFinder draws a finder pattern with its separator centered on x, y
+func (c *Code) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			if x+dx < 0 || x+dx >= c.Size || y+dy < 0 || y+dy >= c.Size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			c.setFunction(x+dx, y+dy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// drawAlignment draws an alignment pattern centered on x, y
+func (c *Code) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// alignmentPositions returns the rows and columns alignment patterns are centered on
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	num := version/7 + 2
+	step := (version*8 + num*3 + 5) / (num*4 - 4) * 2
+	result := make([]int, num)
+	result[0] = 6
+	for i, pos := num-1, 17+4*version-7; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
+
+// drawFormatBits draws both copies of the error correction level and mask, protected by a BCH code
+func (c *Code) drawFormatBits(mask int) {
+	data := eccFormatBits<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(i))
+	}
+	c.setFunction(8, 7, bit(6))
+	c.setFunction(8, 8, bit(7))
+	c.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, bit(i))
+	}
+	// The dark module is always dark
+	c.setFunction(8, c.Size-8, true)
+}
+
+// drawVersion draws both copies of the version, protected by a BCH code, on version 7 and up
+func (c *Code) drawVersion() {
+	if c.Version < 7 {
+		return
+	}
+	rem := c.Version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := c.Version<<12 | rem
+	for i := 0; i < 18; i++ {
+		dark := bits>>i&1 != 0
+		a, b := c.Size-11+i%3, i/3
+		c.setFunction(a, b, dark)
+		c.setFunction(b, a, dark)
+	}
+}
+
+// drawCodewords places the codewords in the modules that are not function patterns, in
+// columns of two going up and down from the bottom right corner
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		// The vertical timing pattern is skipped
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				// Remainder bits are left light
+				if !c.isFunction[y][x] && i < len(data)*8 {
+					c.modules[y][x] = data[i/8]>>(7-i%8)&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by mask, applying it twice undoes it
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.isFunction[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			c.modules[y][x] = c.modules[y][x] != invert
+		}
+	}
+}
+
+// penalty scores the symbol for patterns that make it hard to read, lower is better
+func (c *Code) penalty() int {
+	result := 0
+	line := make([]bool, c.Size)
+	for y := 0; y < c.Size; y++ {
+		result += linePenalty(c.modules[y])
+		for x := 0; x < c.Size; x++ {
+			line[x] = c.modules[x][y]
+		}
+		result += linePenalty(line)
+	}
+
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+			if x+1 < c.Size && y+1 < c.Size {
+				color := c.modules[y][x]
+				if color == c.modules[y][x+1] && color == c.modules[y+1][x] && color == c.modules[y+1][x+1] {
+					result += penaltyBlock
+				}
+			}
+		}
+	}
+
+	// Every 5% the dark modules are off balance costs penaltyDark
+	total := c.Size * c.Size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return result + k*penaltyDark
+}
+
+// linePenalty scores runs of five or more modules of one color and patterns looking like a finder
+func linePenalty(line []bool) int {
+	result := 0
+	run := 0
+	for i := range line {
+		if i > 0 && line[i] == line[i-1] {
+			run++
+		} else {
+			run = 1
+		}
+		if run == 5 {
+			result += penaltyRun
+		} else if run > 5 {
+			result++
+		}
+	}
+
+	// Modules outside the symbol are light
+	padded := make([]bool, 0, len(line)+8)
+	padded = append(padded, make([]bool, 4)...)
+	padded = append(padded, line...)
+	padded = append(padded, make([]bool, 4)...)
+	for i := 0; i+len(finderLike[0]) <= len(padded); i++ {
+		for _, pattern := range finderLike {
+			if matches(padded[i:], pattern) {
+				result += penaltyFinder
+			}
+		}
+	}
+	return result
+}
+
+func matches(line, pattern []bool) bool {
+	for i, p := range pattern {
+		if line[i] != p {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
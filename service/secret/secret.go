@@ -0,0 +1,75 @@
+// Package secret encrypts credentials the service keeps, such as the passwords of routers
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// Length of the key of a Box, selecting AES-256
+const KeySize = 32
+
+var (
+	ErrInvalidKey = errors.New("secret key must be 32 bytes")
+	ErrDecrypt    = errors.New("secret can not be decrypted with this key")
+)
+
+// Box seals secrets with AES-GCM, every sealed secret has its own random nonce
+type Box struct {
+	aead cipher.AEAD
+}
+
+// GenerateKey returns a random key for NewBox
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func NewBox(key []byte) (*Box, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// Seal encrypts plaintext, the result is base64 encoded so it can be stored as text
+func (b *Box) Seal(plaintext string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a secret returned by Seal
+func (b *Box) Open(sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	if len(data) < b.aead.NonceSize() {
+		return "", ErrDecrypt
+	}
+	nonce, ciphertext := data[:b.aead.NonceSize()], data[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(plaintext), nil
+}
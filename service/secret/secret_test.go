@@ -0,0 +1,60 @@
+package secret_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jacobalberty/beenfar/service/secret"
+)
+
+func TestBox(t *testing.T) {
+	key, err := secret.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	box, err := secret.NewBox(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := box.Seal("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "correct horse") {
+		t.Errorf("Expected the secret to be encrypted, got %s", sealed)
+	}
+	again, err := box.Seal("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == sealed {
+		t.Error("Expected a new nonce for every secret")
+	}
+
+	if plaintext, err := box.Open(sealed); err != nil || plaintext != "correct horse" {
+		t.Errorf("Expected %q, got %q and %v", "correct horse", plaintext, err)
+	}
+
+	other, err := secret.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherBox, err := secret.NewBox(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sealed := range []string{sealed[:len(sealed)-4] + "AAA=", "not base64", ""} {
+		if _, err := box.Open(sealed); !errors.Is(err, secret.ErrDecrypt) {
+			t.Errorf("Expected %v opening %q, got %v", secret.ErrDecrypt, sealed, err)
+		}
+	}
+	if _, err := otherBox.Open(sealed); !errors.Is(err, secret.ErrDecrypt) {
+		t.Errorf("Expected %v with another key, got %v", secret.ErrDecrypt, err)
+	}
+
+	if _, err := secret.NewBox(key[:16]); !errors.Is(err, secret.ErrInvalidKey) {
+		t.Errorf("Expected %v, got %v", secret.ErrInvalidKey, err)
+	}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// Name of the file in the data directory holding users, sites, webhooks and the audit log
+const stateFile = "state.json"
+
+// How often changes are saved while the service runs
+const storageInterval = 5 * time.Second
+
+// state is what is kept in the data directory. Sessions are not kept, devices check in again and are pending until adopted.
+type state struct {
+	Users    *model.Users    `json:"users"`
+	Sites    *model.Sites    `json:"sites"`
+	Webhooks *model.Webhooks `json:"webhooks"`
+	Audit    *model.AuditLog `json:"audit"`
+}
+
+// storage saves the state of the service to the data directory
+type storage struct {
+	path  string
+	state state
+	// saved is the last state written, unchanged state is not written again
+	saved []byte
+
+	mu sync.Mutex
+}
+
+// loadState reads the state saved in the data directory, nothing is kept without a data directory
+func (b *BeenFarService) loadState() {
+	b.storage = &storage{state: state{Users: b.users, Sites: b.sites, Webhooks: b.webhooks, Audit: b.audit}}
+	if b.dataDir == "" {
+		b.log.Warn("users, sites, webhooks and the audit log will not be kept after a restart without a data directory")
+		return
+	}
+	b.storage.path = filepath.Join(b.dataDir, stateFile)
+
+	data, err := os.ReadFile(b.storage.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	} else if err != nil {
+		b.fatal("error reading state", "file", b.storage.path, "error", err)
+	}
+	if err := json.Unmarshal(data, &b.storage.state); err != nil {
+		b.fatal("invalid state", "file", b.storage.path, "error", err)
+	}
+	b.storage.saved = data
+}
+
+// save writes the state to the data directory if it changed since it was last written
+func (s *storage) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, s.saved) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(s.path+".tmp", s.path); err != nil {
+		return err
+	}
+	s.saved = data
+	return nil
+}
+
+// storageStage saves the state every storageInterval while the service runs and once more when it stops,
+// it is the first stage started so it is stopped after every stage changing the state.
+// Storage is reported unhealthy while saving fails.
+func (b *BeenFarService) storageStage() Stage {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	return Stage{
+		Name: componentStorage,
+		Start: func(func(error)) error {
+			if err := b.storage.save(); err != nil {
+				return err
+			}
+			go func() {
+				defer close(done)
+				ticker := time.NewTicker(storageInterval)
+				defer ticker.Stop()
+
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+						err := b.storage.save()
+						if err != nil {
+							b.log.Error("error saving state", "component", componentStorage, "error", err)
+						}
+						b.health.Set(componentStorage, err)
+					}
+				}
+			}()
+			return nil
+		},
+		Stop: func(drain context.Context) error {
+			cancel()
+			select {
+			case <-done:
+			case <-drain.Done():
+				return drain.Err()
+			}
+			if err := b.storage.save(); err != nil {
+				return fmt.Errorf("saving state: %w", err)
+			}
+			return nil
+		},
+	}
+}
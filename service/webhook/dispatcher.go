@@ -0,0 +1,223 @@
+// Package webhook delivers bus events to webhook subscriptions as signed HTTP POSTs
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service/event"
+	"github.com/jacobalberty/beenfar/service/logging"
+	"github.com/jacobalberty/beenfar/service/model"
+)
+
+// Headers set on every delivery
+const (
+	HeaderEvent     = "X-Beenfar-Event"
+	HeaderDelivery  = "X-Beenfar-Delivery"
+	HeaderTimestamp = "X-Beenfar-Timestamp"
+	// HeaderSignature is "sha256=" followed by the hex HMAC-SHA256 of Sign
+	HeaderSignature = "X-Beenfar-Signature"
+)
+
+// Defaults used by NewDispatcher
+const (
+	DefaultMaxAttempts = 5
+	DefaultBackoff     = time.Second
+	DefaultTimeout     = 10 * time.Second
+	DefaultWorkers     = 4
+	DefaultQueueSize   = 256
+)
+
+// Sign returns the signature of a delivery, the HMAC-SHA256 of the timestamp, a dot and the body.
+// Receivers should compare it to HeaderSignature and reject stale timestamps.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher delivers events from a bus to the matching webhooks
+type Dispatcher struct {
+	// MaxAttempts is how often a delivery is tried before giving up
+	MaxAttempts int
+	// Backoff is the delay before the first retry, it doubles after every failed attempt
+	Backoff time.Duration
+	// Workers is how many deliveries are made at once
+	Workers int
+	// QueueSize is how many deliveries wait for a worker before events are no longer taken from the bus,
+	// events the bus can not buffer either are dropped and logged as failed deliveries
+	QueueSize int
+	Client    *http.Client
+	Logger    *logging.Logger
+
+	hooks *model.Webhooks
+	bus   *event.Bus
+	sub   *event.Subscription
+}
+
+// NewDispatcher subscribes to the bus right away so no events are missed before Run is called
+func NewDispatcher(hooks *model.Webhooks, bus *event.Bus) *Dispatcher {
+	d := &Dispatcher{
+		MaxAttempts: DefaultMaxAttempts,
+		Backoff:     DefaultBackoff,
+		Workers:     DefaultWorkers,
+		QueueSize:   DefaultQueueSize,
+		Client:      &http.Client{Timeout: DefaultTimeout},
+		Logger:      logging.Default().With("component", "webhooks"),
+		hooks:       hooks,
+		bus:         bus,
+	}
+	d.sub = bus.SubscribeWithDrops(d.dropped)
+	return d
+}
+
+// A delivery of an event to a webhook waiting for a worker
+type delivery struct {
+	hook  model.Webhook
+	event event.Event
+}
+
+// Run delivers events until ctx is done, queued deliveries and pending retries are abandoned when it returns
+func (d *Dispatcher) Run(ctx context.Context) {
+	queue := make(chan delivery, d.QueueSize)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer d.bus.Unsubscribe(d.sub)
+
+	for i := 0; i < d.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-queue:
+					d.deliver(ctx, job.hook, job.event)
+				}
+			}
+		}()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-d.sub.Events():
+			for _, hook := range d.hooks.List() {
+				if !hook.Wants(e.Type) {
+					continue
+				}
+				// Waiting for a worker leaves events on the bus, where they are dropped once its buffer is full
+				select {
+				case <-ctx.Done():
+					return
+				case queue <- delivery{hook: hook, event: e}:
+				}
+			}
+		}
+	}
+}
+
+// dropped logs an event the bus dropped as a failed delivery to every webhook that wants it
+func (d *Dispatcher) dropped(e event.Event) {
+	d.Logger.Warn("dropped event, webhook deliveries are falling behind", "event_id", e.ID, "event", e.Type)
+	for _, hook := range d.hooks.List() {
+		if !hook.Wants(e.Type) {
+			continue
+		}
+		_ = d.hooks.Record(model.WebhookDelivery{
+			WebhookID: hook.ID,
+			EventID:   e.ID,
+			EventType: string(e.Type),
+			Error:     "event dropped, deliveries are falling behind",
+		})
+	}
+}
+
+// deliver posts an event to a webhook, retrying with exponential backoff
+func (d *Dispatcher) deliver(ctx context.Context, hook model.Webhook, e event.Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		d.Logger.Error("error encoding event", "event_id", e.ID, "error", err)
+		return
+	}
+
+	backoff := d.Backoff
+	for attempt := 1; attempt <= d.MaxAttempts; attempt++ {
+		status, err := d.post(ctx, hook, e, body)
+
+		delivery := model.WebhookDelivery{
+			WebhookID:  hook.ID,
+			EventID:    e.ID,
+			EventType:  string(e.Type),
+			Attempt:    attempt,
+			StatusCode: status,
+			Success:    err == nil,
+		}
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		if rerr := d.hooks.Record(delivery); rerr != nil {
+			// The webhook was deleted, stop retrying
+			return
+		}
+		if err == nil {
+			return
+		}
+		if attempt == d.MaxAttempts {
+			d.Logger.Warn("giving up on webhook delivery", "webhook", hook.ID, "event_id", e.ID, "attempts", attempt, "error", err)
+			return
+		}
+		d.Logger.Debug("webhook delivery failed", "webhook", hook.ID, "event_id", e.ID, "attempt", attempt, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes a single delivery attempt, any non 2xx response is an error
+func (d *Dispatcher) post(ctx context.Context, hook model.Webhook, e event.Event, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "beenfar-webhook")
+	req.Header.Set(HeaderEvent, string(e.Type))
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(e.ID, 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, timestamp, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,201 @@
+package webhook_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jacobalberty/beenfar/service/event"
+	"github.com/jacobalberty/beenfar/service/model"
+	"github.com/jacobalberty/beenfar/service/webhook"
+)
+
+func TestDispatcher(t *testing.T) {
+	var (
+		failures int32 = 2
+		received       = make(chan *http.Request, 10)
+		bodies         = make(chan []byte, 10)
+	)
+
+	// The receiver fails the first deliveries to exercise retries
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received <- r
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	hooks := model.NewWebhooks()
+	hook, err := hooks.Add(model.Webhook{
+		URL:    receiver.URL,
+		Secret: "s3cret",
+		Events: []string{string(event.DeviceOffline)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus := event.NewBus()
+	d := webhook.NewDispatcher(hooks, bus)
+	d.Backoff = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+
+	// Events the webhook is not subscribed to are not delivered
+	bus.Publish(event.ConfigChanged, "wifi/1", nil)
+	bus.Publish(event.DeviceOffline, "device/deadbeef0000", nil)
+
+	var req *http.Request
+	select {
+	case req = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a delivery")
+	}
+	body := <-bodies
+
+	if e := req.Header.Get(webhook.HeaderEvent); e != string(event.DeviceOffline) {
+		t.Errorf("Expected event %s, got %s", event.DeviceOffline, e)
+	}
+	expected := webhook.Sign("s3cret", req.Header.Get(webhook.HeaderTimestamp), body)
+	if sig := req.Header.Get(webhook.HeaderSignature); sig != expected {
+		t.Errorf("Expected signature %s, got %s", expected, sig)
+	}
+
+	deliveries := waitForDeliveries(t, hooks, hook.ID, 3)
+	cancel()
+	<-done
+
+	for i, delivery := range deliveries {
+		if delivery.Attempt != i+1 {
+			t.Errorf("Expected attempt %d, got %d", i+1, delivery.Attempt)
+		}
+		if success := i == 2; delivery.Success != success {
+			t.Errorf("Expected attempt %d success to be %t, got %t", i+1, success, delivery.Success)
+		}
+	}
+	if deliveries[0].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, deliveries[0].StatusCode)
+	}
+}
+
+func TestDispatcherGivesUp(t *testing.T) {
+	var requests int32
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	hooks := model.NewWebhooks()
+	hook, err := hooks.Add(model.Webhook{URL: receiver.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus := event.NewBus()
+	d := webhook.NewDispatcher(hooks, bus)
+	d.Backoff = time.Millisecond
+	d.MaxAttempts = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	bus.Publish(event.DevicePending, "device/deadbeef0000", nil)
+
+	waitForDeliveries(t, hooks, hook.ID, 3)
+
+	// Allow time for an unexpected fourth attempt
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("Expected 3 requests, got %d", n)
+	}
+}
+
+func TestDispatcherDrops(t *testing.T) {
+	const events = 100
+
+	// The receiver holds every delivery until released so the dispatcher falls behind
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer receiver.Close()
+
+	hooks := model.NewWebhooks()
+	hook, err := hooks.Add(model.Webhook{URL: receiver.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus := event.NewBus()
+	d := webhook.NewDispatcher(hooks, bus)
+	d.Workers = 1
+	d.QueueSize = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	for i := 0; i < events; i++ {
+		bus.Publish(event.DevicePending, "device/deadbeef0000", nil)
+	}
+	close(release)
+
+	// Every event is either delivered or logged as dropped, never both
+	deliveries := waitForDeliveries(t, hooks, hook.ID, events)
+	seen := make(map[uint64]bool)
+	dropped := 0
+	for _, delivery := range deliveries {
+		if seen[delivery.EventID] {
+			t.Errorf("Expected one delivery of event %d", delivery.EventID)
+		}
+		seen[delivery.EventID] = true
+		if !delivery.Success {
+			dropped++
+			if delivery.Attempt != 0 || delivery.Error == "" {
+				t.Errorf("Expected a dropped event without attempts and with an error, got %+v", delivery)
+			}
+		}
+	}
+	if dropped == 0 {
+		t.Error("Expected dropped events to be logged")
+	}
+	if len(seen) != events {
+		t.Errorf("Expected %d events in the delivery log, got %d", events, len(seen))
+	}
+}
+
+// waitForDeliveries waits until a webhook has n deliveries in its log
+func waitForDeliveries(t *testing.T, hooks *model.Webhooks, id string, n int) []model.WebhookDelivery {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, err := hooks.Deliveries(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) >= n {
+			return deliveries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d delivery attempts, got %d", n, len(deliveries))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}